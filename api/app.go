@@ -23,10 +23,14 @@ import (
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 )
 
-func getApp(name string, u *auth.User) (app.App, error) {
+// getApp loads the app with the given name, checking that the user has the
+// given permission in at least one of the teams of the app.
+func getApp(name string, u *auth.User, perm auth.Permission) (app.App, error) {
 	app := app.App{Name: name}
 	err := app.Get()
 	if err != nil {
@@ -38,6 +42,10 @@ func getApp(name string, u *auth.User) (app.App, error) {
 	if !auth.CheckUserAccess(app.Teams, u) {
 		return app, &errors.HTTP{Code: http.StatusForbidden, Message: "User does not have access to this app"}
 	}
+	if !auth.CheckUserPermission(app.Teams, u, perm) {
+		msg := fmt.Sprintf("User does not have the permission %q in this app", perm)
+		return app, &errors.HTTP{Code: http.StatusForbidden, Message: msg}
+	}
 	return app, nil
 }
 
@@ -47,28 +55,156 @@ func cloneRepository(w http.ResponseWriter, r *http.Request, t *auth.Token) erro
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Missing parameter version"}
 	}
 	w.Header().Set("Content-Type", "text")
-	instance := &app.App{Name: r.URL.Query().Get(":appname")}
-	err := instance.Get()
+	appName := r.URL.Query().Get(":appname")
+	u, err := deployUser(r, t)
+	if err != nil {
+		return err
+	}
+	var (
+		instance app.App
+		user     string
+	)
+	if u != nil {
+		if instance, err = getApp(appName, u, auth.PermAppDeploy); err != nil {
+			return err
+		}
+		user = u.Email
+	} else {
+		instance.Name = appName
+		if err = instance.Get(); err != nil {
+			return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", appName)}
+		}
+		// Deploys triggered with the token of the app, or by the git hooks
+		// without the user that pushed, are recorded as made by the app.
+		if t.AppName != auth.ServerAppName && t.AppName != appName {
+			return &errors.HTTP{Code: http.StatusForbidden, Message: "This token can't deploy the app " + appName}
+		}
+		user = "app:" + t.AppName
+	}
+	rec.Log(user, "deploy", "app="+appName, "version="+version)
+	logger := app.LogWriter{App: &instance, Writer: w}
+	return app.Deploy(&instance, version, user, &logger)
+}
+
+// deployUser returns the user that is deploying the app. The git hooks use
+// the token generated by tsr and send the user that pushed the code, so the
+// deploy is checked and recorded as if the user had used their own token.
+// Deploys made with the token of an app have no user.
+func deployUser(r *http.Request, t *auth.Token) (*auth.User, error) {
+	if t.AppName == "" {
+		return t.User()
+	}
+	if email := r.PostFormValue("user"); t.AppName == auth.ServerAppName && email != "" {
+		u, err := auth.GetUserByEmail(email)
+		if err != nil {
+			return nil, &errors.HTTP{Code: http.StatusForbidden, Message: "User not found: " + email}
+		}
+		return u, nil
+	}
+	return nil, nil
+}
+
+func deployList(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
 	if err != nil {
-		return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", instance.Name)}
+		return err
 	}
-	if err := incrementAppDeploy(instance); err != nil {
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "list-deploys", "app="+appName)
+	a, err := getApp(appName, u, auth.PermAppRead)
+	if err != nil {
 		return err
 	}
-	logger := app.LogWriter{App: instance, Writer: w}
-	return app.Provisioner.Deploy(instance, version, &logger)
+	deploys, err := app.ListDeploys(&a)
+	if err != nil {
+		return err
+	}
+	if len(deploys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(deploys)
 }
 
-func incrementAppDeploy(instance *app.App) error {
-	conn, err := db.Conn()
+func rollback(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	id := r.PostFormValue("deploy")
+	if id == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Missing parameter deploy"}
+	}
+	u, err := t.User()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
-	if err := conn.Apps().Update(bson.M{"name": instance.Name}, bson.M{"$inc": bson.M{"deploys": 1}}); err != nil {
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "rollback", "app="+appName, "deploy="+id)
+	a, err := getApp(appName, u, auth.PermAppDeploy)
+	if err != nil {
 		return err
 	}
-	return nil
+	w.Header().Set("Content-Type", "text")
+	logger := app.LogWriter{App: &a, Writer: w}
+	err = app.Rollback(&a, id, u.Email, &logger)
+	if err == app.ErrDeployNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err == app.ErrRollbackBlueGreen {
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: err.Error()}
+	}
+	return err
+}
+
+func enableBlueGreen(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "enable-blue-green", "app="+appName)
+	a, err := getApp(appName, u, auth.PermAppDeploy)
+	if err != nil {
+		return err
+	}
+	err = app.EnableBlueGreen(&a)
+	if err == app.ErrBlueGreenEnabled {
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
+}
+
+func promote(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "promote", "app="+appName)
+	a, err := getApp(appName, u, auth.PermAppDeploy)
+	if err != nil {
+		return err
+	}
+	return blueGreenError(app.Promote(&a))
+}
+
+func abortDeploy(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "abort-deploy", "app="+appName)
+	a, err := getApp(appName, u, auth.PermAppDeploy)
+	if err != nil {
+		return err
+	}
+	return blueGreenError(app.AbortDeploy(&a))
+}
+
+func blueGreenError(err error) error {
+	if err == app.ErrBlueGreenDisabled || err == app.ErrNoPendingDeploy {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
 }
 
 func appIsAvailable(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
@@ -89,8 +225,8 @@ func appDelete(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "app-delete", r.URL.Query().Get(":app"))
-	a, err := getApp(r.URL.Query().Get(":app"), u)
+	rec.Log(u.Email, "remove-app", "app="+r.URL.Query().Get(":app"))
+	a, err := getApp(r.URL.Query().Get(":app"), u, auth.PermAppAdmin)
 	if err != nil {
 		return err
 	}
@@ -104,7 +240,7 @@ func appList(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "app-list")
+	rec.Log(u.Email, "list-apps")
 	apps, err := app.List(u)
 	if err != nil {
 		return err
@@ -121,8 +257,8 @@ func appInfo(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "app-info", r.URL.Query().Get(":app"))
-	app, err := getApp(r.URL.Query().Get(":app"), u)
+	rec.Log(u.Email, "get-app", "app="+r.URL.Query().Get(":app"))
+	app, err := getApp(r.URL.Query().Get(":app"), u, auth.PermAppRead)
 	if err != nil {
 		return err
 	}
@@ -148,13 +284,16 @@ func createApp(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "create-app", "name="+a.Name, "platform="+a.Platform)
+	rec.Log(u.Email, "create-app", "app="+a.Name, "platform="+a.Platform)
 	err = app.CreateApp(&a, u)
 	if err != nil {
 		log.Printf("Got error while creating app: %s", err)
 		if e, ok := err.(*errors.ValidationError); ok {
 			return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
 		}
+		if _, ok := err.(*quota.QuotaExceededError); ok {
+			return &errors.HTTP{Code: http.StatusForbidden, Message: err.Error()}
+		}
 		if _, ok := err.(app.NoTeamsError); ok {
 			return &errors.HTTP{
 				Code:    http.StatusBadRequest,
@@ -221,7 +360,7 @@ func addUnits(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		return err
 	}
 	rec.Log(u.Email, "add-units", "app="+appName, fmt.Sprintf("units=%d", n))
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
@@ -246,13 +385,91 @@ func removeUnits(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	}
 	appName := r.URL.Query().Get(":app")
 	rec.Log(u.Email, "remove-units", "app="+appName, fmt.Sprintf("units=%d", n))
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
 	return app.RemoveUnits(uint(n))
 }
 
+func getAutoScale(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	a, err := getApp(r.URL.Query().Get(":app"), u, auth.PermAppRead)
+	if err != nil {
+		return err
+	}
+	if a.AutoScale == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(a.AutoScale)
+}
+
+func setAutoScale(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	defer r.Body.Close()
+	var rules app.AutoScaleConfig
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid autoscale rules."}
+	}
+	rec.Log(u.Email, "set-autoscale", "app="+appName, fmt.Sprintf("enabled=%t", rules.Enabled),
+		fmt.Sprintf("min=%d", rules.MinUnits), fmt.Sprintf("max=%d", rules.MaxUnits))
+	a, err := getApp(appName, u, auth.PermAppUpdate)
+	if err != nil {
+		return err
+	}
+	rules.LastScale = time.Time{}
+	if a.AutoScale != nil {
+		rules.LastScale = a.AutoScale.LastScale
+	}
+	err = app.SetAutoScale(&a, &rules)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+func addMetrics(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	appName := r.URL.Query().Get(":app")
+	var a app.App
+	if t.AppName != "" {
+		// Units report their metrics using the token of their app.
+		if t.AppName != appName {
+			return &errors.HTTP{Code: http.StatusForbidden, Message: "This token can't report metrics of the app " + appName}
+		}
+		a.Name = appName
+		if err := a.Get(); err != nil {
+			return &errors.HTTP{Code: http.StatusNotFound, Message: fmt.Sprintf("App %s not found.", a.Name)}
+		}
+	} else {
+		u, err := t.User()
+		if err != nil {
+			return err
+		}
+		if a, err = getApp(appName, u, auth.PermAppUpdate); err != nil {
+			return err
+		}
+	}
+	defer r.Body.Close()
+	var metrics []app.UnitMetric
+	if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid metrics."}
+	}
+	err := app.ReportMetrics(&a, metrics)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
 func grantAppAccess(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	u, err := t.User()
 	if err != nil {
@@ -262,7 +479,7 @@ func grantAppAccess(w http.ResponseWriter, r *http.Request, t *auth.Token) error
 	teamName := r.URL.Query().Get(":team")
 	rec.Log(u.Email, "grant-app-access", "app="+appName, "team="+teamName)
 	team := new(auth.Team)
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppAdmin)
 	if err != nil {
 		return err
 	}
@@ -322,7 +539,7 @@ func revokeAppAccess(w http.ResponseWriter, r *http.Request, t *auth.Token) erro
 	teamName := r.URL.Query().Get(":team")
 	rec.Log(u.Email, "revoke-app-access", "app="+appName, "team="+teamName)
 	team := new(auth.Team)
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppAdmin)
 	if err != nil {
 		return err
 	}
@@ -377,7 +594,7 @@ func runCommand(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	appName := r.URL.Query().Get(":app")
 	once := r.URL.Query().Get("once")
 	rec.Log(u.Email, "run-command", "app="+appName, "command="+string(c))
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
@@ -399,7 +616,7 @@ func getEnv(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	}
 	appName := r.URL.Query().Get(":app")
 	rec.Log(u.Email, "get-env", "app="+appName, fmt.Sprintf("envs=%s", variables))
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppRead)
 	if err != nil {
 		return err
 	}
@@ -407,22 +624,39 @@ func getEnv(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	if l == 0 {
 		l = len(app.Env)
 	}
+	if len(variables) == 0 {
+		for k := range app.Env {
+			variables = append(variables, k)
+		}
+	}
 	result := make(map[string]string, l)
-	w.Header().Set("Content-Type", "application/json")
-	if len(variables) > 0 {
-		for _, variable := range variables {
-			if v, ok := app.Env[variable]; ok {
-				result[variable] = v.String()
-			}
+	for _, variable := range variables {
+		v, ok := app.Env[variable]
+		if !ok {
+			continue
 		}
-	} else {
-		for k, v := range app.Env {
-			result[k] = v.String()
+		if v.Public {
+			if v.Value, err = app.EnvValue(variable); err != nil {
+				return err
+			}
 		}
+		result[variable] = v.String()
 	}
+	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(result)
 }
 
+// envNames returns the sorted names of the variables, so their values (that
+// may be secret) aren't recorded in the audit log.
+func envNames(variables map[string]string) []string {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func setEnv(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	msg := "You must provide the environment variables in a JSON object"
 	if r.Body == nil {
@@ -438,8 +672,8 @@ func setEnv(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		return err
 	}
 	appName := r.URL.Query().Get(":app")
-	rec.Log(u.Email, "set-env", "app="+appName, variables)
-	app, err := getApp(appName, u)
+	rec.Log(u.Email, "set-env", "app="+appName, fmt.Sprintf("envs=%s", envNames(variables)))
+	app, err := getApp(appName, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
@@ -470,13 +704,75 @@ func unsetEnv(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		return err
 	}
 	rec.Log(u.Email, "unset-env", "app="+appName, fmt.Sprintf("envs=%s", variables))
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
 	return app.UnsetEnvs(variables, true)
 }
 
+func importEnv(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	msg := "You must provide the environment variables in a JSON object"
+	if r.Body == nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+	}
+	defer r.Body.Close()
+	var params struct {
+		Envs    map[string]string
+		Restart bool
+	}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "import-env", "app="+appName, fmt.Sprintf("envs=%s", envNames(params.Envs)), fmt.Sprintf("restart=%t", params.Restart))
+	app, err := getApp(appName, u, auth.PermAppUpdate)
+	if err != nil {
+		return err
+	}
+	envs := make([]bind.EnvVar, 0, len(params.Envs))
+	for _, name := range envNames(params.Envs) {
+		envs = append(envs, bind.EnvVar{Name: name, Value: params.Envs[name], Public: true})
+	}
+	w.Header().Set("Content-Type", "text")
+	err = app.ImportEnvs(envs, params.Restart, w)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// exportEnv returns the public environment variables of the app, with their
+// values in plain text. Private variables are never exported.
+func exportEnv(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "export-env", "app="+appName)
+	app, err := getApp(appName, u, auth.PermAppRead)
+	if err != nil {
+		return err
+	}
+	result := make(map[string]string, len(app.Env))
+	for name, env := range app.Env {
+		if !env.Public {
+			continue
+		}
+		if result[name], err = app.EnvValue(name); err != nil {
+			return err
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
 func setCName(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	msg := "You must provide the cname."
 	if r.Body == nil {
@@ -496,7 +792,7 @@ func setCName(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	}
 	appName := r.URL.Query().Get(":app")
 	rec.Log(u.Email, "set-cname", "app="+appName, "cname="+v["cname"])
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
@@ -515,7 +811,7 @@ func unsetCName(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		return err
 	}
 	appName := r.URL.Query().Get(":app")
-	app, err := getApp(appName, u)
+	app, err := getApp(appName, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
@@ -535,8 +831,12 @@ func appLog(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	} else {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: `Parameter "lines" is mandatory.`}
 	}
+	filter, err := logFilter(r)
+	if err != nil {
+		return err
+	}
+	filter.Lines = lines
 	w.Header().Set("Content-Type", "application/json")
-	source := r.URL.Query().Get("source")
 	u, err := t.User()
 	if err != nil {
 		return err
@@ -546,21 +846,28 @@ func appLog(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		"app=" + appName,
 		fmt.Sprintf("lines=%d", lines),
 	}
-	if source != "" {
-		extra = append(extra, "source="+source)
+	for _, param := range []string{"source", "unit", "since", "until", "message", "regex", "cursor"} {
+		if value := r.URL.Query().Get(param); value != "" {
+			extra = append(extra, param+"="+value)
+		}
 	}
 	if r.URL.Query().Get("follow") == "1" {
 		extra = append(extra, "follow=1")
 	}
-	rec.Log(u.Email, "app-log", extra...)
-	a, err := getApp(appName, u)
+	rec.Log(u.Email, "get-app-log", extra...)
+	a, err := getApp(appName, u, auth.PermAppRead)
 	if err != nil {
 		return err
 	}
-	logs, err := a.LastLogs(lines, source)
-	if err != nil {
+	logs, cursor, err := a.SearchLogs(filter)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	} else if err != nil {
 		return err
 	}
+	if cursor != "" {
+		w.Header().Set("X-Tsuru-Log-Cursor", cursor)
+	}
 	encoder := json.NewEncoder(w)
 	err = encoder.Encode(logs)
 	if err != nil {
@@ -571,6 +878,9 @@ func appLog(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		l := app.NewLogListener(&a)
 		defer l.Close()
 		for log := range l.C {
+			if !filter.Match(&log) {
+				continue
+			}
 			err := encoder.Encode([]app.Applog{log})
 			if err != nil {
 				break
@@ -580,6 +890,33 @@ func appLog(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	return nil
 }
 
+// logFilter builds the filter of logs from the parameters of the request.
+// Dates are expected in the RFC 3339 format.
+func logFilter(r *http.Request) (*app.LogFilter, error) {
+	query := r.URL.Query()
+	filter := app.LogFilter{
+		Source:  query.Get("source"),
+		Unit:    query.Get("unit"),
+		Message: query.Get("message"),
+		Regex:   query.Get("regex"),
+		Cursor:  query.Get("cursor"),
+	}
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if v := query.Get(param.name); v != "" {
+			date, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				msg := fmt.Sprintf(`Parameter %q must be a date in the RFC 3339 format.`, param.name)
+				return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+			}
+			*param.value = date
+		}
+	}
+	return &filter, nil
+}
+
 func getServiceInstance(instanceName, appName string, u *auth.User) (*service.ServiceInstance, *app.App, error) {
 	var app app.App
 	conn, err := db.Conn()
@@ -587,9 +924,10 @@ func getServiceInstance(instanceName, appName string, u *auth.User) (*service.Se
 		return nil, nil, err
 	}
 	defer conn.Close()
-	instance, err := getServiceInstanceOrError(instanceName, u)
+	var instance service.ServiceInstance
+	err = conn.ServiceInstances().Find(bson.M{"name": instanceName}).One(&instance)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &errors.HTTP{Code: http.StatusNotFound, Message: service.ErrServiceInstanceNotFound.Error()}
 	}
 	err = conn.Apps().Find(bson.M{"name": appName}).One(&app)
 	if err != nil {
@@ -600,7 +938,17 @@ func getServiceInstance(instanceName, appName string, u *auth.User) (*service.Se
 		err = &errors.HTTP{Code: http.StatusForbidden, Message: "This user does not have access to this app"}
 		return nil, nil, err
 	}
-	return instance, &app, nil
+	if !auth.CheckUserPermission(app.Teams, u, auth.PermAppUpdate) {
+		msg := fmt.Sprintf("User does not have the permission %q in this app", auth.PermAppUpdate)
+		return nil, nil, &errors.HTTP{Code: http.StatusForbidden, Message: msg}
+	}
+	// Apps of the teams the instance is shared with may use it too. Apps that
+	// are already bound can always be unbound, even after the access to the
+	// instance is revoked.
+	if !auth.CheckUserAccess(instance.Teams, u) && !instance.IsSharedWith(app.Teams) && instance.FindApp(app.Name) < 0 {
+		return nil, nil, &errors.HTTP{Code: http.StatusForbidden, Message: service.ErrAccessNotAllowed.Error()}
+	}
+	return &instance, &app, nil
 }
 
 func bindServiceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
@@ -615,6 +963,11 @@ func bindServiceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token)
 	}
 	rec.Log(u.Email, "bind-app", "instance="+instanceName, "app="+appName)
 	err = instance.BindApp(a)
+	if err == service.ErrInstancePending {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, err.Error())
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -648,8 +1001,8 @@ func restart(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		return err
 	}
 	appName := r.URL.Query().Get(":app")
-	rec.Log(u.Email, "restart", appName)
-	instance, err := getApp(appName, u)
+	rec.Log(u.Email, "restart-app", "app="+appName)
+	instance, err := getApp(appName, u, auth.PermAppDeploy)
 	if err != nil {
 		return err
 	}
@@ -674,8 +1027,9 @@ func addLog(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	if len(source) == 0 {
 		source = "app"
 	}
+	unit := queryValues.Get("unit")
 	for _, log := range logs {
-		err := app.Log(log, source)
+		err := app.LogFromUnit(log, source, unit)
 		if err != nil {
 			return err
 		}
@@ -684,12 +1038,78 @@ func addLog(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	return nil
 }
 
+func listLogDrains(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "list-log-drains", "app="+appName)
+	a, err := getApp(appName, u, auth.PermAppRead)
+	if err != nil {
+		return err
+	}
+	drains := a.LogDrains
+	if drains == nil {
+		drains = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(drains)
+}
+
+func addLogDrain(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	var v map[string]string
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&v)
+	}
+	if v["url"] == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the url of the log drain."}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "add-log-drain", "app="+appName, "url="+v["url"])
+	a, err := getApp(appName, u, auth.PermAppUpdate)
+	if err != nil {
+		return err
+	}
+	err = a.AddLogDrain(v["url"])
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+func removeLogDrain(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the url of the log drain."}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "remove-log-drain", "app="+appName, "url="+url)
+	a, err := getApp(appName, u, auth.PermAppUpdate)
+	if err != nil {
+		return err
+	}
+	err = a.RemoveLogDrain(url)
+	if err == app.ErrLogDrainNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
 func platformList(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	u, err := t.User()
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "platform-list")
+	rec.Log(u.Email, "list-platforms")
 	platforms, err := app.Platforms()
 	if err != nil {
 		return err
@@ -704,14 +1124,18 @@ func swap(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	}
 	app1Name := r.URL.Query().Get("app1")
 	app2Name := r.URL.Query().Get("app2")
-	app1, err := getApp(app1Name, u)
+	app1, err := getApp(app1Name, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
-	app2, err := getApp(app2Name, u)
+	app2, err := getApp(app2Name, u, auth.PermAppUpdate)
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "swap", app1Name, app2Name)
-	return app.Swap(&app1, &app2)
+	rec.Log(u.Email, "swap-apps", "app="+app1Name, "app="+app2Name)
+	err = app.Swap(&app1, &app2)
+	if err == app.ErrRejectedDeploy {
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
 }
@@ -145,6 +145,773 @@ func (s *S) TestCloneRepositoryWithoutVersion(c *gocheck.C) {
 	c.Assert(e.Message, gocheck.Equals, "Missing parameter version")
 }
 
+func (s *S) TestCloneRepositoryRecordsTheDeploy(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+		Units:    []app.Unit{{Name: "i-0800", State: "started"}},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("version=a345f3e"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var d app.DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.Commit, gocheck.Equals, "a345f3e")
+	c.Assert(d.User, gocheck.Equals, s.user.Email)
+	c.Assert(d.Success(), gocheck.Equals, true)
+}
+
+func (s *S) TestCloneRepositoryWithAppTokenRecordsTheApp(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+		Units:    []app.Unit{{Name: "i-0800", State: "started"}},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	token, err := auth.CreateApplicationToken(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	body := strings.NewReader("version=a345f3e&user=someone@tsuru.io")
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, token)
+	c.Assert(err, gocheck.IsNil)
+	var d app.DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.User, gocheck.Equals, "app:otherapp")
+}
+
+func (s *S) TestCloneRepositoryWithServerTokenRecordsThePusher(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+		Units:    []app.Unit{{Name: "i-0800", State: "started"}},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	token, err := auth.CreateApplicationToken(auth.ServerAppName)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	body := strings.NewReader("version=a345f3e&user=" + s.user.Email)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Version(&a), gocheck.Equals, "a345f3e")
+	var d app.DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.User, gocheck.Equals, s.user.Email)
+}
+
+func (s *S) TestCloneRepositoryWithServerTokenWithoutThePusher(c *gocheck.C) {
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+		Units:    []app.Unit{{Name: "i-0800", State: "started"}},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	token, err := auth.CreateApplicationToken(auth.ServerAppName)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("version=a345f3e"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, token)
+	c.Assert(err, gocheck.IsNil)
+	var d app.DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.User, gocheck.Equals, "app:tsr")
+}
+
+func (s *S) TestCloneRepositoryWithServerTokenAndUnknownPusher(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	token, err := auth.CreateApplicationToken(auth.ServerAppName)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	body := strings.NewReader("version=a345f3e&user=unknown@tsuru.io")
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestCloneRepositoryReturnsForbiddenIfTheUserDoesNotHaveAccessToTheApp(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Platform: "zend"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("version=a345f3e"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestCloneRepositoryReturnsForbiddenIfTheUserRoleDoesNotAllowDeploys(c *gocheck.C) {
+	team := auth.Team{Name: "viewers", Users: []string{s.user.Email}, Role: "viewer"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("version=a345f3e"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+	c.Assert(e.Message, gocheck.Equals, `User does not have the permission "app.deploy" in this app`)
+}
+
+func (s *S) TestCloneRepositoryWithServerTokenChecksThePermissionOfThePusher(c *gocheck.C) {
+	team := auth.Team{Name: "viewers", Users: []string{s.user.Email}, Role: "viewer"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	token, err := auth.CreateApplicationToken(auth.ServerAppName)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	body := strings.NewReader("version=a345f3e&user=" + s.user.Email)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestCloneRepositoryWithTokenOfAnotherApp(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	token, err := auth.CreateApplicationToken("myapp")
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	url := fmt.Sprintf("/apps/%s/repository/clone?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("version=a345f3e"))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = cloneRepository(recorder, request, token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestDeployList(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	now := time.Now()
+	err = s.conn.Deploys().Insert(
+		app.DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "abc", Timestamp: now.Add(-time.Hour)},
+		app.DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "def", Timestamp: now},
+	)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploys?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = deployList(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var deploys []app.DeployData
+	err = json.NewDecoder(recorder.Body).Decode(&deploys)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(deploys, gocheck.HasLen, 2)
+	c.Assert(deploys[0].Commit, gocheck.Equals, "def")
+	c.Assert(deploys[1].Commit, gocheck.Equals, "abc")
+	action := testing.Action{Action: "list-deploys", User: s.user.Email, Extra: []interface{}{"app=" + a.Name}}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestDeployListWithoutDeploys(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploys?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = deployList(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestDeployListReturns403WhenTheUserDoesNotHaveAccessToTheApp(c *gocheck.C) {
+	a := app.App{Name: "otherapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/deploys?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = deployList(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRollback(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Platform: "zend", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().Remove(bson.M{"appname": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	old := app.DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "abc", Timestamp: time.Now()}
+	err = s.conn.Deploys().Insert(old)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	url := fmt.Sprintf("/apps/%s/rollback?:app=%s", a.Name, a.Name)
+	body := strings.NewReader("deploy=" + old.ID.Hex())
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = rollback(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "Deploy called")
+	c.Assert(s.provisioner.Version(&a), gocheck.Equals, "abc")
+	action := testing.Action{
+		Action: "rollback",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "deploy=" + old.ID.Hex()},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestRollbackWithoutDeploy(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/otherapp/rollback?:app=otherapp", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = rollback(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, "Missing parameter deploy")
+}
+
+func (s *S) TestRollbackBlueGreen(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}, BlueGreen: &app.BlueGreen{Standby: "otherapp-standby"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/rollback?:app=%s", a.Name, a.Name)
+	body := strings.NewReader("deploy=" + bson.NewObjectId().Hex())
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = rollback(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusPreconditionFailed)
+	c.Assert(e.Message, gocheck.Equals, app.ErrRollbackBlueGreen.Error())
+}
+
+func (s *S) TestRollbackUnknownDeploy(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/rollback?:app=%s", a.Name, a.Name)
+	body := strings.NewReader("deploy=" + bson.NewObjectId().Hex())
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = rollback(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestPromote(c *gocheck.C) {
+	a := app.App{
+		Name:      "otherapp",
+		Teams:     []string{s.team.Name},
+		BlueGreen: &app.BlueGreen{Standby: "otherapp-standby", Pending: "abc"},
+	}
+	standby := app.App{Name: "otherapp-standby", Teams: a.Teams, Primary: a.Name}
+	err := s.conn.Apps().Insert(a, standby)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{a.Name, standby.Name}}})
+	url := fmt.Sprintf("/apps/%s/promote?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = promote(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.BlueGreen.Swapped, gocheck.Equals, true)
+	c.Assert(a.BlueGreen.Pending, gocheck.Equals, "")
+	action := testing.Action{Action: "promote", User: s.user.Email, Extra: []interface{}{"app=" + a.Name}}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestPromoteWithoutPendingDeploy(c *gocheck.C) {
+	a := app.App{
+		Name:      "otherapp",
+		Teams:     []string{s.team.Name},
+		BlueGreen: &app.BlueGreen{Standby: "otherapp-standby"},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/promote?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = promote(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, app.ErrNoPendingDeploy.Error())
+}
+
+func (s *S) TestAbortDeploy(c *gocheck.C) {
+	a := app.App{
+		Name:      "otherapp",
+		Teams:     []string{s.team.Name},
+		BlueGreen: &app.BlueGreen{Standby: "otherapp-standby", Pending: "abc"},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/abort-deploy?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = abortDeploy(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.BlueGreen.Swapped, gocheck.Equals, false)
+	c.Assert(a.BlueGreen.Pending, gocheck.Equals, "")
+	c.Assert(a.BlueGreen.Rejected, gocheck.Equals, "abc")
+	action := testing.Action{Action: "abort-deploy", User: s.user.Email, Extra: []interface{}{"app=" + a.Name}}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestAbortDeployWithoutBlueGreen(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/abort-deploy?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = abortDeploy(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, app.ErrBlueGreenDisabled.Error())
+}
+
+func (s *S) TestEnableBlueGreenAlreadyEnabled(c *gocheck.C) {
+	a := app.App{
+		Name:      "otherapp",
+		Teams:     []string{s.team.Name},
+		BlueGreen: &app.BlueGreen{Standby: "otherapp-standby"},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/blue-green?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = enableBlueGreen(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusConflict)
+}
+
+func (s *S) TestSetAutoScale(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"Enabled":true,"MinUnits":1,"MaxUnits":5,` +
+		`"Increase":{"Metric":"cpu","Threshold":80,"Units":1},` +
+		`"Decrease":{"Metric":"cpu","Threshold":20,"Units":1},"Cooldown":300}`)
+	url := fmt.Sprintf("/apps/%s/autoscale?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setAutoScale(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.AutoScale.Enabled, gocheck.Equals, true)
+	c.Assert(a.AutoScale.MaxUnits, gocheck.Equals, uint(5))
+	c.Assert(a.AutoScale.Cooldown, gocheck.Equals, 300)
+	action := testing.Action{
+		Action: "set-autoscale",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "enabled=true", "min=1", "max=5"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestSetAutoScaleInvalidRules(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"Enabled":true,"MinUnits":3,"MaxUnits":1}`)
+	url := fmt.Sprintf("/apps/%s/autoscale?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setAutoScale(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestGetAutoScale(c *gocheck.C) {
+	a := app.App{
+		Name:      "otherapp",
+		Teams:     []string{s.team.Name},
+		AutoScale: &app.AutoScaleConfig{Enabled: true, MinUnits: 1, MaxUnits: 3},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/autoscale?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = getAutoScale(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var rules app.AutoScaleConfig
+	err = json.NewDecoder(recorder.Body).Decode(&rules)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rules.MaxUnits, gocheck.Equals, uint(3))
+}
+
+func (s *S) TestGetAutoScaleWithoutRules(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/autoscale?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = getAutoScale(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestListLogDrains(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}, LogDrains: []string{"syslog://logs.example.com"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log-drains?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listLogDrains(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var drains []string
+	err = json.NewDecoder(recorder.Body).Decode(&drains)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(drains, gocheck.DeepEquals, a.LogDrains)
+}
+
+func (s *S) TestListLogDrainsWithoutDrains(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log-drains?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listLogDrains(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "[]\n")
+}
+
+func (s *S) TestAddLogDrain(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"url":"syslog+tcp://logs.example.com:601"}`)
+	url := fmt.Sprintf("/apps/%s/log-drains?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addLogDrain(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var stored app.App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.LogDrains, gocheck.DeepEquals, []string{"syslog+tcp://logs.example.com:601"})
+	action := testing.Action{
+		Action: "add-log-drain",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "url=syslog+tcp://logs.example.com:601"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestAddLogDrainInvalidURL(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"url":"ftp://logs.example.com"}`)
+	url := fmt.Sprintf("/apps/%s/log-drains?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addLogDrain(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestAddLogDrainWithoutURL(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/otherapp/log-drains?:app=otherapp", strings.NewReader("{}"))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addLogDrain(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestRemoveLogDrain(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}, LogDrains: []string{"syslog://logs.example.com"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log-drains?:app=%s&url=syslog://logs.example.com", a.Name, a.Name)
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = removeLogDrain(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var stored app.App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.LogDrains, gocheck.HasLen, 0)
+}
+
+func (s *S) TestRemoveLogDrainNotFound(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log-drains?:app=%s&url=syslog://logs.example.com", a.Name, a.Name)
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = removeLogDrain(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestAddMetrics(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}, Units: []app.Unit{{Name: "otherapp/0"}}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	body := strings.NewReader(`[{"Unit":"otherapp/0","CPU":42.5}]`)
+	url := fmt.Sprintf("/apps/%s/metrics?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addMetrics(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var metric app.UnitMetric
+	err = s.conn.UnitMetrics().Find(bson.M{"app": a.Name}).One(&metric)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(metric.Unit, gocheck.Equals, "otherapp/0")
+	c.Assert(metric.CPU, gocheck.Equals, 42.5)
+}
+
+func (s *S) TestAddMetricsWithAppToken(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Units: []app.Unit{{Name: "otherapp/0"}}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	token, err := auth.CreateApplicationToken(a.Name)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	body := strings.NewReader(`[{"Unit":"otherapp/0","CPU":42.5}]`)
+	url := fmt.Sprintf("/apps/%s/metrics?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addMetrics(recorder, request, token)
+	c.Assert(err, gocheck.IsNil)
+	n, err := s.conn.UnitMetrics().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 1)
+}
+
+func (s *S) TestAddMetricsUnitOfAnotherApp(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}, Units: []app.Unit{{Name: "otherapp/0"}}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	body := strings.NewReader(`[{"Unit":"myapp/0","CPU":42.5}]`)
+	url := fmt.Sprintf("/apps/%s/metrics?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addMetrics(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	n, err := s.conn.UnitMetrics().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
+func (s *S) TestAddMetricsWithTokenOfAnotherApp(c *gocheck.C) {
+	a := app.App{Name: "otherapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	token, err := auth.CreateApplicationToken("myapp")
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	body := strings.NewReader(`[{"Unit":"otherapp/0","CPU":42.5}]`)
+	url := fmt.Sprintf("/apps/%s/metrics?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addMetrics(recorder, request, token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAddMetricsUserWithoutAccess(c *gocheck.C) {
+	a := app.App{Name: "otherapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`[{"Unit":"otherapp/0","CPU":42.5}]`)
+	url := fmt.Sprintf("/apps/%s/metrics?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addMetrics(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+	n, err := s.conn.UnitMetrics().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
 func (s *S) TestAppList(c *gocheck.C) {
 	app1 := app.App{
 		Name:  "app1",
@@ -184,7 +951,7 @@ func (s *S) TestAppList(c *gocheck.C) {
 			c.Assert(app.Units[0].Ip, gocheck.Equals, "10.10.10.10")
 		}
 	}
-	action := testing.Action{Action: "app-list", User: s.user.Email}
+	action := testing.Action{Action: "list-apps", User: s.user.Email}
 	c.Assert(action, testing.IsRecorded)
 }
 
@@ -261,9 +1028,9 @@ func (s *S) TestDelete(c *gocheck.C) {
 	c.Assert(h.method[1], gocheck.Equals, "DELETE")
 	c.Assert(string(h.body[1]), gocheck.Equals, "null")
 	action := testing.Action{
-		Action: "app-delete",
+		Action: "remove-app",
 		User:   s.user.Email,
-		Extra:  []interface{}{myApp.Name},
+		Extra:  []interface{}{"app=" + myApp.Name},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -327,9 +1094,9 @@ func (s *S) TestAppInfo(c *gocheck.C) {
 	c.Assert(myApp["name"], gocheck.Equals, expectedApp.Name)
 	c.Assert(myApp["repository"], gocheck.Equals, repository.ReadWriteURL(expectedApp.Name))
 	action := testing.Action{
-		Action: "app-info",
+		Action: "get-app",
 		User:   s.user.Email,
-		Extra:  []interface{}{expectedApp.Name},
+		Extra:  []interface{}{"app=" + expectedApp.Name},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -405,7 +1172,7 @@ func (s *S) TestCreateAppHandler(c *gocheck.C) {
 	action := testing.Action{
 		Action: "create-app",
 		User:   s.user.Email,
-		Extra:  []interface{}{"name=someapp", "platform=zend"},
+		Extra:  []interface{}{"app=someapp", "platform=zend"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -1356,6 +2123,39 @@ func (s *S) TestGetEnvHandlerReturnsAllVariablesIfEnvironmentVariablesAreMissing
 	}
 }
 
+func (s *S) TestGetEnvHandlerDecryptsOnlyPublicVariables(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	a := app.App{Name: "time", Platform: "pink-floyd", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().Remove(bson.M{"appname": a.Name})
+	err = a.SetEnvs([]bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		{Name: "DATABASE_PASSWORD", Value: "secret", Public: false},
+	}, false)
+	c.Assert(err, gocheck.IsNil)
+	var stored app.App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Env["DATABASE_HOST"].Value, gocheck.Not(gocheck.Equals), "localhost")
+	c.Assert(stored.Env["DATABASE_PASSWORD"].Value, gocheck.Not(gocheck.Equals), "secret")
+	request, err := http.NewRequest("GET", "/apps/time/env/?:app=time", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = getEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var got map[string]string
+	err = json.Unmarshal(recorder.Body.Bytes(), &got)
+	c.Assert(err, gocheck.IsNil)
+	expected := map[string]string{
+		"DATABASE_HOST":     "localhost",
+		"DATABASE_PASSWORD": "*** (private variable)",
+	}
+	c.Assert(got, gocheck.DeepEquals, expected)
+}
+
 func (s *S) TestGetEnvHandlerReturnsInternalErrorIfReadAllFails(c *gocheck.C) {
 	b := s.getTestData("bodyToBeClosed.txt")
 	request, err := http.NewRequest("GET", "/apps/unkown/env/?:app=unknown", b)
@@ -1420,13 +2220,10 @@ func (s *S) TestSetEnvHandlerShouldSetAPublicEnvironmentVariableInTheApp(c *goch
 	c.Assert(err, gocheck.IsNil)
 	expected := bind.EnvVar{Name: "DATABASE_HOST", Value: "localhost", Public: true}
 	c.Assert(app.Env["DATABASE_HOST"], gocheck.DeepEquals, expected)
-	envs := map[string]string{
-		"DATABASE_HOST": "localhost",
-	}
 	action := testing.Action{
 		Action: "set-env",
 		User:   s.user.Email,
-		Extra:  []interface{}{"app=" + a.Name, envs},
+		Extra:  []interface{}{"app=" + a.Name, "envs=[DATABASE_HOST]"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -1455,14 +2252,10 @@ func (s *S) TestSetEnvHandlerShouldSetMultipleEnvironmentVariablesInTheApp(c *go
 	expectedUser := bind.EnvVar{Name: "DATABASE_USER", Value: "root", Public: true}
 	c.Assert(app.Env["DATABASE_HOST"], gocheck.DeepEquals, expectedHost)
 	c.Assert(app.Env["DATABASE_USER"], gocheck.DeepEquals, expectedUser)
-	envs := map[string]string{
-		"DATABASE_HOST": "localhost",
-		"DATABASE_USER": "root",
-	}
 	action := testing.Action{
 		Action: "set-env",
 		User:   s.user.Email,
-		Extra:  []interface{}{"app=" + a.Name, envs},
+		Extra:  []interface{}{"app=" + a.Name, "envs=[DATABASE_HOST DATABASE_USER]"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -1544,12 +2337,51 @@ func (s *S) TestSetEnvHandlerReturnsForbiddenIfTheGivenUserDoesNotHaveAccessToTh
 	url := fmt.Sprintf("/apps/%s/env/?:app=%s", a.Name, a.Name)
 	request, err := http.NewRequest("POST", url, strings.NewReader(`{"DATABASE_HOST":"localhost"}`))
 	c.Assert(err, gocheck.IsNil)
-	recorder := httptest.NewRecorder()
-	err = setEnv(recorder, request, s.token)
-	c.Assert(err, gocheck.NotNil)
-	e, ok := err.(*errors.HTTP)
-	c.Assert(ok, gocheck.Equals, true)
-	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+	recorder := httptest.NewRecorder()
+	err = setEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestSetEnvHandlerReturnsForbiddenIfTheUserRoleDoesNotAllowUpdates(c *gocheck.C) {
+	team := auth.Team{Name: "viewers", Users: []string{s.user.Email}, Role: "viewer"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	a := app.App{Name: "rock-and-roll", Teams: []string{team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().Remove(bson.M{"appname": a.Name})
+	url := fmt.Sprintf("/apps/%s/env/?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader(`{"DATABASE_HOST":"localhost"}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+	c.Assert(e.Message, gocheck.Equals, `User does not have the permission "app.update" in this app`)
+}
+
+func (s *S) TestGetEnvHandlerAllowsViewers(c *gocheck.C) {
+	team := auth.Team{Name: "viewers", Users: []string{s.user.Email}, Role: "viewer"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	a := app.App{Name: "rock-and-roll", Teams: []string{team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().Remove(bson.M{"appname": a.Name})
+	request, err := http.NewRequest("GET", fmt.Sprintf("/apps/%s/env?:app=%s", a.Name, a.Name), nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = getEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
 }
 
 func (s *S) TestUnsetEnvHandlerRemovesTheEnvironmentVariablesFromTheApp(c *gocheck.C) {
@@ -1715,6 +2547,131 @@ func (s *S) TestUnsetEnvHandlerReturnsForbiddenIfTheGivenUserDoesNotHaveAccessTo
 	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
 }
 
+func (s *S) TestImportEnvHandler(c *gocheck.C) {
+	a := app.App{
+		Name:  "black-dog",
+		Teams: []string{s.team.Name},
+		Env: map[string]bind.EnvVar{
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+		},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().Remove(bson.M{"appname": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	body := strings.NewReader(`{"envs":{"DATABASE_HOST":"localhost","DATABASE_USER":"root"},"restart":true}`)
+	url := fmt.Sprintf("/apps/%s/env/import?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = importEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Restarts(&a), gocheck.Equals, 1)
+	stored := app.App{Name: a.Name}
+	err = stored.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Env, gocheck.HasLen, 3)
+	c.Assert(stored.Env["DATABASE_HOST"], gocheck.DeepEquals, bind.EnvVar{Name: "DATABASE_HOST", Value: "localhost", Public: true})
+	c.Assert(stored.Env["DATABASE_USER"], gocheck.DeepEquals, bind.EnvVar{Name: "DATABASE_USER", Value: "root", Public: true})
+	action := testing.Action{
+		Action: "import-env",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "envs=[DATABASE_HOST DATABASE_USER]", "restart=true"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestImportEnvHandlerWithoutRestart(c *gocheck.C) {
+	a := app.App{Name: "black-dog", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().Remove(bson.M{"appname": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	body := strings.NewReader(`{"envs":{"DATABASE_HOST":"localhost"}}`)
+	url := fmt.Sprintf("/apps/%s/env/import?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = importEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Restarts(&a), gocheck.Equals, 0)
+}
+
+func (s *S) TestImportEnvHandlerInvalidVariables(c *gocheck.C) {
+	a := app.App{Name: "black-dog", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"envs":{"DATABASE-HOST":"localhost"}}`)
+	url := fmt.Sprintf("/apps/%s/env/import?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = importEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, `Invalid environment variable name: "DATABASE-HOST".`)
+}
+
+func (s *S) TestImportEnvHandlerInvalidJSON(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/apps/black-dog/env/import?:app=black-dog", strings.NewReader("{"))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = importEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestExportEnvHandler(c *gocheck.C) {
+	a := app.App{
+		Name:  "black-dog",
+		Teams: []string{s.team.Name},
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+		},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/env/export?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = exportEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var got map[string]string
+	err = json.NewDecoder(recorder.Body).Decode(&got)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(got, gocheck.DeepEquals, map[string]string{"DATABASE_HOST": "localhost"})
+	action := testing.Action{Action: "export-env", User: s.user.Email, Extra: []interface{}{"app=" + a.Name}}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestExportEnvHandlerForbidden(c *gocheck.C) {
+	a := app.App{Name: "mountain-mama"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/mountain-mama/env/export?:app=mountain-mama", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = exportEnv(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
 func (s *S) TestSetCNameHandler(c *gocheck.C) {
 	a := app.App{Name: "leper", Teams: []string{s.team.Name}}
 	err := s.conn.Apps().Insert(a)
@@ -2013,7 +2970,7 @@ func (s *S) TestAppLogSelectByLines(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(logs, gocheck.HasLen, 10)
 	action := testing.Action{
-		Action: "app-log",
+		Action: "get-app-log",
 		User:   s.user.Email,
 		Extra:  []interface{}{"app=" + a.Name, "lines=10"},
 	}
@@ -2049,7 +3006,7 @@ func (s *S) TestAppLogSelectBySource(c *gocheck.C) {
 	c.Assert(logs[0].Message, gocheck.Equals, "mars log")
 	c.Assert(logs[0].Source, gocheck.Equals, "mars")
 	action := testing.Action{
-		Action: "app-log",
+		Action: "get-app-log",
 		User:   s.user.Email,
 		Extra:  []interface{}{"app=" + a.Name, "lines=10", "source=mars"},
 	}
@@ -2097,6 +3054,120 @@ func (s *S) TestAppLogSelectByLinesShouldReturnTheLastestEntries(c *gocheck.C) {
 	c.Assert(logs[2].Message, gocheck.Equals, "14")
 }
 
+func (s *S) TestAppLogSearch(c *gocheck.C) {
+	a := app.App{
+		Name:     "lost",
+		Platform: "vougan",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	coll := s.conn.Logs()
+	defer coll.RemoveAll(bson.M{"appname": a.Name})
+	date := time.Date(2013, 10, 29, 14, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		l := app.Applog{
+			Date:    date.Add(time.Duration(i) * time.Hour),
+			Message: fmt.Sprintf("GET /%d", i),
+			Source:  "source",
+			AppName: a.Name,
+		}
+		coll.Insert(l)
+	}
+	url := fmt.Sprintf("/apps/%s/log/?:app=%s&lines=2&since=2013-10-29T16:00:00Z&until=2013-10-29T20:00:00Z&regex=[3-6]$", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	var logs []app.Applog
+	err = json.NewDecoder(recorder.Body).Decode(&logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 2)
+	c.Assert(logs[0].Message, gocheck.Equals, "GET /5")
+	c.Assert(logs[1].Message, gocheck.Equals, "GET /6")
+	cursor := recorder.Header().Get("X-Tsuru-Log-Cursor")
+	c.Assert(cursor, gocheck.Not(gocheck.Equals), "")
+	request, err = http.NewRequest("GET", url+"&cursor="+cursor, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder = httptest.NewRecorder()
+	err = appLog(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	logs = nil
+	err = json.NewDecoder(recorder.Body).Decode(&logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 2)
+	c.Assert(logs[0].Message, gocheck.Equals, "GET /3")
+	c.Assert(logs[1].Message, gocheck.Equals, "GET /4")
+}
+
+func (s *S) TestAppLogSearchByMessage(c *gocheck.C) {
+	a := app.App{
+		Name:     "lost",
+		Platform: "vougan",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	a.Log("GET /health 200", "app")
+	a.Log("POST /users 500", "app")
+	url := fmt.Sprintf("/apps/%s/log/?:app=%s&lines=10&message=500", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var logs []app.Applog
+	err = json.NewDecoder(recorder.Body).Decode(&logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "POST /users 500")
+	c.Assert(recorder.Header().Get("X-Tsuru-Log-Cursor"), gocheck.Equals, "")
+	action := testing.Action{
+		Action: "get-app-log",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "lines=10", "message=500"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestAppLogReturnsBadRequestIfDateIsInvalid(c *gocheck.C) {
+	url := "/apps/lost/log/?:app=lost&lines=10&since=yesterday"
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, `Parameter "since" must be a date in the RFC 3339 format.`)
+}
+
+func (s *S) TestAppLogReturnsBadRequestIfRegexIsInvalid(c *gocheck.C) {
+	a := app.App{
+		Name:     "lost",
+		Platform: "vougan",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/log/?:app=%s&lines=10&regex=(", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
 func (s *S) TestAppLogShouldReturnLogByApp(c *gocheck.C) {
 	app1 := app.App{
 		Name:     "app1",
@@ -2242,6 +3313,42 @@ func (s *S) TestBindHandler(c *gocheck.C) {
 	c.Assert(action, testing.IsRecorded)
 }
 
+func (s *S) TestBindHandlerPendingInstance(c *gocheck.C) {
+	srvc := service.Service{Name: "mysql"}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		Teams:       []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := app.App{
+		Name:  "painkiller",
+		Teams: []string{s.team.Name},
+		Units: []app.Unit{{Ip: "127.0.0.1", Machine: 1}},
+	}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/services/instances/%s/%s?:instance=%s&:app=%s", instance.Name, a.Name, instance.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = bindServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusAccepted)
+	c.Assert(recorder.Body.String(), gocheck.Equals, service.ErrInstancePending.Error())
+	err = s.conn.ServiceInstances().Find(bson.M{"name": instance.Name}).One(&instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(instance.Apps, gocheck.HasLen, 0)
+	c.Assert(instance.PendingApps, gocheck.DeepEquals, []string{a.Name})
+}
+
 func (s *S) TestBindHandlerReturns404IfTheInstanceDoesNotExist(c *gocheck.C) {
 	a := app.App{
 		Name:     "serviceApp",
@@ -2307,6 +3414,48 @@ func (s *S) TestBindHandlerReturns404IfTheAppDoesNotExist(c *gocheck.C) {
 	c.Assert(e, gocheck.ErrorMatches, "^App unknown not found.$")
 }
 
+func (s *S) TestGetServiceInstanceSharedWithTheTeamOfTheApp(c *gocheck.C) {
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		Teams:       []string{"otherteam"},
+		SharedTeams: []string{s.team.Name},
+	}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := app.App{Name: "serviceApp", Platform: "django", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	u, err := s.token.User()
+	c.Assert(err, gocheck.IsNil)
+	si, gotApp, err := getServiceInstance(instance.Name, a.Name, u)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(si.Name, gocheck.Equals, instance.Name)
+	c.Assert(gotApp.Name, gocheck.Equals, a.Name)
+}
+
+func (s *S) TestGetServiceInstanceAllowsUnbindingAfterRevoke(c *gocheck.C) {
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		Teams:       []string{"otherteam"},
+		Apps:        []string{"serviceApp"},
+	}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := app.App{Name: "serviceApp", Platform: "django", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	u, err := s.token.User()
+	c.Assert(err, gocheck.IsNil)
+	_, _, err = getServiceInstance(instance.Name, a.Name, u)
+	c.Assert(err, gocheck.IsNil)
+}
+
 func (s *S) TestBindHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *gocheck.C) {
 	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
 	err := instance.Create()
@@ -2529,9 +3678,9 @@ func (s *S) TestRestartHandler(c *gocheck.C) {
 	c.Assert(result, gocheck.Matches, ".*# ---> Restarting your app#.*")
 	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "text")
 	action := testing.Action{
-		Action: "restart",
+		Action: "restart-app",
 		User:   s.user.Email,
-		Extra:  []interface{}{a.Name},
+		Extra:  []interface{}{"app=" + a.Name},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -2623,6 +3772,29 @@ func (s *S) TestAddLogHandler(c *gocheck.C) {
 	c.Assert(gotSource, gocheck.DeepEquals, wantSource)
 }
 
+func (s *S) TestAddLogHandlerWithUnit(c *gocheck.C) {
+	a := app.App{
+		Name:     "myapp",
+		Platform: "zend",
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	body := strings.NewReader(`["message 1"]`)
+	request, err := http.NewRequest("POST", "/apps/myapp/log/?:app=myapp&unit=myapp/0", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addLog(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	logs, err := a.LastLogs(1, "")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Source, gocheck.Equals, "app")
+	c.Assert(logs[0].Unit, gocheck.Equals, "myapp/0")
+}
+
 func (s *S) TestPlatformList(c *gocheck.C) {
 	platforms := []app.Platform{
 		{Name: "python"},
@@ -2645,7 +3817,7 @@ func (s *S) TestPlatformList(c *gocheck.C) {
 	err = json.NewDecoder(recorder.Body).Decode(&got)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(got, gocheck.DeepEquals, want)
-	action := testing.Action{Action: "platform-list", User: s.user.Email}
+	action := testing.Action{Action: "list-platforms", User: s.user.Email}
 	c.Assert(action, testing.IsRecorded)
 }
 
@@ -2669,7 +3841,7 @@ func (s *S) TestgetAppOrErrorWhenUserIsAdmin(c *gocheck.C) {
 		err = s.conn.Users().Remove(bson.M{"email": admin.Email})
 		c.Assert(err, gocheck.IsNil)
 	}(admin, adminTeam)
-	app, err := getApp(a.Name, &admin)
+	app, err := getApp(a.Name, &admin, auth.PermAppAdmin)
 	c.Assert(err, gocheck.IsNil)
 	err = a.Get()
 	c.Assert(err, gocheck.IsNil)
@@ -2689,6 +3861,26 @@ func (s *S) TestSwap(c *gocheck.C) {
 	recorder := httptest.NewRecorder()
 	err = swap(recorder, request, s.token)
 	c.Assert(err, gocheck.IsNil)
-	action := testing.Action{Action: "swap", User: s.user.Email, Extra: []interface{}{"app1", "app2"}}
+	action := testing.Action{Action: "swap-apps", User: s.user.Email, Extra: []interface{}{"app=app1", "app=app2"}}
 	c.Assert(action, testing.IsRecorded)
 }
+
+func (s *S) TestSwapRejectedDeploy(c *gocheck.C) {
+	app1 := app.App{
+		Name:      "app1",
+		Teams:     []string{s.team.Name},
+		BlueGreen: &app.BlueGreen{Standby: "app1-standby", Rejected: "abc"},
+	}
+	app2 := app.App{Name: "app1-standby", Teams: []string{s.team.Name}, Primary: "app1"}
+	err := s.conn.Apps().Insert(&app1, &app2)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{app1.Name, app2.Name}}})
+	request, _ := http.NewRequest("PUT", "/swap?app1=app1&app2=app1-standby", nil)
+	recorder := httptest.NewRecorder()
+	err = swap(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusConflict)
+	c.Assert(e.Message, gocheck.Equals, app.ErrRejectedDeploy.Error())
+}
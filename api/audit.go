@@ -0,0 +1,111 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/auth"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/rec"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// auditFilter builds the filter of recorded actions from the parameters of
+// the request. Dates are expected in the RFC 3339 format.
+func auditFilter(r *http.Request) (*rec.Filter, error) {
+	query := r.URL.Query()
+	filter := rec.Filter{
+		User:   query.Get("user"),
+		Action: query.Get("action"),
+		App:    query.Get("app"),
+		Cursor: query.Get("cursor"),
+	}
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if v := query.Get(param.name); v != "" {
+			date, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				msg := fmt.Sprintf(`Parameter %q must be a date in the RFC 3339 format.`, param.name)
+				return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+			}
+			*param.value = date
+		}
+	}
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			msg := `Parameter "limit" must be a positive integer.`
+			return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+		}
+		filter.Limit = limit
+	}
+	return &filter, nil
+}
+
+func auditExtra(f *rec.Filter) []interface{} {
+	var extra []interface{}
+	for _, param := range []struct {
+		name  string
+		value string
+	}{{"user", f.User}, {"action", f.Action}, {"app", f.App}, {"cursor", f.Cursor}} {
+		if param.value != "" {
+			extra = append(extra, param.name+"="+param.value)
+		}
+	}
+	return extra
+}
+
+func writeAudit(w http.ResponseWriter, f *rec.Filter) error {
+	actions, cursor, err := rec.Search(f)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	} else if err != nil {
+		return err
+	}
+	if cursor != "" {
+		w.Header().Set("X-Tsuru-Audit-Cursor", cursor)
+	}
+	if actions == nil {
+		actions = []rec.Action{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(actions)
+}
+
+// listAudit returns the actions recorded for all users. Only admin users are
+// able to use it.
+func listAudit(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	filter, err := auditFilter(r)
+	if err != nil {
+		return err
+	}
+	rec.Log(t.UserEmail, "list-audit", auditExtra(filter)...)
+	return writeAudit(w, filter)
+}
+
+// appAudit returns the actions recorded for an app. Any user with access to
+// the app is able to use it.
+func appAudit(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	filter, err := auditFilter(r)
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	a, err := getApp(r.URL.Query().Get(":app"), u, auth.PermAppRead)
+	if err != nil {
+		return err
+	}
+	filter.App = a.Name
+	rec.Log(u.Email, "list-audit", auditExtra(filter)...)
+	return writeAudit(w, filter)
+}
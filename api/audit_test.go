@@ -0,0 +1,174 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/rec"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) insertAuditActions(c *gocheck.C) {
+	base := time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC)
+	actions := []rec.Action{
+		{User: "auditor@tsuru.io", Action: "create-app", Extra: []interface{}{"app=audited", "platform=python"}, Date: base},
+		{User: "auditor@tsuru.io", Action: "set-env", Extra: []interface{}{"app=audited", "envs=[DATABASE_HOST]"}, Date: base.Add(time.Minute)},
+		{User: "auditor@tsuru.io", Action: "create-app", Extra: []interface{}{"app=unaudited", "platform=ruby"}, Date: base.Add(2 * time.Minute)},
+	}
+	for _, a := range actions {
+		err := s.conn.UserActions().Insert(a)
+		c.Assert(err, gocheck.IsNil)
+	}
+}
+
+func (s *S) removeAuditActions() {
+	s.conn.UserActions().RemoveAll(bson.M{"user": "auditor@tsuru.io"})
+}
+
+func (s *S) TestListAudit(c *gocheck.C) {
+	s.insertAuditActions(c)
+	defer s.removeAuditActions()
+	request, err := http.NewRequest("GET", "/audit?user=auditor@tsuru.io&action=create-app", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	c.Assert(recorder.Header().Get("X-Tsuru-Audit-Cursor"), gocheck.Equals, "")
+	var actions []rec.Action
+	err = json.NewDecoder(recorder.Body).Decode(&actions)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actions, gocheck.HasLen, 2)
+	c.Assert(actions[0].Extra, gocheck.DeepEquals, []interface{}{"app=unaudited", "platform=ruby"})
+	c.Assert(actions[1].Extra, gocheck.DeepEquals, []interface{}{"app=audited", "platform=python"})
+	action := testing.Action{
+		Action: "list-audit",
+		User:   s.user.Email,
+		Extra:  []interface{}{"user=auditor@tsuru.io", "action=create-app"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestListAuditWithTimeRangeAndLimit(c *gocheck.C) {
+	s.insertAuditActions(c)
+	defer s.removeAuditActions()
+	url := "/audit?user=auditor@tsuru.io&since=2013-11-20T10:00:00Z&until=2013-11-20T10:01:00Z&limit=1"
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var actions []rec.Action
+	err = json.NewDecoder(recorder.Body).Decode(&actions)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actions, gocheck.HasLen, 1)
+	c.Assert(actions[0].Action, gocheck.Equals, "set-env")
+	cursor := recorder.Header().Get("X-Tsuru-Audit-Cursor")
+	c.Assert(cursor, gocheck.Not(gocheck.Equals), "")
+	request, err = http.NewRequest("GET", url+"&cursor="+cursor, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder = httptest.NewRecorder()
+	err = listAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	err = json.NewDecoder(recorder.Body).Decode(&actions)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actions, gocheck.HasLen, 1)
+	c.Assert(actions[0].Action, gocheck.Equals, "create-app")
+}
+
+func (s *S) TestListAuditInvalidDate(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/audit?since=yesterday", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, `Parameter "since" must be a date in the RFC 3339 format.`)
+}
+
+func (s *S) TestListAuditInvalidLimit(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/audit?limit=-1", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestListAuditInvalidCursor(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/audit?cursor=invalid", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, "Invalid cursor.")
+}
+
+func (s *S) TestAppAudit(c *gocheck.C) {
+	a := app.App{Name: "audited", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.insertAuditActions(c)
+	defer s.removeAuditActions()
+	// the app filter can't be overridden by the user.
+	request, err := http.NewRequest("GET", "/apps/audited/audit?:app=audited&app=unaudited&user=auditor@tsuru.io", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var actions []rec.Action
+	err = json.NewDecoder(recorder.Body).Decode(&actions)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actions, gocheck.HasLen, 2)
+	c.Assert(actions[0].Action, gocheck.Equals, "set-env")
+	c.Assert(actions[1].Action, gocheck.Equals, "create-app")
+	action := testing.Action{
+		Action: "list-audit",
+		User:   s.user.Email,
+		Extra:  []interface{}{"user=auditor@tsuru.io", "app=audited"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestAppAuditWithoutAccess(c *gocheck.C) {
+	a := app.App{Name: "audited", Teams: []string{}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/audited/audit?:app=audited", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAppAuditAppNotFound(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/apps/unknown/audit?:app=unknown", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appAudit(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
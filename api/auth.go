@@ -65,52 +65,165 @@ func createUser(w http.ResponseWriter, r *http.Request) error {
 }
 
 func login(w http.ResponseWriter, r *http.Request) error {
-	var pass map[string]string
-	err := json.NewDecoder(r.Body).Decode(&pass)
+	var params map[string]string
+	err := json.NewDecoder(r.Body).Decode(&params)
 	if err != nil {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid JSON"}
 	}
-	password, ok := pass["password"]
-	if !ok {
-		msg := "You must provide a password to login"
-		return &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+	if params == nil {
+		params = make(map[string]string)
 	}
-	u, err := auth.GetUserByEmail(r.URL.Query().Get(":email"))
-	if err != nil {
-		if e, ok := err.(*errors.ValidationError); ok {
-			return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
-		} else if err == auth.ErrUserNotFound {
-			return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	if email := r.URL.Query().Get(":email"); email != "" {
+		params["email"] = email
+		if _, ok := params["password"]; !ok {
+			msg := "You must provide a password to login"
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
 		}
-		return err
 	}
-	rec.Log(u.Email, "login")
-	t, err := u.CreateToken(password)
-	if err != nil {
-		switch err.(type) {
-		case *errors.ValidationError:
-			return &errors.HTTP{
-				Code:    http.StatusBadRequest,
-				Message: err.(*errors.ValidationError).Message,
+	var u *auth.User
+	handle := params["login"]
+	if handle != "" {
+		// Second step of a login with two-factor authentication: the user
+		// was already authenticated by the scheme.
+		if u, err = auth.GetPendingLogin(handle); err != nil {
+			return &errors.HTTP{Code: http.StatusUnauthorized, Message: auth.ErrInvalidLogin.Error()}
+		}
+	} else {
+		u, err = auth.Login(params, provisionUser)
+		if err != nil {
+			switch e := err.(type) {
+			case *errors.ValidationError:
+				return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+			case auth.AuthenticationFailure:
+				return &errors.HTTP{Code: http.StatusUnauthorized, Message: e.Error()}
 			}
-		case auth.AuthenticationFailure:
-			return &errors.HTTP{
-				Code:    http.StatusUnauthorized,
-				Message: err.Error(),
+			if err == auth.ErrUserNotFound {
+				return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+			}
+			if err == auth.ErrInvalidLogin {
+				return &errors.HTTP{Code: http.StatusUnauthorized, Message: err.Error()}
 			}
-		default:
 			return err
 		}
 	}
+	if err = checkLoginTOTP(w, u, params["otp"]); err != nil {
+		if e, ok := err.(*errors.HTTP); ok && e.Code == http.StatusUnauthorized {
+			if handle == "" {
+				var createErr error
+				if handle, createErr = auth.CreatePendingLogin(u); createErr != nil {
+					return createErr
+				}
+			}
+			w.Header().Set(loginHeader, handle)
+		}
+		return err
+	}
+	if handle != "" {
+		auth.DeletePendingLogin(handle)
+	}
+	rec.Log(u.Email, "login")
+	t, err := u.CreateSessionToken()
+	if err != nil {
+		return err
+	}
 	fmt.Fprintf(w, `{"token":"%s"}`, t.Token)
 	return nil
 }
 
+// provisionUser creates users authenticated by external authentication
+// schemes on their first login, just like createUser does.
+func provisionUser(email string) (*auth.User, error) {
+	gURL := repository.ServerURL()
+	c := gandalf.Client{Endpoint: gURL}
+	if _, err := c.NewUser(email, nil); err != nil {
+		return nil, fmt.Errorf("Failed to create user in the git server: %s", err)
+	}
+	// Provisioned users don't have a password, so they can't login using the
+	// native scheme.
+	u := auth.User{Email: email}
+	if err := u.Create(); err != nil {
+		return nil, err
+	}
+	rec.Log(u.Email, "create-user", "scheme="+auth.SchemeName())
+	if limit, err := config.GetUint("quota:apps-per-user"); err == nil {
+		quota.Create(u.Email, uint(limit))
+	}
+	return &u, nil
+}
+
+func authScheme(w http.ResponseWriter, r *http.Request) error {
+	scheme, err := auth.GetScheme()
+	if err != nil {
+		return err
+	}
+	info, err := scheme.Info()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{"name": auth.SchemeName(), "data": info})
+}
+
 func logout(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	rec.Log(t.UserEmail, "logout")
 	auth.DeleteToken(t.Token)
 	return nil
 }
 
+func listTokens(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "list-tokens")
+	tokens, err := auth.ListTokens(u)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(tokens)
+}
+
+func revokeToken(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	id := r.URL.Query().Get(":id")
+	rec.Log(u.Email, "revoke-token", "token="+id)
+	err = auth.RevokeToken(u, id)
+	if err == auth.ErrTokenNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
+func createAPIToken(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	var params map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid JSON"}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	name := params["name"]
+	rec.Log(u.Email, "create-api-token", "name="+name)
+	token, err := u.CreateAPIToken(name)
+	switch err {
+	case nil:
+	case auth.ErrInvalidTokenName:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	case auth.ErrTokenAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	default:
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(token)
+}
+
 // ChangePassword changes the password from the logged in user.
 //
 // It reads the request body in JSON format. The JSON in the request body
@@ -199,7 +312,7 @@ func createTeam(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "create-team", name)
+	rec.Log(u.Email, "create-team", "team="+name)
 	err = auth.CreateTeam(name, u)
 	switch err {
 	case auth.ErrInvalidTeamName:
@@ -218,13 +331,18 @@ func removeTeam(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	}
 	defer conn.Close()
 	name := r.URL.Query().Get(":name")
-	rec.Log(t.UserEmail, "remove-team", name)
+	rec.Log(t.UserEmail, "remove-team", "team="+name)
 	if n, err := conn.Apps().Find(bson.M{"teams": name}).Count(); err != nil || n > 0 {
 		msg := `This team cannot be removed because it have access to apps.
 
 Please remove the apps or revoke these accesses, and try again.`
 		return &errors.HTTP{Code: http.StatusForbidden, Message: msg}
 	}
+	if team, err := auth.GetTeam(name); err == nil {
+		if err := checkTeamAdmin(team, &auth.User{Email: t.UserEmail}); err != nil {
+			return err
+		}
+	}
 	query := bson.M{"_id": name, "users": t.UserEmail}
 	err = conn.Teams().Remove(query)
 	if err != nil && err.Error() == "not found" {
@@ -310,6 +428,9 @@ func addUserToTeam(w http.ResponseWriter, r *http.Request, t *auth.Token) error
 		msg := fmt.Sprintf("You are not authorized to add new users to the team %s", team.Name)
 		return &errors.HTTP{Code: http.StatusUnauthorized, Message: msg}
 	}
+	if err := checkTeamAdmin(team, u); err != nil {
+		return err
+	}
 	user, err := auth.GetUserByEmail(email)
 	if err != nil {
 		return &errors.HTTP{Code: http.StatusNotFound, Message: "User not found"}
@@ -367,6 +488,9 @@ func removeUserFromTeam(w http.ResponseWriter, r *http.Request, t *auth.Token) e
 		msg := fmt.Sprintf("You are not authorized to remove a member from the team %s", team.Name)
 		return &errors.HTTP{Code: http.StatusUnauthorized, Message: msg}
 	}
+	if err := checkTeamAdmin(team, u); err != nil {
+		return err
+	}
 	if len(team.Users) == 1 {
 		msg := "You can not remove this user from this team, because it is the last user within the team, and a team can not be orphaned"
 		return &errors.HTTP{Code: http.StatusForbidden, Message: msg}
@@ -382,13 +506,114 @@ func removeUserFromTeam(w http.ResponseWriter, r *http.Request, t *auth.Token) e
 	return removeUserFromTeamInDatabase(user, team)
 }
 
+// checkTeamAdmin returns an error if the user is not allowed to manage the
+// team. Users that are not members of the team are handled by the callers.
+func checkTeamAdmin(team *auth.Team, u *auth.User) error {
+	if role := team.RoleOf(u); role != nil && !role.Allows(auth.PermTeamAdmin) {
+		msg := fmt.Sprintf("You are not allowed to manage the team %s", team.Name)
+		return &errors.HTTP{Code: http.StatusForbidden, Message: msg}
+	}
+	return nil
+}
+
+func listRoles(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(auth.Roles)
+}
+
+func getRoleFromBody(r *http.Request) (string, error) {
+	var params map[string]string
+	if r.Body == nil {
+		return "", &errors.HTTP{Code: http.StatusBadRequest, Message: "Missing role."}
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		return "", &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return params["role"], nil
+}
+
+// getManagedTeam returns the team, ensuring that the user is allowed to
+// manage it.
+func getManagedTeam(teamName string, u *auth.User) (*auth.Team, error) {
+	team, err := auth.GetTeam(teamName)
+	if err != nil {
+		return nil, &errors.HTTP{Code: http.StatusNotFound, Message: "Team not found"}
+	}
+	if !team.ContainsUser(u) {
+		return nil, &errors.HTTP{Code: http.StatusForbidden, Message: "User is not member of this team"}
+	}
+	if err := checkTeamAdmin(team, u); err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+func setTeamRole(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	teamName := r.URL.Query().Get(":team")
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	role, err := getRoleFromBody(r)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "set-team-role", "team="+teamName, "role="+role)
+	team, err := getManagedTeam(teamName, u)
+	if err != nil {
+		return err
+	}
+	if err := team.SetRole(role); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Teams().UpdateId(team.Name, team)
+}
+
+func setTeamUserRole(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	teamName := r.URL.Query().Get(":team")
+	email := r.URL.Query().Get(":user")
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	role, err := getRoleFromBody(r)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "set-team-user-role", "team="+teamName, "user="+email, "role="+role)
+	team, err := getManagedTeam(teamName, u)
+	if err != nil {
+		return err
+	}
+	user := auth.User{Email: email}
+	if !team.ContainsUser(&user) {
+		msg := fmt.Sprintf("User %s is not member of the team %s", email, team.Name)
+		return &errors.HTTP{Code: http.StatusNotFound, Message: msg}
+	}
+	if err := team.SetUserRole(&user, role); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Teams().UpdateId(team.Name, team)
+}
+
 func getTeam(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	teamName := r.URL.Query().Get(":name")
 	user, err := t.User()
 	if err != nil {
 		return err
 	}
-	rec.Log(user.Email, "get-team", teamName)
+	rec.Log(user.Email, "get-team", "team="+teamName)
 	team, err := auth.GetTeam(teamName)
 	if err != nil {
 		return &errors.HTTP{Code: http.StatusNotFound, Message: "Team not found"}
@@ -446,7 +671,7 @@ func addKeyToUser(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "add-key", content)
+	rec.Log(u.Email, "add-key", "key="+content)
 	key := auth.Key{Content: content}
 	if u.HasKey(key) {
 		return &errors.HTTP{Code: http.StatusConflict, Message: "User already has this key"}
@@ -491,7 +716,7 @@ func removeKeyFromUser(w http.ResponseWriter, r *http.Request, t *auth.Token) er
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "remove-key", content)
+	rec.Log(u.Email, "remove-key", "key="+content)
 	key, index := u.FindKey(auth.Key{Content: content})
 	if index < 0 {
 		return &errors.HTTP{Code: http.StatusNotFound, Message: "User does not have this key"}
@@ -598,6 +823,7 @@ func generateAppToken(w http.ResponseWriter, r *http.Request, t *auth.Token) err
 			Message: "Missing client name in JSON body",
 		}
 	}
+	rec.Log(t.UserEmail, "generate-app-token", "app="+body.Client, fmt.Sprintf("export=%t", body.Export))
 	token, err := auth.CreateApplicationToken(body.Client)
 	if err != nil {
 		return err
@@ -354,6 +354,93 @@ func (s *AuthSuite) TestLoginShouldCreateTokenInTheDatabaseAndReturnItWithinTheR
 	c.Assert(action, testing.IsRecorded)
 }
 
+type fakeScheme struct{}
+
+func (fakeScheme) Login(params map[string]string) (string, error) {
+	if params["code"] != "good-code" {
+		return "", auth.AuthenticationFailure{}
+	}
+	return "newcomer@globo.com", nil
+}
+
+func (fakeScheme) Info() (map[string]string, error) {
+	return map[string]string{"authorizeUrl": "http://auth.globo.com/authorize"}, nil
+}
+
+func (s *AuthSuite) TestLoginWithAuthSchemeProvisionsTheUser(c *gocheck.C) {
+	auth.RegisterScheme("fake", fakeScheme{})
+	config.Set("auth:scheme", "fake")
+	defer config.Unset("auth:scheme")
+	h := testHandler{}
+	ts := s.startGandalfTestServer(&h)
+	defer ts.Close()
+	b := bytes.NewBufferString(`{"code":"good-code","redirectUrl":"http://localhost:35666"}`)
+	request, err := http.NewRequest("POST", "/auth/login", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = login(recorder, request)
+	c.Assert(err, gocheck.IsNil)
+	var result map[string]string
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, gocheck.IsNil)
+	t, err := auth.GetToken("bearer " + result["token"])
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.UserEmail, gocheck.Equals, "newcomer@globo.com")
+	u, err := auth.GetUserByEmail("newcomer@globo.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.CheckPassword("123456"), gocheck.NotNil)
+	c.Assert(h.url[0], gocheck.Equals, "/user")
+	c.Assert(h.method[0], gocheck.Equals, "POST")
+	action := testing.Action{Action: "login", User: "newcomer@globo.com"}
+	c.Assert(action, testing.IsRecorded)
+	action = testing.Action{Action: "create-user", User: "newcomer@globo.com", Extra: []interface{}{"scheme=fake"}}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *AuthSuite) TestLoginWithAuthSchemeFailure(c *gocheck.C) {
+	auth.RegisterScheme("fake", fakeScheme{})
+	config.Set("auth:scheme", "fake")
+	defer config.Unset("auth:scheme")
+	b := bytes.NewBufferString(`{"code":"bad-code","redirectUrl":"http://localhost:35666"}`)
+	request, err := http.NewRequest("POST", "/auth/login", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = login(recorder, request)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusUnauthorized)
+}
+
+func (s *AuthSuite) TestAuthScheme(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/auth/scheme", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = authScheme(recorder, request)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	c.Assert(recorder.Body.String(), gocheck.Equals, `{"data":null,"name":"native"}`+"\n")
+}
+
+func (s *AuthSuite) TestAuthSchemeWithInfo(c *gocheck.C) {
+	auth.RegisterScheme("fake", fakeScheme{})
+	config.Set("auth:scheme", "fake")
+	defer config.Unset("auth:scheme")
+	request, err := http.NewRequest("GET", "/auth/scheme", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = authScheme(recorder, request)
+	c.Assert(err, gocheck.IsNil)
+	var result struct {
+		Name string
+		Data map[string]string
+	}
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result.Name, gocheck.Equals, "fake")
+	c.Assert(result.Data, gocheck.DeepEquals, map[string]string{"authorizeUrl": "http://auth.globo.com/authorize"})
+}
+
 func (s *AuthSuite) TestLoginShouldReturnErrorAndBadRequestIfItReceivesAnInvalidJSON(c *gocheck.C) {
 	b := bytes.NewBufferString(`"invalid":"json"]`)
 	request, err := http.NewRequest("POST", "/users/nobody@globo.com/tokens?:email=nobody@globo.com", b)
@@ -473,6 +560,98 @@ func (s *AuthSuite) TestLogout(c *gocheck.C) {
 	c.Assert(err, gocheck.Equals, auth.ErrInvalidToken)
 }
 
+func (s *AuthSuite) TestListTokens(c *gocheck.C) {
+	token, err := s.user.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	defer auth.DeleteToken(token.Token)
+	request, err := http.NewRequest("GET", "/users/tokens", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listTokens(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var tokens []auth.Token
+	err = json.NewDecoder(recorder.Body).Decode(&tokens)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(len(tokens) >= 2, gocheck.Equals, true)
+	for _, t := range tokens {
+		c.Check(t.Token, gocheck.Equals, "")
+		c.Check(t.UserEmail, gocheck.Equals, s.user.Email)
+	}
+	action := testing.Action{Action: "list-tokens", User: s.user.Email}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *AuthSuite) TestRevokeToken(c *gocheck.C) {
+	token, err := s.user.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	defer auth.DeleteToken(token.Token)
+	conn, _ := db.Conn()
+	defer conn.Close()
+	var stored auth.Token
+	err = conn.Tokens().Find(bson.M{"token": token.Token}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	id := stored.ID.Hex()
+	request, err := http.NewRequest("DELETE", "/users/tokens/"+id+"?:id="+id, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = revokeToken(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	_, err = auth.GetToken("bearer " + token.Token)
+	c.Assert(err, gocheck.Equals, auth.ErrInvalidToken)
+	action := testing.Action{Action: "revoke-token", User: s.user.Email, Extra: []interface{}{"token=" + id}}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *AuthSuite) TestRevokeTokenNotFound(c *gocheck.C) {
+	id := bson.NewObjectId().Hex()
+	request, err := http.NewRequest("DELETE", "/users/tokens/"+id+"?:id="+id, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = revokeToken(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestCreateAPIToken(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/users/tokens", strings.NewReader(`{"name":"ci"}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = createAPIToken(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusCreated)
+	var token auth.Token
+	err = json.NewDecoder(recorder.Body).Decode(&token)
+	c.Assert(err, gocheck.IsNil)
+	defer auth.DeleteToken(token.Token)
+	c.Assert(token.Name, gocheck.Equals, "ci")
+	t, err := auth.GetToken("bearer " + token.Token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.UserEmail, gocheck.Equals, s.user.Email)
+	action := testing.Action{Action: "create-api-token", User: s.user.Email, Extra: []interface{}{"name=ci"}}
+	c.Assert(action, testing.IsRecorded)
+	request, err = http.NewRequest("POST", "/users/tokens", strings.NewReader(`{"name":"ci"}`))
+	c.Assert(err, gocheck.IsNil)
+	err = createAPIToken(httptest.NewRecorder(), request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusConflict)
+}
+
+func (s *AuthSuite) TestCreateAPITokenInvalidName(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/users/tokens", strings.NewReader(`{"name":"my token"}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = createAPIToken(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
 func (s *AuthSuite) TestCreateTeamHandlerSavesTheTeamInTheDatabaseWithTheAuthenticatedUser(c *gocheck.C) {
 	b := bytes.NewBufferString(`{"name":"timeredbull"}`)
 	request, err := http.NewRequest("POST", "/teams", b)
@@ -491,7 +670,7 @@ func (s *AuthSuite) TestCreateTeamHandlerSavesTheTeamInTheDatabaseWithTheAuthent
 	action := testing.Action{
 		Action: "create-team",
 		User:   s.user.Email,
-		Extra:  []interface{}{"timeredbull"},
+		Extra:  []interface{}{"team=timeredbull"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -566,7 +745,7 @@ func (s *AuthSuite) TestRemoveTeam(c *gocheck.C) {
 	action := testing.Action{
 		Action: "remove-team",
 		User:   s.user.Email,
-		Extra:  []interface{}{team.Name},
+		Extra:  []interface{}{"team=" + team.Name},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -1034,6 +1213,153 @@ func (s *AuthSuite) TestRemoveUserFromTeamInGandalf(c *gocheck.C) {
 	c.Assert(h.url[0], gocheck.Equals, "/repository/revoke")
 }
 
+func (s *AuthSuite) TestAddUserToTeamShouldReturnForbiddenIfTheUserIsNotTeamAdmin(c *gocheck.C) {
+	h := testHandler{}
+	ts := s.startGandalfTestServer(&h)
+	defer ts.Close()
+	conn, _ := db.Conn()
+	defer conn.Close()
+	team := auth.Team{Name: "deployers", Users: []string{s.user.Email}, Role: "deployer"}
+	err := conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Teams().RemoveId(team.Name)
+	u := &auth.User{Email: "wolverine@xmen.com", Password: "123456"}
+	err = u.Create()
+	c.Assert(err, gocheck.IsNil)
+	url := "/teams/deployers/wolverine@xmen.com?:team=deployers&:user=wolverine@xmen.com"
+	request, err := http.NewRequest("PUT", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addUserToTeam(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+	c.Assert(e.Message, gocheck.Equals, "You are not allowed to manage the team deployers")
+}
+
+func (s *AuthSuite) TestListRoles(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/roles", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listRoles(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var roles []auth.Role
+	err = json.NewDecoder(recorder.Body).Decode(&roles)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(roles, gocheck.DeepEquals, auth.Roles)
+}
+
+func (s *AuthSuite) TestSetTeamRole(c *gocheck.C) {
+	conn, _ := db.Conn()
+	defer conn.Close()
+	team := auth.Team{Name: "roled", Users: []string{s.user.Email}}
+	err := conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Teams().RemoveId(team.Name)
+	body := strings.NewReader(`{"role":"deployer"}`)
+	request, err := http.NewRequest("PUT", "/teams/roled/role?:team=roled", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamRole(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	t, err := auth.GetTeam(team.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.Role, gocheck.Equals, "deployer")
+	action := testing.Action{
+		Action: "set-team-role",
+		User:   s.user.Email,
+		Extra:  []interface{}{"team=roled", "role=deployer"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *AuthSuite) TestSetTeamRoleInvalidRole(c *gocheck.C) {
+	body := strings.NewReader(`{"role":"superuser"}`)
+	request, err := http.NewRequest("PUT", "/teams/tsuruteam/role?:team=tsuruteam", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamRole(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, auth.ErrRoleNotFound.Error())
+}
+
+func (s *AuthSuite) TestSetTeamRoleTeamNotFound(c *gocheck.C) {
+	body := strings.NewReader(`{"role":"viewer"}`)
+	request, err := http.NewRequest("PUT", "/teams/unknown/role?:team=unknown", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamRole(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestSetTeamRoleForbiddenIfTheUserIsNotTeamAdmin(c *gocheck.C) {
+	conn, _ := db.Conn()
+	defer conn.Close()
+	team := auth.Team{Name: "roled", Users: []string{s.user.Email}, Role: "app-admin"}
+	err := conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Teams().RemoveId(team.Name)
+	body := strings.NewReader(`{"role":"team-admin"}`)
+	request, err := http.NewRequest("PUT", "/teams/roled/role?:team=roled", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamRole(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+	t, err := auth.GetTeam(team.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.Role, gocheck.Equals, "app-admin")
+}
+
+func (s *AuthSuite) TestSetTeamUserRole(c *gocheck.C) {
+	conn, _ := db.Conn()
+	defer conn.Close()
+	team := auth.Team{Name: "roled", Users: []string{s.user.Email, "wolverine@xmen.com"}}
+	err := conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Teams().RemoveId(team.Name)
+	body := strings.NewReader(`{"role":"viewer"}`)
+	url := "/teams/roled/wolverine@xmen.com/role?:team=roled&:user=wolverine@xmen.com"
+	request, err := http.NewRequest("PUT", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamUserRole(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	t, err := auth.GetTeam(team.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.UserRoles, gocheck.DeepEquals, []auth.UserRole{{Email: "wolverine@xmen.com", Role: "viewer"}})
+	action := testing.Action{
+		Action: "set-team-user-role",
+		User:   s.user.Email,
+		Extra:  []interface{}{"team=roled", "user=wolverine@xmen.com", "role=viewer"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *AuthSuite) TestSetTeamUserRoleUserNotInTheTeam(c *gocheck.C) {
+	body := strings.NewReader(`{"role":"viewer"}`)
+	url := "/teams/tsuruteam/wolverine@xmen.com/role?:team=tsuruteam&:user=wolverine@xmen.com"
+	request, err := http.NewRequest("PUT", url, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamUserRole(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+	c.Assert(e.Message, gocheck.Equals, "User wolverine@xmen.com is not member of the team tsuruteam")
+}
+
 func (s *AuthSuite) TestGetTeam(c *gocheck.C) {
 	team, err := auth.GetTeam(s.team.Name)
 	c.Assert(err, gocheck.IsNil)
@@ -1051,7 +1377,7 @@ func (s *AuthSuite) TestGetTeam(c *gocheck.C) {
 	action := testing.Action{
 		User:   s.user.Email,
 		Action: "get-team",
-		Extra:  []interface{}{team.Name},
+		Extra:  []interface{}{"team=" + team.Name},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -1110,7 +1436,7 @@ func (s *AuthSuite) TestAddKeyToUserAddsAKeyToTheUser(c *gocheck.C) {
 	action := testing.Action{
 		Action: "add-key",
 		User:   s.user.Email,
-		Extra:  []interface{}{"my-key"},
+		Extra:  []interface{}{"key=my-key"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -1344,7 +1670,7 @@ func (s *AuthSuite) TestRemoveKeyHandlerRemovesTheKeyFromTheUser(c *gocheck.C) {
 	action := testing.Action{
 		Action: "remove-key",
 		User:   s.user.Email,
-		Extra:  []interface{}{"my-key"},
+		Extra:  []interface{}{"key=my-key"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/globocom/tsuru/io"
 	"github.com/globocom/tsuru/log"
 	"net/http"
+	"strings"
 )
 
 const (
@@ -36,10 +37,14 @@ func (fn handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}()
 	fw := io.FlushingWriter{ResponseWriter: w}
 	if err := fn(&fw, r); err != nil {
+		code := http.StatusInternalServerError
+		if e, ok := err.(*errors.HTTP); ok {
+			code = e.Code
+		}
 		if fw.Wrote() {
 			fmt.Fprintln(&fw, err)
 		} else {
-			http.Error(&fw, err.Error(), http.StatusInternalServerError)
+			http.Error(&fw, err.Error(), code)
 		}
 		log.Print(err)
 	}
@@ -61,9 +66,50 @@ func validate(token string, r *http.Request) (*auth.Token, error) {
 			return nil, invalid
 		}
 	}
+	if err := t.Use(r.UserAgent()); err != nil {
+		log.Printf("Failed to record the use of the token: %s", err)
+	}
+	if t.AppName == "" && !totpExempt(r) {
+		if err := checkTOTPRequirement(t); err != nil {
+			return nil, err
+		}
+	}
 	return t, nil
 }
 
+// totpExempt checks whether the request is allowed for users that must
+// enable two-factor authentication but didn't yet: they're still able to
+// enroll and to logout.
+func totpExempt(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/users/totp") {
+		return true
+	}
+	return r.URL.Path == "/users/tokens" && r.Method == "DELETE"
+}
+
+func checkTOTPRequirement(t *auth.Token) error {
+	u, err := t.User()
+	if err == auth.ErrUserNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if u.TOTPEnabled {
+		return nil
+	}
+	required, err := u.TOTPRequired()
+	if err != nil {
+		return err
+	}
+	if required {
+		return &errors.HTTP{
+			Code:    http.StatusForbidden,
+			Message: "One of your teams requires two-factor authentication, you must enable it before using tsuru",
+		}
+	}
+	return nil
+}
+
 type authorizationRequiredHandler func(http.ResponseWriter, *http.Request, *auth.Token) error
 
 func (fn authorizationRequiredHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -76,7 +122,11 @@ func (fn authorizationRequiredHandler) ServeHTTP(w http.ResponseWriter, r *http.
 	fw := io.FlushingWriter{ResponseWriter: w}
 	token := r.Header.Get("Authorization")
 	if t, err := validate(token, r); err != nil {
-		http.Error(&fw, err.Error(), http.StatusUnauthorized)
+		code := http.StatusUnauthorized
+		if e, ok := err.(*errors.HTTP); ok && e.Code != 0 {
+			code = e.Code
+		}
+		http.Error(&fw, err.Error(), code)
 	} else if err = fn(&fw, r, t); err != nil {
 		code := http.StatusInternalServerError
 		if e, ok := err.(*errors.HTTP); ok {
@@ -101,11 +151,13 @@ func (fn adminRequiredHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		}
 	}()
 	fw := io.FlushingWriter{ResponseWriter: w}
-	header := r.Header.Get("Authorization")
-	if header == "" {
-		http.Error(&fw, "You must provide the Authorization header", http.StatusUnauthorized)
-	} else if t, err := auth.GetToken(header); err != nil {
-		http.Error(&fw, "Invalid token", http.StatusUnauthorized)
+	token := r.Header.Get("Authorization")
+	if t, err := validate(token, r); err != nil {
+		code := http.StatusUnauthorized
+		if e, ok := err.(*errors.HTTP); ok && e.Code != 0 {
+			code = e.Code
+		}
+		http.Error(&fw, err.Error(), code)
 	} else if user, err := t.User(); err != nil || !user.IsAdmin() {
 		http.Error(&fw, "Forbidden", http.StatusForbidden)
 	} else if err = fn(&fw, r, t); err != nil {
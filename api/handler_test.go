@@ -113,6 +113,15 @@ func (s *HandlerSuite) TestHandlerReturns500WhenInternalHandlerReturnsAnError(c
 	c.Assert(recorder.Body.String(), gocheck.Equals, "some error\n")
 }
 
+func (s *HandlerSuite) TestHandlerShouldRespectTheHandlerStatusCode(c *gocheck.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/users/tokens", nil)
+	c.Assert(err, gocheck.IsNil)
+	handler(badRequestHandler).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "some error\n")
+}
+
 func (s *HandlerSuite) TestHandlerDontCallWriteHeaderIfItHasAlreadyBeenCalled(c *gocheck.C) {
 	recorder := recorder{httptest.NewRecorder(), 0}
 	request, err := http.NewRequest("GET", "/apps", nil)
@@ -181,6 +190,20 @@ func (s *HandlerSuite) TestAuthorizationRequiredHandlerShouldReturnTheHandlerRes
 	c.Assert(recorder.Body.String(), gocheck.Equals, "success")
 }
 
+func (s *HandlerSuite) TestAuthorizationRequiredHandlerRecordsTheUseOfTheToken(c *gocheck.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/apps", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.Token)
+	request.Header.Set("User-Agent", "tsuru/0.8.2")
+	authorizationRequiredHandler(authorizedSimpleHandler).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	t, err := auth.GetToken("bearer " + s.token.Token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.Client, gocheck.Equals, "tsuru/0.8.2")
+	c.Assert(t.LastUse.IsZero(), gocheck.Equals, false)
+}
+
 func (s *HandlerSuite) TestAuthorizationRequiredHandlerShouldSetVersionHeaders(c *gocheck.C) {
 	recorder := httptest.NewRecorder()
 	request, err := http.NewRequest("GET", "/apps", nil)
@@ -369,3 +392,57 @@ func (s *HandlerSuite) TestAuthorizationRequiredHandlerAppMissng(c *gocheck.C) {
 	authorizationRequiredHandler(authorizedOutputHandler).ServeHTTP(recorder, request)
 	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
 }
+
+func (s *HandlerSuite) TestAuthorizationRequiredHandlerTOTPRequired(c *gocheck.C) {
+	user := &auth.User{Email: "secure@thewho.com", Password: "123456"}
+	err := user.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Users().Remove(bson.M{"email": user.Email})
+	token, err := user.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": token.Token})
+	team := auth.Team{Name: "secureteam", Users: []string{user.Email}, RequireTOTP: true}
+	err = s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/apps", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.Token)
+	authorizationRequiredHandler(authorizedSimpleHandler).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), gocheck.Matches, "^One of your teams requires two-factor authentication.*\n$")
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/users/totp", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.Token)
+	authorizationRequiredHandler(authorizedSimpleHandler).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+}
+
+func (s *HandlerSuite) TestAdminRequiredHandlerRecordsTheUseOfTheToken(c *gocheck.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/apps", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.Token)
+	request.Header.Set("User-Agent", "tsuru-admin/0.3.0")
+	adminRequiredHandler(authorizedSimpleHandler).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	t, err := auth.GetToken("bearer " + s.token.Token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.Client, gocheck.Equals, "tsuru-admin/0.3.0")
+}
+
+func (s *HandlerSuite) TestAdminRequiredHandlerTOTPRequired(c *gocheck.C) {
+	team := auth.Team{Name: "secureteam", Users: []string{s.token.UserEmail}, RequireTOTP: true}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/apps", nil)
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.Token)
+	adminRequiredHandler(authorizedSimpleHandler).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), gocheck.Matches, "^One of your teams requires two-factor authentication.*\n$")
+}
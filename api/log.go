@@ -5,8 +5,12 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/globocom/tsuru/app"
 	"github.com/globocom/tsuru/auth"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/rec"
 	"net/http"
 )
 
@@ -17,11 +21,60 @@ func logRemove(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		if err != nil {
 			return err
 		}
-		a, err := getApp(r.URL.Query().Get("app"), u)
+		rec.Log(u.Email, "remove-logs", "app="+appName)
+		a, err := getApp(appName, u, auth.PermAppAdmin)
 		if err != nil {
 			return err
 		}
 		return app.LogRemove(&a)
 	}
+	rec.Log(t.UserEmail, "remove-logs")
 	return app.LogRemove(nil)
 }
+
+func logUsage(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	var a *app.App
+	if appName := r.URL.Query().Get("app"); appName != "" {
+		u, err := t.User()
+		if err != nil {
+			return err
+		}
+		instance, err := getApp(appName, u, auth.PermAppRead)
+		if err != nil {
+			return err
+		}
+		a = &instance
+	}
+	usage, err := app.LogsUsage(a)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(usage)
+}
+
+func setLogRetention(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	appName := r.URL.Query().Get("app")
+	if appName == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the name of the app."}
+	}
+	var retention app.LogRetention
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&retention) != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid log retention policy."}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "set-log-retention", "app="+appName,
+		fmt.Sprintf("max-age=%d", retention.MaxAge), fmt.Sprintf("max-entries=%d", retention.MaxEntries))
+	a, err := getApp(appName, u, auth.PermAppUpdate)
+	if err != nil {
+		return err
+	}
+	err = app.SetLogRetention(&a, &retention)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
@@ -5,15 +5,18 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/globocom/config"
 	"github.com/globocom/tsuru/app"
 	"github.com/globocom/tsuru/auth"
 	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
 	"labix.org/v2/mgo/bson"
 	"launchpad.net/gocheck"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 )
 
 type LogSuite struct {
@@ -93,3 +96,70 @@ func (s *LogSuite) TestLogRemoveByApp(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(count, gocheck.Equals, 1)
 }
+
+func (s *LogSuite) TestLogUsageByApp(c *gocheck.C) {
+	a := app.App{Name: "words", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	err = a.Log("first log msg\nlast log msg", "tsuru")
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("GET", "/logs/usage?app="+a.Name, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = logUsage(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var usage []app.LogUsage
+	err = json.NewDecoder(recorder.Body).Decode(&usage)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(usage, gocheck.HasLen, 1)
+	c.Assert(usage[0].App, gocheck.Equals, a.Name)
+	c.Assert(usage[0].Entries, gocheck.Equals, 2)
+}
+
+func (s *LogSuite) TestSetLogRetention(c *gocheck.C) {
+	a := app.App{Name: "words", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"MaxAge":86400,"MaxEntries":1000}`)
+	request, err := http.NewRequest("PUT", "/logs/retention?app="+a.Name, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setLogRetention(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var stored app.App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(*stored.LogRetention, gocheck.Equals, app.LogRetention{MaxAge: 86400, MaxEntries: 1000})
+}
+
+func (s *LogSuite) TestSetLogRetentionWithoutApp(c *gocheck.C) {
+	body := strings.NewReader(`{"MaxAge":86400}`)
+	request, err := http.NewRequest("PUT", "/logs/retention", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setLogRetention(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
+func (s *LogSuite) TestSetLogRetentionInvalid(c *gocheck.C) {
+	a := app.App{Name: "words", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"MaxAge":-1}`)
+	request, err := http.NewRequest("PUT", "/logs/retention?app="+a.Name, body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setLogRetention(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
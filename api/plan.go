@@ -0,0 +1,83 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/auth"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/quota"
+	"github.com/globocom/tsuru/rec"
+	"net/http"
+)
+
+func addPlan(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	var plan app.Plan
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid plan."}
+	}
+	rec.Log(t.UserEmail, "add-plan", "name="+plan.Name, fmt.Sprintf("memory=%d", plan.Memory),
+		fmt.Sprintf("swap=%d", plan.Swap), fmt.Sprintf("cpushare=%d", plan.CpuShare))
+	err := plan.Save()
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	if err == app.ErrPlanAlreadyExists {
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func listPlans(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	plans, err := app.PlansList()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(plans)
+}
+
+func removePlan(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	name := r.URL.Query().Get(":planname")
+	rec.Log(t.UserEmail, "remove-plan", "name="+name)
+	err := app.PlanRemove(name)
+	if err == app.ErrPlanNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
+func changePlan(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	var plan app.Plan
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil || plan.Name == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Missing plan name."}
+	}
+	rec.Log(u.Email, "change-plan", "app="+appName, "plan="+plan.Name)
+	a, err := getApp(appName, u, auth.PermAppUpdate)
+	if err != nil {
+		return err
+	}
+	err = a.ChangePlan(plan.Name)
+	if err == app.ErrPlanNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if _, ok := err.(*quota.QuotaExceededError); ok {
+		return &errors.HTTP{Code: http.StatusForbidden, Message: err.Error()}
+	}
+	return err
+}
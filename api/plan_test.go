@@ -0,0 +1,154 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (s *S) TestAddPlan(c *gocheck.C) {
+	body := strings.NewReader(`{"name":"small","memory":268435456,"swap":268435456,"cpushare":50}`)
+	request, err := http.NewRequest("POST", "/plans", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addPlan(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId("small")
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusCreated)
+	var plan app.Plan
+	err = s.conn.Plans().FindId("small").One(&plan)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(plan, gocheck.DeepEquals, app.Plan{Name: "small", Memory: 256 << 20, Swap: 256 << 20, CpuShare: 50})
+	action := testing.Action{
+		Action: "add-plan",
+		User:   s.user.Email,
+		Extra:  []interface{}{"name=small", "memory=268435456", "swap=268435456", "cpushare=50"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestAddPlanDuplicated(c *gocheck.C) {
+	err := s.conn.Plans().Insert(app.Plan{Name: "small"})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId("small")
+	body := strings.NewReader(`{"name":"small","memory":268435456}`)
+	request, err := http.NewRequest("POST", "/plans", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addPlan(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusConflict)
+}
+
+func (s *S) TestAddPlanInvalid(c *gocheck.C) {
+	body := strings.NewReader(`{"memory":268435456}`)
+	request, err := http.NewRequest("POST", "/plans", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addPlan(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
+func (s *S) TestListPlans(c *gocheck.C) {
+	plans := []interface{}{
+		app.Plan{Name: "small", Memory: 256 << 20, Default: true},
+		app.Plan{Name: "large", Memory: 1024 << 20},
+	}
+	err := s.conn.Plans().Insert(plans...)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveAll(nil)
+	request, err := http.NewRequest("GET", "/plans", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listPlans(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var result []app.Plan
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.DeepEquals, []app.Plan{plans[1].(app.Plan), plans[0].(app.Plan)})
+}
+
+func (s *S) TestRemovePlan(c *gocheck.C) {
+	err := s.conn.Plans().Insert(app.Plan{Name: "small"})
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("DELETE", "/plans/small?:planname=small", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = removePlan(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	n, err := s.conn.Plans().FindId("small").Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+	action := testing.Action{Action: "remove-plan", User: s.user.Email, Extra: []interface{}{"name=small"}}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestRemovePlanNotFound(c *gocheck.C) {
+	request, err := http.NewRequest("DELETE", "/plans/small?:planname=small", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = removePlan(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestChangePlan(c *gocheck.C) {
+	plan := app.Plan{Name: "large", Memory: 1024 << 20}
+	err := s.conn.Plans().Insert(plan)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(plan.Name)
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/plan?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, strings.NewReader(`{"name":"large"}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = changePlan(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Plan, gocheck.DeepEquals, plan)
+	action := testing.Action{
+		Action: "change-plan",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=" + a.Name, "plan=large"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestChangePlanNotFound(c *gocheck.C) {
+	a := app.App{Name: "otherapp", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	url := fmt.Sprintf("/apps/%s/plan?:app=%s", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, strings.NewReader(`{"name":"large"}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = changePlan(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
@@ -45,7 +45,10 @@ func RunServer(dry bool) {
 	m.Get("/services/instances", authorizationRequiredHandler(serviceInstances))
 	m.Get("/services/instances/:name", authorizationRequiredHandler(serviceInstance))
 	m.Del("/services/instances/:name", authorizationRequiredHandler(removeServiceInstance))
+	m.Put("/services/instances/:name", authorizationRequiredHandler(updateServiceInstance))
 	m.Post("/services/instances", authorizationRequiredHandler(createServiceInstance))
+	m.Put("/services/instances/:instance/teams/:team", authorizationRequiredHandler(grantServiceInstance))
+	m.Del("/services/instances/:instance/teams/:team", authorizationRequiredHandler(revokeServiceInstance))
 	m.Put("/services/instances/:instance/:app", authorizationRequiredHandler(bindServiceInstance))
 	m.Del("/services/instances/:instance/:app", authorizationRequiredHandler(unbindServiceInstance))
 	m.Get("/services/instances/:instance/status", authorizationRequiredHandler(serviceInstanceStatus))
@@ -55,6 +58,7 @@ func RunServer(dry bool) {
 	m.Put("/services", authorizationRequiredHandler(serviceUpdate))
 	m.Del("/services/:name", authorizationRequiredHandler(serviceDelete))
 	m.Get("/services/:name", authorizationRequiredHandler(serviceInfo))
+	m.Get("/services/:name/plans", authorizationRequiredHandler(servicePlans))
 	m.Get("/services/:name/doc", authorizationRequiredHandler(serviceDoc))
 	m.Put("/services/:name/doc", authorizationRequiredHandler(serviceAddDoc))
 	m.Put("/services/:service/:team", authorizationRequiredHandler(grantServiceAccess))
@@ -69,17 +73,36 @@ func RunServer(dry bool) {
 	m.Get("/apps/:app/env", authorizationRequiredHandler(getEnv))
 	m.Post("/apps/:app/env", authorizationRequiredHandler(setEnv))
 	m.Del("/apps/:app/env", authorizationRequiredHandler(unsetEnv))
+	m.Post("/apps/:app/env/import", authorizationRequiredHandler(importEnv))
+	m.Get("/apps/:app/env/export", authorizationRequiredHandler(exportEnv))
 	m.Get("/apps", authorizationRequiredHandler(appList))
 	m.Post("/apps", authorizationRequiredHandler(createApp))
 	m.Put("/apps/:app/units", authorizationRequiredHandler(addUnits))
 	m.Del("/apps/:app/units", authorizationRequiredHandler(removeUnits))
+	m.Put("/apps/:app/plan", authorizationRequiredHandler(changePlan))
+	m.Get("/apps/:app/autoscale", authorizationRequiredHandler(getAutoScale))
+	m.Put("/apps/:app/autoscale", authorizationRequiredHandler(setAutoScale))
+	m.Post("/apps/:app/metrics", authorizationRequiredHandler(addMetrics))
+	m.Get("/apps/:app/log-drains", authorizationRequiredHandler(listLogDrains))
+	m.Post("/apps/:app/log-drains", authorizationRequiredHandler(addLogDrain))
+	m.Del("/apps/:app/log-drains", authorizationRequiredHandler(removeLogDrain))
 	m.Put("/apps/:app/:team", authorizationRequiredHandler(grantAppAccess))
 	m.Del("/apps/:app/:team", authorizationRequiredHandler(revokeAppAccess))
 	m.Get("/apps/:app/log", authorizationRequiredHandler(appLog))
 	m.Post("/apps/:app/log", authorizationRequiredHandler(addLog))
+	m.Get("/apps/:app/deploys", authorizationRequiredHandler(deployList))
+	m.Get("/apps/:app/audit", authorizationRequiredHandler(appAudit))
+	m.Post("/apps/:app/rollback", authorizationRequiredHandler(rollback))
+	m.Post("/apps/:app/blue-green", authorizationRequiredHandler(enableBlueGreen))
+	m.Post("/apps/:app/promote", authorizationRequiredHandler(promote))
+	m.Post("/apps/:app/abort-deploy", authorizationRequiredHandler(abortDeploy))
 
 	m.Get("/platforms", authorizationRequiredHandler(platformList))
 
+	m.Get("/plans", authorizationRequiredHandler(listPlans))
+	m.Post("/plans", adminRequiredHandler(addPlan))
+	m.Del("/plans/:planname", adminRequiredHandler(removePlan))
+
 	// These handlers don't use :app on purpose. Using :app means that only
 	// the token generate for the given app is valid, but these handlers
 	// use a token generated for Gandalf.
@@ -92,21 +115,42 @@ func RunServer(dry bool) {
 
 	m.Post("/users/:email/password", handler(resetPassword))
 	m.Post("/users/:email/tokens", handler(login))
+	m.Get("/auth/scheme", handler(authScheme))
+	m.Post("/auth/login", handler(login))
 	m.Del("/users/tokens", authorizationRequiredHandler(logout))
+	m.Get("/users/tokens", authorizationRequiredHandler(listTokens))
+	m.Post("/users/tokens", authorizationRequiredHandler(createAPIToken))
+	m.Del("/users/tokens/:id", authorizationRequiredHandler(revokeToken))
 	m.Put("/users/password", authorizationRequiredHandler(changePassword))
 	m.Del("/users", authorizationRequiredHandler(removeUser))
 	m.Get("/users/:email/keys", authorizationRequiredHandler(listKeys))
 	m.Post("/users/keys", authorizationRequiredHandler(addKeyToUser))
 	m.Del("/users/keys", authorizationRequiredHandler(removeKeyFromUser))
+	m.Post("/users/totp", authorizationRequiredHandler(startTOTPEnrollment))
+	m.Post("/users/totp/enable", authorizationRequiredHandler(enableTOTP))
+	m.Post("/users/totp/recovery-codes", authorizationRequiredHandler(regenerateRecoveryCodes))
+	m.Del("/users/totp", authorizationRequiredHandler(disableTOTP))
 
 	m.Post("/tokens", adminRequiredHandler(generateAppToken))
 
 	m.Del("/logs", adminRequiredHandler(logRemove))
+	m.Get("/logs/usage", adminRequiredHandler(logUsage))
+	m.Put("/logs/retention", adminRequiredHandler(setLogRetention))
+
+	m.Get("/audit", adminRequiredHandler(listAudit))
+
+	m.Get("/roles", authorizationRequiredHandler(listRoles))
 
 	m.Get("/teams", authorizationRequiredHandler(teamList))
 	m.Post("/teams", authorizationRequiredHandler(createTeam))
 	m.Get("/teams/:name", authorizationRequiredHandler(getTeam))
 	m.Del("/teams/:name", authorizationRequiredHandler(removeTeam))
+	m.Put("/teams/:team/role", authorizationRequiredHandler(setTeamRole))
+	m.Put("/teams/:team/totp", adminRequiredHandler(setTeamTOTP))
+	m.Get("/teams/:team/webhooks", authorizationRequiredHandler(listWebhooks))
+	m.Post("/teams/:team/webhooks", authorizationRequiredHandler(addWebhook))
+	m.Del("/teams/:team/webhooks/:id", authorizationRequiredHandler(removeWebhook))
+	m.Put("/teams/:team/:user/role", authorizationRequiredHandler(setTeamUserRole))
 	m.Put("/teams/:team/:user", authorizationRequiredHandler(addUserToTeam))
 	m.Del("/teams/:team/:user", authorizationRequiredHandler(removeUserFromTeam))
 
@@ -7,37 +7,122 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/globocom/tsuru/app"
 	"github.com/globocom/tsuru/auth"
 	"github.com/globocom/tsuru/db"
 	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/queue"
 	"github.com/globocom/tsuru/rec"
 	"github.com/globocom/tsuru/service"
-	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 func createServiceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return err
+	var body struct {
+		Name        string            `json:"name"`
+		ServiceName string            `json:"service_name"`
+		Plan        string            `json:"plan"`
+		Params      map[string]string `json:"params"`
 	}
-	var body map[string]string
-	err = json.Unmarshal(b, &body)
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		return err
 	}
-	serviceName := body["service_name"]
 	user, err := t.User()
 	if err != nil {
 		return err
 	}
-	rec.Log(user.Email, "create-service-instance", string(b))
-	srv, err := getServiceOrError(serviceName, user)
+	extra := []interface{}{"instance=" + body.Name, "service=" + body.ServiceName}
+	if body.Plan != "" {
+		extra = append(extra, "plan="+body.Plan)
+	}
+	if len(body.Params) > 0 {
+		extra = append(extra, "params="+paramNames(body.Params))
+	}
+	rec.Log(user.Email, "create-service-instance", extra...)
+	srv, err := getServiceOrError(body.ServiceName, user)
 	if err != nil {
 		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
 	}
-	return service.CreateServiceInstance(body["name"], &srv, user)
+	instance := service.ServiceInstance{Name: body.Name, PlanName: body.Plan, Params: body.Params}
+	err = service.CreateServiceInstance(&instance, &srv, user)
+	if err == service.ErrInvalidPlan || err == service.ErrInvalidParam {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	if instance.State == service.StatePending {
+		watchServiceInstance(instance.Name)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Service instance %q is being provisioned.", instance.Name)
+	}
+	return nil
+}
+
+func updateServiceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	var body struct {
+		Plan   string            `json:"plan"`
+		Params map[string]string `json:"params"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid JSON in the request body."}
+	}
+	if body.Plan == "" && len(body.Params) == 0 {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the plan or the params of the service instance."}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	name := r.URL.Query().Get(":name")
+	extra := []interface{}{"instance=" + name}
+	if body.Plan != "" {
+		extra = append(extra, "plan="+body.Plan)
+	}
+	if len(body.Params) > 0 {
+		extra = append(extra, "params="+paramNames(body.Params))
+	}
+	rec.Log(u.Email, "update-service-instance", extra...)
+	instance, err := getServiceInstanceOrError(name, u)
+	if err != nil {
+		return err
+	}
+	err = instance.Update(body.Plan, body.Params)
+	if err == service.ErrInvalidPlan || err == service.ErrInvalidParam {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	if instance.State == service.StatePending {
+		watchServiceInstance(instance.Name)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "Service instance %q is being updated.", instance.Name)
+	}
+	return nil
+}
+
+// paramNames returns the sorted names of the parameters of a service
+// instance, for the audit log. Values are not logged, because they may
+// contain credentials.
+func paramNames(params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// watchServiceInstance enqueues the check of the status of a pending service
+// instance, that is repeated until the instance is ready.
+func watchServiceInstance(name string) {
+	app.Enqueue(queue.Message{Action: app.CheckServiceInstance, Args: []string{name, "0"}})
 }
 
 func removeServiceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
@@ -46,7 +131,7 @@ func removeServiceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token
 		return err
 	}
 	name := r.URL.Query().Get(":name")
-	rec.Log(u.Email, "remove-service-instance", name)
+	rec.Log(u.Email, "remove-service-instance", "instance="+name)
 	si, err := getServiceInstanceOrError(name, u)
 	if err != nil {
 		return err
@@ -73,6 +158,7 @@ func serviceInstances(w http.ResponseWriter, r *http.Request, t *auth.Token) err
 		for _, si := range sInstances {
 			if si.ServiceName == s.Name {
 				result[i].Instances = append(result[i].Instances, si.Name)
+				result[i].Plans = append(result[i].Plans, si.PlanName)
 			}
 		}
 	}
@@ -99,6 +185,62 @@ func serviceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token) erro
 	return json.NewEncoder(w).Encode(instance)
 }
 
+func getServiceInstanceAndTeam(instanceName, teamName string, u *auth.User) (*service.ServiceInstance, *auth.Team, error) {
+	instance, err := getServiceInstanceOrError(instanceName, u)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	var team auth.Team
+	err = conn.Teams().Find(bson.M{"_id": teamName}).One(&team)
+	if err != nil {
+		return nil, nil, &errors.HTTP{Code: http.StatusNotFound, Message: "Team not found"}
+	}
+	return instance, &team, nil
+}
+
+func grantServiceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	instanceName := r.URL.Query().Get(":instance")
+	teamName := r.URL.Query().Get(":team")
+	rec.Log(u.Email, "grant-service-instance", "instance="+instanceName, "team="+teamName)
+	instance, team, err := getServiceInstanceAndTeam(instanceName, teamName, u)
+	if err != nil {
+		return err
+	}
+	err = instance.GrantAccess(team)
+	if err == service.ErrAlreadyShared {
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
+}
+
+func revokeServiceInstance(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	instanceName := r.URL.Query().Get(":instance")
+	teamName := r.URL.Query().Get(":team")
+	rec.Log(u.Email, "revoke-service-instance", "instance="+instanceName, "team="+teamName)
+	instance, team, err := getServiceInstanceAndTeam(instanceName, teamName, u)
+	if err != nil {
+		return err
+	}
+	err = instance.RevokeAccess(team)
+	if err == service.ErrNotShared {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
 func serviceInstanceStatus(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	u, err := t.User()
 	if err != nil {
@@ -109,7 +251,7 @@ func serviceInstanceStatus(w http.ResponseWriter, r *http.Request, t *auth.Token
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "service-instance-status", siName)
+	rec.Log(u.Email, "get-service-instance-status", "instance="+siName)
 	var b string
 	if b, err = si.Status(); err != nil {
 		msg := fmt.Sprintf("Could not retrieve status of service instance, error: %s", err.Error())
@@ -129,7 +271,7 @@ func serviceInfo(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 		return err
 	}
 	serviceName := r.URL.Query().Get(":name")
-	rec.Log(u.Email, "service-info", serviceName)
+	rec.Log(u.Email, "get-service", "service="+serviceName)
 	_, err = getServiceOrError(serviceName, u)
 	if err != nil {
 		return err
@@ -145,7 +287,13 @@ func serviceInfo(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	}
 	defer conn.Close()
 	teamsNames := auth.GetTeamsNames(teams)
-	q := bson.M{"service_name": serviceName, "teams": bson.M{"$in": teamsNames}}
+	q := bson.M{
+		"service_name": serviceName,
+		"$or": []bson.M{
+			{"teams": bson.M{"$in": teamsNames}},
+			{"shared_teams": bson.M{"$in": teamsNames}},
+		},
+	}
 	err = conn.ServiceInstances().Find(q).All(&instances)
 	if err != nil {
 		return err
@@ -158,13 +306,35 @@ func serviceInfo(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	return nil
 }
 
+func servicePlans(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	serviceName := r.URL.Query().Get(":name")
+	rec.Log(u.Email, "list-service-plans", "service="+serviceName)
+	s, err := getServiceOrError(serviceName, u)
+	if err != nil {
+		return err
+	}
+	plans, err := s.Plans()
+	if err != nil {
+		return err
+	}
+	if plans == nil {
+		plans = []service.Plan{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(plans)
+}
+
 func serviceDoc(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
 	u, err := t.User()
 	if err != nil {
 		return err
 	}
 	sName := r.URL.Query().Get(":name")
-	rec.Log(u.Email, "service-doc", sName)
+	rec.Log(u.Email, "get-service-doc", "service="+sName)
 	s, err := getServiceOrError(sName, u)
 	if err != nil {
 		return err
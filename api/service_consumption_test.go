@@ -34,6 +34,7 @@ func (s *ConsumptionSuite) SetUpSuite(c *gocheck.C) {
 	var err error
 	config.Set("database:url", "127.0.0.1:27017")
 	config.Set("database:name", "tsuru_api_consumption_test")
+	config.Set("queue", "fake")
 	config.Set("auth:hash-cost", 4)
 	s.conn, err = db.Conn()
 	c.Assert(err, gocheck.IsNil)
@@ -95,7 +96,7 @@ func (s *ConsumptionSuite) TestCreateInstanceHandlerSavesServiceInstanceInDb(c *
 	action := testing.Action{
 		Action: "create-service-instance",
 		User:   s.user.Email,
-		Extra:  []interface{}{`{"name":"brainSQL","service_name":"mysql"}`},
+		Extra:  []interface{}{"instance=brainSQL", "service=mysql"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -168,6 +169,222 @@ func (s *ConsumptionSuite) TestCreateInstanceHandlerReturnErrorIfTheServiceAPICa
 	c.Assert(err, gocheck.NotNil)
 }
 
+func (s *ConsumptionSuite) TestCreateInstanceHandlerAsynchronousProvisioning(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	recorder, request := makeRequestToCreateInstanceHandler(c)
+	err = createServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusAccepted)
+	c.Assert(recorder.Body.String(), gocheck.Equals, `Service instance "brainSQL" is being provisioned.`)
+	var si service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "brainSQL"}).One(&si)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(si.State, gocheck.Equals, service.StatePending)
+}
+
+func (s *ConsumptionSuite) TestCreateInstanceHandlerWithPlan(c *gocheck.C) {
+	var plan string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`[{"name":"small","description":"1 GB"}]`))
+			return
+		}
+		plan = r.FormValue("plan")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	b := bytes.NewBufferString(`{"name":"brainSQL","service_name":"mysql","plan":"small"}`)
+	request, err := http.NewRequest("POST", "/services/instances", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = createServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(plan, gocheck.Equals, "small")
+	var si service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "brainSQL"}).One(&si)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(si.PlanName, gocheck.Equals, "small")
+	action := testing.Action{
+		Action: "create-service-instance",
+		User:   s.user.Email,
+		Extra:  []interface{}{"instance=brainSQL", "service=mysql", "plan=small"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *ConsumptionSuite) TestCreateInstanceHandlerWithInvalidPlan(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name":"small","description":"1 GB"}]`))
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	b := bytes.NewBufferString(`{"name":"brainSQL","service_name":"mysql","plan":"huge"}`)
+	request, err := http.NewRequest("POST", "/services/instances", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = createServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, service.ErrInvalidPlan.Error())
+}
+
+func (s *ConsumptionSuite) TestCreateInstanceHandlerWithParams(c *gocheck.C) {
+	var version string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version = r.FormValue("parameters.version")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	b := bytes.NewBufferString(`{"name":"brainSQL","service_name":"mysql","params":{"version":"5.6","charset":"utf8"}}`)
+	request, err := http.NewRequest("POST", "/services/instances", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = createServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(version, gocheck.Equals, "5.6")
+	var si service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "brainSQL"}).One(&si)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(si.Params, gocheck.DeepEquals, map[string]string{"version": "5.6", "charset": "utf8"})
+	action := testing.Action{
+		Action: "create-service-instance",
+		User:   s.user.Email,
+		Extra:  []interface{}{"instance=brainSQL", "service=mysql", "params=charset,version"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *ConsumptionSuite) TestCreateInstanceHandlerWithInvalidParam(c *gocheck.C) {
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": "http://localhost:1"}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	b := bytes.NewBufferString(`{"name":"brainSQL","service_name":"mysql","params":{"my.version":"5.6"}}`)
+	request, err := http.NewRequest("POST", "/services/instances", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = createServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, service.ErrInvalidParam.Error())
+}
+
+func makeRequestToUpdateInstanceHandler(name, body string, c *gocheck.C) (*httptest.ResponseRecorder, *http.Request) {
+	url := fmt.Sprintf("/services/instances/%s?:name=%s", name, name)
+	request, err := http.NewRequest("PUT", url, bytes.NewBufferString(body))
+	c.Assert(err, gocheck.IsNil)
+	request.Header.Set("Content-Type", "application/json")
+	return httptest.NewRecorder(), request
+}
+
+func (s *ConsumptionSuite) TestUpdateInstanceHandler(c *gocheck.C) {
+	var form map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`[{"name":"small","description":"1 GB"},{"name":"large","description":"10 GB"}]`))
+			return
+		}
+		r.ParseForm()
+		form = r.PostForm
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	instance := service.ServiceInstance{
+		Name:        "brainSQL",
+		ServiceName: "mysql",
+		PlanName:    "small",
+		Params:      map[string]string{"version": "5.5"},
+		Teams:       []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToUpdateInstanceHandler("brainSQL", `{"plan":"large","params":{"version":"5.6"}}`, c)
+	err = updateServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusOK)
+	c.Assert(form, gocheck.DeepEquals, map[string][]string{"plan": {"large"}, "parameters.version": {"5.6"}})
+	var si service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "brainSQL"}).One(&si)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(si.PlanName, gocheck.Equals, "large")
+	c.Assert(si.Params, gocheck.DeepEquals, map[string]string{"version": "5.6"})
+	action := testing.Action{
+		Action: "update-service-instance",
+		User:   s.user.Email,
+		Extra:  []interface{}{"instance=brainSQL", "plan=large", "params=version"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *ConsumptionSuite) TestUpdateInstanceHandlerAsynchronously(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	instance := service.ServiceInstance{Name: "brainSQL", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToUpdateInstanceHandler("brainSQL", `{"params":{"version":"5.6"}}`, c)
+	err = updateServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Code, gocheck.Equals, http.StatusAccepted)
+	c.Assert(recorder.Body.String(), gocheck.Equals, `Service instance "brainSQL" is being updated.`)
+	var si service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "brainSQL"}).One(&si)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(si.State, gocheck.Equals, service.StatePending)
+}
+
+func (s *ConsumptionSuite) TestUpdateInstanceHandlerWithoutChanges(c *gocheck.C) {
+	recorder, request := makeRequestToUpdateInstanceHandler("brainSQL", `{}`, c)
+	err := updateServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, "You must provide the plan or the params of the service instance.")
+}
+
+func (s *ConsumptionSuite) TestUpdateInstanceHandlerUserWithoutAccess(c *gocheck.C) {
+	instance := service.ServiceInstance{Name: "brainSQL", ServiceName: "mysql", Teams: []string{"otherteam"}}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToUpdateInstanceHandler("brainSQL", `{"plan":"large"}`, c)
+	err = updateServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
 func makeRequestToRemoveInstanceHandler(name string, c *gocheck.C) (*httptest.ResponseRecorder, *http.Request) {
 	url := fmt.Sprintf("/services/c/instances/%s?:name=%s", name, name)
 	request, err := http.NewRequest("DELETE", url, nil)
@@ -201,7 +418,7 @@ func (s *ConsumptionSuite) TestRemoveServiceInstanceHandler(c *gocheck.C) {
 	action := testing.Action{
 		Action: "remove-service-instance",
 		User:   s.user.Email,
-		Extra:  []interface{}{"foo-instance"},
+		Extra:  []interface{}{"instance=foo-instance"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -261,6 +478,7 @@ func (s *ConsumptionSuite) TestServicesInstancesHandler(c *gocheck.C) {
 	instance := service.ServiceInstance{
 		Name:        "redis-globo",
 		ServiceName: "redis",
+		PlanName:    "small",
 		Apps:        []string{"globo"},
 		Teams:       []string{s.team.Name},
 	}
@@ -277,13 +495,148 @@ func (s *ConsumptionSuite) TestServicesInstancesHandler(c *gocheck.C) {
 	err = json.Unmarshal(body, &instances)
 	c.Assert(err, gocheck.IsNil)
 	expected := []service.ServiceModel{
-		{Service: "redis", Instances: []string{"redis-globo"}},
+		{Service: "redis", Instances: []string{"redis-globo"}, Plans: []string{"small"}},
 	}
 	c.Assert(instances, gocheck.DeepEquals, expected)
 	action := testing.Action{Action: "list-service-instances", User: s.user.Email}
 	c.Assert(action, testing.IsRecorded)
 }
 
+func (s *ConsumptionSuite) TestServicesInstancesHandlerIncludesSharedInstances(c *gocheck.C) {
+	srv := service.Service{Name: "redis", Teams: []string{s.team.Name}}
+	err := srv.Create()
+	c.Assert(err, gocheck.IsNil)
+	instance := service.ServiceInstance{
+		Name:        "redis-shared",
+		ServiceName: "redis",
+		Teams:       []string{"otherteam"},
+		SharedTeams: []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("GET", "/services/instances", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = serviceInstances(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var instances []service.ServiceModel
+	err = json.NewDecoder(recorder.Body).Decode(&instances)
+	c.Assert(err, gocheck.IsNil)
+	expected := []service.ServiceModel{
+		{Service: "redis", Instances: []string{"redis-shared"}, Plans: []string{""}},
+	}
+	c.Assert(instances, gocheck.DeepEquals, expected)
+}
+
+func makeRequestToServiceInstanceTeamHandler(method, instance, team string, c *gocheck.C) (*httptest.ResponseRecorder, *http.Request) {
+	url := fmt.Sprintf("/services/instances/%s/teams/%s?:instance=%s&:team=%s", instance, team, instance, team)
+	request, err := http.NewRequest(method, url, nil)
+	c.Assert(err, gocheck.IsNil)
+	return httptest.NewRecorder(), request
+}
+
+func (s *ConsumptionSuite) TestGrantServiceInstanceHandler(c *gocheck.C) {
+	team := auth.Team{Name: "otherteam"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToServiceInstanceTeamHandler("PUT", instance.Name, team.Name, c)
+	err = grantServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": instance.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.SharedTeams, gocheck.DeepEquals, []string{team.Name})
+	action := testing.Action{
+		Action: "grant-service-instance",
+		User:   s.user.Email,
+		Extra:  []interface{}{"instance=my-mysql", "team=otherteam"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *ConsumptionSuite) TestGrantServiceInstanceHandlerTeamAlreadyHasAccess(c *gocheck.C) {
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToServiceInstanceTeamHandler("PUT", instance.Name, s.team.Name, c)
+	err = grantServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusConflict)
+	c.Assert(e.Message, gocheck.Equals, "This team already has access to this service instance")
+}
+
+func (s *ConsumptionSuite) TestGrantServiceInstanceHandlerTeamNotFound(c *gocheck.C) {
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToServiceInstanceTeamHandler("PUT", instance.Name, "unknown", c)
+	err = grantServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+	c.Assert(e.Message, gocheck.Equals, "Team not found")
+}
+
+func (s *ConsumptionSuite) TestGrantServiceInstanceHandlerUserWithoutAccessToTheInstance(c *gocheck.C) {
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{"otherteam"}}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToServiceInstanceTeamHandler("PUT", instance.Name, s.team.Name, c)
+	err = grantServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *ConsumptionSuite) TestRevokeServiceInstanceHandler(c *gocheck.C) {
+	team := auth.Team{Name: "otherteam"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		Teams:       []string{s.team.Name},
+		SharedTeams: []string{team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToServiceInstanceTeamHandler("DELETE", instance.Name, team.Name, c)
+	err = revokeServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": instance.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.SharedTeams, gocheck.HasLen, 0)
+	action := testing.Action{
+		Action: "revoke-service-instance",
+		User:   s.user.Email,
+		Extra:  []interface{}{"instance=my-mysql", "team=otherteam"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *ConsumptionSuite) TestRevokeServiceInstanceHandlerInstanceNotShared(c *gocheck.C) {
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	recorder, request := makeRequestToServiceInstanceTeamHandler("DELETE", instance.Name, s.team.Name, c)
+	err = revokeServiceInstance(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+	c.Assert(e.Message, gocheck.Equals, "This service instance is not shared with this team")
+}
+
 func (s *ConsumptionSuite) TestServicesInstancesHandlerReturnsOnlyServicesThatTheUserHasAccess(c *gocheck.C) {
 	u := &auth.User{Email: "me@globo.com", Password: "123456"}
 	err := u.Create()
@@ -357,11 +710,11 @@ func (s *ConsumptionSuite) TestServicesInstancesHandlerFilterInstancesPerService
 	err = json.Unmarshal(body, &instances)
 	c.Assert(err, gocheck.IsNil)
 	expected := []service.ServiceModel{
-		{Service: "redis", Instances: []string{"redis1", "redis2"}},
-		{Service: "mysql", Instances: []string{"mysql1", "mysql2"}},
-		{Service: "pgsql", Instances: []string{"pgsql1", "pgsql2"}},
-		{Service: "memcached", Instances: []string{"memcached1", "memcached2"}},
-		{Service: "oracle", Instances: []string(nil)},
+		{Service: "redis", Instances: []string{"redis1", "redis2"}, Plans: []string{"", ""}},
+		{Service: "mysql", Instances: []string{"mysql1", "mysql2"}, Plans: []string{"", ""}},
+		{Service: "pgsql", Instances: []string{"pgsql1", "pgsql2"}, Plans: []string{"", ""}},
+		{Service: "memcached", Instances: []string{"memcached1", "memcached2"}, Plans: []string{"", ""}},
+		{Service: "oracle", Instances: []string(nil), Plans: []string(nil)},
 	}
 	c.Assert(instances, gocheck.DeepEquals, expected)
 }
@@ -395,9 +748,9 @@ func (s *ConsumptionSuite) TestServiceInstanceStatusHandler(c *gocheck.C) {
 	b, err := ioutil.ReadAll(recorder.Body)
 	c.Assert(string(b), gocheck.Equals, "Service instance \"my_nosql\" is up")
 	action := testing.Action{
-		Action: "service-instance-status",
+		Action: "get-service-instance-status",
 		User:   s.user.Email,
-		Extra:  []interface{}{"my_nosql"},
+		Extra:  []interface{}{"instance=my_nosql"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -461,9 +814,9 @@ func (s *ConsumptionSuite) TestServiceInfoHandler(c *gocheck.C) {
 	expected := []service.ServiceInstance{si1, si2}
 	c.Assert(instances, gocheck.DeepEquals, expected)
 	action := testing.Action{
-		Action: "service-info",
+		Action: "get-service",
 		User:   s.user.Email,
-		Extra:  []interface{}{"mongodb"},
+		Extra:  []interface{}{"service=mongodb"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -532,6 +885,51 @@ func (s *ConsumptionSuite) TestServiceInfoHandlerReturns403WhenTheUserDoesNotHav
 	c.Assert(e, gocheck.ErrorMatches, "^This user does not have access to this service$")
 }
 
+func (s *ConsumptionSuite) TestServicePlansHandler(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name":"small","description":"1 GB"},{"name":"large","description":"10 GB"}]`))
+	}))
+	defer ts.Close()
+	srv := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srv.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer srv.Delete()
+	request, err := http.NewRequest("GET", "/services/mysql/plans?:name=mysql", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = servicePlans(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var plans []service.Plan
+	err = json.NewDecoder(recorder.Body).Decode(&plans)
+	c.Assert(err, gocheck.IsNil)
+	expected := []service.Plan{{Name: "small", Description: "1 GB"}, {Name: "large", Description: "10 GB"}}
+	c.Assert(plans, gocheck.DeepEquals, expected)
+	action := testing.Action{
+		Action: "list-service-plans",
+		User:   s.user.Email,
+		Extra:  []interface{}{"service=mysql"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *ConsumptionSuite) TestServicePlansHandlerServiceWithoutPlans(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+	srv := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srv.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer srv.Delete()
+	request, err := http.NewRequest("GET", "/services/mysql/plans?:name=mysql", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = servicePlans(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Body.String(), gocheck.Equals, "[]\n")
+}
+
 func (s *ConsumptionSuite) TestGetServiceInstance(c *gocheck.C) {
 	instance := service.ServiceInstance{
 		Name:        "mongo-1",
@@ -606,9 +1004,9 @@ Collnosql is a really really cool nosql`
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(string(b), gocheck.Equals, doc)
 	action := testing.Action{
-		Action: "service-doc",
+		Action: "get-service-doc",
 		User:   s.user.Email,
-		Extra:  []interface{}{"coolnosql"},
+		Extra:  []interface{}{"service=coolnosql"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -21,6 +21,11 @@ import (
 type serviceYaml struct {
 	Id       string
 	Endpoint map[string]string
+
+	// Password and Auth define the credentials used by tsuru in requests
+	// to the API of the service (see service.Service).
+	Password string
+	Auth     string
 }
 
 func serviceList(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
@@ -59,7 +64,7 @@ func serviceCreate(w http.ResponseWriter, r *http.Request, t *auth.Token) error
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "create-service", sy.Id, sy.Endpoint)
+	rec.Log(u.Email, "create-service", "service="+sy.Id, "endpoint="+sy.Endpoint["production"])
 	conn, err := db.Conn()
 	if err != nil {
 		return err
@@ -87,6 +92,9 @@ func serviceCreate(w http.ResponseWriter, r *http.Request, t *auth.Token) error
 		Endpoint:   sy.Endpoint,
 		OwnerTeams: auth.GetTeamsNames(teams),
 	}
+	if err = s.SetPassword(sy.Password, sy.Auth); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
 	err = s.Create()
 	if err != nil {
 		return err
@@ -107,12 +115,20 @@ func serviceUpdate(w http.ResponseWriter, r *http.Request, t *auth.Token) error
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "update-service", yaml.Id, yaml.Endpoint)
+	rec.Log(u.Email, "update-service", "service="+yaml.Id, "endpoint="+yaml.Endpoint["production"])
 	s, err := getServiceByOwner(yaml.Id, u)
 	if err != nil {
 		return err
 	}
 	s.Endpoint = yaml.Endpoint
+	if yaml.Password != "" {
+		if err = s.SetPassword(yaml.Password, yaml.Auth); err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+	} else if yaml.Auth != "" {
+		msg := "You must provide the password along with the authentication scheme."
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: msg}
+	}
 	if err = s.Update(); err != nil {
 		return err
 	}
@@ -125,7 +141,7 @@ func serviceDelete(w http.ResponseWriter, r *http.Request, t *auth.Token) error
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "delete-service", r.URL.Query().Get(":name"))
+	rec.Log(u.Email, "remove-service", "service="+r.URL.Query().Get(":name"))
 	s, err := getServiceByOwner(r.URL.Query().Get(":name"), u)
 	if err != nil {
 		return err
@@ -232,7 +248,7 @@ func serviceAddDoc(w http.ResponseWriter, r *http.Request, t *auth.Token) error
 	if err != nil {
 		return err
 	}
-	rec.Log(u.Email, "service-add-doc", r.URL.Query().Get(":name"), string(body))
+	rec.Log(u.Email, "add-service-doc", "service="+r.URL.Query().Get(":name"))
 	s, err := getServiceByOwner(r.URL.Query().Get(":name"), u)
 	if err != nil {
 		return err
@@ -266,6 +282,7 @@ func servicesAndInstancesByOwner(u *auth.User) []service.ServiceModel {
 		for _, si := range sInstances {
 			if si.ServiceName == s.Name {
 				results[i].Instances = append(results[i].Instances, si.Name)
+				results[i].Plans = append(results[i].Plans, si.PlanName)
 			}
 		}
 	}
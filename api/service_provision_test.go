@@ -85,7 +85,7 @@ func (s *ProvisionSuite) TestServicesHandlerShoudGetAllServicesFromUsersTeam(c *
 	services := make([]service.ServiceModel, 1)
 	err = json.Unmarshal(b, &services)
 	expected := []service.ServiceModel{
-		{Service: "mongodb", Instances: []string{"my_nosql"}},
+		{Service: "mongodb", Instances: []string{"my_nosql"}, Plans: []string{""}},
 	}
 	c.Assert(services, gocheck.DeepEquals, expected)
 	action := testing.Action{Action: "list-services", User: s.user.Email}
@@ -114,14 +114,10 @@ func (s *ProvisionSuite) TestCreateHandlerSavesNameFromManifestID(c *gocheck.C)
 	err = s.conn.Services().Find(query).One(&rService)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(rService.Name, gocheck.Equals, "some_service")
-	endpoints := map[string]string{
-		"production": "someservice.com",
-		"test":       "test.someservice.com",
-	}
 	action := testing.Action{
 		Action: "create-service",
 		User:   s.user.Email,
-		Extra:  []interface{}{"some_service", endpoints},
+		Extra:  []interface{}{"service=some_service", "endpoint=someservice.com"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -236,15 +232,105 @@ func (s *ProvisionSuite) TestUpdateHandlerShouldUpdateTheServiceWithDataFromMani
 	err = s.conn.Services().Find(bson.M{"_id": service.Name}).One(&service)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(service.Endpoint["production"], gocheck.Equals, "mysqlapi.com")
-	endpoints := map[string]string{"production": "mysqlapi.com", "test": "localhost:8000"}
 	action := testing.Action{
 		Action: "update-service",
 		User:   s.user.Email,
-		Extra:  []interface{}{service.Name, endpoints},
+		Extra:  []interface{}{"service=" + service.Name, "endpoint=mysqlapi.com"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
 
+func (s *ProvisionSuite) TestCreateHandlerSavesThePassword(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	manifest := `id: some_service
+password: s3cr3t
+auth: hmac
+endpoint:
+  production: someservice.com
+`
+	request, err := http.NewRequest("POST", "/services", bytes.NewBufferString(manifest))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = serviceCreate(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var rService service.Service
+	err = s.conn.Services().Find(bson.M{"_id": "some_service"}).One(&rService)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rService.Auth, gocheck.Equals, service.AuthHMAC)
+	c.Assert(rService.Password, gocheck.Not(gocheck.Equals), "")
+	c.Assert(rService.Password, gocheck.Not(gocheck.Equals), "s3cr3t")
+}
+
+func (s *ProvisionSuite) TestCreateHandlerInvalidAuthScheme(c *gocheck.C) {
+	manifest := `id: some_service
+password: s3cr3t
+auth: digest
+endpoint:
+  production: someservice.com
+`
+	request, err := http.NewRequest("POST", "/services", bytes.NewBufferString(manifest))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = serviceCreate(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	n, err := s.conn.Services().Find(bson.M{"_id": "some_service"}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
+func (s *ProvisionSuite) TestUpdateHandlerRotatesThePassword(c *gocheck.C) {
+	srv := service.Service{
+		Name:       "mysqlapi",
+		Endpoint:   map[string]string{"production": "sqlapi.com"},
+		OwnerTeams: []string{s.team.Name},
+	}
+	err := srv.SetPassword("old-password", "")
+	c.Assert(err, gocheck.IsNil)
+	err = srv.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": srv.Name})
+	manifest := "id: mysqlapi\npassword: new-password\nendpoint:\n  production: sqlapi.com\n"
+	request, err := http.NewRequest("PUT", "/services", bytes.NewBufferString(manifest))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = serviceUpdate(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var rService service.Service
+	err = s.conn.Services().Find(bson.M{"_id": srv.Name}).One(&rService)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rService.Password, gocheck.Equals, "new-password")
+}
+
+func (s *ProvisionSuite) TestUpdateHandlerKeepsThePasswordWhenItIsNotInTheManifest(c *gocheck.C) {
+	srv := service.Service{
+		Name:       "mysqlapi",
+		Endpoint:   map[string]string{"production": "sqlapi.com"},
+		OwnerTeams: []string{s.team.Name},
+	}
+	err := srv.SetPassword("old-password", service.AuthHMAC)
+	c.Assert(err, gocheck.IsNil)
+	err = srv.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": srv.Name})
+	p, err := filepath.Abs("testdata/manifest.yml")
+	manifest, err := ioutil.ReadFile(p)
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("PUT", "/services", bytes.NewBuffer(manifest))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = serviceUpdate(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	var rService service.Service
+	err = s.conn.Services().Find(bson.M{"_id": srv.Name}).One(&rService)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(rService.Password, gocheck.Equals, "old-password")
+	c.Assert(rService.Auth, gocheck.Equals, service.AuthHMAC)
+}
+
 func (s *ProvisionSuite) TestUpdateHandlerReturns404WhenTheServiceDoesNotExist(c *gocheck.C) {
 	p, err := filepath.Abs("testdata/manifest.yml")
 	c.Assert(err, gocheck.IsNil)
@@ -315,9 +401,9 @@ func (s *ProvisionSuite) TestDeleteHandler(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(se.Status, gocheck.Equals, "deleted")
 	action := testing.Action{
-		Action: "delete-service",
+		Action: "remove-service",
 		User:   s.user.Email,
-		Extra:  []interface{}{se.Name},
+		Extra:  []interface{}{"service=" + se.Name},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -609,9 +695,9 @@ func (s *ProvisionSuite) TestAddDocHandler(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(serv.Doc, gocheck.Equals, "doc")
 	action := testing.Action{
-		Action: "service-add-doc",
+		Action: "add-service-doc",
 		User:   s.user.Email,
-		Extra:  []interface{}{"some_service", "doc"},
+		Extra:  []interface{}{"service=some_service"},
 	}
 	c.Assert(action, testing.IsRecorded)
 }
@@ -660,7 +746,7 @@ func (s *ProvisionSuite) TestServicesAndInstancesByOwnerTeams(c *gocheck.C) {
 	defer service.DeleteInstance(&sInstance2)
 	results := servicesAndInstancesByOwner(s.user)
 	expected := []service.ServiceModel{
-		{Service: "mysql", Instances: []string{"foo"}},
+		{Service: "mysql", Instances: []string{"foo"}, Plans: []string{""}},
 	}
 	c.Assert(results, gocheck.DeepEquals, expected)
 }
@@ -0,0 +1,177 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/auth"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/rec"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+)
+
+// totpHeader is the header used for signaling clients that the login
+// requires a two-factor authentication code.
+const totpHeader = "X-Tsuru-TOTP"
+
+// loginHeader is the header that carries the handle of the pending login,
+// that clients send along with the two-factor authentication code, instead
+// of the credentials, in the "login" parameter.
+const loginHeader = "X-Tsuru-Login"
+
+// checkLoginTOTP checks the two-factor authentication code provided in the
+// login, when the user has enabled two-factor authentication.
+func checkLoginTOTP(w http.ResponseWriter, u *auth.User, code string) error {
+	if !u.TOTPEnabled {
+		return nil
+	}
+	if code == "" {
+		w.Header().Set(totpHeader, "required")
+		return &errors.HTTP{Code: http.StatusUnauthorized, Message: "Two-factor authentication code required"}
+	}
+	if err := u.CheckTOTP(code); err != nil {
+		if err == auth.ErrInvalidTOTPCode {
+			rec.Log(u.Email, "login-totp-failure")
+			w.Header().Set(totpHeader, "required")
+			return &errors.HTTP{Code: http.StatusUnauthorized, Message: err.Error()}
+		}
+		if err == auth.ErrTOTPLocked {
+			rec.Log(u.Email, "login-totp-locked")
+		}
+		return totpError(err)
+	}
+	return nil
+}
+
+func getTOTPCode(r *http.Request) (string, error) {
+	var params map[string]string
+	if r.Body == nil {
+		return "", &errors.HTTP{Code: http.StatusBadRequest, Message: "Missing code."}
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		return "", &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid JSON"}
+	}
+	if params["code"] == "" {
+		return "", &errors.HTTP{Code: http.StatusBadRequest, Message: "Missing code."}
+	}
+	return params["code"], nil
+}
+
+func totpError(err error) error {
+	switch err {
+	case auth.ErrInvalidTOTPCode, auth.ErrTOTPNotEnrolled:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	case auth.ErrTOTPAlreadyEnabled:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	case auth.ErrTOTPNotEnabled:
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: err.Error()}
+	case auth.ErrTOTPLocked:
+		return &errors.HTTP{Code: http.StatusForbidden, Message: err.Error()}
+	}
+	return err
+}
+
+// startTOTPEnrollment generates a new two-factor authentication secret for the
+// user. The user must confirm the enrollment with a valid code, see
+// enableTOTP.
+func startTOTPEnrollment(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "start-totp-enrollment")
+	secret, uri, err := u.StartTOTPEnrollment()
+	if err != nil {
+		return totpError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]string{"secret": secret, "uri": uri})
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, codes []string) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string][]string{"recoveryCodes": codes})
+}
+
+func enableTOTP(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	code, err := getTOTPCode(r)
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "enable-totp")
+	codes, err := u.EnableTOTP(code)
+	if err != nil {
+		return totpError(err)
+	}
+	// Sessions opened before enabling two-factor authentication didn't
+	// provide a code.
+	if err := auth.RevokeSessions(u, t.Token); err != nil {
+		return err
+	}
+	return writeRecoveryCodes(w, codes)
+}
+
+func disableTOTP(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	code, err := getTOTPCode(r)
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "disable-totp")
+	return totpError(u.DisableTOTP(code))
+}
+
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	code, err := getTOTPCode(r)
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "regenerate-recovery-codes")
+	codes, err := u.RegenerateRecoveryCodes(code)
+	if err != nil {
+		return totpError(err)
+	}
+	return writeRecoveryCodes(w, codes)
+}
+
+// setTeamTOTP defines whether members of the team must use two-factor
+// authentication. It expects a JSON body with the "required" key.
+func setTeamTOTP(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	var params map[string]bool
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid JSON"}
+	}
+	required, ok := params["required"]
+	if !ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Missing required flag."}
+	}
+	teamName := r.URL.Query().Get(":team")
+	rec.Log(t.UserEmail, "set-team-totp", "team="+teamName, fmt.Sprintf("required=%t", required))
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Teams().UpdateId(teamName, bson.M{"$set": bson.M{"requiretotp": required}})
+	if err == mgo.ErrNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: "Team not found"}
+	}
+	return err
+}
@@ -0,0 +1,366 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/auth"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/testing"
+	"launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// currentTOTPCode generates the current code for the given secret, as defined
+// in RFC 6238.
+func currentTOTPCode(c *gocheck.C, secret string) string {
+	key, err := base32.StdEncoding.DecodeString(secret)
+	c.Assert(err, gocheck.IsNil)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func (s *AuthSuite) createTOTPUser(c *gocheck.C) (*auth.User, []string) {
+	u := &auth.User{Email: "secure@globo.com", Password: "123456"}
+	err := u.Create()
+	c.Assert(err, gocheck.IsNil)
+	secret, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	codes, err := u.EnableTOTP(currentTOTPCode(c, secret))
+	c.Assert(err, gocheck.IsNil)
+	return u, codes
+}
+
+func (s *AuthSuite) TestLoginWithTOTPRequiresTheCode(c *gocheck.C) {
+	s.createTOTPUser(c)
+	b := bytes.NewBufferString(`{"password":"123456"}`)
+	request, err := http.NewRequest("POST", "/users/secure@globo.com/tokens?:email=secure@globo.com", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = login(recorder, request)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusUnauthorized)
+	c.Assert(e.Message, gocheck.Equals, "Two-factor authentication code required")
+	c.Assert(recorder.Header().Get("X-Tsuru-TOTP"), gocheck.Equals, "required")
+	c.Assert(recorder.Header().Get("X-Tsuru-Login"), gocheck.Not(gocheck.Equals), "")
+}
+
+func (s *AuthSuite) TestLoginWithTOTPInvalidCode(c *gocheck.C) {
+	s.createTOTPUser(c)
+	b := bytes.NewBufferString(`{"password":"123456","otp":"000000"}`)
+	request, err := http.NewRequest("POST", "/users/secure@globo.com/tokens?:email=secure@globo.com", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = login(recorder, request)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusUnauthorized)
+	c.Assert(e.Message, gocheck.Equals, auth.ErrInvalidTOTPCode.Error())
+	action := testing.Action{Action: "login-totp-failure", User: "secure@globo.com"}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *AuthSuite) TestLoginWithTOTPRecoveryCode(c *gocheck.C) {
+	_, codes := s.createTOTPUser(c)
+	b := bytes.NewBufferString(fmt.Sprintf(`{"password":"123456","otp":%q}`, codes[0]))
+	request, err := http.NewRequest("POST", "/users/secure@globo.com/tokens?:email=secure@globo.com", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = login(recorder, request)
+	c.Assert(err, gocheck.IsNil)
+	var result map[string]string
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result["token"], gocheck.Not(gocheck.Equals), "")
+}
+
+func (s *AuthSuite) TestLoginWithTOTPLocked(c *gocheck.C) {
+	_, codes := s.createTOTPUser(c)
+	for i := 0; i < 5; i++ {
+		b := bytes.NewBufferString(`{"password":"123456","otp":"000000"}`)
+		request, err := http.NewRequest("POST", "/users/secure@globo.com/tokens?:email=secure@globo.com", b)
+		c.Assert(err, gocheck.IsNil)
+		err = login(httptest.NewRecorder(), request)
+		c.Assert(err, gocheck.NotNil)
+	}
+	b := bytes.NewBufferString(fmt.Sprintf(`{"password":"123456","otp":%q}`, codes[0]))
+	request, err := http.NewRequest("POST", "/users/secure@globo.com/tokens?:email=secure@globo.com", b)
+	c.Assert(err, gocheck.IsNil)
+	err = login(httptest.NewRecorder(), request)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+	c.Assert(e.Message, gocheck.Equals, auth.ErrTOTPLocked.Error())
+	action := testing.Action{Action: "login-totp-locked", User: "secure@globo.com"}
+	c.Assert(action, testing.IsRecorded)
+}
+
+// oneTimeScheme is an authentication scheme that accepts each code only
+// once, like OAuth authorization codes.
+type oneTimeScheme struct {
+	used []string
+}
+
+func (s *oneTimeScheme) Login(params map[string]string) (string, error) {
+	for _, code := range s.used {
+		if code == params["code"] {
+			return "", auth.AuthenticationFailure{}
+		}
+	}
+	s.used = append(s.used, params["code"])
+	return "secure@globo.com", nil
+}
+
+func (s *oneTimeScheme) Info() (map[string]string, error) {
+	return nil, nil
+}
+
+func (s *AuthSuite) TestLoginWithSchemeAndTOTPDoesNotSendTheCodeAgain(c *gocheck.C) {
+	_, codes := s.createTOTPUser(c)
+	scheme := oneTimeScheme{}
+	auth.RegisterScheme("onetime", &scheme)
+	config.Set("auth:scheme", "onetime")
+	defer config.Unset("auth:scheme")
+	b := bytes.NewBufferString(`{"code":"some-code","redirectUrl":"http://localhost:35666"}`)
+	request, err := http.NewRequest("POST", "/auth/login", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = login(recorder, request)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusUnauthorized)
+	c.Assert(recorder.Header().Get("X-Tsuru-TOTP"), gocheck.Equals, "required")
+	handle := recorder.Header().Get("X-Tsuru-Login")
+	c.Assert(handle, gocheck.Not(gocheck.Equals), "")
+	b = bytes.NewBufferString(fmt.Sprintf(`{"login":%q,"otp":%q}`, handle, codes[0]))
+	request, err = http.NewRequest("POST", "/auth/login", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder = httptest.NewRecorder()
+	err = login(recorder, request)
+	c.Assert(err, gocheck.IsNil)
+	var result map[string]string
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, gocheck.IsNil)
+	t, err := auth.GetToken("bearer " + result["token"])
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.UserEmail, gocheck.Equals, "secure@globo.com")
+	c.Assert(scheme.used, gocheck.DeepEquals, []string{"some-code"})
+	_, err = auth.GetPendingLogin(handle)
+	c.Assert(err, gocheck.Equals, auth.ErrInvalidLogin)
+}
+
+func (s *AuthSuite) TestLoginWithInvalidPendingLogin(c *gocheck.C) {
+	_, codes := s.createTOTPUser(c)
+	b := bytes.NewBufferString(fmt.Sprintf(`{"login":"unknown","otp":%q}`, codes[0]))
+	request, err := http.NewRequest("POST", "/auth/login", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = login(recorder, request)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusUnauthorized)
+	c.Assert(e.Message, gocheck.Equals, auth.ErrInvalidLogin.Error())
+}
+
+func (s *AuthSuite) TestStartTOTPEnrollment(c *gocheck.C) {
+	u := &auth.User{Email: "secure@globo.com", Password: "123456"}
+	err := u.Create()
+	c.Assert(err, gocheck.IsNil)
+	token, err := u.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("POST", "/users/totp", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = startTOTPEnrollment(recorder, request, token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var result map[string]string
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result["secret"], gocheck.Not(gocheck.Equals), "")
+	c.Assert(result["uri"], gocheck.Matches, "^otpauth://totp/tsuru:secure%40globo.com.*")
+	action := testing.Action{Action: "start-totp-enrollment", User: u.Email}
+	c.Assert(action, testing.IsRecorded)
+	b := bytes.NewBufferString(fmt.Sprintf(`{"code":%q}`, currentTOTPCode(c, result["secret"])))
+	request, err = http.NewRequest("POST", "/users/totp/enable", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder = httptest.NewRecorder()
+	err = enableTOTP(recorder, request, token)
+	c.Assert(err, gocheck.IsNil)
+	var codes map[string][]string
+	err = json.NewDecoder(recorder.Body).Decode(&codes)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(codes["recoveryCodes"], gocheck.HasLen, 10)
+	u, err = auth.GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.TOTPEnabled, gocheck.Equals, true)
+}
+
+func (s *AuthSuite) TestEnableTOTPRevokesOtherSessions(c *gocheck.C) {
+	u := &auth.User{Email: "secure@globo.com", Password: "123456"}
+	err := u.Create()
+	c.Assert(err, gocheck.IsNil)
+	token, err := u.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	other, err := u.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	apiToken, err := u.CreateAPIToken("ci")
+	c.Assert(err, gocheck.IsNil)
+	secret, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	b := bytes.NewBufferString(fmt.Sprintf(`{"code":%q}`, currentTOTPCode(c, secret)))
+	request, err := http.NewRequest("POST", "/users/totp/enable", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = enableTOTP(recorder, request, token)
+	c.Assert(err, gocheck.IsNil)
+	_, err = auth.GetToken("bearer " + other.Token)
+	c.Assert(err, gocheck.NotNil)
+	_, err = auth.GetToken("bearer " + token.Token)
+	c.Assert(err, gocheck.IsNil)
+	_, err = auth.GetToken("bearer " + apiToken.Token)
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *AuthSuite) TestStartTOTPEnrollmentAlreadyEnabled(c *gocheck.C) {
+	u, _ := s.createTOTPUser(c)
+	token, err := u.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	request, err := http.NewRequest("POST", "/users/totp", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = startTOTPEnrollment(recorder, request, token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusConflict)
+}
+
+func (s *AuthSuite) TestEnableTOTPInvalidCode(c *gocheck.C) {
+	u := &auth.User{Email: "secure@globo.com", Password: "123456"}
+	err := u.Create()
+	c.Assert(err, gocheck.IsNil)
+	_, _, err = u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	token, err := u.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	b := bytes.NewBufferString(`{"code":"abcdef"}`)
+	request, err := http.NewRequest("POST", "/users/totp/enable", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = enableTOTP(recorder, request, token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
+
+func (s *AuthSuite) TestEnableTOTPWithoutCode(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/users/totp/enable", bytes.NewBufferString(`{}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = enableTOTP(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, "Missing code.")
+}
+
+func (s *AuthSuite) TestDisableTOTP(c *gocheck.C) {
+	u, codes := s.createTOTPUser(c)
+	token, err := u.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	b := bytes.NewBufferString(fmt.Sprintf(`{"code":%q}`, codes[1]))
+	request, err := http.NewRequest("DELETE", "/users/totp", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = disableTOTP(recorder, request, token)
+	c.Assert(err, gocheck.IsNil)
+	u, err = auth.GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.TOTPEnabled, gocheck.Equals, false)
+	action := testing.Action{Action: "disable-totp", User: u.Email}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *AuthSuite) TestDisableTOTPNotEnabled(c *gocheck.C) {
+	request, err := http.NewRequest("DELETE", "/users/totp", bytes.NewBufferString(`{"code":"123456"}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = disableTOTP(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusPreconditionFailed)
+}
+
+func (s *AuthSuite) TestSetTeamTOTP(c *gocheck.C) {
+	conn, _ := db.Conn()
+	defer conn.Close()
+	team := auth.Team{Name: "secureteam", Users: []string{s.user.Email}}
+	err := conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	b := bytes.NewBufferString(`{"required":true}`)
+	request, err := http.NewRequest("PUT", "/teams/secureteam/totp?:team=secureteam", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamTOTP(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	t, err := auth.GetTeam(team.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.RequireTOTP, gocheck.Equals, true)
+	action := testing.Action{
+		Action: "set-team-totp",
+		User:   s.user.Email,
+		Extra:  []interface{}{"team=secureteam", "required=true"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *AuthSuite) TestSetTeamTOTPTeamNotFound(c *gocheck.C) {
+	b := bytes.NewBufferString(`{"required":true}`)
+	request, err := http.NewRequest("PUT", "/teams/unknown/totp?:team=unknown", b)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamTOTP(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestSetTeamTOTPMissingFlag(c *gocheck.C) {
+	request, err := http.NewRequest("PUT", "/teams/tsuruteam/totp?:team=tsuruteam", bytes.NewBufferString(`{}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = setTeamTOTP(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+}
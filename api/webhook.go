@@ -0,0 +1,92 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/auth"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/rec"
+	"net/http"
+	"strings"
+)
+
+func listWebhooks(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	teamName := r.URL.Query().Get(":team")
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "list-webhooks", "team="+teamName)
+	team, err := auth.GetTeam(teamName)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: "Team not found"}
+	}
+	if !team.ContainsUser(u) {
+		return &errors.HTTP{Code: http.StatusForbidden, Message: "User is not member of this team"}
+	}
+	webhooks, err := app.ListWebhooks(team.Name)
+	if err != nil {
+		return err
+	}
+	if webhooks == nil {
+		webhooks = []app.Webhook{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(webhooks)
+}
+
+func addWebhook(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	var params struct {
+		URL    string
+		Events []string
+		Secret string
+	}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&params)
+	}
+	if params.URL == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the url of the webhook."}
+	}
+	teamName := r.URL.Query().Get(":team")
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "add-webhook", "team="+teamName, "url="+params.URL, "events="+strings.Join(params.Events, ","))
+	team, err := getManagedTeam(teamName, u)
+	if err != nil {
+		return err
+	}
+	webhook := app.Webhook{Team: team.Name, URL: params.URL, Events: params.Events, Secret: params.Secret}
+	err = app.AddWebhook(&webhook)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	} else if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]string{"id": webhook.ID.Hex(), "secret": webhook.Secret})
+}
+
+func removeWebhook(w http.ResponseWriter, r *http.Request, t *auth.Token) error {
+	teamName := r.URL.Query().Get(":team")
+	id := r.URL.Query().Get(":id")
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "remove-webhook", "team="+teamName, "webhook="+id)
+	team, err := getManagedTeam(teamName, u)
+	if err != nil {
+		return err
+	}
+	err = app.RemoveWebhook(team.Name, id)
+	if err == app.ErrWebhookNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
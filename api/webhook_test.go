@@ -0,0 +1,154 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/auth"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func (s *S) TestAddWebhook(c *gocheck.C) {
+	body := strings.NewReader(`{"url":"http://chat.tsuru.io/hook","events":["deploy","restart"],"secret":"s3cr3t"}`)
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/webhooks?:team=tsuruteam", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addWebhook(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveAll(bson.M{"team": s.team.Name})
+	var result map[string]string
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result["secret"], gocheck.Equals, "s3cr3t")
+	var webhook app.Webhook
+	err = s.conn.Webhooks().FindId(bson.ObjectIdHex(result["id"])).One(&webhook)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(webhook.Team, gocheck.Equals, s.team.Name)
+	c.Assert(webhook.URL, gocheck.Equals, "http://chat.tsuru.io/hook")
+	c.Assert(webhook.Events, gocheck.DeepEquals, []string{"deploy", "restart"})
+	action := testing.Action{
+		Action: "add-webhook",
+		User:   s.user.Email,
+		Extra:  []interface{}{"team=tsuruteam", "url=http://chat.tsuru.io/hook", "events=deploy,restart"},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestAddWebhookWithoutURL(c *gocheck.C) {
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/webhooks?:team=tsuruteam", strings.NewReader(`{}`))
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addWebhook(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, "You must provide the url of the webhook.")
+}
+
+func (s *S) TestAddWebhookInvalidEvent(c *gocheck.C) {
+	body := strings.NewReader(`{"url":"http://chat.tsuru.io/hook","events":["explode"]}`)
+	request, err := http.NewRequest("POST", "/teams/tsuruteam/webhooks?:team=tsuruteam", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addWebhook(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, `Unknown event "explode".`)
+}
+
+func (s *S) TestAddWebhookUserNotInTheTeam(c *gocheck.C) {
+	team := auth.Team{Name: "hooked"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	body := strings.NewReader(`{"url":"http://chat.tsuru.io/hook"}`)
+	request, err := http.NewRequest("POST", "/teams/hooked/webhooks?:team=hooked", body)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = addWebhook(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestListWebhooks(c *gocheck.C) {
+	webhook := app.Webhook{Team: s.team.Name, URL: "http://chat.tsuru.io/hook", Events: []string{"deploy"}}
+	err := app.AddWebhook(&webhook)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(webhook.ID)
+	request, err := http.NewRequest("GET", "/teams/tsuruteam/webhooks?:team=tsuruteam", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listWebhooks(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(recorder.Header().Get("Content-Type"), gocheck.Equals, "application/json")
+	var webhooks []map[string]interface{}
+	err = json.NewDecoder(recorder.Body).Decode(&webhooks)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(webhooks, gocheck.DeepEquals, []map[string]interface{}{{
+		"id":     webhook.ID.Hex(),
+		"team":   s.team.Name,
+		"url":    "http://chat.tsuru.io/hook",
+		"events": []interface{}{"deploy"},
+	}})
+	action := testing.Action{Action: "list-webhooks", User: s.user.Email, Extra: []interface{}{"team=tsuruteam"}}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestListWebhooksTeamNotFound(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/teams/unknown/webhooks?:team=unknown", nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = listWebhooks(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRemoveWebhook(c *gocheck.C) {
+	webhook := app.Webhook{Team: s.team.Name, URL: "http://chat.tsuru.io/hook"}
+	err := app.AddWebhook(&webhook)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(webhook.ID)
+	url := "/teams/tsuruteam/webhooks/" + webhook.ID.Hex() + "?:team=tsuruteam&:id=" + webhook.ID.Hex()
+	request, err := http.NewRequest("DELETE", url, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = removeWebhook(recorder, request, s.token)
+	c.Assert(err, gocheck.IsNil)
+	n, err := s.conn.Webhooks().FindId(webhook.ID).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+	action := testing.Action{
+		Action: "remove-webhook",
+		User:   s.user.Email,
+		Extra:  []interface{}{"team=tsuruteam", "webhook=" + webhook.ID.Hex()},
+	}
+	c.Assert(action, testing.IsRecorded)
+}
+
+func (s *S) TestRemoveWebhookNotFound(c *gocheck.C) {
+	id := bson.NewObjectId().Hex()
+	request, err := http.NewRequest("DELETE", "/teams/tsuruteam/webhooks/"+id+"?:team=tsuruteam&:id="+id, nil)
+	c.Assert(err, gocheck.IsNil)
+	recorder := httptest.NewRecorder()
+	err = removeWebhook(recorder, request, s.token)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusNotFound)
+}
@@ -261,7 +261,8 @@ var exportEnvironmentsAction = action.Action{
 	},
 	Backward: func(ctx action.BWContext) {
 		app := ctx.Params[0].(*App)
-		auth.DeleteToken(app.Env["TSURU_APP_TOKEN"].Value)
+		token, _ := app.EnvValue("TSURU_APP_TOKEN")
+		auth.DeleteToken(token)
 		if app.Get() == nil {
 			s3Env := app.InstanceEnv(s3InstanceName)
 			vars := make([]string, len(s3Env)+3)
@@ -21,8 +21,10 @@ import (
 	"github.com/globocom/tsuru/queue"
 	"github.com/globocom/tsuru/quota"
 	"github.com/globocom/tsuru/repository"
+	"github.com/globocom/tsuru/secret"
 	"github.com/globocom/tsuru/service"
 	"io"
+	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"os"
 	"regexp"
@@ -34,8 +36,9 @@ import (
 var Provisioner provision.Provisioner
 
 var (
-	nameRegexp  = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
-	cnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][\w-.]+$`)
+	nameRegexp    = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
+	cnameRegexp   = regexp.MustCompile(`^[a-zA-Z0-9][\w-.]+$`)
+	envNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 )
 
 // App is the main type in tsuru. An app represents a real world application.
@@ -53,11 +56,33 @@ type App struct {
 	State    string
 	Deploys  uint
 
+	// BlueGreen holds the state of the blue/green deploy mode, it is nil
+	// when the mode is disabled.
+	BlueGreen *BlueGreen `bson:",omitempty"`
+
+	// Primary is the name of the app that owns this app, when this app is
+	// a blue/green standby app.
+	Primary string `bson:",omitempty"`
+
+	// Plan defines the resources available to each unit of the app.
+	Plan Plan
+
+	// AutoScale holds the autoscaling rules of the app.
+	AutoScale *AutoScaleConfig `bson:",omitempty"`
+
+	// LogDrains are the URLs of the external log sinks that receive the
+	// logs of the app.
+	LogDrains []string `bson:",omitempty"`
+
+	// LogRetention overrides the default log retention policy for the app.
+	LogRetention *LogRetention `bson:",omitempty"`
+
 	hr hookRunner
 }
 
 // MarshalJSON marshals the app in json format. It returns a JSON object with
-// the following keys: name, framework, teams, units, repository and ip.
+// the following keys: name, framework, teams, units, repository, ip, cname,
+// ready and plan.
 func (app *App) MarshalJSON() ([]byte, error) {
 	result := make(map[string]interface{})
 	result["name"] = app.Name
@@ -68,6 +93,7 @@ func (app *App) MarshalJSON() ([]byte, error) {
 	result["ip"] = app.Ip
 	result["cname"] = app.CName
 	result["ready"] = app.State == "ready"
+	result["plan"] = app.Plan
 	return json.Marshal(&result)
 }
 
@@ -77,6 +103,7 @@ type Applog struct {
 	Message string
 	Source  string
 	AppName string
+	Unit    string
 }
 
 // Get queries the database and fills the App object with data retrieved from
@@ -117,6 +144,21 @@ func CreateApp(app *App, user *auth.User) error {
 	}
 	app.SetTeams(teams)
 	app.Owner = user.Email
+	var plan *Plan
+	if app.Plan.Name == "" {
+		plan, err = defaultPlan()
+	} else {
+		plan, err = findPlanByName(app.Plan.Name)
+	}
+	if err == ErrPlanNotFound {
+		return &errors.ValidationError{Message: err.Error()}
+	} else if err != nil {
+		return err
+	}
+	app.Plan = *plan
+	if err := checkTeamsMemory(app, plan.Memory); err != nil {
+		return err
+	}
 	if !app.isValid() {
 		msg := "Invalid app name, your app should have at most 63 " +
 			"characters, containing only lower case letters, numbers or dashes, " +
@@ -183,7 +225,13 @@ func (app *App) unbind() error {
 //       2. Destroy the app unit using juju
 //       3. Unbind all service instances from the app
 //       4. Remove the app from the database
+//
+// If the app is deployed in blue/green mode, its standby app is destroyed as
+// well.
 func ForceDestroy(app *App) error {
+	if standby, err := app.standby(); err == nil {
+		ForceDestroy(standby)
+	}
 	gURL := repository.ServerURL()
 	(&gandalf.Client{Endpoint: gURL}).RemoveRepository(app.Name)
 	useS3, _ := config.GetBool("bucket-support")
@@ -194,7 +242,7 @@ func ForceDestroy(app *App) error {
 		Provisioner.Destroy(app)
 		app.unbind()
 	}
-	token := app.Env["TSURU_APP_TOKEN"].Value
+	token, _ := app.EnvValue("TSURU_APP_TOKEN")
 	auth.DeleteToken(token)
 	quota.Release(app.Owner, app.Name)
 	conn, err := db.Conn()
@@ -232,11 +280,19 @@ func (app *App) AddUnits(n uint) error {
 	if n == 0 {
 		return stderr.New("Cannot add zero units.")
 	}
-	return action.NewPipeline(
+	if err := checkTeamsMemory(app, int64(n)*app.Plan.Memory); err != nil {
+		return err
+	}
+	err := action.NewPipeline(
 		&reserveUnitsToAdd,
 		&provisionAddUnits,
 		&saveNewUnitsInDatabase,
 	).Execute(app, n)
+	if err != nil {
+		return err
+	}
+	fireWebhooks(app, EventAddUnits, map[string]interface{}{"units": n})
+	return nil
 }
 
 // RemoveUnit removes a unit by its InstanceId or Name.
@@ -351,6 +407,7 @@ func (app *App) RemoveUnits(n uint) error {
 		bson.M{"$set": bson.M{"units": app.Units}},
 	)
 	quota.Release(app.Name, items...)
+	fireWebhooks(app, EventRemoveUnits, map[string]interface{}{"units": len(removed)})
 	if err == nil {
 		return dbErr
 	}
@@ -455,15 +512,34 @@ func (app *App) SetTeams(teams []auth.Team) {
 	sort.Strings(app.Teams)
 }
 
-// setEnv sets the given environment variable in the app.
-func (app *App) setEnv(env bind.EnvVar) {
+// setEnv sets the given environment variable in the app. The value of the
+// variable is encrypted before being stored (see the secret package).
+func (app *App) setEnv(env bind.EnvVar) error {
 	if app.Env == nil {
 		app.Env = make(map[string]bind.EnvVar)
 	}
-	app.Env[env.Name] = env
 	if env.Public {
 		app.Log(fmt.Sprintf("setting env %s with value %s", env.Name, env.Value), "tsuru")
 	}
+	value, err := secret.Encrypt(env.Value)
+	if err != nil {
+		return err
+	}
+	env.Value = value
+	app.Env[env.Name] = env
+	return nil
+}
+
+// EnvValue returns the value of the given environment variable in plain text.
+// It returns an empty string if the variable is not declared in the app.
+//
+// Values of private variables should never be sent to users.
+func (app *App) EnvValue(name string) (string, error) {
+	env, ok := app.Env[name]
+	if !ok {
+		return "", nil
+	}
+	return secret.Decrypt(env.Value)
 }
 
 // getEnv returns the environment variable if it's declared in the app. It will
@@ -511,8 +587,9 @@ func (app *App) Run(cmd string, w io.Writer, once bool) error {
 
 func (app *App) sourced(cmd string, w io.Writer, once bool) error {
 	var mapEnv = func(name string) string {
-		if e, ok := app.Env[name]; ok {
-			return e.Value
+		if _, ok := app.Env[name]; ok {
+			value, _ := app.EnvValue(name)
+			return value
 		}
 		if e := os.Getenv(name); e != "" {
 			return e
@@ -548,6 +625,7 @@ func (app *App) Restart(w io.Writer) error {
 		log.Printf("[restart] error on restart the app %s - %s", app.Name, err)
 		return err
 	}
+	fireWebhooks(app, EventRestart, nil)
 	return app.hookRunner().Restart(app, w, "after")
 }
 
@@ -583,6 +661,15 @@ func (app *App) GetName() string {
 	return app.Name
 }
 
+// GetRepositoryName returns the name of the repository of the app. Standby
+// apps use the repository of their primary app.
+func (app *App) GetRepositoryName() string {
+	if app.Primary != "" {
+		return app.Primary
+	}
+	return app.Name
+}
+
 // GetIp returns the ip of the app.
 func (app *App) GetIp() string {
 	return app.Ip
@@ -617,7 +704,11 @@ func (app *App) SerializeEnvVars() error {
 	cmd := "cat > /home/application/apprc <<END\n"
 	cmd += fmt.Sprintf("# generated by tsuru at %s\n", time.Now().Format(time.RFC822Z))
 	for k, v := range app.Env {
-		cmd += fmt.Sprintf(`export %s="%s"`+"\n", k, v.Value)
+		value, err := secret.Decrypt(v.Value)
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt the env var %s: %s.", k, err)
+		}
+		cmd += fmt.Sprintf(`export %s="%s"`+"\n", k, value)
 	}
 	cmd += "END\n"
 	err := app.run(cmd, &buf, false)
@@ -651,25 +742,7 @@ func (app *App) SetEnvs(envs []bind.EnvVar, publicOnly bool) error {
 // in the units of the app.
 func (app *App) setEnvsToApp(envs []bind.EnvVar, publicOnly, useQueue bool) error {
 	if len(envs) > 0 {
-		for _, env := range envs {
-			set := true
-			if publicOnly {
-				e, err := app.getEnv(env.Name)
-				if err == nil && !e.Public {
-					set = false
-				}
-			}
-			if set {
-				app.setEnv(env)
-			}
-		}
-		conn, err := db.Conn()
-		if err != nil {
-			return err
-		}
-		defer conn.Close()
-		err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"env": app.Env}})
-		if err != nil {
+		if err := app.saveEnvs(envs, publicOnly); err != nil {
 			return err
 		}
 		if useQueue {
@@ -681,6 +754,78 @@ func (app *App) setEnvsToApp(envs []bind.EnvVar, publicOnly, useQueue bool) erro
 	return nil
 }
 
+// saveEnvs sets the environment variables in the app and stores all of them
+// in the database, using a single update.
+func (app *App) saveEnvs(envs []bind.EnvVar, publicOnly bool) error {
+	for _, env := range envs {
+		set := true
+		if publicOnly {
+			e, err := app.getEnv(env.Name)
+			if err == nil && !e.Public {
+				set = false
+			}
+		}
+		if set {
+			if err := app.setEnv(env); err != nil {
+				return err
+			}
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"env": app.Env}})
+	if err != nil {
+		return err
+	}
+	fireWebhooks(app, EventSetEnv, map[string]interface{}{"envs": envNames(envs)})
+	return nil
+}
+
+// ImportEnvs sets a set of public environment variables in the app at once:
+// the variables are validated, stored in a single update and written to the
+// units of the app a single time. Private variables are never overridden.
+//
+// When restart is true, the app is restarted after the variables are written,
+// so its processes get the new values. The output of the restart is written
+// to w.
+func (app *App) ImportEnvs(envs []bind.EnvVar, restart bool, w io.Writer) error {
+	if len(envs) == 0 {
+		return &errors.ValidationError{Message: "There are no environment variables to import."}
+	}
+	for _, env := range envs {
+		if !envNameRegexp.MatchString(env.Name) {
+			return &errors.ValidationError{Message: fmt.Sprintf("Invalid environment variable name: %q.", env.Name)}
+		}
+		if e, err := app.getEnv(env.Name); err == nil && !e.Public {
+			msg := fmt.Sprintf("The environment variable %s is private and can't be overridden.", env.Name)
+			return &errors.ValidationError{Message: msg}
+		}
+	}
+	if err := app.saveEnvs(envs, true); err != nil {
+		return err
+	}
+	if err := app.SerializeEnvVars(); err != nil {
+		return err
+	}
+	if restart {
+		return app.Restart(w)
+	}
+	return nil
+}
+
+// envNames returns the names of the variables, values are never sent to
+// webhooks.
+func envNames(envs []bind.EnvVar) []string {
+	names := make([]string, len(envs))
+	for i, env := range envs {
+		names[i] = env.Name
+	}
+	return names
+}
+
 // UnsetEnvs removes environment variables from an app, serializing the
 // remaining list of environment variables to all units of the app.
 //
@@ -708,11 +853,64 @@ func (app *App) UnsetEnvs(variableNames []string, publicOnly bool) error {
 		if err != nil {
 			return err
 		}
+		fireWebhooks(app, EventUnsetEnv, map[string]interface{}{"envs": variableNames})
 		go app.SerializeEnvVars()
 	}
 	return nil
 }
 
+// RotateEnvs encrypts the environment variables of all apps with the current
+// key, including variables stored in plain text. It returns the number of
+// apps that have been updated.
+//
+// The values are only changed in the database, units are not affected.
+func RotateEnvs() (int, error) {
+	if !secret.Enabled() {
+		return 0, stderr.New("There's no encryption key in the configuration (secret:key).")
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{"env": bson.M{"$exists": true}}).Select(bson.M{"name": 1, "env": 1}).All(&apps)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, app := range apps {
+		var changed bool
+		for name, env := range app.Env {
+			if !secret.NeedsRotation(env.Value) {
+				continue
+			}
+			value, err := secret.Rotate(env.Value)
+			if err != nil {
+				return n, fmt.Errorf("Failed to rotate the env var %s of the app %s: %s", name, app.Name, err)
+			}
+			// Each variable is updated only if it still has the value
+			// that was rotated, so variables set in the meantime are
+			// not overwritten.
+			field := "env." + name + ".value"
+			err = conn.Apps().Update(
+				bson.M{"name": app.Name, field: env.Value},
+				bson.M{"$set": bson.M{field: value}},
+			)
+			if err == mgo.ErrNotFound {
+				continue
+			} else if err != nil {
+				return n, err
+			}
+			changed = true
+		}
+		if changed {
+			n++
+		}
+	}
+	return n, nil
+}
+
 // SetCName defines the CName of the app. It updates the attribute,
 // calls the SetCName function on the provisioner and saves
 // the app in the database, returning an error when it cannot save the change
@@ -758,7 +956,15 @@ func (app *App) UnsetCName() error {
 
 // Log adds a log message to the app. Specifying a good source is good so the
 // user can filter where the message come from.
+//
+// The message is also forwarded to the log drains of the app, without waiting
+// for the delivery.
 func (app *App) Log(message, source string) error {
+	return app.LogFromUnit(message, source, "")
+}
+
+// LogFromUnit adds a log message generated by the given unit of the app.
+func (app *App) LogFromUnit(message, source, unit string) error {
 	messages := strings.Split(message, "\n")
 	logs := make([]interface{}, 0, len(messages))
 	for _, msg := range messages {
@@ -768,12 +974,14 @@ func (app *App) Log(message, source string) error {
 				Message: msg,
 				Source:  source,
 				AppName: app.Name,
+				Unit:    unit,
 			}
 			logs = append(logs, l)
 		}
 	}
 	if len(logs) > 0 {
 		go notify(app.Name, logs)
+		forwardLogs(app, logs)
 		conn, err := db.Conn()
 		if err != nil {
 			return err
@@ -787,31 +995,14 @@ func (app *App) Log(message, source string) error {
 // LastLogs returns a list of the last `lines` log of the app, matching the
 // given source.
 func (app *App) LastLogs(lines int, source string) ([]Applog, error) {
-	conn, err := db.Conn()
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-	logs := []Applog{}
-	q := bson.M{"appname": app.Name}
-	if source != "" {
-		q["source"] = source
-	}
-	err = conn.Logs().Find(q).Sort("-date").Limit(lines).All(&logs)
-	if err != nil {
-		return nil, err
-	}
-	l := len(logs)
-	for i := 0; i < l/2; i++ {
-		logs[i], logs[l-1-i] = logs[l-1-i], logs[i]
-	}
-	return logs, nil
+	logs, _, err := app.SearchLogs(&LogFilter{Lines: lines, Source: source})
+	return logs, err
 }
 
 // List returns the list of apps that the given user has access to.
 //
 // If the user does not have acces to any app, this function returns an empty
-// list and a nil error.
+// list and a nil error. Blue/green standby apps are never listed.
 func List(u *auth.User) ([]App, error) {
 	var apps []App
 	conn, err := db.Conn()
@@ -819,8 +1010,9 @@ func List(u *auth.User) ([]App, error) {
 		return nil, err
 	}
 	defer conn.Close()
+	notStandby := bson.M{"primary": bson.M{"$exists": false}}
 	if u.IsAdmin() {
-		if err := conn.Apps().Find(nil).All(&apps); err != nil {
+		if err := conn.Apps().Find(notStandby).All(&apps); err != nil {
 			return []App{}, err
 		}
 		return apps, nil
@@ -830,13 +1022,29 @@ func List(u *auth.User) ([]App, error) {
 		return []App{}, err
 	}
 	teams := auth.GetTeamsNames(ts)
-	if err := conn.Apps().Find(bson.M{"teams": bson.M{"$in": teams}}).All(&apps); err != nil {
+	query := bson.M{"teams": bson.M{"$in": teams}, "primary": bson.M{"$exists": false}}
+	if err := conn.Apps().Find(query).All(&apps); err != nil {
 		return []App{}, err
 	}
 	return apps, nil
 }
 
-// Swap calls the Provisioner.Swap.
+// Swap calls the Provisioner.Swap. It refuses to swap idle apps that run a
+// version discarded by AbortDeploy.
 func Swap(app1, app2 *App) error {
-	return Provisioner.Swap(app1, app2)
+	for _, a := range []*App{app1, app2} {
+		rejected, err := a.runsRejectedDeploy()
+		if err != nil {
+			return err
+		}
+		if rejected {
+			return ErrRejectedDeploy
+		}
+	}
+	if err := Provisioner.Swap(app1, app2); err != nil {
+		return err
+	}
+	fireWebhooks(app1, EventSwap, map[string]interface{}{"with": app2.Name})
+	fireWebhooks(app2, EventSwap, map[string]interface{}{"with": app1.Name})
+	return nil
 }
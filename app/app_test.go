@@ -17,6 +17,7 @@ import (
 	"github.com/globocom/tsuru/queue"
 	"github.com/globocom/tsuru/quota"
 	"github.com/globocom/tsuru/repository"
+	"github.com/globocom/tsuru/secret"
 	"github.com/globocom/tsuru/service"
 	"github.com/globocom/tsuru/testing"
 	"labix.org/v2/mgo/bson"
@@ -239,6 +240,34 @@ func (s *S) TestCreateAppUserQuotaExceeded(c *gocheck.C) {
 	c.Assert(ok, gocheck.Equals, true)
 }
 
+func (s *S) TestCreateAppWithPlan(c *gocheck.C) {
+	config.Unset("bucket-support")
+	defer config.Set("bucket-support", true)
+	ts := s.t.StartGandalfTestServer(&testHandler{})
+	defer ts.Close()
+	plan := Plan{Name: "small", Memory: 256 << 20, Swap: 256 << 20, CpuShare: 50}
+	err := s.conn.Plans().Insert(plan)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(plan.Name)
+	a := App{Name: "limited", Platform: "python", Plan: Plan{Name: "small"}}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer ForceDestroy(&a)
+	var retrievedApp App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&retrievedApp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(retrievedApp.Plan, gocheck.DeepEquals, plan)
+}
+
+func (s *S) TestCreateAppWithUnknownPlan(c *gocheck.C) {
+	a := App{Name: "limited", Platform: "python", Plan: Plan{Name: "unknown"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.ValidationError)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Message, gocheck.Equals, ErrPlanNotFound.Error())
+}
+
 func (s *S) TestCreateWithoutBucketSupport(c *gocheck.C) {
 	config.Unset("bucket-support")
 	defer config.Set("bucket-support", true)
@@ -1015,6 +1044,45 @@ func (s *S) TestRevokeAccessFailsIfTheTeamsDoesNotHaveAccessToTheApp(c *gocheck.
 	c.Assert(err, gocheck.ErrorMatches, "^This team does not have access to this app$")
 }
 
+func (s *S) TestRotateEnvs(c *gocheck.C) {
+	config.Set("secret:key", "old-key")
+	defer config.Unset("secret:key")
+	defer config.Unset("secret:old-keys")
+	a := App{Name: "rotated", Platform: "django"}
+	err := a.setEnv(bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t"})
+	c.Assert(err, gocheck.IsNil)
+	a.Env["DATABASE_HOST"] = bind.EnvVar{Name: "DATABASE_HOST", Value: "localhost", Public: true}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	config.Set("secret:key", "new-key")
+	config.Set("secret:old-keys", []interface{}{"old-key"})
+	n, err := RotateEnvs()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 1)
+	stored := App{Name: a.Name}
+	err = stored.Get()
+	c.Assert(err, gocheck.IsNil)
+	for _, env := range stored.Env {
+		c.Assert(secret.NeedsRotation(env.Value), gocheck.Equals, false)
+	}
+	config.Unset("secret:old-keys")
+	value, err := stored.EnvValue("DATABASE_PASSWORD")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, "s3cr3t")
+	value, err = stored.EnvValue("DATABASE_HOST")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, "localhost")
+	n, err = RotateEnvs()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
+func (s *S) TestRotateEnvsWithoutKey(c *gocheck.C) {
+	_, err := RotateEnvs()
+	c.Assert(err, gocheck.NotNil)
+}
+
 func (s *S) TestSetEnvNewAppsTheMapIfItIsNil(c *gocheck.C) {
 	a := App{Name: "how-many-more-times"}
 	c.Assert(a.Env, gocheck.IsNil)
@@ -1032,6 +1100,41 @@ func (s *S) TestSetEnvironmentVariableToApp(c *gocheck.C) {
 	c.Assert(env.Public, gocheck.Equals, true)
 }
 
+func (s *S) TestSetEnvEncryptsTheValue(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	a := App{Name: "appName", Platform: "django"}
+	err := a.setEnv(bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t"})
+	c.Assert(err, gocheck.IsNil)
+	env := a.Env["DATABASE_PASSWORD"]
+	c.Assert(secret.IsEncrypted(env.Value), gocheck.Equals, true)
+	value, err := a.EnvValue("DATABASE_PASSWORD")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, "s3cr3t")
+}
+
+func (s *S) TestSetEnvEncryptsValuesThatLookEncrypted(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	a := App{Name: "appName", Platform: "django"}
+	err := a.setEnv(bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t"})
+	c.Assert(err, gocheck.IsNil)
+	ciphertext := a.Env["DATABASE_PASSWORD"].Value
+	err = a.setEnv(bind.EnvVar{Name: "COPIED", Value: ciphertext})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Env["COPIED"].Value, gocheck.Not(gocheck.Equals), ciphertext)
+	value, err := a.EnvValue("COPIED")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, ciphertext)
+}
+
+func (s *S) TestEnvValueUndeclaredVariable(c *gocheck.C) {
+	a := App{Name: "appName"}
+	value, err := a.EnvValue("DATABASE_PASSWORD")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, "")
+}
+
 func (s *S) TestSetEnvRespectsThePublicOnlyFlagKeepPrivateVariablesWhenItsTrue(c *gocheck.C) {
 	a := App{
 		Name:  "myapp",
@@ -1195,6 +1298,92 @@ func (s *S) TestUnsetEnvRespectsThePublicOnlyFlagUnsettingAllVariablesWhenItsFal
 	c.Assert(newApp.Env, gocheck.DeepEquals, map[string]bind.EnvVar{})
 }
 
+func (s *S) TestImportEnvs(c *gocheck.C) {
+	s.provisioner.PrepareOutput([]byte("exported"))
+	a := App{
+		Name:  "imported",
+		Teams: []string{s.team.Name},
+		Units: []Unit{{Name: "i-0800", State: "started"}},
+		Env: map[string]bind.EnvVar{
+			"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	envs := []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "remotehost", Public: true},
+		{Name: "DATABASE_USER", Value: "root", Public: true},
+	}
+	var buf bytes.Buffer
+	err = a.ImportEnvs(envs, false, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "")
+	stored := App{Name: a.Name}
+	err = stored.Get()
+	c.Assert(err, gocheck.IsNil)
+	expected := map[string]bind.EnvVar{
+		"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "remotehost", Public: true},
+		"DATABASE_USER": {Name: "DATABASE_USER", Value: "root", Public: true},
+	}
+	c.Assert(stored.Env, gocheck.DeepEquals, expected)
+	cmds := s.provisioner.GetCmds("", &a)
+	c.Assert(cmds, gocheck.HasLen, 1)
+	c.Assert(s.provisioner.Restarts(&a), gocheck.Equals, 0)
+}
+
+func (s *S) TestImportEnvsRestartsTheApp(c *gocheck.C) {
+	var runner fakeHookRunner
+	a := App{Name: "imported", Platform: "django", hr: &runner}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var buf bytes.Buffer
+	err = a.ImportEnvs([]bind.EnvVar{{Name: "DATABASE_USER", Value: "root", Public: true}}, true, &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Restarts(&a), gocheck.Equals, 1)
+	c.Assert(buf.String(), gocheck.Matches, "(?s).*---> Restarting your app.*")
+}
+
+func (s *S) TestImportEnvsValidation(c *gocheck.C) {
+	a := App{
+		Name: "imported",
+		Env: map[string]bind.EnvVar{
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: false},
+		},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	var tests = []struct {
+		envs    []bind.EnvVar
+		message string
+	}{
+		{nil, "There are no environment variables to import."},
+		{
+			[]bind.EnvVar{{Name: "DATABASE_USER", Value: "root"}, {Name: "1INVALID", Value: "x"}},
+			`Invalid environment variable name: "1INVALID".`,
+		},
+		{
+			[]bind.EnvVar{{Name: "DATABASE_USER", Value: "root"}, {Name: "DATABASE_PASSWORD", Value: "123"}},
+			"The environment variable DATABASE_PASSWORD is private and can't be overridden.",
+		},
+	}
+	for _, t := range tests {
+		err := a.ImportEnvs(t.envs, false, nil)
+		c.Assert(err, gocheck.NotNil)
+		e, ok := err.(*errors.ValidationError)
+		c.Assert(ok, gocheck.Equals, true)
+		c.Assert(e.Message, gocheck.Equals, t.message)
+	}
+	stored := App{Name: a.Name}
+	err = stored.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Env, gocheck.HasLen, 1)
+}
+
 func (s *S) TestGetEnvironmentVariableFromApp(c *gocheck.C) {
 	a := App{Name: "whole-lotta-love"}
 	a.setEnv(bind.EnvVar{Name: "PATH", Value: "/"})
@@ -1488,6 +1677,20 @@ func (s *S) TestLog(c *gocheck.C) {
 	c.Assert(logs[0].AppName, gocheck.Equals, a.Name)
 }
 
+func (s *S) TestLogFromUnit(c *gocheck.C) {
+	a := App{Name: "newApp"}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	err := a.LogFromUnit("unit log msg", "app", "newApp/1")
+	c.Assert(err, gocheck.IsNil)
+	var logs []Applog
+	err = s.conn.Logs().Find(bson.M{"appname": a.Name}).All(&logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "unit log msg")
+	c.Assert(logs[0].Source, gocheck.Equals, "app")
+	c.Assert(logs[0].Unit, gocheck.Equals, "newApp/1")
+}
+
 func (s *S) TestLogShouldAddOneRecordByLine(c *gocheck.C) {
 	a := App{Name: "newApp"}
 	err := s.conn.Apps().Insert(a)
@@ -1654,6 +1857,13 @@ func (s *S) TestAppMarshalJSON(c *gocheck.C) {
 	expected["ip"] = "10.10.10.1"
 	expected["cname"] = "name.mycompany.com"
 	expected["ready"] = false
+	expected["plan"] = map[string]interface{}{
+		"name":     "",
+		"memory":   float64(0),
+		"swap":     float64(0),
+		"cpushare": float64(0),
+		"default":  false,
+	}
 	data, err := app.MarshalJSON()
 	c.Assert(err, gocheck.IsNil)
 	result := make(map[string]interface{})
@@ -1680,6 +1890,13 @@ func (s *S) TestAppMarshalJSONReady(c *gocheck.C) {
 	expected["ip"] = "10.10.10.1"
 	expected["cname"] = "name.mycompany.com"
 	expected["ready"] = true
+	expected["plan"] = map[string]interface{}{
+		"name":     "",
+		"memory":   float64(0),
+		"swap":     float64(0),
+		"cpushare": float64(0),
+		"default":  false,
+	}
 	data, err := app.MarshalJSON()
 	c.Assert(err, gocheck.IsNil)
 	result := make(map[string]interface{})
@@ -1763,6 +1980,35 @@ func (s *S) TestSerializeEnvVars(c *gocheck.C) {
 	c.Assert(cmd, gocheck.Matches, cmdRegexp)
 }
 
+func (s *S) TestSerializeEnvVarsDecryptsTheValues(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	s.provisioner.PrepareOutput([]byte("exported"))
+	app := App{
+		Name:  "time",
+		Teams: []string{s.team.Name},
+		Units: []Unit{{Name: "i-0800", State: "started"}},
+	}
+	err := app.setEnv(bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t"})
+	c.Assert(err, gocheck.IsNil)
+	err = app.SerializeEnvVars()
+	c.Assert(err, gocheck.IsNil)
+	cmds := s.provisioner.GetCmds("", &app)
+	c.Assert(cmds, gocheck.HasLen, 1)
+	c.Assert(cmds[0].Cmd, gocheck.Matches, `(?s).*export DATABASE_PASSWORD="s3cr3t".*`)
+}
+
+func (s *S) TestSerializeEnvVarsUnknownKey(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	app := App{Name: "time", Units: []Unit{{Name: "i-0800", State: "started"}}}
+	err := app.setEnv(bind.EnvVar{Name: "DATABASE_PASSWORD", Value: "s3cr3t"})
+	c.Assert(err, gocheck.IsNil)
+	config.Unset("secret:key")
+	err = app.SerializeEnvVars()
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Matches, "^Failed to decrypt the env var DATABASE_PASSWORD: .*")
+}
+
 func (s *S) TestSerializeEnvVarsErrorWithoutOutput(c *gocheck.C) {
 	s.provisioner.PrepareFailure("ExecuteCommand", stderr.New("Failed to run commands"))
 	app := App{
@@ -0,0 +1,288 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/quota"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+const defaultMetricsWindow = 300
+
+// UnitMetric represents a sample of the metrics of a unit, as reported by the
+// unit itself or by the router.
+type UnitMetric struct {
+	App       string
+	Unit      string
+	CPU       float64
+	Memory    float64
+	Requests  float64
+	Timestamp time.Time
+}
+
+// value returns the value of the named metric in the sample.
+func (m *UnitMetric) value(metric string) float64 {
+	switch metric {
+	case "mem":
+		return m.Memory
+	case "requests":
+		return m.Requests
+	}
+	return m.CPU
+}
+
+// AutoScaleRule is a rule that triggers a scaling decision when the average
+// of a metric crosses the threshold.
+type AutoScaleRule struct {
+	// Metric is the name of the metric: cpu, mem or requests.
+	Metric string
+
+	// Threshold is the value of the metric that triggers the rule.
+	Threshold float64
+
+	// Units is the number of units added or removed by the rule.
+	Units uint
+}
+
+func (r *AutoScaleRule) isValid() bool {
+	switch r.Metric {
+	case "cpu", "mem", "requests":
+		return r.Units > 0
+	}
+	return false
+}
+
+// AutoScaleConfig holds the autoscaling rules of an app.
+//
+// Periodically, the average of the metrics reported by the units of the app
+// is compared to the thresholds of the rules: units are added when the metric
+// of the Increase rule is above its threshold, and removed when the metric of
+// the Decrease rule is below its threshold. The number of units is always kept
+// between MinUnits and MaxUnits, and no decision is taken until Cooldown
+// seconds have passed since the last one.
+type AutoScaleConfig struct {
+	Enabled   bool
+	MinUnits  uint
+	MaxUnits  uint
+	Increase  AutoScaleRule
+	Decrease  AutoScaleRule
+	Cooldown  int
+	LastScale time.Time
+}
+
+func (c *AutoScaleConfig) validate() error {
+	if c.MinUnits == 0 {
+		return &errors.ValidationError{Message: "The minimum number of units must be greater than zero."}
+	}
+	if c.MaxUnits < c.MinUnits {
+		return &errors.ValidationError{Message: "The maximum number of units must not be lower than the minimum."}
+	}
+	if !c.Increase.isValid() || !c.Decrease.isValid() {
+		return &errors.ValidationError{Message: "Invalid rule: metric must be cpu, mem or requests, and the number of units must be greater than zero."}
+	}
+	if c.Increase.Metric == c.Decrease.Metric && c.Decrease.Threshold >= c.Increase.Threshold {
+		return &errors.ValidationError{Message: "The threshold for removing units must be lower than the threshold for adding units."}
+	}
+	return nil
+}
+
+// SetAutoScale validates and saves the autoscaling rules of the app.
+func SetAutoScale(a *App, c *AutoScaleConfig) error {
+	if c.Enabled {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"autoscale": c}})
+	if err != nil {
+		return err
+	}
+	a.AutoScale = c
+	return nil
+}
+
+// ReportMetrics saves metrics samples of units of the app. Every sample must
+// refer to a unit of the app. Samples are stored with the time they're
+// reported, unless they carry an older timestamp: samples from the future
+// would never leave the metrics window.
+func ReportMetrics(a *App, metrics []UnitMetric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	units := make(map[string]bool, len(a.Units))
+	for _, u := range a.Units {
+		units[u.Name] = true
+	}
+	for _, m := range metrics {
+		if !units[m.Unit] {
+			return &errors.ValidationError{Message: fmt.Sprintf("Unit %q does not belong to the app %s.", m.Unit, a.Name)}
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	docs := make([]interface{}, len(metrics))
+	now := time.Now()
+	for i, m := range metrics {
+		m.App = a.Name
+		if m.Timestamp.IsZero() || m.Timestamp.After(now) {
+			m.Timestamp = now
+		}
+		docs[i] = m
+	}
+	return conn.UnitMetrics().Insert(docs...)
+}
+
+// metricsWindow returns the amount of time considered when computing the
+// average of a metric. It is read from the configuration entry
+// autoscale:metrics-window, in seconds.
+func metricsWindow() time.Duration {
+	window, err := config.GetInt("autoscale:metrics-window")
+	if err != nil {
+		window = defaultMetricsWindow
+	}
+	return time.Duration(window) * time.Second
+}
+
+// averageMetrics returns the average of the given metrics reported by the
+// units of the app within the metrics window. The second return value
+// indicates whether there is any sample in the window.
+func (app *App) averageMetrics(names ...string) (map[string]float64, bool, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	since := time.Now().Add(-metricsWindow())
+	var samples []UnitMetric
+	query := bson.M{"app": app.Name, "timestamp": bson.M{"$gte": since}}
+	if err := conn.UnitMetrics().Find(query).All(&samples); err != nil {
+		return nil, false, err
+	}
+	result := make(map[string]float64, len(names))
+	if len(samples) == 0 {
+		return result, false, nil
+	}
+	for _, name := range names {
+		var sum float64
+		for _, s := range samples {
+			sum += s.value(name)
+		}
+		result[name] = sum / float64(len(samples))
+	}
+	return result, true, nil
+}
+
+// autoScale evaluates the autoscaling rules of the app, adding or removing
+// units when needed. Every decision is recorded in the app log. The cooldown
+// applies to failed decisions too, so they're not retried (and logged) on
+// every evaluation.
+func (app *App) autoScale() error {
+	c := app.AutoScale
+	if c == nil || !c.Enabled {
+		return nil
+	}
+	if time.Since(c.LastScale) < time.Duration(c.Cooldown)*time.Second {
+		return nil
+	}
+	units := uint(len(app.Units))
+	var (
+		n   uint
+		add bool
+		msg string
+	)
+	switch {
+	case units < c.MinUnits:
+		n, add = c.MinUnits-units, true
+		msg = fmt.Sprintf("app has %d units, minimum is %d", units, c.MinUnits)
+	case units > c.MaxUnits:
+		n = units - c.MaxUnits
+		msg = fmt.Sprintf("app has %d units, maximum is %d", units, c.MaxUnits)
+	default:
+		avg, ok, err := app.averageMetrics(c.Increase.Metric, c.Decrease.Metric)
+		if err != nil || !ok {
+			return err
+		}
+		if v := avg[c.Increase.Metric]; v > c.Increase.Threshold && units < c.MaxUnits {
+			n, add = minUnits(c.Increase.Units, c.MaxUnits-units), true
+			msg = fmt.Sprintf("%s is %.2f, above %.2f", c.Increase.Metric, v, c.Increase.Threshold)
+		} else if v := avg[c.Decrease.Metric]; v < c.Decrease.Threshold && units > c.MinUnits {
+			n = minUnits(c.Decrease.Units, units-c.MinUnits)
+			msg = fmt.Sprintf("%s is %.2f, below %.2f", c.Decrease.Metric, v, c.Decrease.Threshold)
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	var scaleErr error
+	if add {
+		app.Log(fmt.Sprintf("autoscale: %s, adding %d unit(s)", msg, n), "tsuru")
+		scaleErr = app.AddUnits(n)
+	} else {
+		app.Log(fmt.Sprintf("autoscale: %s, removing %d unit(s)", msg, n), "tsuru")
+		scaleErr = app.RemoveUnits(n)
+	}
+	if scaleErr != nil {
+		if _, ok := scaleErr.(*quota.QuotaExceededError); ok {
+			app.Log(fmt.Sprintf("autoscale: could not add units: %s", scaleErr), "tsuru")
+		} else {
+			app.Log(fmt.Sprintf("autoscale: failed to scale: %s", scaleErr), "tsuru")
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c.LastScale = time.Now()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"autoscale.lastscale": c.LastScale}})
+	if scaleErr != nil {
+		return scaleErr
+	}
+	return err
+}
+
+func minUnits(a, b uint) uint {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// AutoScale evaluates the autoscaling rules of all apps that have
+// autoscaling enabled, and removes metrics samples that are out of the
+// metrics window.
+func AutoScale() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var apps []App
+	if err := conn.Apps().Find(bson.M{"autoscale.enabled": true}).All(&apps); err != nil {
+		return err
+	}
+	for i := range apps {
+		if err := apps[i].autoScale(); err != nil {
+			log.Printf("Failed to autoscale the app %q: %s", apps[i].Name, err)
+		}
+	}
+	since := time.Now().Add(-metricsWindow())
+	_, err = conn.UnitMetrics().RemoveAll(bson.M{"timestamp": bson.M{"$lt": since}})
+	return err
+}
@@ -0,0 +1,267 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/quota"
+	"github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"strings"
+	"time"
+)
+
+func autoScaleConfig() *AutoScaleConfig {
+	return &AutoScaleConfig{
+		Enabled:  true,
+		MinUnits: 1,
+		MaxUnits: 4,
+		Increase: AutoScaleRule{Metric: "cpu", Threshold: 80, Units: 2},
+		Decrease: AutoScaleRule{Metric: "cpu", Threshold: 20, Units: 1},
+	}
+}
+
+func (s *S) TestSetAutoScale(c *gocheck.C) {
+	a := App{Name: "scaly"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	config := autoScaleConfig()
+	err = SetAutoScale(&a, config)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.AutoScale, gocheck.Equals, config)
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.AutoScale.MaxUnits, gocheck.Equals, uint(4))
+	c.Assert(stored.AutoScale.Increase, gocheck.DeepEquals, config.Increase)
+}
+
+func (s *S) TestSetAutoScaleValidation(c *gocheck.C) {
+	a := App{Name: "scaly"}
+	config := autoScaleConfig()
+	config.MinUnits = 0
+	c.Assert(SetAutoScale(&a, config), gocheck.NotNil)
+	config = autoScaleConfig()
+	config.MaxUnits = 0
+	c.Assert(SetAutoScale(&a, config), gocheck.NotNil)
+	config = autoScaleConfig()
+	config.Increase.Metric = "disk"
+	c.Assert(SetAutoScale(&a, config), gocheck.NotNil)
+	config = autoScaleConfig()
+	config.Decrease.Threshold = 90
+	c.Assert(SetAutoScale(&a, config), gocheck.NotNil)
+}
+
+func (s *S) TestReportMetrics(c *gocheck.C) {
+	a := App{Name: "scaly", Units: []Unit{{Name: "scaly/0"}, {Name: "scaly/1"}}}
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	err := ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 50}, {Unit: "scaly/0", CPU: 70}})
+	c.Assert(err, gocheck.IsNil)
+	avg, ok, err := a.averageMetrics("cpu")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(avg["cpu"], gocheck.Equals, 60.0)
+}
+
+func (s *S) TestReportMetricsUnitOfAnotherApp(c *gocheck.C) {
+	a := App{Name: "scaly", Units: []Unit{{Name: "scaly/0"}}}
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	err := ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 50}, {Unit: "other/0", CPU: 70}})
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	n, err := s.conn.UnitMetrics().Find(bson.M{"app": a.Name}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
+func (s *S) TestReportMetricsDoesNotStoreFutureTimestamps(c *gocheck.C) {
+	a := App{Name: "scaly", Units: []Unit{{Name: "scaly/0"}}}
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	future := time.Now().Add(24 * time.Hour)
+	err := ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 50, Timestamp: future}})
+	c.Assert(err, gocheck.IsNil)
+	var metric UnitMetric
+	err = s.conn.UnitMetrics().Find(bson.M{"app": a.Name}).One(&metric)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(metric.Timestamp.After(time.Now()), gocheck.Equals, false)
+}
+
+func (s *S) TestAverageMetricsIgnoresOldSamples(c *gocheck.C) {
+	a := App{Name: "scaly", Units: []Unit{{Name: "scaly/0"}}}
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	old := time.Now().Add(-time.Hour)
+	err := ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 50, Timestamp: old}})
+	c.Assert(err, gocheck.IsNil)
+	_, ok, err := a.averageMetrics("cpu")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ok, gocheck.Equals, false)
+}
+
+func (s *S) TestAutoScaleAddsUnits(c *gocheck.C) {
+	a := App{Name: "scaly", Platform: "python", AutoScale: autoScaleConfig()}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	defer testing.CleanQ(queueName)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(1)
+	c.Assert(err, gocheck.IsNil)
+	err = ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 95}})
+	c.Assert(err, gocheck.IsNil)
+	err = AutoScale()
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Units, gocheck.HasLen, 3)
+	c.Assert(a.AutoScale.LastScale.IsZero(), gocheck.Equals, false)
+	logs, err := a.LastLogs(1, "tsuru")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "autoscale: cpu is 95.00, above 80.00, adding 2 unit(s)")
+}
+
+func (s *S) TestAutoScaleRemovesUnits(c *gocheck.C) {
+	a := App{Name: "scaly", Platform: "python", AutoScale: autoScaleConfig()}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	defer testing.CleanQ(queueName)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(3)
+	c.Assert(err, gocheck.IsNil)
+	err = ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 5}})
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	err = a.autoScale()
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Units, gocheck.HasLen, 2)
+	logs, err := a.LastLogs(1, "tsuru")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs[0].Message, gocheck.Equals, "autoscale: cpu is 5.00, below 20.00, removing 1 unit(s)")
+}
+
+func (s *S) TestAutoScaleRespectsMaxUnits(c *gocheck.C) {
+	config := autoScaleConfig()
+	config.MaxUnits = 2
+	a := App{Name: "scaly", Platform: "python", AutoScale: config}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	defer testing.CleanQ(queueName)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(1)
+	c.Assert(err, gocheck.IsNil)
+	err = ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 95}})
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	err = a.autoScale()
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Units, gocheck.HasLen, 2)
+}
+
+func (s *S) TestAutoScaleRespectsCooldown(c *gocheck.C) {
+	config := autoScaleConfig()
+	config.Cooldown = 600
+	config.LastScale = time.Now()
+	a := App{Name: "scaly", Platform: "python", AutoScale: config}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	defer testing.CleanQ(queueName)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(1)
+	c.Assert(err, gocheck.IsNil)
+	err = ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 95}})
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	err = a.autoScale()
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Units, gocheck.HasLen, 2)
+}
+
+func (s *S) TestAutoScaleLogsQuotaExceeded(c *gocheck.C) {
+	a := App{Name: "scaly", Platform: "python", AutoScale: autoScaleConfig()}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	defer testing.CleanQ(queueName)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(1)
+	c.Assert(err, gocheck.IsNil)
+	err = quota.Create(a.Name, 1)
+	c.Assert(err, gocheck.IsNil)
+	defer quota.Delete(a.Name)
+	err = quota.Reserve(a.Name, a.Name+"-0")
+	c.Assert(err, gocheck.IsNil)
+	err = ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 95}})
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	err = a.autoScale()
+	c.Assert(err, gocheck.NotNil)
+	logs, err := a.LastLogs(1, "tsuru")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(strings.HasPrefix(logs[0].Message, "autoscale: could not add units:"), gocheck.Equals, true)
+}
+
+func (s *S) TestAutoScaleAppliesCooldownToFailures(c *gocheck.C) {
+	config := autoScaleConfig()
+	config.Cooldown = 600
+	a := App{Name: "scaly", Platform: "python", AutoScale: config}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.UnitMetrics().RemoveAll(bson.M{"app": a.Name})
+	defer testing.CleanQ(queueName)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddUnits(1)
+	c.Assert(err, gocheck.IsNil)
+	err = quota.Create(a.Name, 1)
+	c.Assert(err, gocheck.IsNil)
+	defer quota.Delete(a.Name)
+	err = quota.Reserve(a.Name, a.Name+"-0")
+	c.Assert(err, gocheck.IsNil)
+	err = ReportMetrics(&a, []UnitMetric{{Unit: "scaly/0", CPU: 95}})
+	c.Assert(err, gocheck.IsNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	err = a.autoScale()
+	c.Assert(err, gocheck.NotNil)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.AutoScale.LastScale.IsZero(), gocheck.Equals, false)
+	err = a.autoScale()
+	c.Assert(err, gocheck.IsNil)
+	logs, err := a.LastLogs(10, "tsuru")
+	c.Assert(err, gocheck.IsNil)
+	var failures int
+	for _, l := range logs {
+		if strings.HasPrefix(l.Message, "autoscale: could not add units:") {
+			failures++
+		}
+	}
+	c.Assert(failures, gocheck.Equals, 1)
+}
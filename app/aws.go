@@ -190,9 +190,14 @@ func createIAMUserPolicy(user *iam.User, appName, bucketName string) (*iam.UserP
 // related to the bucket (IAM user and IAM access key).
 func destroyBucket(app *App) error {
 	appName := strings.ToLower(app.Name)
-	env := app.InstanceEnv(s3InstanceName)
-	accessKeyID := env["TSURU_S3_ACCESS_KEY_ID"].Value
-	bucketName := env["TSURU_S3_BUCKET"].Value
+	accessKeyID, err := app.EnvValue("TSURU_S3_ACCESS_KEY_ID")
+	if err != nil {
+		return err
+	}
+	bucketName, err := app.EnvValue("TSURU_S3_BUCKET")
+	if err != nil {
+		return err
+	}
 	policyName := fmt.Sprintf("app-%s-bucket", appName)
 	s3Endpoint := getS3Endpoint()
 	iamEndpoint := getIAMEndpoint()
@@ -206,6 +211,6 @@ func destroyBucket(app *App) error {
 	if _, err := iamEndpoint.DeleteAccessKey(accessKeyID, appName); err != nil {
 		return err
 	}
-	_, err := iamEndpoint.DeleteUser(appName)
+	_, err = iamEndpoint.DeleteUser(appName)
 	return err
 }
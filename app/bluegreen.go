@@ -0,0 +1,281 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/action"
+	"github.com/globocom/tsuru/app/bind"
+	"github.com/globocom/tsuru/db"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrBlueGreenEnabled is returned by EnableBlueGreen when the app is
+	// already deployed in blue/green mode, or when it is a standby app.
+	ErrBlueGreenEnabled = stderr.New("Blue/green deploy is already enabled for this app.")
+
+	// ErrBlueGreenDisabled is returned by Promote and AbortDeploy when the
+	// app is not deployed in blue/green mode.
+	ErrBlueGreenDisabled = stderr.New("Blue/green deploy is not enabled for this app.")
+
+	// ErrNoPendingDeploy is returned by Promote and AbortDeploy when there
+	// is no deploy waiting for promotion.
+	ErrNoPendingDeploy = stderr.New("There is no deploy waiting for promotion.")
+
+	// ErrRejectedDeploy is returned by Swap when one of the apps is an idle
+	// app running a version discarded by AbortDeploy.
+	ErrRejectedDeploy = stderr.New("The idle app runs a version discarded by app-abort-deploy. Deploy a new version before putting it live.")
+)
+
+// smokeCheck is the function used to check the idle app after a blue/green
+// deploy, before it is allowed to be promoted.
+var smokeCheck = httpSmokeCheck
+
+// smokeCheckInterval is the time between two consecutive smoke check
+// requests.
+var smokeCheckInterval = time.Second
+
+// smokeCheckRequestTimeout is the maximum amount of time that a single smoke
+// check request may take.
+var smokeCheckRequestTimeout = 10 * time.Second
+
+var smokeCheckClient = &http.Client{
+	Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, smokeCheckRequestTimeout)
+		},
+		ResponseHeaderTimeout: smokeCheckRequestTimeout,
+	},
+}
+
+// BlueGreen holds the state of an app deployed in blue/green mode.
+//
+// In blue/green mode, each app has a hidden standby app, and deploys always go
+// to the app that is not receiving traffic (the idle app). Once the idle app
+// is promoted, the routes of both apps are swapped in the router, and the
+// previously live app becomes the idle one.
+type BlueGreen struct {
+	// Standby is the name of the standby app.
+	Standby string
+
+	// Swapped indicates whether the routes of the app are currently
+	// swapped with the routes of the standby app. When it is true, the
+	// standby app is live and the app itself is idle.
+	Swapped bool
+
+	// Pending is the version deployed to the idle app that is waiting to
+	// be promoted.
+	Pending string
+
+	// Rejected is the version discarded by AbortDeploy. It keeps running
+	// in the idle app, that can't be put live until a new version passes
+	// the smoke checks.
+	Rejected string
+}
+
+// standbyName returns the name of the standby app of the given app.
+func standbyName(appName string) string {
+	return appName + "-standby"
+}
+
+// EnableBlueGreen enables the blue/green deploy mode for the app, creating
+// its standby app.
+//
+// The standby app shares the repository, the teams and the owner of the app.
+// It does not count in the quota of the owner, and it is not listed in the
+// list of apps.
+func EnableBlueGreen(a *App) error {
+	if a.BlueGreen != nil || a.Primary != "" {
+		return ErrBlueGreenEnabled
+	}
+	standby := App{
+		Name:     standbyName(a.Name),
+		Platform: a.Platform,
+		Teams:    a.Teams,
+		Owner:    a.Owner,
+		Primary:  a.Name,
+	}
+	actions := []*action.Action{&insertApp, &exportEnvironmentsAction, &provisionApp}
+	if err := action.NewPipeline(actions...).Execute(&standby); err != nil {
+		return &AppCreationError{app: standby.Name, Err: err}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	a.BlueGreen = &BlueGreen{Standby: standby.Name}
+	return conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"bluegreen": a.BlueGreen}})
+}
+
+// standby returns the standby app of the given app.
+func (app *App) standby() (*App, error) {
+	if app.BlueGreen == nil {
+		return nil, ErrBlueGreenDisabled
+	}
+	standby := App{Name: app.BlueGreen.Standby}
+	if err := standby.Get(); err != nil {
+		return nil, err
+	}
+	return &standby, nil
+}
+
+// idle returns the app that is not receiving traffic in blue/green mode:
+// the standby app, or the app itself when the routes are swapped.
+func (app *App) idle() (*App, error) {
+	if app.BlueGreen.Swapped {
+		return app, nil
+	}
+	return app.standby()
+}
+
+// deployBlueGreen deploys the given version to the idle app, and runs the
+// smoke checks against it. When the smoke checks pass, the version is marked
+// as pending, waiting for Promote or AbortDeploy.
+func deployBlueGreen(a *App, version, user string, w io.Writer) error {
+	target, err := a.idle()
+	if err != nil {
+		return err
+	}
+	if target != a {
+		// The standby app is not bound to the service instances of the
+		// app, so it gets all the variables, including the private ones.
+		var envs []bind.EnvVar
+		for _, env := range a.Env {
+			value, err := a.EnvValue(env.Name)
+			if err != nil {
+				return err
+			}
+			env.Value = value
+			envs = append(envs, env)
+		}
+		if err := target.setEnvsToApp(envs, false, false); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "\n ---> Deploying version %s to the idle app %s\n", version, target.Name)
+	if err := deploy(target, version, user, w); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n ---> Running smoke checks against %s\n", target.Name)
+	if err := smokeCheck(target); err != nil {
+		fmt.Fprintf(w, "\n ---> Smoke checks failed: %s\n", err)
+		return err
+	}
+	a.BlueGreen.Rejected = ""
+	if err := a.setPendingDeploy(version); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n ---> Version %s is ready. Use app-promote to put it live, or app-abort-deploy to discard it.\n", version)
+	return nil
+}
+
+// Promote puts the version pending in the idle app live, swapping the routes
+// of the app and its standby app.
+func Promote(a *App) error {
+	if a.BlueGreen == nil {
+		return ErrBlueGreenDisabled
+	}
+	if a.BlueGreen.Pending == "" {
+		return ErrNoPendingDeploy
+	}
+	standby, err := a.standby()
+	if err != nil {
+		return err
+	}
+	if err := Swap(a, standby); err != nil {
+		return err
+	}
+	a.BlueGreen.Swapped = !a.BlueGreen.Swapped
+	a.BlueGreen.Pending = ""
+	return a.saveBlueGreen()
+}
+
+// AbortDeploy discards the version pending in the idle app. The live app is
+// not touched, and the idle app is marked as rejected, so it can't be swapped
+// with the live app until a new version is deployed to it.
+func AbortDeploy(a *App) error {
+	if a.BlueGreen == nil {
+		return ErrBlueGreenDisabled
+	}
+	if a.BlueGreen.Pending == "" {
+		return ErrNoPendingDeploy
+	}
+	a.BlueGreen.Rejected = a.BlueGreen.Pending
+	return a.setPendingDeploy("")
+}
+
+// runsRejectedDeploy checks whether the app is the idle app of a blue/green
+// deploy, running a version discarded by AbortDeploy.
+func (app *App) runsRejectedDeploy() (bool, error) {
+	if app.BlueGreen != nil {
+		return app.BlueGreen.Swapped && app.BlueGreen.Rejected != "", nil
+	}
+	if app.Primary == "" {
+		return false, nil
+	}
+	primary := App{Name: app.Primary}
+	if err := primary.Get(); err != nil {
+		return false, err
+	}
+	bg := primary.BlueGreen
+	return bg != nil && !bg.Swapped && bg.Rejected != "", nil
+}
+
+func (app *App) setPendingDeploy(version string) error {
+	app.BlueGreen.Pending = version
+	return app.saveBlueGreen()
+}
+
+func (app *App) saveBlueGreen() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"bluegreen": app.BlueGreen}})
+}
+
+// httpSmokeCheck sends requests to the address of the app until it answers
+// with a status lower than 500, or the timeout expires.
+//
+// The path and the timeout (in seconds) are read from the configuration
+// entries blue-green:smoke-check-path and blue-green:smoke-check-timeout,
+// defaulting to "/" and 60.
+func httpSmokeCheck(a *App) error {
+	addr, err := Provisioner.Addr(a)
+	if err != nil {
+		return err
+	}
+	path, _ := config.GetString("blue-green:smoke-check-path")
+	timeout, err := config.GetInt("blue-green:smoke-check-timeout")
+	if err != nil {
+		timeout = 60
+	}
+	url := "http://" + addr + "/" + strings.TrimLeft(path, "/")
+	var lastErr error
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := smokeCheckClient.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < http.StatusInternalServerError {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		lastErr = err
+		time.Sleep(smokeCheckInterval)
+	}
+	return fmt.Errorf("Smoke check for app %s failed: %s.", a.Name, lastErr)
+}
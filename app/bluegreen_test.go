@@ -0,0 +1,249 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	stderr "errors"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/app/bind"
+	"github.com/globocom/tsuru/provision"
+	ttesting "github.com/globocom/tsuru/testing"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func (s *S) TestEnableBlueGreen(c *gocheck.C) {
+	a := App{Name: "blue", Platform: "python", Teams: []string{s.team.Name}, Owner: s.user.Email}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{"blue", "blue-standby"}}})
+	err = EnableBlueGreen(&a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.provisioner.Destroy(&App{Name: "blue-standby"})
+	c.Assert(a.BlueGreen, gocheck.DeepEquals, &BlueGreen{Standby: "blue-standby"})
+	var standby App
+	err = s.conn.Apps().Find(bson.M{"name": "blue-standby"}).One(&standby)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(standby.Primary, gocheck.Equals, a.Name)
+	c.Assert(standby.Platform, gocheck.Equals, a.Platform)
+	c.Assert(standby.Teams, gocheck.DeepEquals, a.Teams)
+	c.Assert(standby.GetRepositoryName(), gocheck.Equals, a.Name)
+	c.Assert(s.provisioner.Provisioned(&standby), gocheck.Equals, true)
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.BlueGreen, gocheck.DeepEquals, a.BlueGreen)
+}
+
+func (s *S) TestEnableBlueGreenTwice(c *gocheck.C) {
+	a := App{Name: "blue", BlueGreen: &BlueGreen{Standby: "blue-standby"}}
+	err := EnableBlueGreen(&a)
+	c.Assert(err, gocheck.Equals, ErrBlueGreenEnabled)
+	standby := App{Name: "blue-standby", Primary: "blue"}
+	err = EnableBlueGreen(&standby)
+	c.Assert(err, gocheck.Equals, ErrBlueGreenEnabled)
+}
+
+func (s *S) TestDeployBlueGreen(c *gocheck.C) {
+	var checked []string
+	smokeCheck = func(a *App) error {
+		checked = append(checked, a.Name)
+		return nil
+	}
+	defer func() { smokeCheck = httpSmokeCheck }()
+	a := App{
+		Name:      "blue",
+		Platform:  "python",
+		BlueGreen: &BlueGreen{Standby: "blue-standby"},
+		Env: map[string]bind.EnvVar{
+			"DEBUG":        {Name: "DEBUG", Value: "1", Public: true},
+			"DATABASE_URL": {Name: "DATABASE_URL", Value: "mysql://db", InstanceName: "mydb"},
+		},
+	}
+	standby := App{Name: "blue-standby", Platform: "python", Primary: "blue"}
+	err := s.conn.Apps().Insert(a, standby)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{a.Name, standby.Name}}})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": standby.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.Provision(&standby)
+	defer s.provisioner.Destroy(&standby)
+	var buf bytes.Buffer
+	err = Deploy(&a, "a345f3e", "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Version(&standby), gocheck.Equals, "a345f3e")
+	c.Assert(s.provisioner.Version(&a), gocheck.Equals, "")
+	c.Assert(checked, gocheck.DeepEquals, []string{standby.Name})
+	c.Assert(a.BlueGreen.Pending, gocheck.Equals, "a345f3e")
+	err = standby.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(standby.Env["DEBUG"].Value, gocheck.Equals, "1")
+	c.Assert(standby.Env["DATABASE_URL"].Value, gocheck.Equals, "mysql://db")
+	c.Assert(standby.Env["DATABASE_URL"].Public, gocheck.Equals, false)
+	n, err := s.conn.Deploys().Find(bson.M{"app": standby.Name}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 1)
+}
+
+func (s *S) TestDeployBlueGreenSwapped(c *gocheck.C) {
+	smokeCheck = func(a *App) error { return nil }
+	defer func() { smokeCheck = httpSmokeCheck }()
+	a := App{
+		Name:      "blue",
+		Platform:  "python",
+		BlueGreen: &BlueGreen{Standby: "blue-standby", Swapped: true, Rejected: "f1d2d2f"},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var buf bytes.Buffer
+	err = Deploy(&a, "a345f3e", "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Version(&a), gocheck.Equals, "a345f3e")
+	c.Assert(a.BlueGreen.Pending, gocheck.Equals, "a345f3e")
+	c.Assert(a.BlueGreen.Rejected, gocheck.Equals, "")
+}
+
+func (s *S) TestDeployBlueGreenSmokeCheckFailure(c *gocheck.C) {
+	smokeCheck = func(a *App) error { return stderr.New("app is down") }
+	defer func() { smokeCheck = httpSmokeCheck }()
+	a := App{
+		Name:      "blue",
+		Platform:  "python",
+		BlueGreen: &BlueGreen{Standby: "blue-standby", Swapped: true},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var buf bytes.Buffer
+	err = Deploy(&a, "a345f3e", "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "app is down")
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.BlueGreen.Pending, gocheck.Equals, "")
+}
+
+func (s *S) TestPromote(c *gocheck.C) {
+	a := App{Name: "blue", BlueGreen: &BlueGreen{Standby: "blue-standby", Pending: "abc"}}
+	standby := App{Name: "blue-standby", Primary: "blue"}
+	err := s.conn.Apps().Insert(a, standby)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{a.Name, standby.Name}}})
+	err = Promote(&a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.BlueGreen.Swapped, gocheck.Equals, true)
+	c.Assert(a.BlueGreen.Pending, gocheck.Equals, "")
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.BlueGreen, gocheck.DeepEquals, a.BlueGreen)
+}
+
+func (s *S) TestPromoteWithoutPendingDeploy(c *gocheck.C) {
+	a := App{Name: "blue", BlueGreen: &BlueGreen{Standby: "blue-standby"}}
+	err := Promote(&a)
+	c.Assert(err, gocheck.Equals, ErrNoPendingDeploy)
+	a = App{Name: "blue"}
+	err = Promote(&a)
+	c.Assert(err, gocheck.Equals, ErrBlueGreenDisabled)
+}
+
+func (s *S) TestAbortDeploy(c *gocheck.C) {
+	a := App{Name: "blue", BlueGreen: &BlueGreen{Standby: "blue-standby", Pending: "abc"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = AbortDeploy(&a)
+	c.Assert(err, gocheck.IsNil)
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.BlueGreen, gocheck.DeepEquals, &BlueGreen{Standby: "blue-standby", Rejected: "abc"})
+	err = AbortDeploy(&a)
+	c.Assert(err, gocheck.Equals, ErrNoPendingDeploy)
+}
+
+func (s *S) TestSwapRefusesRejectedStandby(c *gocheck.C) {
+	a := App{Name: "blue", BlueGreen: &BlueGreen{Standby: "blue-standby", Pending: "abc"}}
+	standby := App{Name: "blue-standby", Primary: "blue"}
+	err := s.conn.Apps().Insert(a, standby)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{a.Name, standby.Name}}})
+	err = AbortDeploy(&a)
+	c.Assert(err, gocheck.IsNil)
+	err = Swap(&a, &standby)
+	c.Assert(err, gocheck.Equals, ErrRejectedDeploy)
+	err = Swap(&standby, &a)
+	c.Assert(err, gocheck.Equals, ErrRejectedDeploy)
+}
+
+func (s *S) TestSwapRefusesRejectedSwappedApp(c *gocheck.C) {
+	a := App{Name: "blue", BlueGreen: &BlueGreen{Standby: "blue-standby", Swapped: true, Rejected: "abc"}}
+	other := App{Name: "green"}
+	err := Swap(&other, &a)
+	c.Assert(err, gocheck.Equals, ErrRejectedDeploy)
+}
+
+func (s *S) TestHTTPSmokeCheckAddrFailure(c *gocheck.C) {
+	s.provisioner.PrepareFailure("Addr", stderr.New("no address"))
+	a := App{Name: "blue"}
+	err := httpSmokeCheck(&a)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "no address")
+}
+
+type addrProvisioner struct {
+	*ttesting.FakeProvisioner
+	addr string
+}
+
+func (p *addrProvisioner) Addr(a provision.App) (string, error) {
+	return p.addr, nil
+}
+
+func (s *S) TestHTTPSmokeCheckRequestTimeout(c *gocheck.C) {
+	done := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+	Provisioner = &addrProvisioner{FakeProvisioner: s.provisioner, addr: strings.TrimPrefix(ts.URL, "http://")}
+	defer func() { Provisioner = s.provisioner }()
+	oldTimeout := smokeCheckRequestTimeout
+	smokeCheckRequestTimeout = 100 * time.Millisecond
+	defer func() { smokeCheckRequestTimeout = oldTimeout }()
+	config.Set("blue-green:smoke-check-timeout", 1)
+	defer config.Unset("blue-green:smoke-check-timeout")
+	start := time.Now()
+	err := httpSmokeCheck(&App{Name: "blue"})
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(time.Since(start) < 3*time.Second, gocheck.Equals, true)
+}
+
+func (s *S) TestListDoesNotReturnStandbyApps(c *gocheck.C) {
+	a := App{Name: "blue", Teams: []string{s.team.Name}}
+	standby := App{Name: "blue-standby", Teams: []string{s.team.Name}, Primary: "blue"}
+	err := s.conn.Apps().Insert(a, standby)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{a.Name, standby.Name}}})
+	apps, err := List(s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(apps, gocheck.HasLen, 1)
+	c.Assert(apps[0].Name, gocheck.Equals, a.Name)
+}
@@ -0,0 +1,195 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/safe"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// ErrDeployNotFound is returned by Rollback when the given deploy is not
+// found, or can not be used in a rollback.
+var ErrDeployNotFound = stderr.New("Deploy not found.")
+
+// ErrRollbackBlueGreen is returned by Rollback when the app is deployed in
+// blue/green mode, where versions go live only through Promote.
+var ErrRollbackBlueGreen = stderr.New("Rollback is not available in blue/green mode. Deploy the old version again and promote it.")
+
+// DeployData represents a deploy of an app, as recorded in the database.
+type DeployData struct {
+	ID        bson.ObjectId `bson:"_id,omitempty"`
+	App       string
+	Timestamp time.Time
+	Duration  time.Duration
+	Commit    string
+	Image     string
+	User      string
+	Log       string
+	Error     string
+	Rollback  bool
+}
+
+// Success indicates whether the deploy has finished without errors.
+func (d *DeployData) Success() bool {
+	return d.Error == ""
+}
+
+// Deploy deploys the given version of the app, writing the progress to w.
+//
+// Every deploy, successful or not, is recorded in the deploys collection,
+// along with the user that triggered it and the output of the deploy.
+//
+// When the app is in blue/green mode, the version is deployed to the idle app
+// and must be promoted before receiving traffic. See Promote for more
+// details.
+func Deploy(a *App, version, user string, w io.Writer) error {
+	if a.BlueGreen != nil {
+		return deployBlueGreen(a, version, user, w)
+	}
+	return deploy(a, version, user, w)
+}
+
+func deploy(a *App, version, user string, w io.Writer) error {
+	start := time.Now()
+	var buf safe.Buffer
+	writer := io.MultiWriter(w, &buf)
+	if err := a.incrementDeploy(); err != nil {
+		return err
+	}
+	err := Provisioner.Deploy(a, version, writer)
+	d := DeployData{
+		App:       a.Name,
+		Timestamp: start,
+		Duration:  time.Since(start),
+		Commit:    version,
+		User:      user,
+		Log:       buf.String(),
+	}
+	if err != nil {
+		d.Error = err.Error()
+	} else if p, ok := Provisioner.(provision.ImageDeployer); ok {
+		d.Image = p.Image(a, version)
+	}
+	if dbErr := saveDeployData(&d); dbErr != nil {
+		log.Printf("Failed to save deploy data for the app %q: %s", a.Name, dbErr)
+	}
+	fireDeployWebhooks(a, &d)
+	return err
+}
+
+// Rollback deploys again the version of the app deployed in the given
+// deploy, identified by its id.
+//
+// When the provisioner keeps the images built in every deploy, the image
+// recorded in the old deploy is used to start the units. Otherwise, the
+// provisioner deploys the commit recorded in the old deploy.
+//
+// Apps deployed in blue/green mode, and their standby apps, can't be rolled
+// back.
+func Rollback(a *App, id, user string, w io.Writer) error {
+	if a.BlueGreen != nil || a.Primary != "" {
+		return ErrRollbackBlueGreen
+	}
+	if !bson.IsObjectIdHex(id) {
+		return ErrDeployNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var old DeployData
+	query := bson.M{"_id": bson.ObjectIdHex(id), "app": a.Name, "error": ""}
+	if err := conn.Deploys().Find(query).One(&old); err != nil {
+		return ErrDeployNotFound
+	}
+	start := time.Now()
+	var buf safe.Buffer
+	writer := io.MultiWriter(w, &buf)
+	if p, ok := Provisioner.(provision.ImageDeployer); ok && old.Image != "" {
+		err = p.ImageDeploy(a, old.Image, writer)
+	} else {
+		err = Provisioner.Deploy(a, old.Commit, writer)
+	}
+	d := DeployData{
+		App:       a.Name,
+		Timestamp: start,
+		Duration:  time.Since(start),
+		Commit:    old.Commit,
+		Image:     old.Image,
+		User:      user,
+		Log:       buf.String(),
+		Rollback:  true,
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if dbErr := saveDeployData(&d); dbErr != nil {
+		log.Printf("Failed to save deploy data for the app %q: %s", a.Name, dbErr)
+	}
+	fireDeployWebhooks(a, &d)
+	return err
+}
+
+// ListDeploys returns the list of deploys of the app, sorted from the most
+// recent to the oldest one.
+func ListDeploys(a *App) ([]DeployData, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var deploys []DeployData
+	err = conn.Deploys().Find(bson.M{"app": a.Name}).Sort("-timestamp").All(&deploys)
+	if err != nil {
+		return nil, err
+	}
+	return deploys, nil
+}
+
+func fireDeployWebhooks(a *App, d *DeployData) {
+	data := map[string]interface{}{
+		"version":  d.Commit,
+		"user":     d.User,
+		"duration": d.Duration.Seconds(),
+		"rollback": d.Rollback,
+	}
+	if d.Error != "" {
+		data["error"] = d.Error
+	}
+	fireWebhooks(a, EventDeploy, data)
+}
+
+func saveDeployData(d *DeployData) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	d.ID = bson.NewObjectId()
+	return conn.Deploys().Insert(d)
+}
+
+// incrementDeploy increments the number of deploys of the app, both in the
+// database and in the given instance.
+func (app *App) incrementDeploy() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$inc": bson.M{"deploys": 1}})
+	if err != nil {
+		return err
+	}
+	app.Deploys++
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	stderr "errors"
+	"github.com/globocom/tsuru/provision"
+	ttesting "github.com/globocom/tsuru/testing"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) TestDeploy(c *gocheck.C) {
+	a := App{Name: "smashed", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	var buf bytes.Buffer
+	err = Deploy(&a, "a345f3e", "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, "Deploy called")
+	c.Assert(s.provisioner.Version(&a), gocheck.Equals, "a345f3e")
+	c.Assert(a.Deploys, gocheck.Equals, uint(1))
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Deploys, gocheck.Equals, uint(1))
+	var d DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.Commit, gocheck.Equals, "a345f3e")
+	c.Assert(d.User, gocheck.Equals, "someone@tsuru.io")
+	c.Assert(d.Log, gocheck.Equals, "Deploy called")
+	c.Assert(d.Success(), gocheck.Equals, true)
+	c.Assert(d.Rollback, gocheck.Equals, false)
+}
+
+func (s *S) TestDeployRecordsFailures(c *gocheck.C) {
+	a := App{Name: "smashed", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.PrepareFailure("Deploy", stderr.New("deploy failed"))
+	var buf bytes.Buffer
+	err = Deploy(&a, "a345f3e", "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.NotNil)
+	var d DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.Success(), gocheck.Equals, false)
+	c.Assert(d.Error, gocheck.Equals, "deploy failed")
+}
+
+func (s *S) TestListDeploys(c *gocheck.C) {
+	a := App{Name: "smashed"}
+	now := time.Now()
+	deploys := []interface{}{
+		DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "abc", Timestamp: now.Add(-time.Hour)},
+		DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "def", Timestamp: now},
+		DeployData{ID: bson.NewObjectId(), App: "other", Commit: "ghi", Timestamp: now},
+	}
+	err := s.conn.Deploys().Insert(deploys...)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(nil)
+	result, err := ListDeploys(&a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 2)
+	c.Assert(result[0].Commit, gocheck.Equals, "def")
+	c.Assert(result[1].Commit, gocheck.Equals, "abc")
+}
+
+func (s *S) TestRollback(c *gocheck.C) {
+	a := App{Name: "smashed", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	old := DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "abc", Timestamp: time.Now()}
+	err = s.conn.Deploys().Insert(old)
+	c.Assert(err, gocheck.IsNil)
+	var buf bytes.Buffer
+	err = Rollback(&a, old.ID.Hex(), "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(s.provisioner.Version(&a), gocheck.Equals, "abc")
+	var d DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name, "rollback": true}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.Commit, gocheck.Equals, "abc")
+	c.Assert(d.User, gocheck.Equals, "someone@tsuru.io")
+}
+
+type imageDeployerProvisioner struct {
+	*ttesting.FakeProvisioner
+	images []string
+}
+
+func (p *imageDeployerProvisioner) Image(a provision.App, version string) string {
+	return "tsuru/" + a.GetName() + ":" + version
+}
+
+func (p *imageDeployerProvisioner) ImageDeploy(a provision.App, image string, w io.Writer) error {
+	p.images = append(p.images, image)
+	return nil
+}
+
+func (s *S) TestRollbackUsesTheRecordedImage(c *gocheck.C) {
+	p := &imageDeployerProvisioner{FakeProvisioner: s.provisioner}
+	Provisioner = p
+	defer func() { Provisioner = s.provisioner }()
+	a := App{Name: "smashed", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	old := DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "abc", Image: "tsuru/smashed:v1", Timestamp: time.Now()}
+	err = s.conn.Deploys().Insert(old)
+	c.Assert(err, gocheck.IsNil)
+	var buf bytes.Buffer
+	err = Rollback(&a, old.ID.Hex(), "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(p.images, gocheck.DeepEquals, []string{"tsuru/smashed:v1"})
+	var d DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name, "rollback": true}).One(&d)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(d.Image, gocheck.Equals, "tsuru/smashed:v1")
+}
+
+func (s *S) TestRollbackUnknownDeploy(c *gocheck.C) {
+	a := App{Name: "smashed"}
+	var buf bytes.Buffer
+	err := Rollback(&a, "unknown", "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.Equals, ErrDeployNotFound)
+	err = Rollback(&a, bson.NewObjectId().Hex(), "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.Equals, ErrDeployNotFound)
+}
+
+func (s *S) TestRollbackBlueGreen(c *gocheck.C) {
+	a := App{Name: "blue", BlueGreen: &BlueGreen{Standby: "blue-standby"}}
+	old := DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "abc"}
+	err := s.conn.Deploys().Insert(old)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	var buf bytes.Buffer
+	err = Rollback(&a, old.ID.Hex(), "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.Equals, ErrRollbackBlueGreen)
+	standby := App{Name: "blue-standby", Primary: "blue"}
+	err = Rollback(&standby, old.ID.Hex(), "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.Equals, ErrRollbackBlueGreen)
+}
+
+func (s *S) TestRollbackFailedDeploy(c *gocheck.C) {
+	a := App{Name: "smashed"}
+	old := DeployData{ID: bson.NewObjectId(), App: a.Name, Commit: "abc", Error: "failed"}
+	err := s.conn.Deploys().Insert(old)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Deploys().RemoveAll(bson.M{"app": a.Name})
+	var buf bytes.Buffer
+	err = Rollback(&a, old.ID.Hex(), "someone@tsuru.io", &buf)
+	c.Assert(err, gocheck.Equals, ErrDeployNotFound)
+}
@@ -0,0 +1,370 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	stderr "errors"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/log"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrLogDrainNotFound is returned when removing a log drain that is not
+// configured in the app.
+var ErrLogDrainNotFound = stderr.New("Log drain not found.")
+
+const (
+	drainBufferSize = 1000
+	drainBatchSize  = 100
+	drainTimeout    = 10 * time.Second
+)
+
+var (
+	// drainRetries is the number of times that the delivery of a batch of
+	// logs is retried before the batch is discarded.
+	drainRetries = 5
+
+	// drainRetryInterval is the time waited before the first retry. It
+	// doubles on each retry.
+	drainRetryInterval = time.Second
+)
+
+// drainClient is the client used by the http log drains. It refuses to
+// connect to private addresses, see dialDrain.
+var drainClient = &http.Client{
+	Transport: &http.Transport{
+		Dial:                  dialDrain,
+		ResponseHeaderTimeout: drainTimeout,
+	},
+}
+
+// drainHostAllowed checks whether the host is listed in the
+// log-drains:allowed-hosts setting, that administrators use for allowing log
+// drains to internal services.
+func drainHostAllowed(host string) bool {
+	return hostAllowed("log-drains:allowed-hosts", host)
+}
+
+// dialDrain connects to the drain host, refusing private addresses.
+func dialDrain(network, addr string) (net.Conn, error) {
+	return dialPublic(network, addr, drainTimeout, drainHostAllowed)
+}
+
+// drainSender delivers logs to an external log sink.
+type drainSender interface {
+	Send(logs []Applog) error
+	Close() error
+}
+
+// newDrainSender returns the sender for the given drain URL. The following
+// schemes are supported:
+//
+//     syslog://host[:port]      syslog (RFC 5424) over UDP, port defaults to 514
+//     syslog+udp://host[:port]  same as syslog://
+//     syslog+tcp://host[:port]  syslog (RFC 5424) over TCP, using octet counting
+//     tcp://host:port           one plain text line per log
+//     http[s]://host[/path]     batches of logs POSTed in JSON format
+func newDrainSender(rawurl string) (drainSender, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return nil, &errors.ValidationError{Message: "Invalid log drain URL: " + rawurl}
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if isPrivateHost(host) && !drainHostAllowed(host) {
+		return nil, &errors.ValidationError{Message: "Log drains can't point to private addresses: " + rawurl}
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp", "syslog+tcp":
+		network := "udp"
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		addr := u.Host
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "514")
+		}
+		return &syslogSender{network: network, addr: addr}, nil
+	case "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return nil, &errors.ValidationError{Message: "Log drains using tcp must define the port: " + rawurl}
+		}
+		return &lineSender{addr: u.Host}, nil
+	case "http", "https":
+		return &httpSender{url: rawurl}, nil
+	}
+	return nil, &errors.ValidationError{Message: fmt.Sprintf("Unsupported log drain scheme %q.", u.Scheme)}
+}
+
+// syslogSender sends logs in the syslog format defined by RFC 5424.
+type syslogSender struct {
+	network string
+	addr    string
+	conn    net.Conn
+}
+
+// formatSyslog formats the log as a RFC 5424 message, using the facility user
+// and the severity info. The hostname is the name of the app, the app-name is
+// the source of the log and the procid is the unit that generated the log.
+func formatSyslog(l *Applog) string {
+	date := l.Date.UTC().Format("2006-01-02T15:04:05.000000Z07:00")
+	procid := l.Unit
+	if procid == "" {
+		procid = "-"
+	}
+	return fmt.Sprintf("<14>1 %s %s %s %s - - %s", date, l.AppName, l.Source, procid, l.Message)
+}
+
+func (s *syslogSender) Send(logs []Applog) error {
+	if s.conn == nil {
+		conn, err := dialDrain(s.network, s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	for i := range logs {
+		msg := formatSyslog(&logs[i])
+		if s.network == "tcp" {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(drainTimeout))
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogSender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// lineSender sends one plain text line per log over TCP.
+type lineSender struct {
+	addr string
+	conn net.Conn
+}
+
+func (s *lineSender) Send(logs []Applog) error {
+	if s.conn == nil {
+		conn, err := dialDrain("tcp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	var buf bytes.Buffer
+	for _, l := range logs {
+		fmt.Fprintf(&buf, "%s %s[%s]: %s\n", l.Date.UTC().Format(time.RFC3339), l.AppName, l.Source, l.Message)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(drainTimeout))
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		s.Close()
+		return err
+	}
+	return nil
+}
+
+func (s *lineSender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// httpSender POSTs batches of logs, in JSON format, to an URL.
+type httpSender struct {
+	url string
+}
+
+func (s *httpSender) Send(logs []Applog) error {
+	body, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+	resp, err := drainClient.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSender) Close() error {
+	return nil
+}
+
+// logDrain holds the logs waiting for delivery to a drain of an app.
+type logDrain struct {
+	url    string
+	sender drainSender
+	c      chan Applog
+}
+
+// run delivers the logs sent to the drain, in batches, until the drain is
+// stopped.
+func (d *logDrain) run() {
+	for l := range d.c {
+		batch := []Applog{l}
+	collect:
+		for len(batch) < drainBatchSize {
+			select {
+			case l, ok := <-d.c:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, l)
+			default:
+				break collect
+			}
+		}
+		d.deliver(batch)
+	}
+	d.sender.Close()
+}
+
+// deliver sends the logs to the drain, retrying with exponential backoff.
+func (d *logDrain) deliver(logs []Applog) {
+	interval := drainRetryInterval
+	for i := 0; ; i++ {
+		err := d.sender.Send(logs)
+		if err == nil {
+			return
+		}
+		if i == drainRetries {
+			log.Printf("Failed to deliver %d log(s) to the drain %s: %s. Giving up.", len(logs), d.url, err)
+			return
+		}
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
+var drains = struct {
+	m map[string]*logDrain
+	sync.Mutex
+}{
+	m: make(map[string]*logDrain),
+}
+
+func drainKey(appName, url string) string {
+	return appName + " " + url
+}
+
+// forwardLogs enqueues the logs for delivery to the drains of the app. It
+// never blocks: when the buffer of a drain is full, the log is discarded.
+func forwardLogs(app *App, logs []interface{}) {
+	if len(app.LogDrains) == 0 {
+		return
+	}
+	drains.Lock()
+	defer drains.Unlock()
+	for _, u := range app.LogDrains {
+		key := drainKey(app.Name, u)
+		d, ok := drains.m[key]
+		if !ok {
+			sender, err := newDrainSender(u)
+			if err != nil {
+				log.Printf("Invalid log drain %s for the app %s: %s", u, app.Name, err)
+				continue
+			}
+			d = &logDrain{url: u, sender: sender, c: make(chan Applog, drainBufferSize)}
+			drains.m[key] = d
+			go d.run()
+		}
+		for _, l := range logs {
+			select {
+			case d.c <- l.(Applog):
+			default:
+				log.Printf("Buffer of the log drain %s is full, discarding log of the app %s.", u, app.Name)
+			}
+		}
+	}
+}
+
+// stopDrain stops the delivery of logs to a drain of an app. Logs already
+// enqueued are still delivered.
+func stopDrain(appName, url string) {
+	drains.Lock()
+	defer drains.Unlock()
+	key := drainKey(appName, url)
+	if d, ok := drains.m[key]; ok {
+		close(d.c)
+		delete(drains.m, key)
+	}
+}
+
+// AddLogDrain adds a log drain to the app. All logs of the app are forwarded,
+// asynchronously, to its drains.
+func (app *App) AddLogDrain(url string) error {
+	if _, err := newDrainSender(url); err != nil {
+		return err
+	}
+	for _, u := range app.LogDrains {
+		if u == url {
+			return &errors.ValidationError{Message: "This log drain is already configured in the app."}
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$addToSet": bson.M{"logdrains": url}})
+	if err != nil {
+		return err
+	}
+	app.LogDrains = append(app.LogDrains, url)
+	return nil
+}
+
+// RemoveLogDrain removes a log drain from the app.
+func (app *App) RemoveLogDrain(url string) error {
+	index := -1
+	for i, u := range app.LogDrains {
+		if u == url {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return ErrLogDrainNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$pull": bson.M{"logdrains": url}})
+	if err != nil {
+		return err
+	}
+	app.LogDrains = append(app.LogDrains[:index], app.LogDrains[index+1:]...)
+	stopDrain(app.Name, url)
+	return nil
+}
@@ -0,0 +1,310 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	stderr "errors"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func (s *S) TestNewDrainSender(c *gocheck.C) {
+	var tests = []struct {
+		url      string
+		expected drainSender
+	}{
+		{"syslog://logs.example.com", &syslogSender{network: "udp", addr: "logs.example.com:514"}},
+		{"syslog+udp://logs.example.com:5140", &syslogSender{network: "udp", addr: "logs.example.com:5140"}},
+		{"syslog+tcp://logs.example.com:601", &syslogSender{network: "tcp", addr: "logs.example.com:601"}},
+		{"tcp://logs.example.com:5000", &lineSender{addr: "logs.example.com:5000"}},
+		{"https://logs.example.com/drain", &httpSender{url: "https://logs.example.com/drain"}},
+	}
+	for _, t := range tests {
+		sender, err := newDrainSender(t.url)
+		c.Check(err, gocheck.IsNil)
+		c.Check(sender, gocheck.DeepEquals, t.expected)
+	}
+}
+
+func (s *S) TestNewDrainSenderInvalidURL(c *gocheck.C) {
+	for _, u := range []string{"logs.example.com", "ftp://logs.example.com", "tcp://logs.example.com", "syslog://"} {
+		_, err := newDrainSender(u)
+		c.Check(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	}
+}
+
+func (s *S) TestNewDrainSenderPrivateAddress(c *gocheck.C) {
+	for _, u := range []string{"tcp://127.0.0.1:5000", "syslog://localhost", "http://10.0.0.1/drain", "syslog+tcp://[::1]:601", "https://169.254.169.254/"} {
+		_, err := newDrainSender(u)
+		c.Check(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	}
+}
+
+func (s *S) TestNewDrainSenderAllowedPrivateAddress(c *gocheck.C) {
+	config.Set("log-drains:allowed-hosts", []interface{}{"10.1.2.3"})
+	defer config.Unset("log-drains:allowed-hosts")
+	sender, err := newDrainSender("tcp://10.1.2.3:5000")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(sender, gocheck.DeepEquals, &lineSender{addr: "10.1.2.3:5000"})
+}
+
+func (s *S) TestFormatSyslog(c *gocheck.C) {
+	l := Applog{
+		Date:    time.Date(2013, 10, 29, 14, 30, 0, 125000000, time.UTC),
+		Message: "GET / 200",
+		Source:  "app",
+		AppName: "myapp",
+	}
+	c.Assert(formatSyslog(&l), gocheck.Equals, "<14>1 2013-10-29T14:30:00.125000Z myapp app - - - GET / 200")
+}
+
+func (s *S) TestFormatSyslogWithUnit(c *gocheck.C) {
+	l := Applog{
+		Date:    time.Date(2013, 10, 29, 14, 30, 0, 125000000, time.UTC),
+		Message: "GET / 200",
+		Source:  "app",
+		AppName: "myapp",
+		Unit:    "myapp/0",
+	}
+	c.Assert(formatSyslog(&l), gocheck.Equals, "<14>1 2013-10-29T14:30:00.125000Z myapp app myapp/0 - - GET / 200")
+}
+
+func (s *S) TestSyslogSenderUDP(c *gocheck.C) {
+	config.Set("log-drains:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("log-drains:allowed-hosts")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Close()
+	sender := syslogSender{network: "udp", addr: conn.LocalAddr().String()}
+	defer sender.Close()
+	l := Applog{Date: time.Now(), Message: "hello", Source: "app", AppName: "myapp"}
+	err = sender.Send([]Applog{l})
+	c.Assert(err, gocheck.IsNil)
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(buf[:n]), gocheck.Equals, formatSyslog(&l))
+}
+
+func (s *S) TestSyslogSenderTCPUsesOctetCounting(c *gocheck.C) {
+	config.Set("log-drains:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("log-drains:allowed-hosts")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, gocheck.IsNil)
+	defer listener.Close()
+	sender := syslogSender{network: "tcp", addr: listener.Addr().String()}
+	defer sender.Close()
+	l := Applog{Date: time.Now(), Message: "hello", Source: "app", AppName: "myapp"}
+	err = sender.Send([]Applog{l})
+	c.Assert(err, gocheck.IsNil)
+	conn, err := listener.Accept()
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Close()
+	msg := formatSyslog(&l)
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(buf[:n]), gocheck.Equals, fmt.Sprintf("%d %s", len(msg), msg))
+}
+
+func (s *S) TestLineSender(c *gocheck.C) {
+	config.Set("log-drains:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("log-drains:allowed-hosts")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, gocheck.IsNil)
+	defer listener.Close()
+	sender := lineSender{addr: listener.Addr().String()}
+	defer sender.Close()
+	date := time.Date(2013, 10, 29, 14, 30, 0, 0, time.UTC)
+	logs := []Applog{
+		{Date: date, Message: "first", Source: "app", AppName: "myapp"},
+		{Date: date, Message: "second", Source: "tsuru", AppName: "myapp"},
+	}
+	err = sender.Send(logs)
+	c.Assert(err, gocheck.IsNil)
+	conn, err := listener.Accept()
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(line, gocheck.Equals, "2013-10-29T14:30:00Z myapp[app]: first\n")
+	line, err = reader.ReadString('\n')
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(line, gocheck.Equals, "2013-10-29T14:30:00Z myapp[tsuru]: second\n")
+}
+
+func (s *S) TestHTTPSender(c *gocheck.C) {
+	config.Set("log-drains:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("log-drains:allowed-hosts")
+	var received []Applog
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+	sender := httpSender{url: server.URL}
+	logs := []Applog{{Message: "first", Source: "app", AppName: "myapp"}, {Message: "second", Source: "app", AppName: "myapp"}}
+	err := sender.Send(logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(received, gocheck.HasLen, 2)
+	c.Assert(received[1].Message, gocheck.Equals, "second")
+}
+
+func (s *S) TestHTTPSenderFailure(c *gocheck.C) {
+	config.Set("log-drains:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("log-drains:allowed-hosts")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	sender := httpSender{url: server.URL}
+	err := sender.Send([]Applog{{Message: "first"}})
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestDrainSendersRefusePrivateAddresses(c *gocheck.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, gocheck.IsNil)
+	defer listener.Close()
+	addr := listener.Addr().String()
+	senders := []drainSender{
+		&syslogSender{network: "tcp", addr: addr},
+		&lineSender{addr: addr},
+		&httpSender{url: "http://" + addr},
+	}
+	for _, sender := range senders {
+		err := sender.Send([]Applog{{Message: "hello"}})
+		c.Check(err, gocheck.NotNil)
+		c.Check(strings.Contains(err.Error(), errPrivateAddress.Error()), gocheck.Equals, true)
+		sender.Close()
+	}
+}
+
+type failingSender struct {
+	failures int
+	calls    int
+}
+
+func (s *failingSender) Send(logs []Applog) error {
+	s.calls++
+	if s.calls <= s.failures {
+		return stderr.New("drain is down")
+	}
+	return nil
+}
+
+func (s *failingSender) Close() error {
+	return nil
+}
+
+func (s *S) TestLogDrainDeliverRetries(c *gocheck.C) {
+	old := drainRetryInterval
+	drainRetryInterval = time.Millisecond
+	defer func() { drainRetryInterval = old }()
+	sender := failingSender{failures: 2}
+	d := logDrain{url: "tcp://logs.example.com:5000", sender: &sender}
+	d.deliver([]Applog{{Message: "hello"}})
+	c.Assert(sender.calls, gocheck.Equals, 3)
+}
+
+func (s *S) TestLogDrainDeliverGivesUp(c *gocheck.C) {
+	old := drainRetryInterval
+	drainRetryInterval = time.Millisecond
+	defer func() { drainRetryInterval = old }()
+	sender := failingSender{failures: 100}
+	d := logDrain{url: "tcp://logs.example.com:5000", sender: &sender}
+	d.deliver([]Applog{{Message: "hello"}})
+	c.Assert(sender.calls, gocheck.Equals, drainRetries+1)
+}
+
+func (s *S) TestAddLogDrain(c *gocheck.C) {
+	a := App{Name: "drained"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = a.AddLogDrain("syslog://logs.example.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.LogDrains, gocheck.DeepEquals, []string{"syslog://logs.example.com"})
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.LogDrains, gocheck.DeepEquals, a.LogDrains)
+	err = a.AddLogDrain("syslog://logs.example.com")
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	err = a.AddLogDrain("ftp://logs.example.com")
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+}
+
+func (s *S) TestRemoveLogDrain(c *gocheck.C) {
+	a := App{Name: "drained", LogDrains: []string{"syslog://logs.example.com", "tcp://logs.example.com:5000"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = a.RemoveLogDrain("syslog://logs.example.com")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.LogDrains, gocheck.DeepEquals, []string{"tcp://logs.example.com:5000"})
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.LogDrains, gocheck.DeepEquals, a.LogDrains)
+	err = a.RemoveLogDrain("syslog://logs.example.com")
+	c.Assert(err, gocheck.Equals, ErrLogDrainNotFound)
+}
+
+func (s *S) TestLogForwardsToDrains(c *gocheck.C) {
+	config.Set("log-drains:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("log-drains:allowed-hosts")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, gocheck.IsNil)
+	defer listener.Close()
+	u := "tcp://" + listener.Addr().String()
+	a := App{Name: "drained", LogDrains: []string{u}}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	defer stopDrain(a.Name, u)
+	err = a.Log("hello drain", "tsuru")
+	c.Assert(err, gocheck.IsNil)
+	conn, err := listener.Accept()
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(line, gocheck.Matches, ".* drained\\[tsuru\\]: hello drain\n")
+}
+
+func (s *S) TestLogDoesNotBlockOnUnreachableDrains(c *gocheck.C) {
+	old := drainRetryInterval
+	drainRetryInterval = time.Hour
+	defer func() { drainRetryInterval = old }()
+	u := "tcp://127.0.0.1:1"
+	a := App{Name: "drained", LogDrains: []string{u}}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	defer stopDrain(a.Name, u)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < drainBufferSize+10; i++ {
+			a.Log("hello", "tsuru")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		c.Fatal("App.Log blocked on an unreachable drain.")
+	}
+}
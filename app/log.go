@@ -5,11 +5,17 @@
 package app
 
 import (
-	"errors"
+	stderr "errors"
+	"fmt"
 	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
 	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const (
@@ -46,7 +52,7 @@ func NewLogListener(a *App) *LogListener {
 
 func (l *LogListener) Close() error {
 	if !atomic.CompareAndSwapInt32(&l.state, open, closed) {
-		return errors.New("Already closed.")
+		return stderr.New("Already closed.")
 	}
 	listeners.Lock()
 	defer listeners.Unlock()
@@ -103,3 +109,163 @@ func LogRemove(a *App) error {
 	}
 	return err
 }
+
+// LogFilter defines the criteria used to search the logs of an app. Zero
+// values don't filter anything.
+type LogFilter struct {
+	// Lines is the maximum number of logs returned.
+	Lines int
+
+	// Source is the source of the logs.
+	Source string
+
+	// Unit is the name of the unit that generated the logs.
+	Unit string
+
+	// Since and Until define the time range of the logs.
+	Since time.Time
+	Until time.Time
+
+	// Message is a substring that must be present in the message of the
+	// logs.
+	Message string
+
+	// Regex is a regular expression that must match the message of the logs.
+	Regex string
+
+	// Cursor is returned by SearchLogs when there are older logs matching
+	// the filter. Searching with the cursor returns the next page of logs.
+	Cursor string
+
+	re *regexp.Regexp
+}
+
+func (f *LogFilter) compile() error {
+	if f.Regex == "" || f.re != nil {
+		return nil
+	}
+	re, err := regexp.Compile(f.Regex)
+	if err != nil {
+		return &errors.ValidationError{Message: fmt.Sprintf("Invalid regular expression: %s", err)}
+	}
+	f.re = re
+	return nil
+}
+
+// Match checks whether the log matches the filter. It ignores the number of
+// lines and the cursor, and is intended to filter logs as they're generated.
+func (f *LogFilter) Match(l *Applog) bool {
+	if f.Source != "" && l.Source != f.Source {
+		return false
+	}
+	if f.Unit != "" && l.Unit != f.Unit {
+		return false
+	}
+	if !f.Since.IsZero() && l.Date.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && l.Date.After(f.Until) {
+		return false
+	}
+	if f.Message != "" && !strings.Contains(l.Message, f.Message) {
+		return false
+	}
+	if f.compile() != nil || (f.re != nil && !f.re.MatchString(l.Message)) {
+		return false
+	}
+	return true
+}
+
+func (f *LogFilter) query(appName string) (bson.M, error) {
+	if err := f.compile(); err != nil {
+		return nil, err
+	}
+	q := bson.M{"appname": appName}
+	if f.Source != "" {
+		q["source"] = f.Source
+	}
+	if f.Unit != "" {
+		q["unit"] = f.Unit
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		date := bson.M{}
+		if !f.Since.IsZero() {
+			date["$gte"] = f.Since
+		}
+		if !f.Until.IsZero() {
+			date["$lte"] = f.Until
+		}
+		q["date"] = date
+	}
+	var messages []bson.M
+	if f.Message != "" {
+		messages = append(messages, bson.M{"message": bson.RegEx{Pattern: regexp.QuoteMeta(f.Message)}})
+	}
+	if f.Regex != "" {
+		messages = append(messages, bson.M{"message": bson.RegEx{Pattern: f.Regex}})
+	}
+	if len(messages) > 0 {
+		q["$and"] = messages
+	}
+	if f.Cursor != "" {
+		date, id, err := parseLogCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		q["$or"] = []bson.M{
+			{"date": bson.M{"$lt": date}},
+			{"date": date, "_id": bson.M{"$lt": id}},
+		}
+	}
+	return q, nil
+}
+
+func logCursor(date time.Time, id bson.ObjectId) string {
+	return fmt.Sprintf("%d-%s", date.UnixNano(), id.Hex())
+}
+
+func parseLogCursor(cursor string) (time.Time, bson.ObjectId, error) {
+	invalid := &errors.ValidationError{Message: "Invalid log cursor."}
+	parts := strings.SplitN(cursor, "-", 2)
+	if len(parts) != 2 || !bson.IsObjectIdHex(parts[1]) {
+		return time.Time{}, "", invalid
+	}
+	nsec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", invalid
+	}
+	return time.Unix(0, nsec), bson.ObjectIdHex(parts[1]), nil
+}
+
+// SearchLogs returns the latest logs of the app matching the filter, sorted
+// by date. When the number of logs reaches the limit of lines, it also
+// returns a cursor that can be used to fetch the previous page of logs.
+func (app *App) SearchLogs(f *LogFilter) ([]Applog, string, error) {
+	q, err := f.query(app.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	var entries []struct {
+		ID     bson.ObjectId `bson:"_id"`
+		Applog `bson:",inline"`
+	}
+	err = conn.Logs().Find(q).Sort("-date", "-_id").Limit(f.Lines).All(&entries)
+	if err != nil {
+		return nil, "", err
+	}
+	var cursor string
+	l := len(entries)
+	if f.Lines > 0 && l == f.Lines {
+		cursor = logCursor(entries[l-1].Date, entries[l-1].ID)
+	}
+	logs := make([]Applog, l)
+	for i, e := range entries {
+		logs[l-1-i] = e.Applog
+	}
+	return logs, cursor, nil
+}
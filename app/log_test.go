@@ -5,6 +5,7 @@
 package app
 
 import (
+	"github.com/globocom/tsuru/errors"
 	"labix.org/v2/mgo/bson"
 	"launchpad.net/gocheck"
 	"sync"
@@ -132,3 +133,131 @@ func (s *S) TestLogRemoveByApp(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(count, gocheck.Equals, 1)
 }
+
+func (s *S) TestSearchLogsByDate(c *gocheck.C) {
+	a := App{Name: "searched"}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	date := time.Date(2013, 10, 29, 14, 30, 0, 0, time.UTC)
+	s.insertLogs(c, a.Name, 10, date)
+	filter := LogFilter{Since: date.Add(2 * time.Second), Until: date.Add(4 * time.Second)}
+	logs, cursor, err := a.SearchLogs(&filter)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cursor, gocheck.Equals, "")
+	c.Assert(logs, gocheck.HasLen, 3)
+	c.Assert(logs[0].Message, gocheck.Equals, "log 2")
+	c.Assert(logs[2].Message, gocheck.Equals, "log 4")
+}
+
+func (s *S) TestSearchLogsByMessage(c *gocheck.C) {
+	a := App{Name: "searched"}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	s.insertLogs(c, a.Name, 12, time.Now())
+	logs, _, err := a.SearchLogs(&LogFilter{Message: "log 1"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 3)
+	c.Assert(logs[0].Message, gocheck.Equals, "log 1")
+	c.Assert(logs[1].Message, gocheck.Equals, "log 10")
+	c.Assert(logs[2].Message, gocheck.Equals, "log 11")
+	logs, _, err = a.SearchLogs(&LogFilter{Regex: "^log [2-4]$"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 3)
+	c.Assert(logs[0].Message, gocheck.Equals, "log 2")
+	logs, _, err = a.SearchLogs(&LogFilter{Message: "log 1", Regex: "0$"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "log 10")
+}
+
+func (s *S) TestSearchLogsByUnit(c *gocheck.C) {
+	a := App{Name: "searched"}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	a.LogFromUnit("from the first unit", "app", "searched/0")
+	a.LogFromUnit("from the second unit", "app", "searched/1")
+	logs, _, err := a.SearchLogs(&LogFilter{Unit: "searched/1"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "from the second unit")
+	c.Assert(logs[0].Unit, gocheck.Equals, "searched/1")
+}
+
+func (s *S) TestSearchLogsInvalidRegex(c *gocheck.C) {
+	a := App{Name: "searched"}
+	_, _, err := a.SearchLogs(&LogFilter{Regex: "log ("})
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+}
+
+func (s *S) TestSearchLogsPagination(c *gocheck.C) {
+	a := App{Name: "searched"}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	s.insertLogs(c, a.Name, 5, time.Now())
+	filter := LogFilter{Lines: 2}
+	logs, cursor, err := a.SearchLogs(&filter)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cursor, gocheck.Not(gocheck.Equals), "")
+	c.Assert(logs, gocheck.HasLen, 2)
+	c.Assert(logs[0].Message, gocheck.Equals, "log 3")
+	c.Assert(logs[1].Message, gocheck.Equals, "log 4")
+	filter.Cursor = cursor
+	logs, cursor, err = a.SearchLogs(&filter)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 2)
+	c.Assert(logs[0].Message, gocheck.Equals, "log 1")
+	c.Assert(logs[1].Message, gocheck.Equals, "log 2")
+	filter.Cursor = cursor
+	logs, cursor, err = a.SearchLogs(&filter)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cursor, gocheck.Equals, "")
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "log 0")
+}
+
+func (s *S) TestSearchLogsPaginationWithSameDate(c *gocheck.C) {
+	a := App{Name: "searched"}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	date := time.Date(2013, 10, 29, 14, 30, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		err := s.conn.Logs().Insert(Applog{Date: date, Message: "same date", AppName: a.Name})
+		c.Assert(err, gocheck.IsNil)
+	}
+	filter := LogFilter{Lines: 2}
+	logs, cursor, err := a.SearchLogs(&filter)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 2)
+	filter.Cursor = cursor
+	logs, _, err = a.SearchLogs(&filter)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+}
+
+func (s *S) TestSearchLogsInvalidCursor(c *gocheck.C) {
+	a := App{Name: "searched"}
+	for _, cursor := range []string{"abc", "123-xyz", "abc-52702f5e9cf9a1e4d4000001"} {
+		_, _, err := a.SearchLogs(&LogFilter{Cursor: cursor})
+		c.Check(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	}
+}
+
+func (s *S) TestLogFilterMatch(c *gocheck.C) {
+	date := time.Date(2013, 10, 29, 14, 30, 0, 0, time.UTC)
+	l := Applog{Date: date, Message: "GET /health 200", Source: "app", Unit: "myapp/0"}
+	var tests = []struct {
+		filter   LogFilter
+		expected bool
+	}{
+		{LogFilter{}, true},
+		{LogFilter{Source: "app"}, true},
+		{LogFilter{Source: "tsuru"}, false},
+		{LogFilter{Unit: "myapp/0"}, true},
+		{LogFilter{Unit: "myapp/1"}, false},
+		{LogFilter{Since: date.Add(-time.Minute), Until: date.Add(time.Minute)}, true},
+		{LogFilter{Since: date.Add(time.Minute)}, false},
+		{LogFilter{Until: date.Add(-time.Minute)}, false},
+		{LogFilter{Message: "/health"}, true},
+		{LogFilter{Message: "/status"}, false},
+		{LogFilter{Regex: "^GET .* 2\\d\\d$"}, true},
+		{LogFilter{Regex: "^POST"}, false},
+	}
+	for _, t := range tests {
+		c.Check(t.filter.Match(&l), gocheck.Equals, t.expected)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"github.com/globocom/config"
+	"net"
+	"strings"
+	"time"
+)
+
+// errPrivateAddress is returned when connecting to a host, defined by users,
+// that resolves to an internal address.
+var errPrivateAddress = stderr.New("host resolves to a private address")
+
+// privateNetworks are the networks that hosts defined by users (like webhooks
+// and log drains) can't reach, unless the host is explicitly allowed in the
+// configuration: loopback, link-local (including cloud metadata services) and
+// private networks.
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateHost checks whether the host is localhost or a private IP address,
+// without resolving it.
+func isPrivateHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && isPrivateIP(ip)
+}
+
+// hostAllowed checks whether the host is listed in the given setting, that
+// administrators use for allowing connections to internal services.
+func hostAllowed(setting, host string) bool {
+	hosts, _ := config.GetList(setting)
+	for _, h := range hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialPublic connects to the address, refusing hosts that resolve to private
+// addresses, unless allowed returns true for the host. The connection uses the
+// resolved address, so hosts can't point to private addresses after being
+// checked.
+func dialPublic(network, addr string, timeout time.Duration, allowed func(host string) bool) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if allowed(host) {
+		return net.DialTimeout(network, addr, timeout)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return nil, errPrivateAddress
+		}
+	}
+	return net.DialTimeout(network, net.JoinHostPort(ips[0].String(), port), timeout)
+}
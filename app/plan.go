@@ -0,0 +1,195 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/quota"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+var (
+	ErrPlanNotFound      = stderr.New("Plan not found.")
+	ErrPlanAlreadyExists = stderr.New("A plan with this name already exists.")
+)
+
+// Plan represents the resources available to each unit of an app. Memory and
+// Swap are expressed in bytes, CpuShare is a relative weight. A zero value
+// means no limit.
+type Plan struct {
+	Name     string `bson:"_id" json:"name"`
+	Memory   int64  `json:"memory"`
+	Swap     int64  `json:"swap"`
+	CpuShare int    `json:"cpushare"`
+	Default  bool   `json:"default"`
+}
+
+// Save stores a new plan in the database. If the plan is the default one,
+// the previous default plan stops being the default.
+func (p *Plan) Save() error {
+	if p.Name == "" {
+		return &errors.ValidationError{Message: "Plan name is required."}
+	}
+	if p.Memory < 0 || p.Swap < 0 || p.CpuShare < 0 {
+		return &errors.ValidationError{Message: "Plan resources must not be negative."}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Plans().Insert(p)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "E11000") {
+			return ErrPlanAlreadyExists
+		}
+		return err
+	}
+	if p.Default {
+		query := bson.M{"_id": bson.M{"$ne": p.Name}, "default": true}
+		_, err = conn.Plans().UpdateAll(query, bson.M{"$set": bson.M{"default": false}})
+	}
+	return err
+}
+
+// PlansList returns the list of plans, sorted by name.
+func PlansList() ([]Plan, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var plans []Plan
+	err = conn.Plans().Find(nil).Sort("_id").All(&plans)
+	return plans, err
+}
+
+// PlanRemove removes the plan with the given name. Apps using the plan keep
+// their limits.
+func PlanRemove(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Plans().RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return ErrPlanNotFound
+	}
+	return err
+}
+
+func findPlanByName(name string) (*Plan, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var plan Plan
+	if err := conn.Plans().FindId(name).One(&plan); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrPlanNotFound
+		}
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// defaultPlan returns the default plan, or a plan without limits when there
+// is no default plan.
+func defaultPlan() (*Plan, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var plan Plan
+	if err := conn.Plans().Find(bson.M{"default": true}).One(&plan); err != nil {
+		return &Plan{}, nil
+	}
+	return &plan, nil
+}
+
+// ChangePlan changes the plan of the app. The new limits are applied to the
+// units created from now on, including the ones created in the next deploy.
+func (app *App) ChangePlan(name string) error {
+	plan, err := findPlanByName(name)
+	if err != nil {
+		return err
+	}
+	extra := int64(len(app.Units)) * (plan.Memory - app.Plan.Memory)
+	if err := checkTeamsMemory(app, extra); err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"plan": plan}})
+	if err != nil {
+		return err
+	}
+	app.Plan = *plan
+	return nil
+}
+
+// checkTeamsMemory checks whether the teams of the app are able to consume
+// extra bytes of memory, returning a *quota.QuotaExceededError otherwise.
+//
+// The memory consumed by a team is the sum of the memory of the plans of all
+// units of apps that the team has access to, and the limit is defined, in
+// megabytes, by the configuration entry quota:memory-per-team. When the entry
+// is not defined, teams may consume any amount of memory.
+func checkTeamsMemory(app *App, extra int64) error {
+	limit, err := config.GetInt("quota:memory-per-team")
+	if err != nil || limit <= 0 || extra <= 0 {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, team := range app.Teams {
+		var apps []App
+		err := conn.Apps().Find(bson.M{"teams": team}).Select(bson.M{"units": 1, "plan": 1}).All(&apps)
+		if err != nil {
+			return err
+		}
+		var used int64
+		for _, a := range apps {
+			used += int64(len(a.Units)) * a.Plan.Memory
+		}
+		available := int64(limit)<<20 - used
+		if extra > available {
+			if available < 0 {
+				available = 0
+			}
+			return &quota.QuotaExceededError{Requested: uint(extra >> 20), Available: uint(available >> 20)}
+		}
+	}
+	return nil
+}
+
+// GetMemory returns the memory limit of each unit of the app, in bytes.
+func (app *App) GetMemory() int64 {
+	return app.Plan.Memory
+}
+
+// GetSwap returns the swap limit of each unit of the app, in bytes.
+func (app *App) GetSwap() int64 {
+	return app.Plan.Swap
+}
+
+// GetCpuShare returns the cpu share of each unit of the app.
+func (app *App) GetCpuShare() int {
+	return app.Plan.CpuShare
+}
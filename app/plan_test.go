@@ -0,0 +1,177 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/provision"
+	"github.com/globocom/tsuru/quota"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestPlanSave(c *gocheck.C) {
+	p := Plan{Name: "small", Memory: 256 << 20, Swap: 512 << 20, CpuShare: 50}
+	err := p.Save()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(p.Name)
+	var stored Plan
+	err = s.conn.Plans().FindId(p.Name).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored, gocheck.DeepEquals, p)
+	err = p.Save()
+	c.Assert(err, gocheck.Equals, ErrPlanAlreadyExists)
+}
+
+func (s *S) TestPlanSaveInvalid(c *gocheck.C) {
+	p := Plan{Memory: 256 << 20}
+	err := p.Save()
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+	p = Plan{Name: "small", Memory: -1}
+	err = p.Save()
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+}
+
+func (s *S) TestPlanSaveDefault(c *gocheck.C) {
+	p1 := Plan{Name: "small", Memory: 256 << 20, Default: true}
+	err := p1.Save()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(p1.Name)
+	p2 := Plan{Name: "large", Memory: 1024 << 20, Default: true}
+	err = p2.Save()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(p2.Name)
+	plan, err := defaultPlan()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(plan.Name, gocheck.Equals, "large")
+	n, err := s.conn.Plans().Find(bson.M{"default": true}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 1)
+}
+
+func (s *S) TestPlanSaveDuplicateDefaultKeepsTheCurrentDefault(c *gocheck.C) {
+	p1 := Plan{Name: "small", Memory: 256 << 20, Default: true}
+	err := p1.Save()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(p1.Name)
+	p2 := Plan{Name: "small", Memory: 1024 << 20, Default: true}
+	err = p2.Save()
+	c.Assert(err, gocheck.Equals, ErrPlanAlreadyExists)
+	large := Plan{Name: "large", Memory: 1024 << 20}
+	err = large.Save()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(large.Name)
+	large.Default = true
+	err = large.Save()
+	c.Assert(err, gocheck.Equals, ErrPlanAlreadyExists)
+	plan, err := defaultPlan()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(plan.Name, gocheck.Equals, "small")
+}
+
+func (s *S) TestDefaultPlanWithoutPlans(c *gocheck.C) {
+	plan, err := defaultPlan()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(*plan, gocheck.DeepEquals, Plan{})
+}
+
+func (s *S) TestPlansList(c *gocheck.C) {
+	plans := []interface{}{Plan{Name: "small"}, Plan{Name: "large"}}
+	err := s.conn.Plans().Insert(plans...)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveAll(nil)
+	result, err := PlansList()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 2)
+	c.Assert(result[0].Name, gocheck.Equals, "large")
+	c.Assert(result[1].Name, gocheck.Equals, "small")
+}
+
+func (s *S) TestPlanRemove(c *gocheck.C) {
+	err := s.conn.Plans().Insert(Plan{Name: "small"})
+	c.Assert(err, gocheck.IsNil)
+	err = PlanRemove("small")
+	c.Assert(err, gocheck.IsNil)
+	n, err := s.conn.Plans().FindId("small").Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+	err = PlanRemove("small")
+	c.Assert(err, gocheck.Equals, ErrPlanNotFound)
+}
+
+func (s *S) TestChangePlan(c *gocheck.C) {
+	p := Plan{Name: "large", Memory: 1024 << 20, CpuShare: 100}
+	err := s.conn.Plans().Insert(p)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(p.Name)
+	a := App{Name: "limited", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = a.ChangePlan("large")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.Plan, gocheck.DeepEquals, p)
+	c.Assert(a.GetMemory(), gocheck.Equals, int64(1024<<20))
+	c.Assert(a.GetCpuShare(), gocheck.Equals, 100)
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Plan, gocheck.DeepEquals, p)
+	err = a.ChangePlan("unknown")
+	c.Assert(err, gocheck.Equals, ErrPlanNotFound)
+}
+
+func (s *S) TestChangePlanExceedsTeamMemory(c *gocheck.C) {
+	config.Set("quota:memory-per-team", 1024)
+	defer config.Unset("quota:memory-per-team")
+	p := Plan{Name: "large", Memory: 1024 << 20}
+	err := s.conn.Plans().Insert(p)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Plans().RemoveId(p.Name)
+	a := App{
+		Name:  "limited",
+		Teams: []string{s.team.Name},
+		Units: []Unit{{Name: "limited/0"}, {Name: "limited/1"}},
+		Plan:  Plan{Name: "small", Memory: 256 << 20},
+	}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = a.ChangePlan("large")
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*quota.QuotaExceededError)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Available, gocheck.Equals, uint(512))
+	c.Assert(e.Requested, gocheck.Equals, uint(1536))
+}
+
+func (s *S) TestCheckTeamsMemory(c *gocheck.C) {
+	config.Set("quota:memory-per-team", 1024)
+	defer config.Unset("quota:memory-per-team")
+	a := App{
+		Name:  "limited",
+		Teams: []string{s.team.Name},
+		Units: []Unit{{Name: "limited/0"}, {Name: "limited/1"}},
+		Plan:  Plan{Name: "small", Memory: 256 << 20},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = checkTeamsMemory(&a, 512<<20)
+	c.Assert(err, gocheck.IsNil)
+	err = checkTeamsMemory(&a, 768<<20)
+	c.Assert(err, gocheck.FitsTypeOf, &quota.QuotaExceededError{})
+}
+
+func (s *S) TestCheckTeamsMemoryWithoutLimit(c *gocheck.C) {
+	a := App{Name: "limited", Teams: []string{s.team.Name}}
+	err := checkTeamsMemory(&a, 1<<40)
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestAppIsResourceLimiter(c *gocheck.C) {
+	var _ provision.ResourceLimiter = &App{}
+}
@@ -14,7 +14,9 @@ import (
 	"github.com/globocom/tsuru/service"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
+	"strconv"
 	"sync"
+	"time"
 )
 
 const (
@@ -23,10 +25,22 @@ const (
 	startApp                = "start-app"
 	RegenerateApprcAndStart = "regenerate-apprc-start-app"
 	BindService             = "bind-service"
+	CheckServiceInstance    = "check-service-instance"
+	deliverWebhook          = "deliver-webhook"
 
 	queueName = "tsuru-app"
 )
 
+var (
+	// instanceStatusInterval is the time waited between two checks of the
+	// status of a pending service instance.
+	instanceStatusInterval = 30 * time.Second
+
+	// instanceStatusChecks is the number of checks before giving up on a
+	// pending service instance and marking it as failed.
+	instanceStatusChecks = 120
+)
+
 // ensureAppIsStarted make sure that the app and all units present in the given
 // message are started.
 func ensureAppIsStarted(msg *queue.Message) (App, error) {
@@ -91,6 +105,114 @@ func bindUnit(msg *queue.Message) error {
 	return nil
 }
 
+// checkServiceInstance handles the check-service-instance message, checking the
+// status of a pending service instance in the service API. When the instance
+// is up, the apps waiting for it are bound to it. While it's pending, the
+// message is enqueued again.
+func checkServiceInstance(msg *queue.Message) error {
+	msg.Delete()
+	if len(msg.Args) != 2 {
+		return fmt.Errorf("Error handling %q: invalid arguments.", msg.Action)
+	}
+	attempt, err := strconv.Atoi(msg.Args[1])
+	if err != nil {
+		return fmt.Errorf("Error handling %q: invalid arguments.", msg.Action)
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return fmt.Errorf("Error handling %q: %s", msg.Action, err)
+	}
+	defer conn.Close()
+	var instance service.ServiceInstance
+	err = conn.ServiceInstances().Find(bson.M{"name": msg.Args[0]}).One(&instance)
+	if err != nil {
+		return fmt.Errorf("Error handling %q: service instance %q does not exist.", msg.Action, msg.Args[0])
+	}
+	if instance.State != service.StatePending {
+		return nil
+	}
+	status, err := instance.Status()
+	if err == service.ErrStatusNotImplemented {
+		// The status is optional in the service API. Without it, there's
+		// no way to follow the provisioning, so the instance is
+		// considered up.
+		status, err = service.StateUp, nil
+	}
+	switch {
+	case err != nil:
+		// Transient failures, like network errors, are retried.
+		log.Printf("Failed to check the status of the service instance %q: %s", instance.Name, err)
+	case status == service.StateUp:
+		apps, err := instance.SetState(service.StateUp)
+		if err != nil {
+			return fmt.Errorf("Error handling %q: %s", msg.Action, err)
+		}
+		bindPendingApps(&instance, apps)
+		return nil
+	case status == "down" && instance.Creating:
+		// Instances that are being updated may be down for a while,
+		// only failures in the creation are final.
+		apps, err := instance.SetState(service.StateFailed)
+		if err != nil {
+			return fmt.Errorf("Error handling %q: %s", msg.Action, err)
+		}
+		dropPendingApps(&instance, apps)
+		return fmt.Errorf("Service instance %q failed to be provisioned.", instance.Name)
+	}
+	if attempt >= instanceStatusChecks {
+		if !instance.Creating {
+			// The instance was working before the update, so it's
+			// considered up again instead of failed.
+			log.Printf("Service instance %q is still updating after %d checks. Considering it up.", instance.Name, attempt+1)
+			apps, err := instance.SetState(service.StateUp)
+			if err != nil {
+				return fmt.Errorf("Error handling %q: %s", msg.Action, err)
+			}
+			bindPendingApps(&instance, apps)
+			return nil
+		}
+		apps, err := instance.SetState(service.StateFailed)
+		if err != nil {
+			return fmt.Errorf("Error handling %q: %s", msg.Action, err)
+		}
+		dropPendingApps(&instance, apps)
+		return fmt.Errorf("Service instance %q is still pending after %d checks. Giving up.", instance.Name, attempt+1)
+	}
+	retry := queue.Message{Action: CheckServiceInstance, Args: []string{instance.Name, strconv.Itoa(attempt + 1)}}
+	if qErr := aqueue().Put(&retry, instanceStatusInterval); qErr != nil {
+		return fmt.Errorf("Failed to enqueue the status check of the service instance %q: %s", instance.Name, qErr)
+	}
+	return nil
+}
+
+// bindPendingApps binds the apps that were waiting for the service instance
+// to be ready.
+func bindPendingApps(instance *service.ServiceInstance, apps []string) {
+	for _, name := range apps {
+		a := App{Name: name}
+		if err := a.Get(); err != nil {
+			log.Printf("Failed to bind the app %q to the service instance %q: the app does not exist.", name, instance.Name)
+			continue
+		}
+		if err := instance.BindApp(&a); err != nil && err != service.ErrInstancePending {
+			log.Printf("Failed to bind the app %q to the service instance %q: %s", name, instance.Name, err)
+		}
+	}
+}
+
+// dropPendingApps records, in the log of tsuru and of each app, that the apps
+// waiting for the service instance won't be bound to it, because it failed to
+// be provisioned.
+func dropPendingApps(instance *service.ServiceInstance, apps []string) {
+	for _, name := range apps {
+		log.Printf("The app %q won't be bound to the service instance %q: the instance failed to be provisioned.", name, instance.Name)
+		a := App{Name: name}
+		if err := a.Get(); err == nil {
+			a.Log(fmt.Sprintf("The service instance %q failed to be provisioned, the app won't be bound to it.", instance.Name), "tsuru")
+		}
+	}
+}
+
 // handle is the function called by the queue handler on each message.
 func handle(msg *queue.Message) {
 	switch msg.Action {
@@ -135,6 +257,14 @@ func handle(msg *queue.Message) {
 			return
 		}
 		msg.Delete()
+	case CheckServiceInstance:
+		if err := checkServiceInstance(msg); err != nil {
+			log.Print(err)
+		}
+	case deliverWebhook:
+		if err := handleWebhookDelivery(msg); err != nil {
+			log.Print(err)
+		}
 	default:
 		log.Printf("Error handling %q: invalid action.", msg.Action)
 		msg.Delete()
@@ -16,6 +16,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 )
 
 func (s *S) TestHandleMessage(c *gocheck.C) {
@@ -362,6 +363,302 @@ func (s *S) TestHandleBindServiceMessage(c *gocheck.C) {
 	c.Assert(called, gocheck.Equals, true)
 }
 
+func (s *S) TestCheckServiceInstanceUp(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/resources/my-mysql/status" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"DATABASE_USER":"root","DATABASE_PASSWORD":"s3cr3t"}`))
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		PendingApps: []string{"nemesis"},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := App{Name: "nemesis", Units: []Unit{{Name: "i-00800", Ip: "10.10.10.10", State: "started"}}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "0"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, service.StateUp)
+	c.Assert(stored.Apps, gocheck.DeepEquals, []string{"nemesis"})
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+	err = a.Get()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.InstanceEnv("my-mysql"), gocheck.HasLen, 2)
+}
+
+func (s *S) TestCheckServiceInstanceStillPending(c *gocheck.C) {
+	old := instanceStatusInterval
+	instanceStatusInterval = time.Millisecond
+	defer func() { instanceStatusInterval = old }()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", State: service.StatePending}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "3"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.IsNil)
+	retry, err := aqueue().Get(1e9)
+	c.Assert(err, gocheck.IsNil)
+	defer retry.Delete()
+	c.Assert(retry.Action, gocheck.Equals, CheckServiceInstance)
+	c.Assert(retry.Args, gocheck.DeepEquals, []string{"my-mysql", "4"})
+}
+
+func (s *S) TestCheckServiceInstanceFailed(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		Creating:    true,
+		PendingApps: []string{"nemesis"},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "0"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.ErrorMatches, `^Service instance "my-mysql" failed to be provisioned\.$`)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, service.StateFailed)
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+	_, err = aqueue().Get(1e6)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestCheckServiceInstanceDownWhileUpdating(c *gocheck.C) {
+	old := instanceStatusInterval
+	instanceStatusInterval = time.Millisecond
+	defer func() { instanceStatusInterval = old }()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", State: service.StatePending}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "0"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, service.StatePending)
+	retry, err := aqueue().Get(1e9)
+	c.Assert(err, gocheck.IsNil)
+	defer retry.Delete()
+	c.Assert(retry.Args, gocheck.DeepEquals, []string{"my-mysql", "1"})
+}
+
+func (s *S) TestCheckServiceInstanceRetriesTransientErrors(c *gocheck.C) {
+	old := instanceStatusInterval
+	instanceStatusInterval = time.Millisecond
+	defer func() { instanceStatusInterval = old }()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		Creating:    true,
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "0"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, service.StatePending)
+	retry, err := aqueue().Get(1e9)
+	c.Assert(err, gocheck.IsNil)
+	defer retry.Delete()
+	c.Assert(retry.Args, gocheck.DeepEquals, []string{"my-mysql", "1"})
+}
+
+func (s *S) TestCheckServiceInstanceStatusNotImplemented(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/resources/my-mysql/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"DATABASE_USER":"root"}`))
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		Creating:    true,
+		PendingApps: []string{"nemesis"},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := App{Name: "nemesis", Units: []Unit{{Name: "i-00800", Ip: "10.10.10.10", State: "started"}}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "0"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, service.StateUp)
+	c.Assert(stored.Creating, gocheck.Equals, false)
+	c.Assert(stored.Apps, gocheck.DeepEquals, []string{"nemesis"})
+	_, err = aqueue().Get(1e6)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestCheckServiceInstanceGivesUp(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		Creating:    true,
+		PendingApps: []string{"nemesis"},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := App{Name: "nemesis"}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "120"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.ErrorMatches, `^.*Giving up\.$`)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, service.StateFailed)
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+	count, err := s.conn.Logs().Find(bson.M{"appname": a.Name, "source": "tsuru"}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 1)
+	_, err = aqueue().Get(1e6)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestCheckServiceInstanceGivesUpUpdating(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/resources/my-mysql/status" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Write([]byte(`{"DATABASE_USER":"root","DATABASE_PASSWORD":"s3cr3t"}`))
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		PendingApps: []string{"nemesis"},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := App{Name: "nemesis", Units: []Unit{{Name: "i-00800", Ip: "10.10.10.10", State: "started"}}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "120"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, service.StateUp)
+	c.Assert(stored.Apps, gocheck.DeepEquals, []string{"nemesis"})
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+	_, err = aqueue().Get(1e6)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestCheckServiceInstanceAlreadyUp(c *gocheck.C) {
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", State: service.StateUp}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"my-mysql", "0"}}
+	err = checkServiceInstance(&msg)
+	c.Assert(err, gocheck.IsNil)
+	_, err = aqueue().Get(1e6)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestCheckServiceInstanceUnknownInstance(c *gocheck.C) {
+	msg := queue.Message{Action: CheckServiceInstance, Args: []string{"unknown", "0"}}
+	err := checkServiceInstance(&msg)
+	c.Assert(err, gocheck.ErrorMatches, `^Error handling "check-service-instance": service instance "unknown" does not exist\.$`)
+}
+
 func (s *S) TestEnsureAppIsStartedUnknownUnits(c *gocheck.C) {
 	a := App{
 		Name:     "neon",
@@ -0,0 +1,180 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/log"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"time"
+)
+
+// LogRetention defines which logs of an app are kept in the database. Logs
+// older than MaxAge seconds are removed, as well as the oldest logs when the
+// app has more than MaxEntries logs. A zero value means no limit.
+type LogRetention struct {
+	MaxAge     int
+	MaxEntries int
+}
+
+// defaultLogRetention returns the retention policy applied to apps that
+// don't define their own. It's read from the configuration entries
+// log:retention:max-age (in seconds) and log:retention:max-entries.
+func defaultLogRetention() LogRetention {
+	var r LogRetention
+	r.MaxAge, _ = config.GetInt("log:retention:max-age")
+	r.MaxEntries, _ = config.GetInt("log:retention:max-entries")
+	return r
+}
+
+// logRetention returns the retention policy of the app: each limit defined
+// by the app overrides the default one.
+func (app *App) logRetention() LogRetention {
+	r := defaultLogRetention()
+	if app.LogRetention != nil {
+		if app.LogRetention.MaxAge > 0 {
+			r.MaxAge = app.LogRetention.MaxAge
+		}
+		if app.LogRetention.MaxEntries > 0 {
+			r.MaxEntries = app.LogRetention.MaxEntries
+		}
+	}
+	return r
+}
+
+// SetLogRetention saves the log retention policy of the app. A policy without
+// limits makes the app use the default policy again.
+func SetLogRetention(a *App, r *LogRetention) error {
+	if r.MaxAge < 0 || r.MaxEntries < 0 {
+		return &errors.ValidationError{Message: "Log retention limits must not be negative."}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	update := bson.M{"$set": bson.M{"logretention": r}}
+	if r.MaxAge == 0 && r.MaxEntries == 0 {
+		update = bson.M{"$unset": bson.M{"logretention": ""}}
+		r = nil
+	}
+	if err := conn.Apps().Update(bson.M{"name": a.Name}, update); err != nil {
+		return err
+	}
+	a.LogRetention = r
+	return nil
+}
+
+// trimLogs removes the logs of the app that are out of its retention policy,
+// returning the number of removed logs.
+func (app *App) trimLogs(conn *db.Storage) (int, error) {
+	r := app.logRetention()
+	var removed int
+	if r.MaxAge > 0 {
+		limit := time.Now().Add(-time.Duration(r.MaxAge) * time.Second)
+		info, err := conn.Logs().RemoveAll(bson.M{"appname": app.Name, "date": bson.M{"$lt": limit}})
+		if err != nil {
+			return removed, err
+		}
+		removed += info.Removed
+	}
+	if r.MaxEntries > 0 {
+		var last Applog
+		err := conn.Logs().Find(bson.M{"appname": app.Name}).Sort("-date").Skip(r.MaxEntries).One(&last)
+		if err == mgo.ErrNotFound {
+			return removed, nil
+		}
+		if err != nil {
+			return removed, err
+		}
+		info, err := conn.Logs().RemoveAll(bson.M{"appname": app.Name, "date": bson.M{"$lte": last.Date}})
+		if err != nil {
+			return removed, err
+		}
+		removed += info.Removed
+	}
+	return removed, nil
+}
+
+// EnforceLogRetention removes the logs that are out of the retention policy
+// of their apps.
+func EnforceLogRetention() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(nil).Select(bson.M{"name": 1, "logretention": 1}).All(&apps)
+	if err != nil {
+		return err
+	}
+	for i := range apps {
+		n, err := apps[i].trimLogs(conn)
+		if err != nil {
+			log.Printf("Failed to enforce the log retention of the app %q: %s", apps[i].Name, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Removed %d log(s) of the app %q.", n, apps[i].Name)
+		}
+	}
+	return nil
+}
+
+// LogUsage represents the storage consumed by the logs of an app. Size is an
+// estimation, in bytes, based on the average size of the logs in the
+// database.
+type LogUsage struct {
+	App       string
+	Entries   int
+	Size      int64
+	Oldest    time.Time
+	Retention LogRetention
+}
+
+// LogsUsage returns the storage consumed by the logs of the given app, or of
+// all apps when a is nil.
+func LogsUsage(a *App) ([]LogUsage, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var apps []App
+	if a != nil {
+		apps = []App{*a}
+	} else {
+		err = conn.Apps().Find(nil).Select(bson.M{"name": 1, "logretention": 1}).Sort("name").All(&apps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var stats struct {
+		AvgObjSize float64 `bson:"avgObjSize"`
+	}
+	conn.Logs().Database.Run(bson.D{{"collStats", conn.Logs().Name}}, &stats)
+	usage := make([]LogUsage, len(apps))
+	for i, app := range apps {
+		usage[i] = LogUsage{App: app.Name, Retention: app.logRetention()}
+		query := conn.Logs().Find(bson.M{"appname": app.Name})
+		if usage[i].Entries, err = query.Count(); err != nil {
+			return nil, err
+		}
+		if usage[i].Entries == 0 {
+			continue
+		}
+		usage[i].Size = int64(float64(usage[i].Entries) * stats.AvgObjSize)
+		var oldest Applog
+		if err := query.Sort("date").One(&oldest); err != nil {
+			return nil, err
+		}
+		usage[i].Oldest = oldest.Date
+	}
+	return usage, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"time"
+)
+
+func (s *S) insertLogs(c *gocheck.C, appName string, n int, date time.Time) {
+	logs := make([]interface{}, n)
+	for i := range logs {
+		logs[i] = Applog{
+			Date:    date.Add(time.Duration(i) * time.Second),
+			Message: fmt.Sprintf("log %d", i),
+			Source:  "tsuru",
+			AppName: appName,
+		}
+	}
+	err := s.conn.Logs().Insert(logs...)
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestLogRetentionDefault(c *gocheck.C) {
+	config.Set("log:retention:max-age", 3600)
+	config.Set("log:retention:max-entries", 1000)
+	defer config.Unset("log:retention")
+	a := App{Name: "retained"}
+	c.Assert(a.logRetention(), gocheck.Equals, LogRetention{MaxAge: 3600, MaxEntries: 1000})
+}
+
+func (s *S) TestLogRetentionOverridesDefault(c *gocheck.C) {
+	config.Set("log:retention:max-age", 3600)
+	config.Set("log:retention:max-entries", 1000)
+	defer config.Unset("log:retention")
+	a := App{Name: "retained", LogRetention: &LogRetention{MaxEntries: 50}}
+	c.Assert(a.logRetention(), gocheck.Equals, LogRetention{MaxAge: 3600, MaxEntries: 50})
+}
+
+func (s *S) TestSetLogRetention(c *gocheck.C) {
+	a := App{Name: "retained"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = SetLogRetention(&a, &LogRetention{MaxAge: 86400})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(*a.LogRetention, gocheck.Equals, LogRetention{MaxAge: 86400})
+	var stored App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(*stored.LogRetention, gocheck.Equals, LogRetention{MaxAge: 86400})
+	err = SetLogRetention(&a, &LogRetention{})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(a.LogRetention, gocheck.IsNil)
+	stored = App{}
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.LogRetention, gocheck.IsNil)
+}
+
+func (s *S) TestSetLogRetentionInvalid(c *gocheck.C) {
+	a := App{Name: "retained"}
+	err := SetLogRetention(&a, &LogRetention{MaxEntries: -1})
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+}
+
+func (s *S) TestTrimLogsByAge(c *gocheck.C) {
+	a := App{Name: "retained", LogRetention: &LogRetention{MaxAge: 3600}}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	s.insertLogs(c, a.Name, 3, time.Now().Add(-2*time.Hour))
+	s.insertLogs(c, a.Name, 2, time.Now().Add(-time.Minute))
+	n, err := a.trimLogs(s.conn)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 3)
+	count, err := s.conn.Logs().Find(bson.M{"appname": a.Name}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 2)
+}
+
+func (s *S) TestTrimLogsByEntries(c *gocheck.C) {
+	a := App{Name: "retained", LogRetention: &LogRetention{MaxEntries: 4}}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	s.insertLogs(c, a.Name, 10, time.Now().Add(-time.Hour))
+	n, err := a.trimLogs(s.conn)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 6)
+	var logs []Applog
+	err = s.conn.Logs().Find(bson.M{"appname": a.Name}).Sort("date").All(&logs)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 4)
+	c.Assert(logs[0].Message, gocheck.Equals, "log 6")
+}
+
+func (s *S) TestTrimLogsWithinLimits(c *gocheck.C) {
+	a := App{Name: "retained", LogRetention: &LogRetention{MaxEntries: 4}}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	s.insertLogs(c, a.Name, 4, time.Now().Add(-time.Hour))
+	n, err := a.trimLogs(s.conn)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
+func (s *S) TestEnforceLogRetention(c *gocheck.C) {
+	config.Set("log:retention:max-entries", 5)
+	defer config.Unset("log:retention")
+	apps := []interface{}{
+		App{Name: "retained"},
+		App{Name: "limited", LogRetention: &LogRetention{MaxEntries: 2}},
+	}
+	err := s.conn.Apps().Insert(apps...)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{"retained", "limited"}}})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": bson.M{"$in": []string{"retained", "limited"}}})
+	s.insertLogs(c, "retained", 8, time.Now().Add(-time.Hour))
+	s.insertLogs(c, "limited", 8, time.Now().Add(-time.Hour))
+	err = EnforceLogRetention()
+	c.Assert(err, gocheck.IsNil)
+	count, err := s.conn.Logs().Find(bson.M{"appname": "retained"}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 5)
+	count, err = s.conn.Logs().Find(bson.M{"appname": "limited"}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 2)
+}
+
+func (s *S) TestLogsUsage(c *gocheck.C) {
+	a := App{Name: "retained", LogRetention: &LogRetention{MaxEntries: 100}}
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	date := time.Date(2013, 10, 29, 14, 30, 0, 0, time.UTC)
+	s.insertLogs(c, a.Name, 3, date)
+	usage, err := LogsUsage(&a)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(usage, gocheck.HasLen, 1)
+	c.Assert(usage[0].App, gocheck.Equals, a.Name)
+	c.Assert(usage[0].Entries, gocheck.Equals, 3)
+	c.Assert(usage[0].Size > 0, gocheck.Equals, true)
+	c.Assert(usage[0].Oldest.Equal(date), gocheck.Equals, true)
+	c.Assert(usage[0].Retention, gocheck.Equals, LogRetention{MaxEntries: 100})
+}
+
+func (s *S) TestLogsUsageAllApps(c *gocheck.C) {
+	apps := []interface{}{App{Name: "retained"}, App{Name: "limited"}}
+	err := s.conn.Apps().Insert(apps...)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().RemoveAll(bson.M{"name": bson.M{"$in": []string{"retained", "limited"}}})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": "retained"})
+	s.insertLogs(c, "retained", 2, time.Now())
+	usage, err := LogsUsage(nil)
+	c.Assert(err, gocheck.IsNil)
+	byApp := make(map[string]LogUsage)
+	for _, u := range usage {
+		byApp[u.App] = u
+	}
+	c.Assert(byApp["retained"].Entries, gocheck.Equals, 2)
+	c.Assert(byApp["limited"].Entries, gocheck.Equals, 0)
+	c.Assert(byApp["limited"].Size, gocheck.Equals, int64(0))
+}
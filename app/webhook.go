@@ -0,0 +1,310 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stderr "errors"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/queue"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrWebhookNotFound is returned when removing a webhook that does not exist.
+var ErrWebhookNotFound = stderr.New("Webhook not found.")
+
+// Events that fire webhooks.
+const (
+	EventDeploy      = "deploy"
+	EventRestart     = "restart"
+	EventAddUnits    = "add-units"
+	EventRemoveUnits = "remove-units"
+	EventSetEnv      = "set-env"
+	EventUnsetEnv    = "unset-env"
+	EventSwap        = "swap"
+)
+
+// WebhookEvents is the list of events that may be used in the events filter
+// of webhooks.
+var WebhookEvents = []string{
+	EventDeploy, EventRestart, EventAddUnits, EventRemoveUnits,
+	EventSetEnv, EventUnsetEnv, EventSwap,
+}
+
+const webhookTimeout = 10 * time.Second
+
+// webhookClient is the client used for delivering webhooks. It refuses to
+// connect to private addresses, see dialWebhook.
+var webhookClient = &http.Client{
+	Transport: &http.Transport{
+		Dial:                  dialWebhook,
+		ResponseHeaderTimeout: webhookTimeout,
+	},
+}
+
+// webhookHostAllowed checks whether the host is listed in the
+// webhooks:allowed-hosts setting, that administrators use for allowing
+// webhooks to internal services.
+func webhookHostAllowed(host string) bool {
+	return hostAllowed("webhooks:allowed-hosts", host)
+}
+
+// dialWebhook connects to the webhook host, refusing private addresses.
+func dialWebhook(network, addr string) (net.Conn, error) {
+	return dialPublic(network, addr, webhookTimeout, webhookHostAllowed)
+}
+
+var (
+	// webhookRetries is the number of times that the delivery of a
+	// webhook is retried before the event is discarded.
+	webhookRetries = 5
+
+	// webhookRetryInterval is the time waited before the first retry. It
+	// doubles on each retry.
+	webhookRetryInterval = 10 * time.Second
+)
+
+// Webhook is an URL notified about the events of the apps of a team.
+//
+// Events are POSTed to the URL in JSON format. The payload is signed with
+// HMAC-SHA256, using the secret of the webhook as key, and the signature is
+// sent in the X-Tsuru-Signature header, in the format "sha256=<hex digest>".
+type Webhook struct {
+	ID     bson.ObjectId `bson:"_id" json:"id"`
+	Team   string        `json:"team"`
+	URL    string        `json:"url"`
+	Secret string        `json:"-"`
+
+	// Events is the list of events that fire the webhook. When it's
+	// empty, all events fire the webhook.
+	Events []string `json:"events"`
+}
+
+// WebhookPayload is the content sent to webhooks.
+type WebhookPayload struct {
+	Event string                 `json:"event"`
+	App   string                 `json:"app"`
+	Date  time.Time              `json:"date"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+func (w *Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return &errors.ValidationError{Message: "Invalid webhook URL: " + w.URL}
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if isPrivateHost(host) && !webhookHostAllowed(host) {
+		return &errors.ValidationError{Message: "Webhooks can't point to private addresses: " + w.URL}
+	}
+	for _, event := range w.Events {
+		valid := false
+		for _, e := range WebhookEvents {
+			if e == event {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return &errors.ValidationError{Message: fmt.Sprintf("Unknown event %q.", event)}
+		}
+	}
+	return nil
+}
+
+func (w *Webhook) fires(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AddWebhook validates and stores the webhook. When the webhook doesn't
+// define a secret, a random one is generated.
+func AddWebhook(w *Webhook) error {
+	if err := w.validate(); err != nil {
+		return err
+	}
+	if w.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return err
+		}
+		w.Secret = secret
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	w.ID = bson.NewObjectId()
+	return conn.Webhooks().Insert(w)
+}
+
+// ListWebhooks returns the webhooks of the team.
+func ListWebhooks(team string) ([]Webhook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var webhooks []Webhook
+	err = conn.Webhooks().Find(bson.M{"team": team}).All(&webhooks)
+	return webhooks, err
+}
+
+// RemoveWebhook removes a webhook of the team, identified by its id.
+func RemoveWebhook(team, id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return ErrWebhookNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().Remove(bson.M{"_id": bson.ObjectIdHex(id), "team": team})
+	if err != nil {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// signWebhookPayload returns the signature of the payload, sent in the
+// X-Tsuru-Signature header.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// fireWebhooks enqueues the delivery of the event to the webhooks of the
+// teams of the app. Failures are logged, and never interrupt the operation
+// that generated the event.
+func fireWebhooks(app *App, event string, data map[string]interface{}) {
+	if len(app.Teams) == 0 {
+		return
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		log.Printf("Failed to fire webhooks for the app %s: %s", app.Name, err)
+		return
+	}
+	defer conn.Close()
+	var webhooks []Webhook
+	err = conn.Webhooks().Find(bson.M{"team": bson.M{"$in": app.Teams}}).All(&webhooks)
+	if err != nil {
+		log.Printf("Failed to fire webhooks for the app %s: %s", app.Name, err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	payload := WebhookPayload{Event: event, App: app.Name, Date: time.Now().In(time.UTC), Data: data}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to fire webhooks for the app %s: %s", app.Name, err)
+		return
+	}
+	var msgs []queue.Message
+	for _, w := range webhooks {
+		if w.fires(event) {
+			msgs = append(msgs, queue.Message{Action: deliverWebhook, Args: []string{w.ID.Hex(), string(body), "0"}})
+		}
+	}
+	if len(msgs) > 0 {
+		Enqueue(msgs...)
+	}
+}
+
+// postWebhook delivers the payload to the webhook.
+func postWebhook(w *Webhook, event string, payload []byte) error {
+	request, err := http.NewRequest("POST", w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Tsuru-Event", event)
+	request.Header.Set("X-Tsuru-Signature", signWebhookPayload(w.Secret, payload))
+	resp, err := webhookClient.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleWebhookDelivery handles the deliver-webhook message. Its arguments
+// are the id of the webhook, the payload and the number of the attempt. When
+// the delivery fails, the message is enqueued again, with exponential
+// backoff, until the number of retries is exhausted.
+func handleWebhookDelivery(msg *queue.Message) error {
+	msg.Delete()
+	if len(msg.Args) != 3 || !bson.IsObjectIdHex(msg.Args[0]) {
+		return fmt.Errorf("Error handling %q: invalid arguments.", msg.Action)
+	}
+	attempt, err := strconv.Atoi(msg.Args[2])
+	if err != nil {
+		return fmt.Errorf("Error handling %q: invalid arguments.", msg.Action)
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return fmt.Errorf("Error handling %q: %s", msg.Action, err)
+	}
+	defer conn.Close()
+	var w Webhook
+	if err := conn.Webhooks().FindId(bson.ObjectIdHex(msg.Args[0])).One(&w); err != nil {
+		return fmt.Errorf("Error handling %q: webhook %s does not exist.", msg.Action, msg.Args[0])
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal([]byte(msg.Args[1]), &payload); err != nil {
+		return fmt.Errorf("Error handling %q: invalid payload.", msg.Action)
+	}
+	err = postWebhook(&w, payload.Event, []byte(msg.Args[1]))
+	if err == nil {
+		return nil
+	}
+	if attempt >= webhookRetries {
+		return fmt.Errorf("Failed to deliver the event %q of the app %s to the webhook %s: %s. Giving up.", payload.Event, payload.App, w.URL, err)
+	}
+	retry := queue.Message{Action: deliverWebhook, Args: []string{msg.Args[0], msg.Args[1], strconv.Itoa(attempt + 1)}}
+	delay := webhookRetryInterval * time.Duration(1<<uint(attempt))
+	if qErr := aqueue().Put(&retry, delay); qErr != nil {
+		return fmt.Errorf("Failed to enqueue the retry of the webhook %s: %s", w.URL, qErr)
+	}
+	return fmt.Errorf("Failed to deliver the event %q of the app %s to the webhook %s: %s. Retrying in %s.", payload.Event, payload.App, w.URL, err, delay)
+}
@@ -0,0 +1,263 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"encoding/json"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/app/bind"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/queue"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestAddWebhook(c *gocheck.C) {
+	w := Webhook{Team: s.team.Name, URL: "https://chat.tsuru.io/hook", Events: []string{EventDeploy}}
+	err := AddWebhook(&w)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(w.ID)
+	c.Assert(w.Secret, gocheck.HasLen, 40)
+	var stored Webhook
+	err = s.conn.Webhooks().FindId(w.ID).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored, gocheck.DeepEquals, w)
+}
+
+func (s *S) TestAddWebhookKeepsTheSecret(c *gocheck.C) {
+	w := Webhook{Team: s.team.Name, URL: "http://chat.tsuru.io/hook", Secret: "s3cr3t"}
+	err := AddWebhook(&w)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(w.ID)
+	c.Assert(w.Secret, gocheck.Equals, "s3cr3t")
+}
+
+func (s *S) TestAddWebhookValidation(c *gocheck.C) {
+	var tests = []struct {
+		webhook Webhook
+		message string
+	}{
+		{Webhook{URL: "chat.tsuru.io"}, "Invalid webhook URL: chat.tsuru.io"},
+		{Webhook{URL: "ftp://chat.tsuru.io"}, "Invalid webhook URL: ftp://chat.tsuru.io"},
+		{Webhook{URL: "http://chat.tsuru.io", Events: []string{"deploy", "explode"}}, `Unknown event "explode".`},
+		{Webhook{URL: "http://127.0.0.1:8080/hook"}, "Webhooks can't point to private addresses: http://127.0.0.1:8080/hook"},
+		{Webhook{URL: "http://169.254.169.254/latest"}, "Webhooks can't point to private addresses: http://169.254.169.254/latest"},
+		{Webhook{URL: "https://10.1.2.3/hook"}, "Webhooks can't point to private addresses: https://10.1.2.3/hook"},
+		{Webhook{URL: "http://[::1]/hook"}, "Webhooks can't point to private addresses: http://[::1]/hook"},
+		{Webhook{URL: "http://localhost/hook"}, "Webhooks can't point to private addresses: http://localhost/hook"},
+	}
+	for _, t := range tests {
+		err := AddWebhook(&t.webhook)
+		c.Assert(err, gocheck.NotNil)
+		e, ok := err.(*errors.ValidationError)
+		c.Assert(ok, gocheck.Equals, true)
+		c.Assert(e.Message, gocheck.Equals, t.message)
+	}
+}
+
+func (s *S) TestAddWebhookAllowedPrivateHost(c *gocheck.C) {
+	config.Set("webhooks:allowed-hosts", []interface{}{"10.1.2.3"})
+	defer config.Unset("webhooks:allowed-hosts")
+	w := Webhook{Team: s.team.Name, URL: "https://10.1.2.3/hook"}
+	err := AddWebhook(&w)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(w.ID)
+}
+
+func (s *S) TestListAndRemoveWebhooks(c *gocheck.C) {
+	w1 := Webhook{Team: s.team.Name, URL: "http://chat.tsuru.io/hook"}
+	w2 := Webhook{Team: "otherteam", URL: "http://chat.tsuru.io/hook"}
+	for _, w := range []*Webhook{&w1, &w2} {
+		err := AddWebhook(w)
+		c.Assert(err, gocheck.IsNil)
+		defer s.conn.Webhooks().RemoveId(w.ID)
+	}
+	webhooks, err := ListWebhooks(s.team.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(webhooks, gocheck.DeepEquals, []Webhook{w1})
+	err = RemoveWebhook(s.team.Name, w2.ID.Hex())
+	c.Assert(err, gocheck.Equals, ErrWebhookNotFound)
+	err = RemoveWebhook(s.team.Name, w1.ID.Hex())
+	c.Assert(err, gocheck.IsNil)
+	webhooks, err = ListWebhooks(s.team.Name)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(webhooks, gocheck.HasLen, 0)
+	err = RemoveWebhook(s.team.Name, "invalid")
+	c.Assert(err, gocheck.Equals, ErrWebhookNotFound)
+}
+
+func (s *S) TestSignWebhookPayload(c *gocheck.C) {
+	// from RFC 4231, test case 2.
+	signature := signWebhookPayload("Jefe", []byte("what do ya want for nothing?"))
+	c.Assert(signature, gocheck.Equals, "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843")
+}
+
+func (s *S) TestFireWebhooks(c *gocheck.C) {
+	w1 := Webhook{Team: s.team.Name, URL: "http://chat.tsuru.io/hook", Events: []string{EventSwap}}
+	w2 := Webhook{Team: s.team.Name, URL: "http://ci.tsuru.io/hook", Events: []string{EventDeploy}}
+	for _, w := range []*Webhook{&w1, &w2} {
+		err := AddWebhook(w)
+		c.Assert(err, gocheck.IsNil)
+		defer s.conn.Webhooks().RemoveId(w.ID)
+	}
+	a := App{Name: "hooked", Teams: []string{s.team.Name}}
+	fireWebhooks(&a, EventSwap, map[string]interface{}{"with": "other"})
+	msg, err := aqueue().Get(1e6)
+	c.Assert(err, gocheck.IsNil)
+	defer msg.Delete()
+	c.Assert(msg.Action, gocheck.Equals, deliverWebhook)
+	c.Assert(msg.Args, gocheck.HasLen, 3)
+	c.Assert(msg.Args[0], gocheck.Equals, w1.ID.Hex())
+	c.Assert(msg.Args[2], gocheck.Equals, "0")
+	var payload WebhookPayload
+	err = json.Unmarshal([]byte(msg.Args[1]), &payload)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(payload.Event, gocheck.Equals, EventSwap)
+	c.Assert(payload.App, gocheck.Equals, "hooked")
+	c.Assert(payload.Data, gocheck.DeepEquals, map[string]interface{}{"with": "other"})
+	_, err = aqueue().Get(1e6)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestSetEnvsFiresWebhooks(c *gocheck.C) {
+	w := Webhook{Team: s.team.Name, URL: "http://chat.tsuru.io/hook"}
+	err := AddWebhook(&w)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(w.ID)
+	a := App{Name: "hooked", Teams: []string{s.team.Name}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = a.SetEnvs([]bind.EnvVar{{Name: "DATABASE_PASSWORD", Value: "s3cr3t", Public: true}}, true)
+	c.Assert(err, gocheck.IsNil)
+	msg, err := aqueue().Get(1e6)
+	c.Assert(err, gocheck.IsNil)
+	defer msg.Delete()
+	c.Assert(msg.Action, gocheck.Equals, deliverWebhook)
+	var payload WebhookPayload
+	err = json.Unmarshal([]byte(msg.Args[1]), &payload)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(payload.Event, gocheck.Equals, EventSetEnv)
+	c.Assert(payload.Data, gocheck.DeepEquals, map[string]interface{}{"envs": []interface{}{"DATABASE_PASSWORD"}})
+}
+
+func (s *S) TestHandleWebhookDelivery(c *gocheck.C) {
+	config.Set("webhooks:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("webhooks:allowed-hosts")
+	var (
+		body    []byte
+		headers http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		headers = r.Header
+	}))
+	defer server.Close()
+	w := Webhook{Team: s.team.Name, URL: server.URL, Secret: "s3cr3t"}
+	err := AddWebhook(&w)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(w.ID)
+	payload := `{"event":"restart","app":"hooked","date":"2013-11-20T10:00:00Z"}`
+	msg := queue.Message{Action: deliverWebhook, Args: []string{w.ID.Hex(), payload, "0"}}
+	handle(&msg)
+	c.Assert(string(body), gocheck.Equals, payload)
+	c.Assert(headers.Get("Content-Type"), gocheck.Equals, "application/json")
+	c.Assert(headers.Get("X-Tsuru-Event"), gocheck.Equals, "restart")
+	c.Assert(headers.Get("X-Tsuru-Signature"), gocheck.Equals, signWebhookPayload("s3cr3t", []byte(payload)))
+	_, err = aqueue().Get(1e6)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestPostWebhookRefusesPrivateAddresses(c *gocheck.C) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+	w := Webhook{URL: server.URL, Secret: "s3cr3t"}
+	err := postWebhook(&w, EventRestart, []byte("{}"))
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err, gocheck.ErrorMatches, ".*"+errPrivateAddress.Error())
+	c.Assert(called, gocheck.Equals, false)
+}
+
+func (s *S) TestIsPrivateIP(c *gocheck.C) {
+	var tests = []struct {
+		ip      string
+		private bool
+	}{
+		{"127.0.0.1", true},
+		{"10.0.0.5", true},
+		{"172.20.1.1", true},
+		{"192.168.0.1", true},
+		{"169.254.169.254", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, t := range tests {
+		c.Check(isPrivateIP(net.ParseIP(t.ip)), gocheck.Equals, t.private, gocheck.Commentf("%s", t.ip))
+	}
+}
+
+func (s *S) TestHandleWebhookDeliveryRetries(c *gocheck.C) {
+	config.Set("webhooks:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("webhooks:allowed-hosts")
+	old := webhookRetryInterval
+	webhookRetryInterval = time.Millisecond
+	defer func() { webhookRetryInterval = old }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	w := Webhook{Team: s.team.Name, URL: server.URL}
+	err := AddWebhook(&w)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(w.ID)
+	payload := `{"event":"restart","app":"hooked","date":"2013-11-20T10:00:00Z"}`
+	msg := queue.Message{Action: deliverWebhook, Args: []string{w.ID.Hex(), payload, "2"}}
+	err = handleWebhookDelivery(&msg)
+	c.Assert(err, gocheck.NotNil)
+	retry, err := aqueue().Get(1e9)
+	c.Assert(err, gocheck.IsNil)
+	defer retry.Delete()
+	c.Assert(retry.Action, gocheck.Equals, deliverWebhook)
+	c.Assert(retry.Args, gocheck.DeepEquals, []string{w.ID.Hex(), payload, "3"})
+}
+
+func (s *S) TestHandleWebhookDeliveryGivesUp(c *gocheck.C) {
+	config.Set("webhooks:allowed-hosts", []interface{}{"127.0.0.1"})
+	defer config.Unset("webhooks:allowed-hosts")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	w := Webhook{Team: s.team.Name, URL: server.URL}
+	err := AddWebhook(&w)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Webhooks().RemoveId(w.ID)
+	payload := `{"event":"restart","app":"hooked","date":"2013-11-20T10:00:00Z"}`
+	msg := queue.Message{Action: deliverWebhook, Args: []string{w.ID.Hex(), payload, "5"}}
+	err = handleWebhookDelivery(&msg)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Matches, `.*Giving up\.$`)
+	_, err = aqueue().Get(1e6)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestHandleWebhookDeliveryUnknownWebhook(c *gocheck.C) {
+	msg := queue.Message{Action: deliverWebhook, Args: []string{bson.NewObjectId().Hex(), "{}", "0"}}
+	err := handleWebhookDelivery(&msg)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Matches, `Error handling "deliver-webhook": webhook .* does not exist\.`)
+}
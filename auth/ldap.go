@@ -0,0 +1,152 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/mmitton/ldap"
+	"strings"
+)
+
+// ldapConn is the subset of an LDAP connection used by the LDAP scheme.
+type ldapConn interface {
+	Bind(dn, password string) error
+	// SearchEmail searches for a single user matching the filter, returning
+	// its DN and the value of the email attribute.
+	SearchEmail(baseDN, filter, attr string) (dn, email string, err error)
+	Close()
+}
+
+// ldapDial connects to the LDAP server, using the given TLS mode: "ldaps"
+// for connecting over TLS, "starttls" for upgrading the connection with the
+// StartTLS operation, or "" for a plain TCP connection. It's a variable so
+// tests can replace it.
+var ldapDial = func(addr, mode string) (ldapConn, error) {
+	var (
+		conn *ldap.Conn
+		err  *ldap.Error
+	)
+	switch mode {
+	case "ldaps":
+		conn, err = ldap.DialSSL("tcp", addr)
+	case "starttls":
+		conn, err = ldap.DialTLS("tcp", addr)
+	case "":
+		conn, err = ldap.Dial("tcp", addr)
+	default:
+		return nil, fmt.Errorf("Invalid value for the setting \"auth:ldap:tls\": %q.", mode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &mmittonConn{conn}, nil
+}
+
+type mmittonConn struct {
+	conn *ldap.Conn
+}
+
+func (c *mmittonConn) Bind(dn, password string) error {
+	if err := c.conn.Bind(dn, password); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *mmittonConn) SearchEmail(baseDN, filter, attr string) (string, string, error) {
+	request := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 0, false, filter, []string{attr}, nil)
+	result, err := c.conn.Search(request)
+	if err != nil {
+		return "", "", err
+	}
+	if len(result.Entries) != 1 {
+		return "", "", AuthenticationFailure{}
+	}
+	entry := result.Entries[0]
+	return entry.DN, entry.GetAttributeValue(attr), nil
+}
+
+func (c *mmittonConn) Close() {
+	c.conn.Close()
+}
+
+// ldapScheme authenticates users against an LDAP directory. It looks for the
+// user using a service account, and then binds as the user to check the
+// password.
+//
+// It's configured with the following settings:
+//
+//   - auth:ldap:server: address of the LDAP server (host:port)
+//   - auth:ldap:tls: "ldaps" or "starttls" for protecting the connection with
+//     TLS (optional, passwords are sent in plain text when missing)
+//   - auth:ldap:bind-dn and auth:ldap:bind-password: service account used
+//     for searching users (optional, anonymous search is used when missing)
+//   - auth:ldap:base-dn: base DN for searching users
+//   - auth:ldap:user-filter: filter for searching users, where %s is replaced
+//     with the login provided by the user (defaults to "(mail=%s)")
+//   - auth:ldap:email-attribute: attribute that holds the email of the user
+//     (defaults to "mail")
+type ldapScheme struct{}
+
+func (ldapScheme) Login(params map[string]string) (string, error) {
+	login, password := params["email"], params["password"]
+	if login == "" || password == "" {
+		return "", AuthenticationFailure{}
+	}
+	server, err := config.GetString("auth:ldap:server")
+	if err != nil {
+		return "", errors.New(`Setting "auth:ldap:server" is not defined.`)
+	}
+	baseDN, err := config.GetString("auth:ldap:base-dn")
+	if err != nil {
+		return "", errors.New(`Setting "auth:ldap:base-dn" is not defined.`)
+	}
+	filter, err := config.GetString("auth:ldap:user-filter")
+	if err != nil {
+		filter = "(mail=%s)"
+	}
+	attr, err := config.GetString("auth:ldap:email-attribute")
+	if err != nil {
+		attr = "mail"
+	}
+	mode, _ := config.GetString("auth:ldap:tls")
+	conn, err := ldapDial(server, mode)
+	if err != nil {
+		return "", fmt.Errorf("Failed to connect to the LDAP server: %s", err)
+	}
+	defer conn.Close()
+	bindDN, _ := config.GetString("auth:ldap:bind-dn")
+	bindPassword, _ := config.GetString("auth:ldap:bind-password")
+	if err := conn.Bind(bindDN, bindPassword); err != nil {
+		return "", fmt.Errorf("Failed to bind to the LDAP server: %s", err)
+	}
+	filter = fmt.Sprintf(filter, ldapEscape(login))
+	dn, email, err := conn.SearchEmail(baseDN, filter, attr)
+	if err != nil {
+		return "", err
+	}
+	if err := conn.Bind(dn, password); err != nil {
+		return "", AuthenticationFailure{}
+	}
+	if email == "" {
+		return "", fmt.Errorf("The LDAP entry of the user does not have the attribute %q.", attr)
+	}
+	return email, nil
+}
+
+func (ldapScheme) Info() (map[string]string, error) {
+	return nil, nil
+}
+
+var ldapEscaper = strings.NewReplacer(`\`, `\5c`, `*`, `\2a`, `(`, `\28`, `)`, `\29`, "\x00", `\00`)
+
+// ldapEscape escapes special characters in values used in LDAP filters, as
+// defined in RFC 4515.
+func ldapEscape(value string) string {
+	return ldapEscaper.Replace(value)
+}
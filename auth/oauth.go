@@ -0,0 +1,237 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultOAuthCallbackPort = "35666"
+
+// oauthTimeout is the maximum amount of time that the OAuth provider may take
+// to accept a connection and to answer a request.
+var oauthTimeout = 10 * time.Second
+
+var oauthClient = &http.Client{
+	Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, oauthTimeout)
+		},
+		ResponseHeaderTimeout: oauthTimeout,
+	},
+}
+
+// oauthScheme authenticates users with an OAuth2 provider, using the
+// authorization code flow. Clients open the authorization URL (returned by
+// Info) in the browser, receive the code in a local callback and send it to
+// tsuru, which exchanges it for an access token and loads the email of the
+// user from the provider.
+//
+// Each authorization URL carries a random state, stored by tsuru until the
+// login is completed. Clients check that the callback has the same state, and
+// send it to tsuru with the code, so the login can't be completed with a code
+// obtained by someone else.
+//
+// It's configured with the following settings:
+//
+//   - auth:oauth:client-id and auth:oauth:client-secret: credentials of tsuru
+//     in the provider
+//   - auth:oauth:auth-url: URL of the authorization endpoint
+//   - auth:oauth:token-url: URL of the token endpoint
+//   - auth:oauth:info-url: URL that returns the information of the user, as
+//     a JSON object containing the email of the user
+//   - auth:oauth:scope: scope requested to the provider (optional)
+//   - auth:oauth:callback-port: port used by clients to listen for the
+//     callback (defaults to 35666)
+type oauthScheme struct{}
+
+type oauthConfig struct {
+	clientID     string
+	clientSecret string
+	authURL      string
+	tokenURL     string
+	infoURL      string
+	scope        string
+	callbackPort string
+}
+
+func loadOAuthConfig() (*oauthConfig, error) {
+	var c oauthConfig
+	settings := []struct {
+		name  string
+		value *string
+	}{
+		{"auth:oauth:client-id", &c.clientID},
+		{"auth:oauth:client-secret", &c.clientSecret},
+		{"auth:oauth:auth-url", &c.authURL},
+		{"auth:oauth:token-url", &c.tokenURL},
+		{"auth:oauth:info-url", &c.infoURL},
+	}
+	for _, s := range settings {
+		value, err := config.GetString(s.name)
+		if err != nil {
+			return nil, fmt.Errorf("Setting %q is not defined.", s.name)
+		}
+		*s.value = value
+	}
+	c.scope, _ = config.GetString("auth:oauth:scope")
+	if port, err := config.GetInt("auth:oauth:callback-port"); err == nil {
+		c.callbackPort = fmt.Sprint(port)
+	} else {
+		c.callbackPort = defaultOAuthCallbackPort
+	}
+	return &c, nil
+}
+
+func (oauthScheme) Login(params map[string]string) (string, error) {
+	c, err := loadOAuthConfig()
+	if err != nil {
+		return "", err
+	}
+	code := params["code"]
+	if code == "" {
+		return "", AuthenticationFailure{}
+	}
+	if err := consumeOAuthLogin(params["state"]); err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    []string{"authorization_code"},
+		"code":          []string{code},
+		"redirect_uri":  []string{params["redirectUrl"]},
+		"client_id":     []string{c.clientID},
+		"client_secret": []string{c.clientSecret},
+	}
+	request, err := http.NewRequest("POST", c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := oauthRequest(request, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", AuthenticationFailure{}
+	}
+	request, err = http.NewRequest("GET", c.infoURL, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	request.Header.Set("Accept", "application/json")
+	var info struct {
+		Email string `json:"email"`
+	}
+	if err := oauthRequest(request, &info); err != nil {
+		return "", err
+	}
+	if info.Email == "" {
+		return "", errors.New("The OAuth provider did not return the email of the user.")
+	}
+	return info.Email, nil
+}
+
+func oauthRequest(request *http.Request, result interface{}) error {
+	resp, err := oauthClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to the OAuth provider: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return AuthenticationFailure{}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("The OAuth provider returned an unexpected status: %d.", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Info returns the authorization URL, in which the redirect URI is replaced
+// by the __redirect_url__ placeholder, and the port that clients should use
+// for listening for the callback.
+func (oauthScheme) Info() (map[string]string, error) {
+	c, err := loadOAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+	state, err := createOAuthLogin()
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"client_id":     []string{c.clientID},
+		"response_type": []string{"code"},
+		"state":         []string{state},
+	}
+	if c.scope != "" {
+		query.Set("scope", c.scope)
+	}
+	sep := "?"
+	if strings.Contains(c.authURL, "?") {
+		sep = "&"
+	}
+	authorizeURL := c.authURL + sep + query.Encode() + "&redirect_uri=__redirect_url__"
+	return map[string]string{"authorizeUrl": authorizeURL, "port": c.callbackPort, "state": state}, nil
+}
+
+// oauthLogin is an OAuth login waiting for the callback of the provider,
+// identified by its state.
+type oauthLogin struct {
+	State    string `bson:"_id"`
+	Creation time.Time
+}
+
+// createOAuthLogin starts an OAuth login, returning its state. Logins that
+// were not completed in time are removed.
+func createOAuthLogin() (string, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	now := timeNow()
+	conn.OAuthLogins().RemoveAll(bson.M{"creation": bson.M{"$lt": now.Add(-pendingLoginExpiration)}})
+	l := oauthLogin{State: token("oauth", crypto.SHA256), Creation: now}
+	if err := conn.OAuthLogins().Insert(l); err != nil {
+		return "", err
+	}
+	return l.State, nil
+}
+
+// consumeOAuthLogin completes the OAuth login identified by the given state.
+// Each state can be used only once.
+func consumeOAuthLogin(state string) error {
+	if state == "" {
+		return ErrInvalidLogin
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	query := bson.M{"_id": state, "creation": bson.M{"$gte": timeNow().Add(-pendingLoginExpiration)}}
+	err = conn.OAuthLogins().Remove(query)
+	if err == mgo.ErrNotFound {
+		return ErrInvalidLogin
+	}
+	return err
+}
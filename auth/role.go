@@ -0,0 +1,166 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/log"
+	"labix.org/v2/mgo/bson"
+)
+
+// Permission is an action that members of a team are allowed to perform.
+type Permission string
+
+const (
+	// PermAppRead allows reading information and logs of apps.
+	PermAppRead = Permission("app.read")
+
+	// PermAppDeploy allows deploying, restarting and rolling apps back.
+	PermAppDeploy = Permission("app.deploy")
+
+	// PermAppUpdate allows changing apps: units, environment variables,
+	// cnames, plans, services and running commands in units.
+	PermAppUpdate = Permission("app.update")
+
+	// PermAppAdmin allows removing apps and managing the teams that have
+	// access to them.
+	PermAppAdmin = Permission("app.admin")
+
+	// PermTeamAdmin allows managing the members of a team and their roles.
+	PermTeamAdmin = Permission("team.admin")
+)
+
+// DefaultRole is the role of members of teams that don't define a role. It
+// grants every permission, so teams created before roles existed keep full
+// access to their apps.
+const DefaultRole = "team-admin"
+
+var ErrRoleNotFound = errors.New("Role not found")
+
+// Role is a named set of permissions.
+type Role struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Allows checks whether the role grants the given permission.
+func (r *Role) Allows(p Permission) bool {
+	for _, perm := range r.Permissions {
+		if perm == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles is the list of available roles, sorted from the least to the most
+// privileged.
+var Roles = []Role{
+	{Name: "viewer", Permissions: []Permission{PermAppRead}},
+	{Name: "deployer", Permissions: []Permission{PermAppRead, PermAppDeploy}},
+	{Name: "app-admin", Permissions: []Permission{PermAppRead, PermAppDeploy, PermAppUpdate, PermAppAdmin}},
+	{Name: "team-admin", Permissions: []Permission{PermAppRead, PermAppDeploy, PermAppUpdate, PermAppAdmin, PermTeamAdmin}},
+}
+
+// GetRole returns the role with the given name.
+func GetRole(name string) (*Role, error) {
+	for i := range Roles {
+		if Roles[i].Name == name {
+			return &Roles[i], nil
+		}
+	}
+	return nil, ErrRoleNotFound
+}
+
+// UserRole assigns a role to a member of a team, overriding the role of the
+// team.
+type UserRole struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// RoleOf returns the role of the user in the team, or nil if the user is not
+// a member of the team.
+func (t *Team) RoleOf(u *User) *Role {
+	if !t.ContainsUser(u) {
+		return nil
+	}
+	name := t.Role
+	for _, ur := range t.UserRoles {
+		if ur.Email == u.Email {
+			name = ur.Role
+			break
+		}
+	}
+	if name == "" {
+		name = DefaultRole
+	}
+	role, err := GetRole(name)
+	if err != nil {
+		log.Printf("Team %q has an unknown role %q.", t.Name, name)
+		return &Role{Name: name}
+	}
+	return role
+}
+
+// SetRole defines the role of all members of the team that don't have a
+// role of their own. An empty name resets the role of the team to the
+// default role.
+func (t *Team) SetRole(name string) error {
+	if name != "" {
+		if _, err := GetRole(name); err != nil {
+			return err
+		}
+	}
+	t.Role = name
+	return nil
+}
+
+// SetUserRole defines the role of a member of the team. An empty name makes
+// the user use the role of the team again.
+func (t *Team) SetUserRole(u *User, name string) error {
+	if !t.ContainsUser(u) {
+		return errors.New("User " + u.Email + " is not in the team " + t.Name + ".")
+	}
+	if name != "" {
+		if _, err := GetRole(name); err != nil {
+			return err
+		}
+	}
+	t.removeUserRole(u.Email)
+	if name != "" {
+		t.UserRoles = append(t.UserRoles, UserRole{Email: u.Email, Role: name})
+	}
+	return nil
+}
+
+func (t *Team) removeUserRole(email string) {
+	for i, ur := range t.UserRoles {
+		if ur.Email == email {
+			t.UserRoles = append(t.UserRoles[:i], t.UserRoles[i+1:]...)
+			return
+		}
+	}
+}
+
+// CheckUserPermission checks whether the user has the given permission in
+// any of the given teams.
+func CheckUserPermission(teamNames []string, u *User, p Permission) bool {
+	conn, err := db.Conn()
+	if err != nil {
+		log.Printf("Failed to connect to the database: %s", err)
+		return false
+	}
+	defer conn.Close()
+	var teams []Team
+	conn.Teams().Find(bson.M{"_id": bson.M{"$in": teamNames}, "users": u.Email}).All(&teams)
+	for i := range teams {
+		if role := teams[i].RoleOf(u); role != nil && role.Allows(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"launchpad.net/gocheck"
+)
+
+func (s *S) TestGetRole(c *gocheck.C) {
+	role, err := GetRole("viewer")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(role.Name, gocheck.Equals, "viewer")
+	c.Assert(role.Permissions, gocheck.DeepEquals, []Permission{PermAppRead})
+	_, err = GetRole("superuser")
+	c.Assert(err, gocheck.Equals, ErrRoleNotFound)
+}
+
+func (s *S) TestRoleAllows(c *gocheck.C) {
+	var tests = []struct {
+		role     string
+		perm     Permission
+		expected bool
+	}{
+		{"viewer", PermAppRead, true},
+		{"viewer", PermAppDeploy, false},
+		{"viewer", PermAppUpdate, false},
+		{"deployer", PermAppDeploy, true},
+		{"deployer", PermAppUpdate, false},
+		{"app-admin", PermAppUpdate, true},
+		{"app-admin", PermAppAdmin, true},
+		{"app-admin", PermTeamAdmin, false},
+		{"team-admin", PermTeamAdmin, true},
+	}
+	for _, t := range tests {
+		role, err := GetRole(t.role)
+		c.Assert(err, gocheck.IsNil)
+		c.Check(role.Allows(t.perm), gocheck.Equals, t.expected)
+	}
+}
+
+func (s *S) TestTeamRoleOf(c *gocheck.C) {
+	u1 := User{Email: "viewer@tsuru.io"}
+	u2 := User{Email: "deployer@tsuru.io"}
+	u3 := User{Email: "outsider@tsuru.io"}
+	team := Team{
+		Name:      "roled",
+		Users:     []string{u1.Email, u2.Email},
+		Role:      "viewer",
+		UserRoles: []UserRole{{Email: u2.Email, Role: "deployer"}},
+	}
+	c.Assert(team.RoleOf(&u1).Name, gocheck.Equals, "viewer")
+	c.Assert(team.RoleOf(&u2).Name, gocheck.Equals, "deployer")
+	c.Assert(team.RoleOf(&u3), gocheck.IsNil)
+}
+
+func (s *S) TestTeamRoleOfDefaultsToFullAccess(c *gocheck.C) {
+	u := User{Email: "member@tsuru.io"}
+	team := Team{Name: "legacy", Users: []string{u.Email}}
+	role := team.RoleOf(&u)
+	c.Assert(role.Name, gocheck.Equals, DefaultRole)
+	for _, p := range []Permission{PermAppRead, PermAppDeploy, PermAppUpdate, PermAppAdmin, PermTeamAdmin} {
+		c.Check(role.Allows(p), gocheck.Equals, true)
+	}
+}
+
+func (s *S) TestTeamSetRole(c *gocheck.C) {
+	team := Team{Name: "roled"}
+	err := team.SetRole("deployer")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(team.Role, gocheck.Equals, "deployer")
+	err = team.SetRole("superuser")
+	c.Assert(err, gocheck.Equals, ErrRoleNotFound)
+	c.Assert(team.Role, gocheck.Equals, "deployer")
+	err = team.SetRole("")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(team.Role, gocheck.Equals, "")
+}
+
+func (s *S) TestTeamSetUserRole(c *gocheck.C) {
+	u := User{Email: "member@tsuru.io"}
+	team := Team{Name: "roled", Users: []string{u.Email}}
+	err := team.SetUserRole(&u, "viewer")
+	c.Assert(err, gocheck.IsNil)
+	err = team.SetUserRole(&u, "deployer")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(team.UserRoles, gocheck.DeepEquals, []UserRole{{Email: u.Email, Role: "deployer"}})
+	err = team.SetUserRole(&u, "")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(team.UserRoles, gocheck.HasLen, 0)
+	err = team.SetUserRole(&u, "superuser")
+	c.Assert(err, gocheck.Equals, ErrRoleNotFound)
+	err = team.SetUserRole(&User{Email: "outsider@tsuru.io"}, "viewer")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestRemoveUserRemovesUserRole(c *gocheck.C) {
+	u := User{Email: "member@tsuru.io"}
+	team := Team{Name: "roled", Users: []string{u.Email}, UserRoles: []UserRole{{Email: u.Email, Role: "viewer"}}}
+	err := team.RemoveUser(&u)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(team.UserRoles, gocheck.HasLen, 0)
+}
+
+func (s *S) TestCheckUserPermission(c *gocheck.C) {
+	u := User{Email: "member@tsuru.io"}
+	viewers := Team{Name: "viewers", Users: []string{u.Email}, Role: "viewer"}
+	deployers := Team{Name: "deployers", Users: []string{u.Email}, Role: "deployer"}
+	err := s.conn.Teams().Insert(viewers, deployers)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(viewers.Name)
+	defer s.conn.Teams().RemoveId(deployers.Name)
+	c.Assert(CheckUserPermission([]string{"viewers"}, &u, PermAppRead), gocheck.Equals, true)
+	c.Assert(CheckUserPermission([]string{"viewers"}, &u, PermAppDeploy), gocheck.Equals, false)
+	c.Assert(CheckUserPermission([]string{"viewers", "deployers"}, &u, PermAppDeploy), gocheck.Equals, true)
+	c.Assert(CheckUserPermission([]string{"viewers", "deployers"}, &u, PermAppUpdate), gocheck.Equals, false)
+	c.Assert(CheckUserPermission([]string{"cobrateam"}, &u, PermAppRead), gocheck.Equals, false)
+}
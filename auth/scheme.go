@@ -0,0 +1,94 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"fmt"
+	"github.com/globocom/config"
+)
+
+// Scheme is an authentication scheme. It's responsible for checking the
+// credentials of users, and is selected with the auth:scheme setting.
+type Scheme interface {
+	// Login checks the credentials in the given parameters, returning the
+	// email of the authenticated user.
+	//
+	// Parameters depend on the scheme: the native and LDAP schemes expect
+	// "email" and "password", while the OAuth scheme expects "code" and
+	// "redirectUrl".
+	Login(params map[string]string) (string, error)
+
+	// Info returns the information that clients need to start the login
+	// process, like the URL for the authorization in an OAuth provider.
+	Info() (map[string]string, error)
+}
+
+var schemes = map[string]Scheme{
+	"native": nativeScheme{},
+	"ldap":   ldapScheme{},
+	"oauth":  oauthScheme{},
+}
+
+// RegisterScheme registers a new authentication scheme.
+func RegisterScheme(name string, scheme Scheme) {
+	schemes[name] = scheme
+}
+
+// SchemeName returns the name of the authentication scheme in use, as defined
+// in the auth:scheme setting. It defaults to "native".
+func SchemeName() string {
+	name, err := config.GetString("auth:scheme")
+	if err != nil || name == "" {
+		name = "native"
+	}
+	return name
+}
+
+// GetScheme returns the authentication scheme in use.
+func GetScheme() (Scheme, error) {
+	name := SchemeName()
+	if s, ok := schemes[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("Authentication scheme %q is not known.", name)
+}
+
+// Login authenticates the user with the authentication scheme in use,
+// returning the user. Users that don't exist in tsuru yet are created,
+// through the provision function, on their first login.
+func Login(params map[string]string, provision func(email string) (*User, error)) (*User, error) {
+	scheme, err := GetScheme()
+	if err != nil {
+		return nil, err
+	}
+	email, err := scheme.Login(params)
+	if err != nil {
+		return nil, err
+	}
+	u, err := GetUserByEmail(email)
+	if err == ErrUserNotFound {
+		return provision(email)
+	}
+	return u, err
+}
+
+// nativeScheme authenticates users against the passwords stored in tsuru's
+// database.
+type nativeScheme struct{}
+
+func (nativeScheme) Login(params map[string]string) (string, error) {
+	u, err := GetUserByEmail(params["email"])
+	if err != nil {
+		return "", err
+	}
+	if err := u.CheckPassword(params["password"]); err != nil {
+		return "", err
+	}
+	return u.Email, nil
+}
+
+func (nativeScheme) Info() (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/globocom/config"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func (s *S) TestGetSchemeDefault(c *gocheck.C) {
+	scheme, err := GetScheme()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(scheme, gocheck.FitsTypeOf, nativeScheme{})
+	c.Assert(SchemeName(), gocheck.Equals, "native")
+}
+
+func (s *S) TestGetScheme(c *gocheck.C) {
+	config.Set("auth:scheme", "ldap")
+	defer config.Unset("auth:scheme")
+	scheme, err := GetScheme()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(scheme, gocheck.FitsTypeOf, ldapScheme{})
+}
+
+func (s *S) TestGetSchemeUnknown(c *gocheck.C) {
+	config.Set("auth:scheme", "kerberos")
+	defer config.Unset("auth:scheme")
+	_, err := GetScheme()
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, `Authentication scheme "kerberos" is not known.`)
+}
+
+type fakeScheme struct {
+	email string
+}
+
+func (s fakeScheme) Login(params map[string]string) (string, error) {
+	if params["secret"] != "open sesame" {
+		return "", AuthenticationFailure{}
+	}
+	return s.email, nil
+}
+
+func (fakeScheme) Info() (map[string]string, error) {
+	return map[string]string{"fake": "true"}, nil
+}
+
+func (s *S) TestRegisterScheme(c *gocheck.C) {
+	RegisterScheme("fake", fakeScheme{})
+	defer delete(schemes, "fake")
+	config.Set("auth:scheme", "fake")
+	defer config.Unset("auth:scheme")
+	scheme, err := GetScheme()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(scheme, gocheck.FitsTypeOf, fakeScheme{})
+}
+
+func (s *S) TestLoginExistingUser(c *gocheck.C) {
+	RegisterScheme("fake", fakeScheme{email: s.user.Email})
+	defer delete(schemes, "fake")
+	config.Set("auth:scheme", "fake")
+	defer config.Unset("auth:scheme")
+	provision := func(email string) (*User, error) {
+		c.Fatalf("Unexpected provisioning of %s.", email)
+		return nil, nil
+	}
+	u, err := Login(map[string]string{"secret": "open sesame"}, provision)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.Email, gocheck.Equals, s.user.Email)
+}
+
+func (s *S) TestLoginProvisionsNewUsers(c *gocheck.C) {
+	RegisterScheme("fake", fakeScheme{email: "newcomer@tsuru.io"})
+	defer delete(schemes, "fake")
+	config.Set("auth:scheme", "fake")
+	defer config.Unset("auth:scheme")
+	var provisioned string
+	provision := func(email string) (*User, error) {
+		provisioned = email
+		return &User{Email: email}, nil
+	}
+	u, err := Login(map[string]string{"secret": "open sesame"}, provision)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.Email, gocheck.Equals, "newcomer@tsuru.io")
+	c.Assert(provisioned, gocheck.Equals, "newcomer@tsuru.io")
+}
+
+func (s *S) TestLoginFailure(c *gocheck.C) {
+	RegisterScheme("fake", fakeScheme{email: s.user.Email})
+	defer delete(schemes, "fake")
+	config.Set("auth:scheme", "fake")
+	defer config.Unset("auth:scheme")
+	_, err := Login(map[string]string{"secret": "wrong"}, nil)
+	c.Assert(err, gocheck.FitsTypeOf, AuthenticationFailure{})
+}
+
+func (s *S) TestNativeSchemeLogin(c *gocheck.C) {
+	email, err := nativeScheme{}.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(email, gocheck.Equals, s.user.Email)
+	_, err = nativeScheme{}.Login(map[string]string{"email": s.user.Email, "password": "1234567"})
+	c.Assert(err, gocheck.FitsTypeOf, AuthenticationFailure{})
+	_, err = nativeScheme{}.Login(map[string]string{"email": "unknown@tsuru.io", "password": "123456"})
+	c.Assert(err, gocheck.Equals, ErrUserNotFound)
+}
+
+func (s *S) TestCreateSessionToken(c *gocheck.C) {
+	t, err := s.user.CreateSessionToken()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": t.Token})
+	c.Assert(t.UserEmail, gocheck.Equals, s.user.Email)
+	_, err = GetToken("bearer " + t.Token)
+	c.Assert(err, gocheck.IsNil)
+}
+
+type fakeLDAPConn struct {
+	users  map[string]string
+	binds  []string
+	filter string
+	mode   string
+}
+
+func (c *fakeLDAPConn) Bind(dn, password string) error {
+	c.binds = append(c.binds, dn)
+	if dn == "cn=tsuru,dc=tsuru,dc=io" && password == "service" {
+		return nil
+	}
+	if p, ok := c.users[dn]; ok && p == password {
+		return nil
+	}
+	return errors.New("Invalid credentials")
+}
+
+func (c *fakeLDAPConn) SearchEmail(baseDN, filter, attr string) (string, string, error) {
+	c.filter = filter
+	if filter == "(uid=gopher)" {
+		return "uid=gopher,ou=people,dc=tsuru,dc=io", "gopher@tsuru.io", nil
+	}
+	return "", "", AuthenticationFailure{}
+}
+
+func (c *fakeLDAPConn) Close() {}
+
+var originalLDAPDial = ldapDial
+
+func (s *S) setupLDAP(c *gocheck.C) *fakeLDAPConn {
+	conn := &fakeLDAPConn{users: map[string]string{"uid=gopher,ou=people,dc=tsuru,dc=io": "secret"}}
+	ldapDial = func(addr, mode string) (ldapConn, error) {
+		c.Assert(addr, gocheck.Equals, "ldap.tsuru.io:389")
+		conn.mode = mode
+		return conn, nil
+	}
+	config.Set("auth:ldap:server", "ldap.tsuru.io:389")
+	config.Set("auth:ldap:base-dn", "ou=people,dc=tsuru,dc=io")
+	config.Set("auth:ldap:bind-dn", "cn=tsuru,dc=tsuru,dc=io")
+	config.Set("auth:ldap:bind-password", "service")
+	config.Set("auth:ldap:user-filter", "(uid=%s)")
+	return conn
+}
+
+func (s *S) TestLDAPSchemeLogin(c *gocheck.C) {
+	conn := s.setupLDAP(c)
+	defer config.Unset("auth:ldap")
+	defer func() { ldapDial = originalLDAPDial }()
+	email, err := ldapScheme{}.Login(map[string]string{"email": "gopher", "password": "secret"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(email, gocheck.Equals, "gopher@tsuru.io")
+	c.Assert(conn.binds, gocheck.DeepEquals, []string{"cn=tsuru,dc=tsuru,dc=io", "uid=gopher,ou=people,dc=tsuru,dc=io"})
+}
+
+func (s *S) TestLDAPSchemeLoginWithTLS(c *gocheck.C) {
+	conn := s.setupLDAP(c)
+	config.Set("auth:ldap:tls", "starttls")
+	defer config.Unset("auth:ldap")
+	defer func() { ldapDial = originalLDAPDial }()
+	_, err := ldapScheme{}.Login(map[string]string{"email": "gopher", "password": "secret"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(conn.mode, gocheck.Equals, "starttls")
+}
+
+func (s *S) TestLDAPDialInvalidTLSMode(c *gocheck.C) {
+	_, err := ldapDial("ldap.tsuru.io:389", "ssl")
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, `Invalid value for the setting "auth:ldap:tls": "ssl".`)
+}
+
+func (s *S) TestLDAPSchemeLoginWrongPassword(c *gocheck.C) {
+	s.setupLDAP(c)
+	defer config.Unset("auth:ldap")
+	defer func() { ldapDial = originalLDAPDial }()
+	_, err := ldapScheme{}.Login(map[string]string{"email": "gopher", "password": "wrong"})
+	c.Assert(err, gocheck.FitsTypeOf, AuthenticationFailure{})
+}
+
+func (s *S) TestLDAPSchemeLoginUnknownUser(c *gocheck.C) {
+	s.setupLDAP(c)
+	defer config.Unset("auth:ldap")
+	defer func() { ldapDial = originalLDAPDial }()
+	_, err := ldapScheme{}.Login(map[string]string{"email": "nobody", "password": "secret"})
+	c.Assert(err, gocheck.FitsTypeOf, AuthenticationFailure{})
+}
+
+func (s *S) TestLDAPSchemeEscapesTheLogin(c *gocheck.C) {
+	conn := s.setupLDAP(c)
+	defer config.Unset("auth:ldap")
+	defer func() { ldapDial = originalLDAPDial }()
+	ldapScheme{}.Login(map[string]string{"email": "*)(uid=*", "password": "secret"})
+	c.Assert(conn.filter, gocheck.Equals, `(uid=\2a\29\28uid=\2a)`)
+}
+
+func (s *S) TestLDAPSchemeLoginWithoutServer(c *gocheck.C) {
+	_, err := ldapScheme{}.Login(map[string]string{"email": "gopher", "password": "secret"})
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, `Setting "auth:ldap:server" is not defined.`)
+}
+
+func (s *S) setupOAuth(c *gocheck.C) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			if r.Form.Get("code") != "good-code" || r.Form.Get("client_secret") != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			c.Check(r.Form.Get("redirect_uri"), gocheck.Equals, "http://localhost:35666")
+			fmt.Fprint(w, `{"access_token":"access","token_type":"bearer"}`)
+		case "/user":
+			if r.Header.Get("Authorization") != "Bearer access" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"email":"gopher@tsuru.io","name":"Gopher"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	config.Set("auth:oauth:client-id", "tsuru")
+	config.Set("auth:oauth:client-secret", "secret")
+	config.Set("auth:oauth:auth-url", server.URL+"/authorize")
+	config.Set("auth:oauth:token-url", server.URL+"/token")
+	config.Set("auth:oauth:info-url", server.URL+"/user")
+	return server
+}
+
+func (s *S) TestOAuthSchemeLogin(c *gocheck.C) {
+	server := s.setupOAuth(c)
+	defer server.Close()
+	defer config.Unset("auth:oauth")
+	state, err := createOAuthLogin()
+	c.Assert(err, gocheck.IsNil)
+	params := map[string]string{"code": "good-code", "redirectUrl": "http://localhost:35666", "state": state}
+	email, err := oauthScheme{}.Login(params)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(email, gocheck.Equals, "gopher@tsuru.io")
+	n, err := s.conn.OAuthLogins().FindId(state).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
+func (s *S) TestOAuthSchemeLoginStateCantBeReused(c *gocheck.C) {
+	server := s.setupOAuth(c)
+	defer server.Close()
+	defer config.Unset("auth:oauth")
+	state, err := createOAuthLogin()
+	c.Assert(err, gocheck.IsNil)
+	params := map[string]string{"code": "good-code", "redirectUrl": "http://localhost:35666", "state": state}
+	_, err = oauthScheme{}.Login(params)
+	c.Assert(err, gocheck.IsNil)
+	_, err = oauthScheme{}.Login(params)
+	c.Assert(err, gocheck.Equals, ErrInvalidLogin)
+}
+
+func (s *S) TestOAuthSchemeLoginInvalidState(c *gocheck.C) {
+	server := s.setupOAuth(c)
+	defer server.Close()
+	defer config.Unset("auth:oauth")
+	for _, state := range []string{"", "unknown-state"} {
+		params := map[string]string{"code": "good-code", "redirectUrl": "http://localhost:35666", "state": state}
+		_, err := oauthScheme{}.Login(params)
+		c.Check(err, gocheck.Equals, ErrInvalidLogin)
+	}
+}
+
+func (s *S) TestOAuthSchemeLoginExpiredState(c *gocheck.C) {
+	server := s.setupOAuth(c)
+	defer server.Close()
+	defer config.Unset("auth:oauth")
+	state, err := createOAuthLogin()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.OAuthLogins().RemoveId(state)
+	now := time.Now().Add(pendingLoginExpiration + time.Second)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	params := map[string]string{"code": "good-code", "redirectUrl": "http://localhost:35666", "state": state}
+	_, err = oauthScheme{}.Login(params)
+	c.Assert(err, gocheck.Equals, ErrInvalidLogin)
+}
+
+func (s *S) TestOAuthSchemeLoginInvalidCode(c *gocheck.C) {
+	server := s.setupOAuth(c)
+	defer server.Close()
+	defer config.Unset("auth:oauth")
+	state, err := createOAuthLogin()
+	c.Assert(err, gocheck.IsNil)
+	params := map[string]string{"code": "bad-code", "redirectUrl": "http://localhost:35666", "state": state}
+	_, err = oauthScheme{}.Login(params)
+	c.Assert(err, gocheck.FitsTypeOf, AuthenticationFailure{})
+}
+
+func (s *S) TestOAuthSchemeInfo(c *gocheck.C) {
+	server := s.setupOAuth(c)
+	defer server.Close()
+	defer config.Unset("auth:oauth")
+	config.Set("auth:oauth:scope", "email")
+	info, err := oauthScheme{}.Info()
+	c.Assert(err, gocheck.IsNil)
+	state := info["state"]
+	defer s.conn.OAuthLogins().RemoveId(state)
+	n, err := s.conn.OAuthLogins().FindId(state).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 1)
+	expected := map[string]string{
+		"authorizeUrl": server.URL + "/authorize?client_id=tsuru&response_type=code&scope=email&state=" + state + "&redirect_uri=__redirect_url__",
+		"port":         "35666",
+		"state":        state,
+	}
+	c.Assert(info, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestOAuthSchemeInfoWithoutSettings(c *gocheck.C) {
+	_, err := oauthScheme{}.Info()
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, `Setting "auth:oauth:client-id" is not defined.`)
+}
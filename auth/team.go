@@ -26,6 +26,18 @@ var (
 type Team struct {
 	Name  string   `bson:"_id" json:"name"`
 	Users []string `json:"users"`
+
+	// Role is the role of the members of the team, see Roles. When empty,
+	// members have the DefaultRole.
+	Role string `bson:",omitempty" json:"role,omitempty"`
+
+	// UserRoles holds the roles of members that don't use the role of the
+	// team.
+	UserRoles []UserRole `bson:",omitempty" json:"userroles,omitempty"`
+
+	// RequireTOTP indicates whether members of the team must use two-factor
+	// authentication.
+	RequireTOTP bool `bson:",omitempty" json:"requiretotp,omitempty"`
 }
 
 func (t *Team) ContainsUser(u *User) bool {
@@ -58,6 +70,7 @@ func (t *Team) RemoveUser(u *User) error {
 	}
 	copy(t.Users[index:], t.Users[index+1:])
 	t.Users = t.Users[:len(t.Users)-1]
+	t.removeUserRole(u.Email)
 	return nil
 }
 
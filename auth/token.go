@@ -11,21 +11,68 @@ import (
 	"fmt"
 	"github.com/globocom/config"
 	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
+	"regexp"
 	"strings"
 	"time"
 )
 
-const keySize = 32
+const (
+	keySize = 32
 
-var ErrInvalidToken = errors.New("Invalid token")
+	// useInterval is the minimum interval between two updates of the last
+	// use of a token, so tokens aren't written on every request.
+	useInterval = time.Minute
+
+	defaultAPITokenExpiration = 365 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidToken       = errors.New("Invalid token")
+	ErrTokenNotFound      = errors.New("Token not found")
+	ErrTokenAlreadyExists = errors.New("There is already a token with this name")
+	ErrInvalidTokenName   = errors.New("Invalid token name, token names must be composed of letters, numbers, dashes and underscores")
+)
+
+// ServerAppName is the name of the application token generated by "tsr token",
+// used by the git hooks of the repositories to deploy apps.
+const ServerAppName = "tsr"
+
+var tokenNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
 type Token struct {
+	ID        bson.ObjectId `bson:"_id,omitempty" json:"id,omitempty"`
 	Token     string        `json:"token"`
 	Creation  time.Time     `json:"creation"`
 	Expires   time.Duration `json:"expires"`
 	UserEmail string        `json:"email"`
 	AppName   string        `json:"app"`
+	Name      string        `bson:",omitempty" json:"name,omitempty"`
+	LastUse   time.Time     `bson:",omitempty" json:"lastuse"`
+	Client    string        `bson:",omitempty" json:"client,omitempty"`
+}
+
+// IsExpired checks whether the token has already expired.
+func (t *Token) IsExpired() bool {
+	return t.Creation.Add(t.Expires).Sub(time.Now()) < 1
+}
+
+// Use records that the token was used by the given client (generally the
+// User-Agent of the request).
+func (t *Token) Use(client string) error {
+	now := time.Now()
+	if now.Sub(t.LastUse) < useInterval && client == t.Client {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	t.LastUse = now
+	t.Client = client
+	return conn.Tokens().Update(bson.M{"token": t.Token}, bson.M{"$set": bson.M{"lastuse": now, "client": client}})
 }
 
 func (t *Token) User() (*User, error) {
@@ -64,12 +111,70 @@ func GetToken(header string) (*Token, error) {
 	if err != nil {
 		return nil, ErrInvalidToken
 	}
-	if t.Creation.Add(t.Expires).Sub(time.Now()) < 1 {
+	if t.IsExpired() {
+		conn.Tokens().Remove(bson.M{"token": token})
 		return nil, ErrInvalidToken
 	}
 	return &t, nil
 }
 
+// ListTokens returns the active tokens of the user: the sessions opened with
+// login and the named API tokens. The value of the tokens is not returned.
+func ListTokens(u *User) ([]Token, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var tokens []Token
+	err = conn.Tokens().Find(bson.M{"useremail": u.Email}).Sort("creation").All(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	active := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		if !t.IsExpired() {
+			t.Token = ""
+			active = append(active, t)
+		}
+	}
+	return active, nil
+}
+
+// RevokeToken removes the token with the given id, provided that it belongs
+// to the user.
+func RevokeToken(u *User, id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return ErrTokenNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Tokens().Remove(bson.M{"_id": bson.ObjectIdHex(id), "useremail": u.Email})
+	if err == mgo.ErrNotFound {
+		return ErrTokenNotFound
+	}
+	return err
+}
+
+// RevokeSessions removes the sessions opened by the user with login, except
+// for the session identified by the given token. Named API tokens are kept.
+func RevokeSessions(u *User, except string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Tokens().RemoveAll(bson.M{
+		"useremail": u.Email,
+		"name":      bson.M{"$exists": false},
+		"token":     bson.M{"$ne": except},
+	})
+	return err
+}
+
 func DeleteToken(token string) error {
 	conn, err := db.Conn()
 	if err != nil {
@@ -129,6 +234,40 @@ func newUserToken(u *User) (*Token, error) {
 	return &t, nil
 }
 
+// CreateAPIToken creates a long-lived named token for the user, meant to be
+// used by automated clients, like continuous integration systems. Named
+// tokens don't count in the limit of simultaneous sessions, and expire after
+// the amount of days defined in the setting auth:api-token-expire-days (365
+// by default).
+func (u *User) CreateAPIToken(name string) (*Token, error) {
+	if !tokenNameRegexp.MatchString(name) {
+		return nil, ErrInvalidTokenName
+	}
+	t, err := newUserToken(u)
+	if err != nil {
+		return nil, err
+	}
+	t.Name = name
+	t.Expires = defaultAPITokenExpiration
+	if days, err := config.GetInt("auth:api-token-expire-days"); err == nil {
+		t.Expires = time.Duration(days) * 24 * time.Hour
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if n, err := conn.Tokens().Find(bson.M{"useremail": u.Email, "name": name}).Count(); err != nil {
+		return nil, err
+	} else if n > 0 {
+		return nil, ErrTokenAlreadyExists
+	}
+	if err := conn.Tokens().Insert(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
 func createPasswordToken(u *User) (*passwordToken, error) {
 	if u == nil {
 		return nil, errors.New("User is nil")
@@ -184,7 +323,8 @@ func removeOldTokens(userEmail string) error {
 	if limit, err = config.GetInt("auth:max-simultaneous-sessions"); err != nil {
 		return err
 	}
-	count, err := conn.Tokens().Find(bson.M{"useremail": userEmail}).Count()
+	query := bson.M{"useremail": userEmail, "name": bson.M{"$exists": false}}
+	count, err := conn.Tokens().Find(query).Count()
 	if err != nil {
 		return err
 	}
@@ -193,7 +333,7 @@ func removeOldTokens(userEmail string) error {
 		return nil
 	}
 	var tokens []map[string]interface{}
-	err = conn.Tokens().Find(bson.M{"useremail": userEmail}).Select(bson.M{"_id": 1}).Limit(diff).All(&tokens)
+	err = conn.Tokens().Find(query).Select(bson.M{"_id": 1}).Limit(diff).All(&tokens)
 	if err != nil {
 		return nil
 	}
@@ -92,6 +92,157 @@ func (s *S) TestGetExpiredToken(c *gocheck.C) {
 	c.Assert(err, gocheck.Equals, ErrInvalidToken)
 }
 
+func (s *S) TestGetExpiredTokenRemovesIt(c *gocheck.C) {
+	t := Token{
+		Token:     "expired-token",
+		Creation:  time.Now().Add(-48 * time.Hour),
+		Expires:   time.Hour,
+		UserEmail: s.user.Email,
+	}
+	err := s.conn.Tokens().Insert(t)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": t.Token})
+	_, err = GetToken("bearer " + t.Token)
+	c.Assert(err, gocheck.Equals, ErrInvalidToken)
+	n, err := s.conn.Tokens().Find(bson.M{"token": t.Token}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
+func (s *S) TestTokenIsExpired(c *gocheck.C) {
+	t := Token{Creation: time.Now().Add(-2 * time.Hour), Expires: time.Hour}
+	c.Assert(t.IsExpired(), gocheck.Equals, true)
+	t.Expires = 3 * time.Hour
+	c.Assert(t.IsExpired(), gocheck.Equals, false)
+}
+
+func (s *S) TestTokenUse(c *gocheck.C) {
+	t, err := s.user.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": t.Token})
+	err = t.Use("tsuru/0.8.2")
+	c.Assert(err, gocheck.IsNil)
+	var stored Token
+	err = s.conn.Tokens().Find(bson.M{"token": t.Token}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Client, gocheck.Equals, "tsuru/0.8.2")
+	c.Assert(time.Since(stored.LastUse) < time.Minute, gocheck.Equals, true)
+	c.Assert(t.Client, gocheck.Equals, "tsuru/0.8.2")
+}
+
+func (s *S) TestTokenUseIsThrottled(c *gocheck.C) {
+	t, err := s.user.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": t.Token})
+	lastUse := time.Now().Add(-10 * time.Second)
+	t.LastUse = lastUse
+	t.Client = "tsuru/0.8.2"
+	err = t.Use("tsuru/0.8.2")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(t.LastUse, gocheck.Equals, lastUse)
+	var stored Token
+	err = s.conn.Tokens().Find(bson.M{"token": t.Token}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.LastUse.IsZero(), gocheck.Equals, true)
+}
+
+func (s *S) TestListTokens(c *gocheck.C) {
+	user := User{Email: "tokens@tsuru.io"}
+	defer s.conn.Tokens().RemoveAll(bson.M{"useremail": user.Email})
+	now := time.Now()
+	tokens := []interface{}{
+		Token{Token: "session", Creation: now.Add(-time.Hour), Expires: 24 * time.Hour, UserEmail: user.Email},
+		Token{Token: "expired", Creation: now.Add(-48 * time.Hour), Expires: time.Hour, UserEmail: user.Email},
+		Token{Token: "ci", Name: "ci", Creation: now, Expires: 24 * time.Hour, UserEmail: user.Email},
+		Token{Token: "other", Creation: now, Expires: 24 * time.Hour, UserEmail: "other@tsuru.io"},
+	}
+	err := s.conn.Tokens().Insert(tokens...)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": "other"})
+	result, err := ListTokens(&user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result, gocheck.HasLen, 2)
+	c.Assert(result[0].Name, gocheck.Equals, "")
+	c.Assert(result[1].Name, gocheck.Equals, "ci")
+	for _, t := range result {
+		c.Check(t.Token, gocheck.Equals, "")
+		c.Check(t.ID.Valid(), gocheck.Equals, true)
+	}
+}
+
+func (s *S) TestRevokeToken(c *gocheck.C) {
+	t, err := s.user.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": t.Token})
+	var stored Token
+	err = s.conn.Tokens().Find(bson.M{"token": t.Token}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	err = RevokeToken(&User{Email: "other@tsuru.io"}, stored.ID.Hex())
+	c.Assert(err, gocheck.Equals, ErrTokenNotFound)
+	err = RevokeToken(s.user, stored.ID.Hex())
+	c.Assert(err, gocheck.IsNil)
+	_, err = GetToken("bearer " + t.Token)
+	c.Assert(err, gocheck.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestRevokeTokenInvalidID(c *gocheck.C) {
+	err := RevokeToken(s.user, "not-an-id")
+	c.Assert(err, gocheck.Equals, ErrTokenNotFound)
+}
+
+func (s *S) TestRevokeSessions(c *gocheck.C) {
+	u := &User{Email: "sessions@tsuru.io", Password: "123456"}
+	err := u.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	defer s.conn.Tokens().RemoveAll(bson.M{"useremail": u.Email})
+	current, err := u.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	other, err := u.CreateToken("123456")
+	c.Assert(err, gocheck.IsNil)
+	apiToken, err := u.CreateAPIToken("ci")
+	c.Assert(err, gocheck.IsNil)
+	err = RevokeSessions(u, current.Token)
+	c.Assert(err, gocheck.IsNil)
+	_, err = GetToken("bearer " + other.Token)
+	c.Assert(err, gocheck.Equals, ErrInvalidToken)
+	_, err = GetToken("bearer " + current.Token)
+	c.Assert(err, gocheck.IsNil)
+	_, err = GetToken("bearer " + apiToken.Token)
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestCreateAPIToken(c *gocheck.C) {
+	t, err := s.user.CreateAPIToken("ci")
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": t.Token})
+	c.Assert(t.Name, gocheck.Equals, "ci")
+	c.Assert(t.UserEmail, gocheck.Equals, s.user.Email)
+	c.Assert(t.Expires, gocheck.Equals, 365*24*time.Hour)
+	got, err := GetToken("bearer " + t.Token)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(got.Name, gocheck.Equals, "ci")
+	_, err = s.user.CreateAPIToken("ci")
+	c.Assert(err, gocheck.Equals, ErrTokenAlreadyExists)
+}
+
+func (s *S) TestCreateAPITokenExpiration(c *gocheck.C) {
+	config.Set("auth:api-token-expire-days", 30)
+	defer config.Unset("auth:api-token-expire-days")
+	t, err := s.user.CreateAPIToken("ci")
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Tokens().Remove(bson.M{"token": t.Token})
+	c.Assert(t.Expires, gocheck.Equals, 30*24*time.Hour)
+}
+
+func (s *S) TestCreateAPITokenInvalidName(c *gocheck.C) {
+	for _, name := range []string{"", "my token", "ci/cd"} {
+		t, err := s.user.CreateAPIToken(name)
+		c.Check(t, gocheck.IsNil)
+		c.Check(err, gocheck.Equals, ErrInvalidTokenName)
+	}
+}
+
 func (s *S) TestCreateApplicationToken(c *gocheck.C) {
 	t, err := CreateApplicationToken("tsuru-healer")
 	c.Assert(err, gocheck.IsNil)
@@ -114,7 +265,7 @@ func (s *S) TestTokenMarshalJSON(c *gocheck.C) {
 	}
 	b, err := json.Marshal(&t)
 	c.Assert(err, gocheck.IsNil)
-	want := fmt.Sprintf(`{"token":"12saii","creation":%q,"expires":%d,"email":"something@something.com","app":"myapp"}`,
+	want := fmt.Sprintf(`{"token":"12saii","creation":%q,"expires":%d,"email":"something@something.com","app":"myapp","lastuse":"0001-01-01T00:00:00Z"}`,
 		valid.Format(time.RFC3339Nano), time.Hour)
 	c.Assert(string(b), gocheck.Equals, want)
 }
@@ -273,6 +424,24 @@ func (s *S) TestRemoveOld(c *gocheck.C) {
 	c.Assert(names, gocheck.DeepEquals, expected)
 }
 
+func (s *S) TestRemoveOldIgnoresAPITokens(c *gocheck.C) {
+	config.Set("auth:max-simultaneous-sessions", 1)
+	defer config.Unset("auth:max-simultaneous-sessions")
+	user := "removeme@tsuru.io"
+	defer s.conn.Tokens().RemoveAll(bson.M{"useremail": user})
+	tokens := []interface{}{
+		Token{Token: "ci", Name: "ci", Creation: time.Now(), Expires: time.Hour, UserEmail: user},
+		Token{Token: "session", Creation: time.Now(), Expires: time.Hour, UserEmail: user},
+	}
+	err := s.conn.Tokens().Insert(tokens...)
+	c.Assert(err, gocheck.IsNil)
+	err = removeOldTokens(user)
+	c.Assert(err, gocheck.IsNil)
+	count, err := s.conn.Tokens().Find(bson.M{"useremail": user}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 2)
+}
+
 func (s *S) TestRemoveOldNothingToRemove(c *gocheck.C) {
 	config.Set("auth:max-simultaneous-sessions", 6)
 	defer config.Unset("auth:max-simultaneous-sessions")
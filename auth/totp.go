@@ -0,0 +1,399 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSecretSize    = 20
+	recoveryCodeCount = 10
+
+	// pendingLoginExpiration is the amount of time that users have for
+	// providing the two-factor authentication code after being
+	// authenticated by the authentication scheme.
+	pendingLoginExpiration = 5 * time.Minute
+
+	// maxTOTPFailures is the number of consecutive invalid codes that
+	// locks the two-factor authentication of the user for totpLockout.
+	maxTOTPFailures = 5
+	totpLockout     = 5 * time.Minute
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("Two-factor authentication is already enabled")
+	ErrTOTPNotEnabled     = errors.New("Two-factor authentication is not enabled")
+	ErrTOTPNotEnrolled    = errors.New("You must start the enrollment in two-factor authentication first")
+	ErrInvalidTOTPCode    = errors.New("Invalid two-factor authentication code")
+	ErrInvalidLogin       = errors.New("Invalid or expired login, please log in again")
+	ErrTOTPLocked         = errors.New("Too many invalid two-factor authentication codes, please try again later")
+)
+
+// timeNow returns the current time. It's a variable so tests can control the
+// time used for generating and checking codes.
+var timeNow = time.Now
+
+// TOTPRequired checks whether any of the teams of the user requires
+// two-factor authentication.
+func (u *User) TOTPRequired() (bool, error) {
+	teams, err := u.Teams()
+	if err != nil {
+		return false, err
+	}
+	for _, t := range teams {
+		if t.RequireTOTP {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// StartTOTPEnrollment generates a new secret for the user, returning the
+// secret (base32 encoded) and the provisioning URI, that authenticator apps
+// can read from a QR code. Two-factor authentication is only enabled after
+// the user confirms the enrollment with a valid code, see EnableTOTP.
+func (u *User) StartTOTPEnrollment() (string, string, error) {
+	if u.TOTPEnabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+	var key [totpSecretSize]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", "", err
+	}
+	u.TOTPSecret = base32.StdEncoding.EncodeToString(key[:])
+	u.TOTPCounter = 0
+	if err := u.Update(); err != nil {
+		return "", "", err
+	}
+	uri := fmt.Sprintf("otpauth://totp/tsuru:%s?secret=%s&issuer=tsuru",
+		url.QueryEscape(u.Email), u.TOTPSecret)
+	return u.TOTPSecret, uri, nil
+}
+
+// EnableTOTP enables two-factor authentication for the user, after checking
+// the given code against the secret generated in the enrollment. It returns
+// the recovery codes of the user, that can be used in place of codes when the
+// user loses the device.
+func (u *User) EnableTOTP(code string) ([]string, error) {
+	if u.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if !u.checkTOTPCode(code) {
+		return nil, ErrInvalidTOTPCode
+	}
+	u.TOTPEnabled = true
+	codes := u.generateRecoveryCodes()
+	if err := u.Update(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP disables two-factor authentication for the user, after
+// checking the given code (or recovery code).
+func (u *User) DisableTOTP(code string) error {
+	if !u.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if err := u.CheckTOTP(code); err != nil {
+		return err
+	}
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+	u.TOTPCounter = 0
+	u.RecoveryCodes = nil
+	return u.Update()
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user, after
+// checking the given code.
+func (u *User) RegenerateRecoveryCodes(code string) ([]string, error) {
+	if !u.TOTPEnabled {
+		return nil, ErrTOTPNotEnabled
+	}
+	if err := u.countTOTPAttempt(); err != nil {
+		return nil, err
+	}
+	ok, err := u.useTOTPCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	codes := u.generateRecoveryCodes()
+	if err := u.Update(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CheckTOTP checks the given code against the secret of the user. Recovery
+// codes are also accepted, and can be used only once. After maxTOTPFailures
+// consecutive invalid codes, no code is accepted for totpLockout.
+func (u *User) CheckTOTP(code string) error {
+	if !u.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+	if err := u.countTOTPAttempt(); err != nil {
+		return err
+	}
+	code = strings.TrimSpace(code)
+	ok, err := u.useTOTPCode(code)
+	if err != nil || ok {
+		return err
+	}
+	ok, err = u.useRecoveryCode(code)
+	if err != nil || ok {
+		return err
+	}
+	return ErrInvalidTOTPCode
+}
+
+// countTOTPAttempt counts an attempt of checking a code before the code is
+// checked, so concurrent guesses can't go over maxTOTPFailures. The attempt
+// that reaches the limit locks the two-factor authentication of the user for
+// totpLockout, and accepting a code resets the count.
+func (u *User) countTOTPAttempt() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	now := timeNow()
+	err = conn.Users().Update(
+		bson.M{"email": u.Email, "totplockeduntil": bson.M{"$lte": now}},
+		bson.M{"$unset": bson.M{"totpfailures": 1, "totplockeduntil": 1}},
+	)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	var stored User
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"totpfailures": 1}}, ReturnNew: true}
+	// $not also matches users without failures, that don't have the field.
+	query := bson.M{"email": u.Email, "totpfailures": bson.M{"$not": bson.M{"$gte": maxTOTPFailures}}}
+	_, err = conn.Users().Find(query).Apply(change, &stored)
+	if err == mgo.ErrNotFound {
+		return ErrTOTPLocked
+	} else if err != nil {
+		return err
+	}
+	u.TOTPFailures = stored.TOTPFailures
+	if u.TOTPFailures < maxTOTPFailures {
+		return nil
+	}
+	u.TOTPLockedUntil = now.Add(totpLockout)
+	return conn.Users().Update(
+		bson.M{"email": u.Email},
+		bson.M{"$set": bson.M{"totplockeduntil": u.TOTPLockedUntil}},
+	)
+}
+
+// useTOTPCode checks the code and stores its counter, resetting the count of
+// failures. The counter is only stored if it's still greater than the stored
+// one, so concurrent requests can't use the same code twice.
+func (u *User) useTOTPCode(code string) (bool, error) {
+	last := u.TOTPCounter
+	if !u.checkTOTPCode(code) {
+		return false, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	err = conn.Users().Update(
+		bson.M{"email": u.Email, "totpcounter": bson.M{"$not": bson.M{"$gte": u.TOTPCounter}}},
+		bson.M{
+			"$set":   bson.M{"totpcounter": u.TOTPCounter},
+			"$unset": bson.M{"totpfailures": 1, "totplockeduntil": 1},
+		},
+	)
+	if err == mgo.ErrNotFound {
+		u.TOTPCounter = last
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	u.resetTOTPFailures()
+	return true, nil
+}
+
+// useRecoveryCode removes the recovery code from the user, resetting the
+// count of failures. The code is only accepted if it's actually removed, so
+// concurrent requests can't use the same code twice.
+func (u *User) useRecoveryCode(code string) (bool, error) {
+	hashed := hashRecoveryCode(code)
+	index := -1
+	for i, c := range u.RecoveryCodes {
+		if c == hashed {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return false, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	err = conn.Users().Update(
+		bson.M{"email": u.Email, "recoverycodes": hashed},
+		bson.M{
+			"$pull":  bson.M{"recoverycodes": hashed},
+			"$unset": bson.M{"totpfailures": 1, "totplockeduntil": 1},
+		},
+	)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	u.RecoveryCodes = append(u.RecoveryCodes[:index], u.RecoveryCodes[index+1:]...)
+	u.resetTOTPFailures()
+	return true, nil
+}
+
+func (u *User) resetTOTPFailures() {
+	u.TOTPFailures = 0
+	u.TOTPLockedUntil = time.Time{}
+}
+
+// checkTOTPCode checks the code, accepting codes from the previous and the
+// next periods to cope with clock skew. Codes can't be reused: the counter of
+// the last accepted code is stored in the user.
+func (u *User) checkTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	key, err := base32.StdEncoding.DecodeString(u.TOTPSecret)
+	if err != nil {
+		return false
+	}
+	counter := timeNow().Unix() / totpPeriod
+	for _, c := range []int64{counter - 1, counter, counter + 1} {
+		if c <= u.TOTPCounter {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(key, c)), []byte(code)) {
+			u.TOTPCounter = c
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) generateRecoveryCodes() []string {
+	codes := make([]string, recoveryCodeCount)
+	u.RecoveryCodes = make([]string, recoveryCodeCount)
+	for i := range codes {
+		var b [5]byte
+		n, err := rand.Read(b[:])
+		for n < len(b) || err != nil {
+			n, err = rand.Read(b[:])
+		}
+		codes[i] = fmt.Sprintf("%x", b)
+		u.RecoveryCodes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes
+}
+
+func hashRecoveryCode(code string) string {
+	return fmt.Sprintf("%x", sha512.Sum512([]byte(code)))
+}
+
+// pendingLogin is a login in which the user was authenticated by the
+// authentication scheme, but still has to provide the two-factor
+// authentication code. Clients identify it using the handle, so credentials
+// that can't be used twice (like OAuth authorization codes) aren't sent
+// again.
+type pendingLogin struct {
+	Handle    string `bson:"_id"`
+	UserEmail string
+	Creation  time.Time
+}
+
+// CreatePendingLogin starts a login that waits for the two-factor
+// authentication code of the user, returning its handle.
+func CreatePendingLogin(u *User) (string, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	l := pendingLogin{
+		Handle:    token(u.Email, crypto.SHA256),
+		UserEmail: u.Email,
+		Creation:  timeNow(),
+	}
+	if err := conn.PendingLogins().Insert(l); err != nil {
+		return "", err
+	}
+	return l.Handle, nil
+}
+
+// GetPendingLogin returns the user of the pending login identified by the
+// given handle.
+func GetPendingLogin(handle string) (*User, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var l pendingLogin
+	if err := conn.PendingLogins().FindId(handle).One(&l); err != nil {
+		return nil, ErrInvalidLogin
+	}
+	if timeNow().Sub(l.Creation) > pendingLoginExpiration {
+		conn.PendingLogins().RemoveId(handle)
+		return nil, ErrInvalidLogin
+	}
+	return GetUserByEmail(l.UserEmail)
+}
+
+// DeletePendingLogin removes the pending login identified by the given
+// handle, once the login is completed.
+func DeletePendingLogin(handle string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.PendingLogins().RemoveId(handle)
+}
+
+// totpCode generates the code for the given counter, as defined in RFC 4226.
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
@@ -0,0 +1,264 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"encoding/base32"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"strings"
+	"time"
+)
+
+func (s *S) TestTOTPCode(c *gocheck.C) {
+	// Test vectors from RFC 6238, truncated to 6 digits.
+	key := []byte("12345678901234567890")
+	c.Assert(totpCode(key, 59/totpPeriod), gocheck.Equals, "287082")
+	c.Assert(totpCode(key, 1111111109/totpPeriod), gocheck.Equals, "081804")
+	c.Assert(totpCode(key, 1234567890/totpPeriod), gocheck.Equals, "005924")
+}
+
+func (s *S) createTOTPUser(c *gocheck.C) *User {
+	u := &User{Email: "totp@tsuru.io", Password: "123456"}
+	err := u.Create()
+	c.Assert(err, gocheck.IsNil)
+	return u
+}
+
+func (s *S) codeFor(c *gocheck.C, u *User, t time.Time) string {
+	key, err := base32.StdEncoding.DecodeString(u.TOTPSecret)
+	c.Assert(err, gocheck.IsNil)
+	return totpCode(key, t.Unix()/totpPeriod)
+}
+
+func (s *S) TestStartTOTPEnrollment(c *gocheck.C) {
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	secret, uri, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(secret, gocheck.HasLen, 32)
+	c.Assert(uri, gocheck.Equals, "otpauth://totp/tsuru:totp%40tsuru.io?secret="+secret+"&issuer=tsuru")
+	u, err = GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.TOTPSecret, gocheck.Equals, secret)
+	c.Assert(u.TOTPEnabled, gocheck.Equals, false)
+}
+
+func (s *S) TestEnableTOTP(c *gocheck.C) {
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	codes, err := u.EnableTOTP(s.codeFor(c, u, time.Now()))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(codes, gocheck.HasLen, recoveryCodeCount)
+	u, err = GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.TOTPEnabled, gocheck.Equals, true)
+	c.Assert(u.RecoveryCodes, gocheck.HasLen, recoveryCodeCount)
+	c.Assert(u.RecoveryCodes[0], gocheck.Equals, hashRecoveryCode(codes[0]))
+}
+
+func (s *S) TestEnableTOTPInvalidCode(c *gocheck.C) {
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	code := s.codeFor(c, u, time.Now().Add(-time.Hour))
+	_, err = u.EnableTOTP(code)
+	c.Assert(err, gocheck.Equals, ErrInvalidTOTPCode)
+	c.Assert(u.TOTPEnabled, gocheck.Equals, false)
+}
+
+func (s *S) TestEnableTOTPWithoutEnrollment(c *gocheck.C) {
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, err := u.EnableTOTP("123456")
+	c.Assert(err, gocheck.Equals, ErrTOTPNotEnrolled)
+}
+
+func (s *S) TestCheckTOTP(c *gocheck.C) {
+	now := time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	_, err = u.EnableTOTP(s.codeFor(c, u, now.Add(-totpPeriod*time.Second)))
+	c.Assert(err, gocheck.IsNil)
+	err = u.CheckTOTP(s.codeFor(c, u, now))
+	c.Assert(err, gocheck.IsNil)
+	// codes can't be reused
+	err = u.CheckTOTP(s.codeFor(c, u, now))
+	c.Assert(err, gocheck.Equals, ErrInvalidTOTPCode)
+	err = u.CheckTOTP(s.codeFor(c, u, now.Add(2*totpPeriod*time.Second)))
+	c.Assert(err, gocheck.Equals, ErrInvalidTOTPCode)
+}
+
+func (s *S) TestCheckTOTPWithRecoveryCode(c *gocheck.C) {
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	codes, err := u.EnableTOTP(s.codeFor(c, u, time.Now()))
+	c.Assert(err, gocheck.IsNil)
+	err = u.CheckTOTP(" " + strings.ToLower(codes[3]) + "\n")
+	c.Assert(err, gocheck.IsNil)
+	u, err = GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.RecoveryCodes, gocheck.HasLen, recoveryCodeCount-1)
+	err = u.CheckTOTP(codes[3])
+	c.Assert(err, gocheck.Equals, ErrInvalidTOTPCode)
+}
+
+func (s *S) TestCheckTOTPLocksAfterConsecutiveFailures(c *gocheck.C) {
+	now := time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	codes, err := u.EnableTOTP(s.codeFor(c, u, now))
+	c.Assert(err, gocheck.IsNil)
+	for i := 0; i < maxTOTPFailures-1; i++ {
+		c.Assert(u.CheckTOTP("000000"), gocheck.Equals, ErrInvalidTOTPCode)
+	}
+	// a valid code resets the count
+	c.Assert(u.CheckTOTP(codes[0]), gocheck.IsNil)
+	for i := 0; i < maxTOTPFailures; i++ {
+		c.Assert(u.CheckTOTP("000000"), gocheck.Equals, ErrInvalidTOTPCode)
+	}
+	u, err = GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.CheckTOTP(codes[1]), gocheck.Equals, ErrTOTPLocked)
+	now = now.Add(totpLockout + time.Second)
+	c.Assert(u.CheckTOTP(codes[1]), gocheck.IsNil)
+}
+
+func (s *S) TestCheckTOTPCountsConcurrentFailures(c *gocheck.C) {
+	now := time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	codes, err := u.EnableTOTP(s.codeFor(c, u, now))
+	c.Assert(err, gocheck.IsNil)
+	// each request loads the user before the others record their
+	// failures
+	users := make([]*User, maxTOTPFailures+2)
+	for i := range users {
+		users[i], err = GetUserByEmail(u.Email)
+		c.Assert(err, gocheck.IsNil)
+	}
+	for _, user := range users[:maxTOTPFailures] {
+		c.Assert(user.CheckTOTP("000000"), gocheck.Equals, ErrInvalidTOTPCode)
+	}
+	c.Assert(users[maxTOTPFailures].CheckTOTP("000000"), gocheck.Equals, ErrTOTPLocked)
+	c.Assert(users[maxTOTPFailures+1].CheckTOTP(codes[0]), gocheck.Equals, ErrTOTPLocked)
+}
+
+func (s *S) TestCheckTOTPCodesCantBeUsedConcurrently(c *gocheck.C) {
+	now := time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	codes, err := u.EnableTOTP(s.codeFor(c, u, now.Add(-totpPeriod*time.Second)))
+	c.Assert(err, gocheck.IsNil)
+	first, err := GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	second, err := GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(first.CheckTOTP(s.codeFor(c, u, now)), gocheck.IsNil)
+	c.Assert(second.CheckTOTP(s.codeFor(c, u, now)), gocheck.Equals, ErrInvalidTOTPCode)
+	c.Assert(first.CheckTOTP(codes[0]), gocheck.IsNil)
+	c.Assert(second.CheckTOTP(codes[0]), gocheck.Equals, ErrInvalidTOTPCode)
+	u, err = GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.RecoveryCodes, gocheck.HasLen, recoveryCodeCount-1)
+}
+
+func (s *S) TestCheckTOTPNotEnabled(c *gocheck.C) {
+	u := User{Email: "totp@tsuru.io"}
+	c.Assert(u.CheckTOTP("123456"), gocheck.Equals, ErrTOTPNotEnabled)
+}
+
+func (s *S) TestDisableTOTP(c *gocheck.C) {
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	codes, err := u.EnableTOTP(s.codeFor(c, u, time.Now()))
+	c.Assert(err, gocheck.IsNil)
+	err = u.DisableTOTP(codes[0])
+	c.Assert(err, gocheck.IsNil)
+	u, err = GetUserByEmail(u.Email)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.TOTPEnabled, gocheck.Equals, false)
+	c.Assert(u.TOTPSecret, gocheck.Equals, "")
+	c.Assert(u.RecoveryCodes, gocheck.HasLen, 0)
+}
+
+func (s *S) TestRegenerateRecoveryCodes(c *gocheck.C) {
+	now := time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	u := s.createTOTPUser(c)
+	defer s.conn.Users().Remove(bson.M{"email": u.Email})
+	_, _, err := u.StartTOTPEnrollment()
+	c.Assert(err, gocheck.IsNil)
+	old, err := u.EnableTOTP(s.codeFor(c, u, now.Add(-totpPeriod*time.Second)))
+	c.Assert(err, gocheck.IsNil)
+	codes, err := u.RegenerateRecoveryCodes(s.codeFor(c, u, now))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(codes, gocheck.HasLen, recoveryCodeCount)
+	c.Assert(u.CheckTOTP(old[0]), gocheck.Equals, ErrInvalidTOTPCode)
+}
+
+func (s *S) TestTOTPRequired(c *gocheck.C) {
+	required, err := s.user.TOTPRequired()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(required, gocheck.Equals, false)
+	team := Team{Name: "secureteam", Users: []string{s.user.Email}, RequireTOTP: true}
+	err = s.conn.Teams().Insert(team)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Teams().RemoveId(team.Name)
+	required, err = s.user.TOTPRequired()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(required, gocheck.Equals, true)
+}
+
+func (s *S) TestPendingLogin(c *gocheck.C) {
+	handle, err := CreatePendingLogin(s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.PendingLogins().RemoveId(handle)
+	u, err := GetPendingLogin(handle)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(u.Email, gocheck.Equals, s.user.Email)
+	err = DeletePendingLogin(handle)
+	c.Assert(err, gocheck.IsNil)
+	_, err = GetPendingLogin(handle)
+	c.Assert(err, gocheck.Equals, ErrInvalidLogin)
+}
+
+func (s *S) TestPendingLoginExpired(c *gocheck.C) {
+	handle, err := CreatePendingLogin(s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.PendingLogins().RemoveId(handle)
+	now := time.Now().Add(pendingLoginExpiration + time.Second)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	_, err = GetPendingLogin(handle)
+	c.Assert(err, gocheck.Equals, ErrInvalidLogin)
+	n, err := s.conn.PendingLogins().FindId(handle).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
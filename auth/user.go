@@ -63,6 +63,21 @@ type User struct {
 	Email    string
 	Password string
 	Keys     []Key
+
+	// TOTPSecret is the secret used for generating two-factor
+	// authentication codes, that is only checked when TOTPEnabled is true.
+	TOTPSecret  string `bson:",omitempty" json:"-"`
+	TOTPEnabled bool   `bson:",omitempty" json:"-"`
+	// TOTPCounter is the counter of the last accepted code, so codes can't
+	// be reused.
+	TOTPCounter int64 `bson:",omitempty" json:"-"`
+	// TOTPFailures is the number of consecutive invalid codes. After
+	// maxTOTPFailures, codes aren't checked until TOTPLockedUntil.
+	TOTPFailures    int       `bson:",omitempty" json:"-"`
+	TOTPLockedUntil time.Time `bson:",omitempty" json:"-"`
+	// RecoveryCodes holds the hashes of the recovery codes that weren't used
+	// yet.
+	RecoveryCodes []string `bson:",omitempty" json:"-"`
 }
 
 func GetUserByEmail(email string) (*User, error) {
@@ -124,6 +139,16 @@ func (u *User) CreateToken(password string) (*Token, error) {
 	if err := u.CheckPassword(password); err != nil {
 		return nil, err
 	}
+	return u.CreateSessionToken()
+}
+
+// CreateSessionToken creates a new session token for the user, without
+// checking its credentials. It should be used only after the user has been
+// authenticated by an authentication scheme.
+func (u *User) CreateSessionToken() (*Token, error) {
+	if u.Email == "" {
+		return nil, stderrors.New("User does not have an email")
+	}
 	conn, err := db.Conn()
 	if err != nil {
 		return nil, err
@@ -16,6 +16,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strings"
 )
 
 type userCreate struct{}
@@ -107,24 +108,57 @@ func (c *userRemove) Info() *Info {
 type login struct{}
 
 func (c *login) Run(context *Context, client *Client) error {
-	email := context.Args[0]
-	url, err := GetURL("/users/" + email + "/tokens")
-	if err != nil {
-		return err
+	if len(context.Args) == 0 {
+		scheme, err := getAuthScheme(client)
+		if err != nil {
+			return err
+		}
+		if scheme.Name == "oauth" {
+			return oauthLogin(context, client, scheme.Data)
+		}
+		return errors.New("You must provide your email to login.")
 	}
+	email := context.Args[0]
 	fmt.Fprint(context.Stdout, "Password: ")
 	password, err := passwordFromReader(context.Stdin)
 	if err != nil {
 		return err
 	}
 	fmt.Fprintln(context.Stdout)
-	b := bytes.NewBufferString(`{"password":"` + password + `"}`)
-	request, err := http.NewRequest("POST", url, b)
+	return requestToken(context, client, "/users/"+email+"/tokens", map[string]string{"password": password})
+}
+
+// requestToken sends the credentials to the tsuru server, storing the token
+// returned by the server. When the server asks for a two-factor
+// authentication code, the user is prompted for it, and the code is sent
+// along with the handle of the pending login returned by the server, instead
+// of the credentials.
+func requestToken(context *Context, client *Client, path string, params map[string]string) error {
+	url, err := GetURL(path)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
 	if err != nil {
 		return err
 	}
 	response, err := client.Do(request)
 	if err != nil {
+		if response != nil && response.Header.Get("X-Tsuru-TOTP") == "required" && params["otp"] == "" {
+			handle := response.Header.Get("X-Tsuru-Login")
+			if handle == "" {
+				return err
+			}
+			code, err := readTOTPCode(context)
+			if err != nil {
+				return err
+			}
+			return requestToken(context, client, "/auth/login", map[string]string{"login": handle, "otp": code})
+		}
 		return err
 	}
 	defer response.Body.Close()
@@ -143,10 +177,14 @@ func (c *login) Run(context *Context, client *Client) error {
 
 func (c *login) Info() *Info {
 	return &Info{
-		Name:    "login",
-		Usage:   "login <email>",
-		Desc:    "log in with your credentials.",
-		MinArgs: 1,
+		Name:  "login",
+		Usage: "login [email]",
+		Desc: `log in with your credentials.
+
+When tsuru server uses OAuth for authentication, run this command without the
+email: it will open the authorization page of the OAuth provider in your
+browser, and wait for the authorization.`,
+		MinArgs: 0,
 	}
 }
 
@@ -335,6 +373,105 @@ func (teamUserList) Info() *Info {
 	}
 }
 
+type roleList struct{}
+
+func (c *roleList) Info() *Info {
+	return &Info{
+		Name:    "role-list",
+		Usage:   "role-list",
+		Desc:    "List the roles that can be assigned to teams and their members.",
+		MinArgs: 0,
+	}
+}
+
+func (c *roleList) Run(context *Context, client *Client) error {
+	url, err := GetURL("/roles")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var roles []struct {
+		Name        string
+		Permissions []string
+	}
+	err = json.NewDecoder(resp.Body).Decode(&roles)
+	if err != nil {
+		return err
+	}
+	table := NewTable()
+	table.Headers = Row([]string{"Role", "Permissions"})
+	for _, role := range roles {
+		table.AddRow(Row([]string{role.Name, strings.Join(role.Permissions, ", ")}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+func setRole(client *Client, path, role string) error {
+	url, err := GetURL(path)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(map[string]string{"role": role})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	return err
+}
+
+type teamRoleSet struct{}
+
+func (c *teamRoleSet) Info() *Info {
+	return &Info{
+		Name:    "team-role-set",
+		Usage:   "team-role-set <teamname> <role>",
+		Desc:    "defines the role of the members of a team. See role-list for the available roles.",
+		MinArgs: 2,
+	}
+}
+
+func (c *teamRoleSet) Run(context *Context, client *Client) error {
+	teamName, role := context.Args[0], context.Args[1]
+	if err := setRole(client, fmt.Sprintf("/teams/%s/role", teamName), role); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, `Role of the "%s" team set to "%s".`+"\n", teamName, role)
+	return nil
+}
+
+type teamUserRoleSet struct{}
+
+func (c *teamUserRoleSet) Info() *Info {
+	return &Info{
+		Name:    "team-user-role-set",
+		Usage:   "team-user-role-set <teamname> <useremail> <role>",
+		Desc:    "defines the role of a member of a team, overriding the role of the team.",
+		MinArgs: 3,
+	}
+}
+
+func (c *teamUserRoleSet) Run(context *Context, client *Client) error {
+	teamName, userName, role := context.Args[0], context.Args[1], context.Args[2]
+	if err := setRole(client, fmt.Sprintf("/teams/%s/%s/role", teamName, userName), role); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, `Role of the user "%s" in the "%s" team set to "%s".`+"\n", userName, teamName, role)
+	return nil
+}
+
 type teamList struct{}
 
 func (c *teamList) Info() *Info {
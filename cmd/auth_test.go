@@ -7,6 +7,7 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	ttesting "github.com/globocom/tsuru/cmd/testing"
 	"github.com/globocom/tsuru/fs/testing"
 	"io"
@@ -63,6 +64,103 @@ func (s *S) TestLoginShouldReturnErrorIfThePasswordIsNotGiven(c *gocheck.C) {
 	c.Assert(err, gocheck.ErrorMatches, "^You must provide the password!$")
 }
 
+var originalOpenBrowser = openBrowser
+
+type authTransport struct {
+	c        *gocheck.C
+	scheme   string
+	loggedIn map[string]string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var message string
+	switch req.URL.Path {
+	case "/auth/scheme":
+		message = t.scheme
+	case "/auth/login":
+		err := json.NewDecoder(req.Body).Decode(&t.loggedIn)
+		t.c.Assert(err, gocheck.IsNil)
+		message = `{"token":"oauthtoken"}`
+	default:
+		return &http.Response{Body: nil, StatusCode: 500}, errors.New("unexpected request")
+	}
+	transport := ttesting.Transport{Message: message, Status: http.StatusOK}
+	return transport.RoundTrip(req)
+}
+
+func (s *S) TestLoginWithOAuth(c *gocheck.C) {
+	fsystem = &testing.RecordingFs{}
+	defer func() {
+		fsystem = nil
+	}()
+	var opened string
+	openBrowser = func(url string) error {
+		opened = url
+		go func() {
+			resp, err := http.Get("http://localhost:35999/?code=xpto&state=abc123")
+			c.Check(err, gocheck.IsNil)
+			resp.Body.Close()
+		}()
+		return nil
+	}
+	defer func() { openBrowser = originalOpenBrowser }()
+	trans := authTransport{
+		c:      c,
+		scheme: `{"name":"oauth","data":{"authorizeUrl":"http://auth.tsuru.io/authorize?state=abc123&redirect_uri=__redirect_url__","port":"35999","state":"abc123"}}`,
+	}
+	context := Context{[]string{}, manager.stdout, manager.stderr, nil}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(opened, gocheck.Equals, "http://auth.tsuru.io/authorize?state=abc123&redirect_uri=http%3A%2F%2Flocalhost%3A35999")
+	expected := map[string]string{"code": "xpto", "redirectUrl": "http://localhost:35999", "state": "abc123"}
+	c.Assert(trans.loggedIn, gocheck.DeepEquals, expected)
+	c.Assert(manager.stdout.(*bytes.Buffer).String(), gocheck.Matches, "(?s).*Successfully logged in!\n$")
+	token, err := readToken()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(token, gocheck.Equals, "oauthtoken")
+}
+
+func (s *S) TestLoginWithOAuthRefusesCallbackWithAnotherState(c *gocheck.C) {
+	openBrowser = func(url string) error {
+		go func() {
+			resp, err := http.Get("http://localhost:35998/?code=xpto&state=other")
+			c.Check(err, gocheck.IsNil)
+			resp.Body.Close()
+		}()
+		return nil
+	}
+	defer func() { openBrowser = originalOpenBrowser }()
+	trans := authTransport{
+		c:      c,
+		scheme: `{"name":"oauth","data":{"authorizeUrl":"http://auth.tsuru.io/authorize?state=abc123&redirect_uri=__redirect_url__","port":"35998","state":"abc123"}}`,
+	}
+	context := Context{[]string{}, manager.stdout, manager.stderr, nil}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "The OAuth provider did not authorize the login.")
+	c.Assert(trans.loggedIn, gocheck.IsNil)
+}
+
+func (s *S) TestLoginWithoutEmailInNativeScheme(c *gocheck.C) {
+	trans := authTransport{c: c, scheme: `{"name":"native","data":null}`}
+	context := Context{[]string{}, manager.stdout, manager.stderr, nil}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "You must provide your email to login.")
+}
+
+func (s *S) TestLoginInfo(c *gocheck.C) {
+	info := (&login{}).Info()
+	c.Assert(info.Usage, gocheck.Equals, "login [email]")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
 func (s *S) TestLogout(c *gocheck.C) {
 	var called bool
 	rfs := &testing.RecordingFs{}
@@ -164,6 +262,103 @@ func (s *S) TestTeamRemoveUserInfo(c *gocheck.C) {
 	c.Assert((&teamUserRemove{}).Info(), gocheck.DeepEquals, expected)
 }
 
+func (s *S) TestRoleList(c *gocheck.C) {
+	var buf bytes.Buffer
+	result := `[{"name":"viewer","permissions":["app.read"]},{"name":"deployer","permissions":["app.read","app.deploy"]}]`
+	expected := `+----------+----------------------+
+| Role     | Permissions          |
++----------+----------------------+
+| viewer   | app.read             |
+| deployer | app.read, app.deploy |
++----------+----------------------+
+`
+	context := Context{Stdout: &buf}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/roles" && req.Method == "GET"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := roleList{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestRoleListInfo(c *gocheck.C) {
+	info := (&roleList{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "role-list")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestTeamRoleSet(c *gocheck.C) {
+	var (
+		buf    bytes.Buffer
+		called bool
+	)
+	context := Context{Args: []string{"cobrateam", "deployer"}, Stdout: &buf}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			var body map[string]string
+			err := json.NewDecoder(req.Body).Decode(&body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(body, gocheck.DeepEquals, map[string]string{"role": "deployer"})
+			return req.URL.Path == "/teams/cobrateam/role" && req.Method == "PUT"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := teamRoleSet{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(buf.String(), gocheck.Equals, `Role of the "cobrateam" team set to "deployer".`+"\n")
+}
+
+func (s *S) TestTeamRoleSetInfo(c *gocheck.C) {
+	expected := &Info{
+		Name:    "team-role-set",
+		Usage:   "team-role-set <teamname> <role>",
+		Desc:    "defines the role of the members of a team. See role-list for the available roles.",
+		MinArgs: 2,
+	}
+	c.Assert((&teamRoleSet{}).Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestTeamUserRoleSet(c *gocheck.C) {
+	var (
+		buf    bytes.Buffer
+		called bool
+	)
+	context := Context{Args: []string{"cobrateam", "andorito@tsuru.io", "viewer"}, Stdout: &buf}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			var body map[string]string
+			err := json.NewDecoder(req.Body).Decode(&body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(body, gocheck.DeepEquals, map[string]string{"role": "viewer"})
+			return req.URL.Path == "/teams/cobrateam/andorito@tsuru.io/role" && req.Method == "PUT"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := teamUserRoleSet{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(buf.String(), gocheck.Equals, `Role of the user "andorito@tsuru.io" in the "cobrateam" team set to "viewer".`+"\n")
+}
+
+func (s *S) TestTeamUserRoleSetInfo(c *gocheck.C) {
+	info := (&teamUserRoleSet{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "team-user-role-set")
+	c.Assert(info.Usage, gocheck.Equals, "team-user-role-set <teamname> <useremail> <role>")
+	c.Assert(info.MinArgs, gocheck.Equals, 3)
+}
+
 func (s *S) TestTeamCreate(c *gocheck.C) {
 	expected := `Team "core" successfully created!` + "\n"
 	context := Context{[]string{"core"}, manager.stdout, manager.stderr, manager.stdin}
@@ -49,6 +49,7 @@ func (c *Client) Do(request *http.Request) (*http.Response, error) {
 	if token, err := readToken(); err == nil {
 		request.Header.Set("Authorization", "bearer "+token)
 	}
+	request.Header.Set("User-Agent", c.progname+"/"+c.currentVersion)
 	request.Close = true
 	response, err := c.HTTPClient.Do(request)
 	err = c.detectClientError(err)
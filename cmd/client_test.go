@@ -24,6 +24,18 @@ func (s *S) TestShouldSetCloseToTrue(c *gocheck.C) {
 	c.Assert(request.Close, gocheck.Equals, true)
 }
 
+func (s *S) TestShouldSetUserAgent(c *gocheck.C) {
+	request, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, gocheck.IsNil)
+	transport := ttesting.Transport{
+		Status:  http.StatusOK,
+		Message: "OK",
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, manager)
+	client.Do(request)
+	c.Assert(request.Header.Get("User-Agent"), gocheck.Equals, "glb/1.0")
+}
+
 func (s *S) TestShouldReturnBodyMessageOnError(c *gocheck.C) {
 	request, err := http.NewRequest("GET", "/", nil)
 	c.Assert(err, gocheck.IsNil)
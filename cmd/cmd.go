@@ -61,7 +61,16 @@ func BuildBaseManager(name, version, versionHeader string) *Manager {
 	m.Register(&teamUserAdd{})
 	m.Register(&teamUserRemove{})
 	m.Register(teamUserList{})
+	m.Register(&roleList{})
+	m.Register(&teamRoleSet{})
+	m.Register(&teamUserRoleSet{})
 	m.Register(&changePassword{})
+	m.Register(&tokenCreate{})
+	m.Register(&tokenList{})
+	m.Register(&tokenRemove{})
+	m.Register(&twoFactorEnable{})
+	m.Register(&twoFactorDisable{})
+	m.Register(&twoFactorRecoveryCodes{})
 	m.Register(&targetList{})
 	m.Register(&targetAdd{})
 	m.Register(&targetRemove{})
@@ -438,6 +438,53 @@ func (s *S) TestTeamRemoveUserIsRegistered(c *gocheck.C) {
 	c.Assert(removeuser, gocheck.FitsTypeOf, &teamUserRemove{})
 }
 
+func (s *S) TestRoleListIsRegistered(c *gocheck.C) {
+	manager := BuildBaseManager("tsuru", "1.0", "")
+	list, ok := manager.Commands["role-list"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(list, gocheck.FitsTypeOf, &roleList{})
+}
+
+func (s *S) TestTeamRoleSetIsRegistered(c *gocheck.C) {
+	manager := BuildBaseManager("tsuru", "1.0", "")
+	set, ok := manager.Commands["team-role-set"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(set, gocheck.FitsTypeOf, &teamRoleSet{})
+}
+
+func (s *S) TestTeamUserRoleSetIsRegistered(c *gocheck.C) {
+	manager := BuildBaseManager("tsuru", "1.0", "")
+	set, ok := manager.Commands["team-user-role-set"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(set, gocheck.FitsTypeOf, &teamUserRoleSet{})
+}
+
+func (s *S) TestTokenCommandsAreRegistered(c *gocheck.C) {
+	manager := BuildBaseManager("tsuru", "1.0", "")
+	create, ok := manager.Commands["token-create"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(create, gocheck.FitsTypeOf, &tokenCreate{})
+	list, ok := manager.Commands["token-list"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(list, gocheck.FitsTypeOf, &tokenList{})
+	remove, ok := manager.Commands["token-remove"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(remove, gocheck.FitsTypeOf, &tokenRemove{})
+}
+
+func (s *S) TestTwoFactorCommandsAreRegistered(c *gocheck.C) {
+	manager := BuildBaseManager("tsuru", "1.0", "")
+	enable, ok := manager.Commands["two-factor-enable"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(enable, gocheck.FitsTypeOf, &twoFactorEnable{})
+	disable, ok := manager.Commands["two-factor-disable"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(disable, gocheck.FitsTypeOf, &twoFactorDisable{})
+	codes, ok := manager.Commands["two-factor-recovery-codes"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(codes, gocheck.FitsTypeOf, &twoFactorRecoveryCodes{})
+}
+
 func (s *S) TestTeamUserListIsRegistered(c *gocheck.C) {
 	manager := BuildBaseManager("tsuru", "1.0", "")
 	listuser, ok := manager.Commands["team-user-list"]
@@ -35,6 +35,9 @@ The currently available commands are (grouped by subject):
 	team-list         list teams that the user is member
 	team-user-add     adds a user to a team
 	team-user-remove  removes a user from a team
+	team-role-set     defines the role of the members of a team
+	team-user-role-set defines the role of a member of a team
+	role-list         lists the available roles and their permissions
 
 	template          generates a new manifest file, so you can just fill information for your service
 	create            creates a new service from a manifest file
@@ -98,12 +101,19 @@ Authenticate within remote crane server
 
 Usage:
 
-	% crane login <email>
+	% crane login [email]
 
 Login will ask for the password and check if the user is successfully
 authenticated. If so, the token generated by the crane server will be stored in
 ${HOME}/.crane_token.
 
+When the tsuru server uses OAuth for authentication, the email must be omitted:
+login will open the authorization page of the OAuth provider in the browser,
+and wait for the authorization in a local port.
+
+When two-factor authentication is enabled, login will also ask for the code
+generated by the authenticator app (or one of the recovery codes).
+
 All crane actions require the user to be authenticated (except login and
 user-create, obviously).
 
@@ -202,6 +212,39 @@ A team can never have 0 users. If you are the last member of a team, you can't
 remove yourself from it.
 
 
+List the available roles
+
+Usage:
+
+	% crane role-list
+
+role-list lists the roles that can be assigned to teams and to their members,
+along with the permissions each role grants: viewers can only read information
+and logs of apps, deployers can also deploy and restart them, app-admins can
+change and remove apps, and team-admins can also manage the team.
+
+
+Define the role of a team
+
+Usage:
+
+	% crane team-role-set <teamname> <role>
+
+team-role-set defines the role of all members of a team in the apps of the
+team. Members of teams without a role are team-admins. Only team-admins can
+change roles or manage the members of a team.
+
+
+Define the role of a member of a team
+
+Usage:
+
+	% crane team-user-role-set <teamname> <useremail> <role>
+
+team-user-role-set defines the role of a member of a team, overriding the role
+of the team for that user.
+
+
 Create an empty manifest file
 
 Usage:
@@ -226,7 +269,7 @@ Create a new service
 
 Usage:
 
-	% crane create <manifest-file.yaml>
+	% crane create <manifest-file.yaml> [--password password] [--auth basic|hmac]
 
 Create will create a new service with information present in the manifest file.
 Here is an example of usage:
@@ -244,16 +287,26 @@ endpoint are required fields.
 When creating a new service, crane will add all user's teams as administrator
 teams of the service.
 
+The password, defined in the manifest (password field) or using the --password
+flag, is used by tsuru to authenticate in the API of the service. By default,
+tsuru sends the service id and the password using HTTP Basic authentication.
+With "--auth hmac" (or the auth field in the manifest), tsuru signs each
+request with HMAC-SHA256 instead, using the password as key. Services without
+password receive requests without credentials.
+
 
 Update a service
 
 Usage:
 
-	% crane update <manifest-file.yaml>
+	% crane update <manifest-file.yaml> [--password password] [--auth basic|hmac]
 
 Update will update a service using a manifest file. Currently, it's only
-possible to edit an endpoint, or add new endpoints. You need to be an
-administrator of the team to perform an update.
+possible to edit an endpoint, add new endpoints, or change the password of the
+service. You need to be an administrator of the team to perform an update.
+
+To rotate the password, provide the new one with the --password flag (or in
+the manifest). When no password is given, the current one is kept.
 
 
 Remove a service
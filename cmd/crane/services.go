@@ -10,29 +10,41 @@ import (
 	"fmt"
 	"github.com/globocom/tsuru/cmd"
 	"io/ioutil"
+	"launchpad.net/gnuflag"
+	"launchpad.net/goyaml"
 	"net/http"
 	"os"
 )
 
-type ServiceCreate struct{}
+type ServiceCreate struct {
+	credentials
+}
 
 func (c *ServiceCreate) Info() *cmd.Info {
-	desc := "Creates a service based on a passed manifest. The manifest format should be a yaml and follow the standard described in the documentation (should link to it here)"
+	desc := `Creates a service based on a passed manifest. The manifest format should be a yaml and follow the standard described in the documentation (should link to it here)
+
+The password used by tsuru to authenticate in the API of the service may be
+defined in the manifest or using the --password flag. The --auth flag defines
+the authentication scheme: basic (default) or hmac.`
 	return &cmd.Info{
 		Name:    "create",
-		Usage:   "create path/to/manifesto",
+		Usage:   "create path/to/manifesto [--password password] [--auth basic|hmac]",
 		Desc:    desc,
 		MinArgs: 1,
 	}
 }
 
+func (c *ServiceCreate) Flags() *gnuflag.FlagSet {
+	return c.credentials.flags("create")
+}
+
 func (c *ServiceCreate) Run(context *cmd.Context, client *cmd.Client) error {
 	manifest := context.Args[0]
 	url, err := cmd.GetURL("/services")
 	if err != nil {
 		return err
 	}
-	b, err := ioutil.ReadFile(manifest)
+	b, err := c.readManifest(manifest)
 	if err != nil {
 		return err
 	}
@@ -117,20 +129,30 @@ func (c *ServiceList) Run(ctx *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type ServiceUpdate struct{}
+type ServiceUpdate struct {
+	credentials
+}
 
 func (c *ServiceUpdate) Info() *cmd.Info {
 	return &cmd.Info{
-		Name:    "update",
-		Usage:   "update <path/to/manifesto>",
-		Desc:    "Update service data, extracting it from the given manifesto file.",
+		Name:  "update",
+		Usage: "update <path/to/manifesto> [--password password] [--auth basic|hmac]",
+		Desc: `Update service data, extracting it from the given manifesto file.
+
+Use the --password flag (or the password in the manifest) to rotate the
+password used by tsuru to authenticate in the API of the service. When no
+password is given, the current one is kept.`,
 		MinArgs: 1,
 	}
 }
 
+func (c *ServiceUpdate) Flags() *gnuflag.FlagSet {
+	return c.credentials.flags("update")
+}
+
 func (c *ServiceUpdate) Run(ctx *cmd.Context, client *cmd.Client) error {
 	manifest := ctx.Args[0]
-	b, err := ioutil.ReadFile(manifest)
+	b, err := c.readManifest(manifest)
 	if err != nil {
 		return err
 	}
@@ -152,6 +174,51 @@ func (c *ServiceUpdate) Run(ctx *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
+// credentials handles the flags that define the password and the
+// authentication scheme of the service, overriding the values in the
+// manifest.
+type credentials struct {
+	password string
+	auth     string
+	fs       *gnuflag.FlagSet
+}
+
+func (c *credentials) flags(name string) *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet(name, gnuflag.ExitOnError)
+		c.fs.StringVar(&c.password, "password", "", "Password used by tsuru to authenticate in the service API")
+		c.fs.StringVar(&c.password, "p", "", "Password used by tsuru to authenticate in the service API")
+		c.fs.StringVar(&c.auth, "auth", "", "Authentication scheme: basic or hmac")
+	}
+	return c.fs
+}
+
+// readManifest reads the manifest file, setting the password and the
+// authentication scheme given in the command line.
+func (c *credentials) readManifest(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if c.password == "" && c.auth == "" {
+		return b, nil
+	}
+	var manifest map[string]interface{}
+	if err := goyaml.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		manifest = make(map[string]interface{})
+	}
+	if c.password != "" {
+		manifest["password"] = c.password
+	}
+	if c.auth != "" {
+		manifest["auth"] = c.auth
+	}
+	return goyaml.Marshal(manifest)
+}
+
 type ServiceDocAdd struct{}
 
 func (c *ServiceDocAdd) Info() *cmd.Info {
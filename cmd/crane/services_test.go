@@ -10,20 +10,29 @@ import (
 	"github.com/globocom/tsuru/cmd/testing"
 	"io/ioutil"
 	"launchpad.net/gocheck"
+	"launchpad.net/goyaml"
 	"net/http"
 	"os"
 )
 
 func (s *S) TestServiceCreateInfo(c *gocheck.C) {
-	desc := "Creates a service based on a passed manifest. The manifest format should be a yaml and follow the standard described in the documentation (should link to it here)"
+	desc := `Creates a service based on a passed manifest. The manifest format should be a yaml and follow the standard described in the documentation (should link to it here)
+
+The password used by tsuru to authenticate in the API of the service may be
+defined in the manifest or using the --password flag. The --auth flag defines
+the authentication scheme: basic (default) or hmac.`
 	cmd := ServiceCreate{}
 	i := cmd.Info()
 	c.Assert(i.Name, gocheck.Equals, "create")
-	c.Assert(i.Usage, gocheck.Equals, "create path/to/manifesto")
+	c.Assert(i.Usage, gocheck.Equals, "create path/to/manifesto [--password password] [--auth basic|hmac]")
 	c.Assert(i.Desc, gocheck.Equals, desc)
 	c.Assert(i.MinArgs, gocheck.Equals, 1)
 }
 
+func (s *S) TestServiceCreateIsAFlaggedCommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &ServiceCreate{}
+}
+
 func (s *S) TestServiceCreateRun(c *gocheck.C) {
 	var stdout, stderr bytes.Buffer
 	args := []string{"testdata/manifest.yml"}
@@ -39,6 +48,33 @@ func (s *S) TestServiceCreateRun(c *gocheck.C) {
 	c.Assert(stdout.String(), gocheck.Equals, "success")
 }
 
+func (s *S) TestServiceCreateRunWithPassword(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"testdata/manifest.yml"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "success", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var manifest map[string]interface{}
+			defer req.Body.Close()
+			b, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			err = goyaml.Unmarshal(b, &manifest)
+			c.Assert(err, gocheck.IsNil)
+			return manifest["id"] == "mysqlapi" && manifest["password"] == "s3cr3t" && manifest["auth"] == "hmac"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := ServiceCreate{}
+	command.Flags().Parse(true, []string{"-p", "s3cr3t", "--auth", "hmac"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "success")
+}
+
 func (s *S) TestServiceRemoveRun(c *gocheck.C) {
 	var (
 		called         bool
@@ -175,14 +211,46 @@ func (s *S) TestServiceUpdateIsACommand(c *gocheck.C) {
 
 func (s *S) TestServiceUpdateInfo(c *gocheck.C) {
 	expected := &cmd.Info{
-		Name:    "update",
-		Usage:   "update <path/to/manifesto>",
-		Desc:    "Update service data, extracting it from the given manifesto file.",
+		Name:  "update",
+		Usage: "update <path/to/manifesto> [--password password] [--auth basic|hmac]",
+		Desc: `Update service data, extracting it from the given manifesto file.
+
+Use the --password flag (or the password in the manifest) to rotate the
+password used by tsuru to authenticate in the API of the service. When no
+password is given, the current one is kept.`,
 		MinArgs: 1,
 	}
 	c.Assert((&ServiceUpdate{}).Info(), gocheck.DeepEquals, expected)
 }
 
+func (s *S) TestServiceUpdateRotatesThePassword(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	trans := testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusNoContent},
+		CondFunc: func(req *http.Request) bool {
+			var manifest map[string]interface{}
+			defer req.Body.Close()
+			b, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			err = goyaml.Unmarshal(b, &manifest)
+			c.Assert(err, gocheck.IsNil)
+			_, hasAuth := manifest["auth"]
+			return req.Method == "PUT" && manifest["password"] == "n3w-s3cr3t" && !hasAuth
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	context := cmd.Context{
+		Args:   []string{"testdata/manifest.yml"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	command := ServiceUpdate{}
+	command.Flags().Parse(true, []string{"--password", "n3w-s3cr3t"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Service successfully updated.\n")
+}
+
 func (s *S) TestServiceDocAdd(c *gocheck.C) {
 	var (
 		called         bool
@@ -0,0 +1,110 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const callbackPage = `<html>
+<head><title>tsuru</title></head>
+<body><h1>%s</h1><p>You can close this window now.</p></body>
+</html>`
+
+type authScheme struct {
+	Name string
+	Data map[string]string
+}
+
+func getAuthScheme(client *Client) (*authScheme, error) {
+	url, err := GetURL("/auth/scheme")
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var scheme authScheme
+	if err := json.NewDecoder(response.Body).Decode(&scheme); err != nil {
+		return nil, err
+	}
+	return &scheme, nil
+}
+
+// openBrowser opens the given URL in the browser of the user. It's a variable
+// so tests can replace it.
+var openBrowser = func(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+// oauthLogin runs the OAuth login flow: it opens the authorization page in
+// the browser, waits for the provider to redirect the user to a local
+// listener with the authorization code, and sends the code to the tsuru
+// server in exchange for a token. Callbacks that don't carry the state of the
+// login, provided by the server, are refused.
+func oauthLogin(context *Context, client *Client, data map[string]string) error {
+	port := data["port"]
+	if port == "" {
+		return errors.New("The tsuru server did not provide the port for the OAuth callback.")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		return fmt.Errorf("Failed to listen for the OAuth callback: %s", err)
+	}
+	defer listener.Close()
+	redirectURL := "http://localhost:" + port
+	authorizeURL := strings.Replace(data["authorizeUrl"], "__redirect_url__", url.QueryEscape(redirectURL), 1)
+	state := data["state"]
+	codes := make(chan string, 1)
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := r.URL.Query().Get("code")
+		if r.URL.Query().Get("state") != state {
+			code = ""
+		}
+		if code == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, callbackPage, "Authorization failed.")
+		} else {
+			fmt.Fprintf(w, callbackPage, "Successfully authorized!")
+		}
+		select {
+		case codes <- code:
+		default:
+		}
+	}))
+	fmt.Fprintf(context.Stdout, "Opening the authorization page in your browser. If it does not open, visit:\n\n%s\n\n", authorizeURL)
+	if err := openBrowser(authorizeURL); err != nil {
+		fmt.Fprintf(context.Stderr, "Failed to open the browser: %s\n", err)
+	}
+	code := <-codes
+	if code == "" {
+		return errors.New("The OAuth provider did not authorize the login.")
+	}
+	params := map[string]string{"code": code, "redirectUrl": redirectURL, "state": state}
+	return requestToken(context, client, "/auth/login", params)
+}
@@ -37,3 +37,18 @@ func (t *ConditionalTransport) RoundTrip(req *http.Request) (*http.Response, err
 	}
 	return t.Transport.RoundTrip(req)
 }
+
+// MultiConditionalTransport handles a sequence of requests, using one
+// ConditionalTransport per request, in order.
+type MultiConditionalTransport struct {
+	ConditionalTransports []ConditionalTransport
+}
+
+func (t *MultiConditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.ConditionalTransports) == 0 {
+		return &http.Response{Body: nil, StatusCode: 500}, errors.New("no more transports")
+	}
+	ct := t.ConditionalTransports[0]
+	t.ConditionalTransports = t.ConditionalTransports[1:]
+	return ct.RoundTrip(req)
+}
@@ -58,3 +58,32 @@ func (S) TestConditionalTransport(c *gocheck.C) {
 	c.Assert(err.Error(), gocheck.Equals, "condition failed")
 	c.Assert(r.StatusCode, gocheck.Equals, http.StatusInternalServerError)
 }
+
+func (S) TestMultiConditionalTransport(c *gocheck.C) {
+	var t http.RoundTripper = &MultiConditionalTransport{
+		ConditionalTransports: []ConditionalTransport{
+			{
+				Transport: Transport{Message: "first", Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/first" },
+			},
+			{
+				Transport: Transport{Message: "second", Status: http.StatusOK},
+				CondFunc:  func(req *http.Request) bool { return req.URL.Path == "/second" },
+			},
+		},
+	}
+	req, _ := http.NewRequest("GET", "/first", nil)
+	r, err := t.RoundTrip(req)
+	c.Assert(err, gocheck.IsNil)
+	b, _ := ioutil.ReadAll(r.Body)
+	c.Assert(string(b), gocheck.Equals, "first")
+	req, _ = http.NewRequest("GET", "/second", nil)
+	r, err = t.RoundTrip(req)
+	c.Assert(err, gocheck.IsNil)
+	b, _ = ioutil.ReadAll(r.Body)
+	c.Assert(string(b), gocheck.Equals, "second")
+	req, _ = http.NewRequest("GET", "/first", nil)
+	_, err = t.RoundTrip(req)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "no more transports")
+}
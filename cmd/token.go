@@ -0,0 +1,158 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"launchpad.net/gnuflag"
+	"net/http"
+	"time"
+)
+
+type tokenCreate struct {
+	name string
+	fs   *gnuflag.FlagSet
+}
+
+func (c *tokenCreate) Info() *Info {
+	return &Info{
+		Name:  "token-create",
+		Usage: "token-create --name <name>",
+		Desc: `creates a long-lived named token, to be used by automated clients (like
+continuous integration systems) in the Authorization header.
+
+Named tokens are not affected by logout, and can be revoked using token-remove.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *tokenCreate) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("token-create", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.name, "name", "", "Name of the token")
+		c.fs.StringVar(&c.name, "n", "", "Name of the token")
+	}
+	return c.fs
+}
+
+func (c *tokenCreate) Run(context *Context, client *Client) error {
+	if c.name == "" {
+		return errors.New("Please provide the name of the token, with the --name flag.")
+	}
+	url, err := GetURL("/users/tokens")
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(map[string]string{"name": c.name})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var token map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Token %q successfully created: %s\n", c.name, token["token"])
+	return nil
+}
+
+type tokenList struct{}
+
+func (c *tokenList) Info() *Info {
+	return &Info{
+		Name:    "token-list",
+		Usage:   "token-list",
+		Desc:    "lists your active sessions and named tokens.",
+		MinArgs: 0,
+	}
+}
+
+func (c *tokenList) Run(context *Context, client *Client) error {
+	url, err := GetURL("/users/tokens")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var tokens []struct {
+		ID       string
+		Name     string
+		Creation time.Time
+		Expires  time.Duration
+		LastUse  time.Time
+		Client   string
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	if err != nil {
+		return err
+	}
+	format := "2006-01-02 15:04:05"
+	table := NewTable()
+	table.Headers = Row([]string{"ID", "Name", "Created", "Expires", "Last use", "Client"})
+	for _, t := range tokens {
+		name, lastUse, client := "(session)", "-", "-"
+		if t.Name != "" {
+			name = t.Name
+		}
+		if !t.LastUse.IsZero() {
+			lastUse = t.LastUse.In(time.Local).Format(format)
+		}
+		if t.Client != "" {
+			client = t.Client
+		}
+		expires := t.Creation.Add(t.Expires).In(time.Local).Format(format)
+		table.AddRow(Row([]string{t.ID, name, t.Creation.In(time.Local).Format(format), expires, lastUse, client}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type tokenRemove struct{}
+
+func (c *tokenRemove) Info() *Info {
+	return &Info{
+		Name:    "token-remove",
+		Usage:   "token-remove <id>",
+		Desc:    "revokes one of your sessions or named tokens. Use token-list to find the id of the token.",
+		MinArgs: 1,
+	}
+}
+
+func (c *tokenRemove) Run(context *Context, client *Client) error {
+	id := context.Args[0]
+	url, err := GetURL("/users/tokens/" + id)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Token %q successfully revoked.\n", id)
+	return nil
+}
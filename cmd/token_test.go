@@ -0,0 +1,115 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	ttesting "github.com/globocom/tsuru/cmd/testing"
+	"launchpad.net/gocheck"
+	"net/http"
+	"time"
+)
+
+func (s *S) TestTokenCreateInfo(c *gocheck.C) {
+	info := (&tokenCreate{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "token-create")
+	c.Assert(info.Usage, gocheck.Equals, "token-create --name <name>")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestTokenCreate(c *gocheck.C) {
+	var (
+		buf    bytes.Buffer
+		called bool
+	)
+	context := Context{Stdout: &buf}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: `{"token":"abc123","name":"ci"}`, Status: http.StatusCreated},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			var body map[string]string
+			err := json.NewDecoder(req.Body).Decode(&body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(body, gocheck.DeepEquals, map[string]string{"name": "ci"})
+			return req.URL.Path == "/users/tokens" && req.Method == "POST"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := tokenCreate{}
+	command.Flags().Parse(true, []string{"--name", "ci"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(buf.String(), gocheck.Equals, `Token "ci" successfully created: abc123`+"\n")
+}
+
+func (s *S) TestTokenCreateWithoutName(c *gocheck.C) {
+	command := tokenCreate{}
+	err := command.Run(&Context{Stdout: &bytes.Buffer{}}, nil)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "Please provide the name of the token, with the --name flag.")
+}
+
+func (s *S) TestTokenList(c *gocheck.C) {
+	var buf bytes.Buffer
+	creation := time.Date(2013, 11, 4, 10, 0, 0, 0, time.UTC)
+	lastUse := time.Date(2013, 11, 5, 12, 30, 0, 0, time.UTC)
+	result := fmt.Sprintf(`[{"id":"527a4b3c","creation":%q,"expires":%d,"email":"me@tsuru.io","app":"","lastuse":%q,"client":"tsuru/0.8.2"},`+
+		`{"id":"527a4b3d","name":"ci","creation":%q,"expires":%d,"email":"me@tsuru.io","app":"","lastuse":"0001-01-01T00:00:00Z"}]`,
+		creation.Format(time.RFC3339), 7*24*time.Hour, lastUse.Format(time.RFC3339), creation.Format(time.RFC3339), 24*time.Hour)
+	format := "2006-01-02 15:04:05"
+	c1 := creation.In(time.Local).Format(format)
+	e1 := creation.Add(7 * 24 * time.Hour).In(time.Local).Format(format)
+	e2 := creation.Add(24 * time.Hour).In(time.Local).Format(format)
+	l1 := lastUse.In(time.Local).Format(format)
+	expected := fmt.Sprintf(`+----------+-----------+---------------------+---------------------+---------------------+-------------+
+| ID       | Name      | Created             | Expires             | Last use            | Client      |
++----------+-----------+---------------------+---------------------+---------------------+-------------+
+| 527a4b3c | (session) | %s | %s | %s | tsuru/0.8.2 |
+| 527a4b3d | ci        | %s | %s | -                   | -           |
++----------+-----------+---------------------+---------------------+---------------------+-------------+
+`, c1, e1, l1, c1, e2)
+	context := Context{Stdout: &buf}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/users/tokens" && req.Method == "GET"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := tokenList{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(buf.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestTokenRemove(c *gocheck.C) {
+	var (
+		buf    bytes.Buffer
+		called bool
+	)
+	context := Context{Args: []string{"527a4b3c"}, Stdout: &buf}
+	trans := ttesting.ConditionalTransport{
+		Transport: ttesting.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/users/tokens/527a4b3c" && req.Method == "DELETE"
+		},
+	}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := tokenRemove{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(buf.String(), gocheck.Equals, `Token "527a4b3c" successfully revoked.`+"\n")
+}
+
+func (s *S) TestTokenRemoveInfo(c *gocheck.C) {
+	info := (&tokenRemove{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "token-remove")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
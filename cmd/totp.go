@@ -0,0 +1,151 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func readTOTPCode(context *Context) (string, error) {
+	fmt.Fprint(context.Stdout, "Two-factor authentication code: ")
+	var code string
+	fmt.Fscanln(context.Stdin, &code)
+	if code == "" {
+		return "", errors.New("You must provide the two-factor authentication code!")
+	}
+	return code, nil
+}
+
+func sendTOTPCode(client *Client, method, path, code string) (*http.Response, error) {
+	url, err := GetURL(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(map[string]string{"code": code})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(request)
+}
+
+func printRecoveryCodes(w io.Writer, response *http.Response) error {
+	defer response.Body.Close()
+	var result map[string][]string
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "Recovery codes (store them in a safe place, each code can be used only once):")
+	fmt.Fprintln(w)
+	for _, code := range result["recoveryCodes"] {
+		fmt.Fprintf(w, "  %s\n", code)
+	}
+	return nil
+}
+
+type twoFactorEnable struct{}
+
+func (c *twoFactorEnable) Info() *Info {
+	return &Info{
+		Name:  "two-factor-enable",
+		Usage: "two-factor-enable",
+		Desc: `enables two-factor authentication for your user.
+
+It displays a secret that must be added to an authenticator app (like Google
+Authenticator), and asks for a code generated by the app to confirm the
+enrollment. After that, login will ask for a code whenever you log in.
+
+The recovery codes displayed at the end may be used instead of codes generated
+by the app, in case you lose your device.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *twoFactorEnable) Run(context *Context, client *Client) error {
+	url, err := GetURL("/users/totp")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var enrollment map[string]string
+	if err := json.NewDecoder(response.Body).Decode(&enrollment); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Add the following secret to your authenticator app: %s\n", enrollment["secret"])
+	fmt.Fprintf(context.Stdout, "Or use this URI to generate a QR code: %s\n\n", enrollment["uri"])
+	code, err := readTOTPCode(context)
+	if err != nil {
+		return err
+	}
+	response, err = sendTOTPCode(client, "POST", "/users/totp/enable", code)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(context.Stdout, "\nTwo-factor authentication successfully enabled!\n\n")
+	return printRecoveryCodes(context.Stdout, response)
+}
+
+type twoFactorDisable struct{}
+
+func (c *twoFactorDisable) Info() *Info {
+	return &Info{
+		Name:    "two-factor-disable",
+		Usage:   "two-factor-disable",
+		Desc:    "disables two-factor authentication for your user. It asks for a code (or a recovery code).",
+		MinArgs: 0,
+	}
+}
+
+func (c *twoFactorDisable) Run(context *Context, client *Client) error {
+	code, err := readTOTPCode(context)
+	if err != nil {
+		return err
+	}
+	if _, err := sendTOTPCode(client, "DELETE", "/users/totp", code); err != nil {
+		return err
+	}
+	fmt.Fprintln(context.Stdout, "Two-factor authentication successfully disabled.")
+	return nil
+}
+
+type twoFactorRecoveryCodes struct{}
+
+func (c *twoFactorRecoveryCodes) Info() *Info {
+	return &Info{
+		Name:    "two-factor-recovery-codes",
+		Usage:   "two-factor-recovery-codes",
+		Desc:    "generates new recovery codes for two-factor authentication, invalidating the previous ones.",
+		MinArgs: 0,
+	}
+}
+
+func (c *twoFactorRecoveryCodes) Run(context *Context, client *Client) error {
+	code, err := readTOTPCode(context)
+	if err != nil {
+		return err
+	}
+	response, err := sendTOTPCode(client, "POST", "/users/totp/recovery-codes", code)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(context.Stdout)
+	return printRecoveryCodes(context.Stdout, response)
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	ttesting "github.com/globocom/tsuru/cmd/testing"
+	"github.com/globocom/tsuru/fs/testing"
+	"launchpad.net/gocheck"
+	"net/http"
+	"strings"
+)
+
+type totpTransport struct {
+	bodies []map[string]string
+}
+
+func (t *totpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body map[string]string
+	if req.Body != nil {
+		json.NewDecoder(req.Body).Decode(&body)
+	}
+	t.bodies = append(t.bodies, body)
+	var transport ttesting.Transport
+	switch {
+	case req.URL.Path == "/users/foo@foo.com/tokens" && body["otp"] == "":
+		transport = ttesting.Transport{
+			Message: "Two-factor authentication code required",
+			Status:  http.StatusUnauthorized,
+			Headers: map[string][]string{"X-Tsuru-Totp": {"required"}, "X-Tsuru-Login": {"handle123"}},
+		}
+	case req.URL.Path == "/auth/login" && body["login"] == "handle123":
+		transport = ttesting.Transport{Message: `{"token":"totptoken"}`, Status: http.StatusOK}
+	case req.URL.Path == "/users/totp" && req.Method == "POST":
+		transport = ttesting.Transport{Message: `{"secret":"ABCDEF","uri":"otpauth://totp/tsuru:foo%40foo.com?secret=ABCDEF&issuer=tsuru"}`, Status: http.StatusOK}
+	case req.URL.Path == "/users/totp/enable" || req.URL.Path == "/users/totp/recovery-codes":
+		transport = ttesting.Transport{Message: `{"recoveryCodes":["a1b2c3d4e5","f6a7b8c9d0"]}`, Status: http.StatusOK}
+	case req.URL.Path == "/users/totp" && req.Method == "DELETE":
+		transport = ttesting.Transport{Message: "", Status: http.StatusOK}
+	default:
+		return &http.Response{Body: nil, StatusCode: 500}, errors.New("unexpected request")
+	}
+	return transport.RoundTrip(req)
+}
+
+func (s *S) TestLoginWithTOTP(c *gocheck.C) {
+	fsystem = &testing.RecordingFs{}
+	defer func() {
+		fsystem = nil
+	}()
+	expected := "Password: \nTwo-factor authentication code: Successfully logged in!\n"
+	reader := strings.NewReader("chico\n123456\n")
+	context := Context{[]string{"foo@foo.com"}, manager.stdout, manager.stderr, reader}
+	trans := totpTransport{}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(manager.stdout.(*bytes.Buffer).String(), gocheck.Equals, expected)
+	c.Assert(trans.bodies, gocheck.DeepEquals, []map[string]string{
+		{"password": "chico"},
+		{"login": "handle123", "otp": "123456"},
+	})
+	token, err := readToken()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(token, gocheck.Equals, "totptoken")
+}
+
+func (s *S) TestLoginWithTOTPWithoutCode(c *gocheck.C) {
+	reader := strings.NewReader("chico\n\n")
+	context := Context{[]string{"foo@foo.com"}, manager.stdout, manager.stderr, reader}
+	trans := totpTransport{}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "You must provide the two-factor authentication code!")
+}
+
+func (s *S) TestTwoFactorEnableInfo(c *gocheck.C) {
+	info := (&twoFactorEnable{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "two-factor-enable")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestTwoFactorEnable(c *gocheck.C) {
+	var stdout bytes.Buffer
+	context := Context{Stdout: &stdout, Stdin: strings.NewReader("654321\n")}
+	trans := totpTransport{}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := twoFactorEnable{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(trans.bodies[1], gocheck.DeepEquals, map[string]string{"code": "654321"})
+	expected := `Add the following secret to your authenticator app: ABCDEF
+Or use this URI to generate a QR code: otpauth://totp/tsuru:foo%40foo.com?secret=ABCDEF&issuer=tsuru
+
+Two-factor authentication code: ` + `
+Two-factor authentication successfully enabled!
+
+Recovery codes (store them in a safe place, each code can be used only once):
+
+  a1b2c3d4e5
+  f6a7b8c9d0
+`
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestTwoFactorDisable(c *gocheck.C) {
+	var stdout bytes.Buffer
+	context := Context{Stdout: &stdout, Stdin: strings.NewReader("a1b2c3d4e5\n")}
+	trans := totpTransport{}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := twoFactorDisable{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(trans.bodies, gocheck.DeepEquals, []map[string]string{{"code": "a1b2c3d4e5"}})
+	c.Assert(stdout.String(), gocheck.Equals, "Two-factor authentication code: Two-factor authentication successfully disabled.\n")
+}
+
+func (s *S) TestTwoFactorDisableWithoutCode(c *gocheck.C) {
+	context := Context{Stdout: &bytes.Buffer{}, Stdin: strings.NewReader("\n")}
+	command := twoFactorDisable{}
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "You must provide the two-factor authentication code!")
+}
+
+func (s *S) TestTwoFactorRecoveryCodes(c *gocheck.C) {
+	var stdout bytes.Buffer
+	context := Context{Stdout: &stdout, Stdin: strings.NewReader("654321\n")}
+	trans := totpTransport{}
+	client := NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := twoFactorRecoveryCodes{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Matches, "(?s).*a1b2c3d4e5\n  f6a7b8c9d0\n$")
+}
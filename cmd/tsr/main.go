@@ -21,6 +21,7 @@ func buildManager() *cmd.Manager {
 	m.Register(&tsrCommand{Command: &collectorCmd{}})
 	m.Register(&tsrCommand{Command: tokenCmd{}})
 	m.Register(&tsrCommand{Command: &healerCmd{}})
+	m.Register(&tsrCommand{Command: envRotateCmd{}})
 	registerProvisionersCommands(m)
 	return m
 }
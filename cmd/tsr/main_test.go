@@ -78,3 +78,12 @@ func (s *S) TestHealerCmdIsRegistered(c *gocheck.C) {
 	c.Assert(ok, gocheck.Equals, true)
 	c.Assert(tsrHealer.Command, gocheck.FitsTypeOf, &healerCmd{})
 }
+
+func (s *S) TestEnvRotateCmdIsRegistered(c *gocheck.C) {
+	manager := buildManager()
+	rotate, ok := manager.Commands["env-rotate"]
+	c.Assert(ok, gocheck.Equals, true)
+	tsrRotate, ok := rotate.(*tsrCommand)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(tsrRotate.Command, gocheck.FitsTypeOf, envRotateCmd{})
+}
@@ -0,0 +1,42 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/service"
+)
+
+type envRotateCmd struct{}
+
+func (envRotateCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	n, err := app.RotateEnvs()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Environment variables of %d app(s) encrypted with the current key.\n", n)
+	n, err = service.RotateParams()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Parameters of %d service instance(s) encrypted with the current key.\n", n)
+	return nil
+}
+
+func (envRotateCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-rotate",
+		Usage: "env-rotate",
+		Desc: `Encrypts the environment variables of all apps and the parameters of
+all service instances with the current key.
+
+Use this command after changing the key defined in the "secret:key" setting,
+keeping the previous key in the "secret:old-keys" setting. Values stored in
+plain text are encrypted too.`,
+		MinArgs: 0,
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/cmd"
+	"launchpad.net/gocheck"
+	"net/http"
+	"os"
+)
+
+func (s *S) TestEnvRotateCmdInfo(c *gocheck.C) {
+	info := envRotateCmd{}.Info()
+	c.Assert(info.Name, gocheck.Equals, "env-rotate")
+	c.Assert(info.Usage, gocheck.Equals, "env-rotate")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestEnvRotateCmdIsACommand(c *gocheck.C) {
+	var _ cmd.Command = envRotateCmd{}
+}
+
+func (s *S) TestEnvRotateRun(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	manager := cmd.NewManager("glb", "", "", &stdout, &stderr, os.Stdin)
+	client := cmd.NewClient(&http.Client{}, nil, manager)
+	err := envRotateCmd{}.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Matches, `^Environment variables of \d+ app\(s\) encrypted with the current key.\nParameters of \d+ service instance\(s\) encrypted with the current key.\n$`)
+}
+
+func (s *S) TestEnvRotateRunWithoutKey(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	manager := cmd.NewManager("glb", "", "", &stdout, &stderr, os.Stdin)
+	client := cmd.NewClient(&http.Client{}, nil, manager)
+	err := envRotateCmd{}.Run(&context, client)
+	c.Assert(err, gocheck.NotNil)
+}
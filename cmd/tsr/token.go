@@ -13,7 +13,7 @@ import (
 type tokenCmd struct{}
 
 func (tokenCmd) Run(context *cmd.Context, client *cmd.Client) error {
-	t, err := auth.CreateApplicationToken("tsr")
+	t, err := auth.CreateApplicationToken(auth.ServerAppName)
 	if err != nil {
 		return err
 	}
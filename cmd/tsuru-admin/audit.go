@@ -0,0 +1,118 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/tsuru-base"
+	"launchpad.net/gnuflag"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type audit struct {
+	user   string
+	action string
+	app    string
+	since  string
+	until  string
+	limit  int
+	cursor string
+	fs     *gnuflag.FlagSet
+}
+
+func (c *audit) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "audit",
+		Usage: "audit [--user email] [--action action] [--app appname] [--since date] [--until date] [--limit number] [--cursor cursor]",
+		Desc: `lists the actions performed by users, the most recent first.
+
+Dates given to --since and --until may be absolute, in the RFC 3339 format
+(e.g.: 2013-10-29T14:30:00Z), or relative to the current time (e.g.: 30m, 2h).
+When there are older actions matching the filters, tsuru-admin displays a
+cursor that can be used to see them.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *audit) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("audit", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.user, "user", "", "The actions performed by the given user")
+		c.fs.StringVar(&c.user, "u", "", "The actions performed by the given user")
+		c.fs.StringVar(&c.action, "action", "", "The actions with the given name (e.g.: set-env)")
+		c.fs.StringVar(&c.app, "app", "", "The actions that affected the given app")
+		c.fs.StringVar(&c.app, "a", "", "The actions that affected the given app")
+		c.fs.StringVar(&c.since, "since", "", "The actions performed after the given date")
+		c.fs.StringVar(&c.until, "until", "", "The actions performed before the given date")
+		c.fs.IntVar(&c.limit, "limit", 0, "The maximum number of actions to display")
+		c.fs.IntVar(&c.limit, "l", 0, "The maximum number of actions to display")
+		c.fs.StringVar(&c.cursor, "cursor", "", "The cursor of the page of actions")
+	}
+	return c.fs
+}
+
+func (c *audit) Run(context *cmd.Context, client *cmd.Client) error {
+	params := url.Values{}
+	for name, value := range map[string]string{"user": c.user, "action": c.action, "app": c.app, "cursor": c.cursor} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+	for name, value := range map[string]string{"since": c.since, "until": c.until} {
+		if value != "" {
+			date, err := tsuru.ParseDate(value)
+			if err != nil {
+				return err
+			}
+			params.Set(name, date)
+		}
+	}
+	if c.limit > 0 {
+		params.Set("limit", strconv.Itoa(c.limit))
+	}
+	u, err := cmd.GetURL("/audit?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var actions []struct {
+		User   string
+		Action string
+		Extra  []interface{}
+		Date   time.Time
+	}
+	if err := json.NewDecoder(response.Body).Decode(&actions); err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Date", "User", "Action", "Details"})
+	for _, a := range actions {
+		details := make([]string, len(a.Extra))
+		for i, extra := range a.Extra {
+			details[i] = fmt.Sprint(extra)
+		}
+		date := a.Date.In(time.Local).Format("2006-01-02 15:04:05")
+		table.AddRow(cmd.Row([]string{date, a.User, a.Action, strings.Join(details, " ")}))
+	}
+	context.Stdout.Write(table.Bytes())
+	if cursor := response.Header.Get("X-Tsuru-Audit-Cursor"); cursor != "" {
+		fmt.Fprintf(context.Stderr, "There are older actions, use --cursor %s to see them.\n", cursor)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"launchpad.net/gocheck"
+	"net/http"
+	"time"
+)
+
+func (s *S) TestAuditInfo(c *gocheck.C) {
+	info := (&audit{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "audit")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestAuditRun(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	date := time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC)
+	result := `[{"user":"gopher@tsuru.io","action":"set-env","extra":["app=myapp","envs=[DATABASE_HOST]"],"date":"2013-11-20T10:00:00Z"}]`
+	expected := fmt.Sprintf(`+---------------------+-----------------+---------+--------------------------------+
+| Date                | User            | Action  | Details                        |
++---------------------+-----------------+---------+--------------------------------+
+| %s | gopher@tsuru.io | set-env | app=myapp envs=[DATABASE_HOST] |
++---------------------+-----------------+---------+--------------------------------+
+`, date.In(time.Local).Format("2006-01-02 15:04:05"))
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{
+			Message: result,
+			Status:  http.StatusOK,
+			Headers: map[string][]string{"X-Tsuru-Audit-Cursor": {"1384941600000000000-528c8a2e9b9c1a3f6a000001"}},
+		},
+		CondFunc: func(req *http.Request) bool {
+			query := req.URL.Query()
+			return req.URL.Path == "/audit" && req.Method == "GET" &&
+				query.Get("user") == "gopher@tsuru.io" && query.Get("app") == "myapp" &&
+				query.Get("since") == "2013-11-20T09:00:00Z" && query.Get("limit") == "1"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := audit{}
+	command.Flags().Parse(true, []string{"--user", "gopher@tsuru.io", "--app", "myapp", "--since", "2013-11-20T09:00:00Z", "-l", "1"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+	c.Assert(stderr.String(), gocheck.Equals, "There are older actions, use --cursor 1384941600000000000-528c8a2e9b9c1a3f6a000001 to see them.\n")
+}
+
+func (s *S) TestAuditRunInvalidDate(c *gocheck.C) {
+	command := audit{}
+	command.Flags().Parse(true, []string{"--until", "yesterday"})
+	err := command.Run(&cmd.Context{}, nil)
+	c.Assert(err, gocheck.NotNil)
+}
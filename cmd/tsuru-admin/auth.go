@@ -54,3 +54,50 @@ func (c *tokenGen) Flags() *gnuflag.FlagSet {
 	fs.BoolVar(&c.export, "e", false, "Define the token as environment variable in the app")
 	return fs
 }
+
+type teamTwoFactorRequire struct {
+	off bool
+	fs  *gnuflag.FlagSet
+}
+
+func (c *teamTwoFactorRequire) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "team-two-factor-require",
+		Usage: "team-two-factor-require <team> [--off]",
+		Desc: `requires members of the team to use two-factor authentication.
+
+Members that didn't enable two-factor authentication won't be able to use tsuru
+until they enable it. Use --off to remove the requirement.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *teamTwoFactorRequire) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("team-two-factor-require", gnuflag.ExitOnError)
+		c.fs.BoolVar(&c.off, "off", false, "Remove the requirement of two-factor authentication")
+	}
+	return c.fs
+}
+
+func (c *teamTwoFactorRequire) Run(ctx *cmd.Context, client *cmd.Client) error {
+	team := ctx.Args[0]
+	url, err := cmd.GetURL("/teams/" + team + "/totp")
+	if err != nil {
+		return err
+	}
+	body := strings.NewReader(fmt.Sprintf(`{"required":%v}`, !c.off))
+	request, err := http.NewRequest("PUT", url, body)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Do(request); err != nil {
+		return err
+	}
+	if c.off {
+		fmt.Fprintf(ctx.Stdout, "Two-factor authentication is no longer required for the team %q.\n", team)
+	} else {
+		fmt.Fprintf(ctx.Stdout, "Two-factor authentication is now required for the team %q.\n", team)
+	}
+	return nil
+}
@@ -103,3 +103,57 @@ func (s *S) TestTokenGetFlags(c *gocheck.C) {
 func (s *S) TestTokenGenIsAFlaggedCommand(c *gocheck.C) {
 	var _ cmd.FlaggedCommand = &tokenGen{}
 }
+
+func (s *S) TestTeamTwoFactorRequire(c *gocheck.C) {
+	var called bool
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"secureteam"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	manager := cmd.NewManager("glb", "0.2", "ad-ver", &stdout, &stderr, nil)
+	trans := testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			defer req.Body.Close()
+			body, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(string(body), gocheck.Equals, `{"required":true}`)
+			return req.Method == "PUT" && req.URL.Path == "/teams/secureteam/totp"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := teamTwoFactorRequire{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, `Two-factor authentication is now required for the team "secureteam".`+"\n")
+}
+
+func (s *S) TestTeamTwoFactorRequireOff(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"secureteam"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	manager := cmd.NewManager("glb", "0.2", "ad-ver", &stdout, &stderr, nil)
+	trans := testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			defer req.Body.Close()
+			body, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(string(body), gocheck.Equals, `{"required":false}`)
+			return req.Method == "PUT" && req.URL.Path == "/teams/secureteam/totp"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := teamTwoFactorRequire{}
+	command.Flags().Parse(true, []string{"--off"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, `Two-factor authentication is no longer required for the team "secureteam".`+"\n")
+}
@@ -22,7 +22,13 @@ func buildManager(name string) *cmd.Manager {
 	m.Register(&tsuru.SetCName{})
 	m.Register(&tsuru.UnsetCName{})
 	m.Register(&tokenGen{})
+	m.Register(&teamTwoFactorRequire{})
 	m.Register(&logRemove{})
+	m.Register(&logRetentionSet{})
+	m.Register(&logUsage{})
+	m.Register(&audit{})
+	m.Register(&planCreate{})
+	m.Register(planRemove{})
 	return m
 }
 
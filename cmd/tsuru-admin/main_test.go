@@ -38,6 +38,13 @@ func (s *S) TestTokenGenIsRegistered(c *gocheck.C) {
 	c.Assert(token, gocheck.FitsTypeOf, &tokenGen{})
 }
 
+func (s *S) TestTeamTwoFactorRequireIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru-admin")
+	require, ok := manager.Commands["team-two-factor-require"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(require, gocheck.FitsTypeOf, &teamTwoFactorRequire{})
+}
+
 func (s *S) TestLogRemoveIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru-admin")
 	token, ok := manager.Commands["log-remove"]
@@ -45,6 +52,41 @@ func (s *S) TestLogRemoveIsRegistered(c *gocheck.C) {
 	c.Assert(token, gocheck.FitsTypeOf, &logRemove{})
 }
 
+func (s *S) TestLogRetentionSetIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru-admin")
+	set, ok := manager.Commands["log-retention-set"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(set, gocheck.FitsTypeOf, &logRetentionSet{})
+}
+
+func (s *S) TestLogUsageIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru-admin")
+	usage, ok := manager.Commands["log-usage"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(usage, gocheck.FitsTypeOf, &logUsage{})
+}
+
+func (s *S) TestAuditIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru-admin")
+	command, ok := manager.Commands["audit"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(command, gocheck.FitsTypeOf, &audit{})
+}
+
+func (s *S) TestPlanCreateIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru-admin")
+	create, ok := manager.Commands["plan-create"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(create, gocheck.FitsTypeOf, &planCreate{})
+}
+
+func (s *S) TestPlanRemoveIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru-admin")
+	remove, ok := manager.Commands["plan-remove"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(remove, gocheck.FitsTypeOf, planRemove{})
+}
+
 func (s *S) TestCommandsFromBaseManagerAreRegistered(c *gocheck.C) {
 	baseManager := cmd.BuildBaseManager("tsuru", version, header)
 	manager := buildManager("tsuru")
@@ -0,0 +1,104 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"launchpad.net/gnuflag"
+	"net/http"
+)
+
+type planCreate struct {
+	memory     int64
+	swap       int64
+	cpushare   int
+	setDefault bool
+	fs         *gnuflag.FlagSet
+}
+
+func (c *planCreate) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "plan-create",
+		Usage: "plan-create <name> [--memory megabytes] [--swap megabytes] [--cpushare share] [--default]",
+		Desc: `creates a new plan.
+
+Memory and swap are defined in megabytes. Zero means no limit.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *planCreate) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("plan-create", gnuflag.ExitOnError)
+		c.fs.Int64Var(&c.memory, "memory", 0, "Memory limit of each unit, in megabytes")
+		c.fs.Int64Var(&c.memory, "m", 0, "Memory limit of each unit, in megabytes")
+		c.fs.Int64Var(&c.swap, "swap", 0, "Swap limit of each unit, in megabytes")
+		c.fs.Int64Var(&c.swap, "s", 0, "Swap limit of each unit, in megabytes")
+		c.fs.IntVar(&c.cpushare, "cpushare", 0, "Relative cpu share of each unit")
+		c.fs.IntVar(&c.cpushare, "c", 0, "Relative cpu share of each unit")
+		c.fs.BoolVar(&c.setDefault, "default", false, "Use the plan as the default plan for new apps")
+		c.fs.BoolVar(&c.setDefault, "d", false, "Use the plan as the default plan for new apps")
+	}
+	return c.fs
+}
+
+func (c *planCreate) Run(context *cmd.Context, client *cmd.Client) error {
+	plan := map[string]interface{}{
+		"name":     context.Args[0],
+		"memory":   c.memory << 20,
+		"swap":     c.swap << 20,
+		"cpushare": c.cpushare,
+		"default":  c.setDefault,
+	}
+	body, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL("/plans")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Plan %q successfully created!\n", context.Args[0])
+	return nil
+}
+
+type planRemove struct{}
+
+func (planRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "plan-remove",
+		Usage:   "plan-remove <name>",
+		Desc:    "removes a plan. Apps using the plan keep their limits.",
+		MinArgs: 1,
+	}
+}
+
+func (planRemove) Run(context *cmd.Context, client *cmd.Client) error {
+	url, err := cmd.GetURL("/plans/" + context.Args[0])
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Plan %q successfully removed!\n", context.Args[0])
+	return nil
+}
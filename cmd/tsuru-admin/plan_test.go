@@ -0,0 +1,78 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestPlanCreateInfo(c *gocheck.C) {
+	info := (&planCreate{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "plan-create")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestPlanCreateRun(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"small"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusCreated},
+		CondFunc: func(req *http.Request) bool {
+			var plan map[string]interface{}
+			err := json.NewDecoder(req.Body).Decode(&plan)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(plan["name"], gocheck.Equals, "small")
+			c.Assert(plan["memory"], gocheck.Equals, float64(256<<20))
+			c.Assert(plan["swap"], gocheck.Equals, float64(512<<20))
+			c.Assert(plan["cpushare"], gocheck.Equals, float64(50))
+			c.Assert(plan["default"], gocheck.Equals, true)
+			return req.URL.Path == "/plans" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := planCreate{}
+	command.Flags().Parse(true, []string{"--memory", "256", "-s", "512", "--cpushare", "50", "--default"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Plan \"small\" successfully created!\n")
+}
+
+func (s *S) TestPlanRemoveInfo(c *gocheck.C) {
+	expected := &cmd.Info{
+		Name:    "plan-remove",
+		Usage:   "plan-remove <name>",
+		Desc:    "removes a plan. Apps using the plan keep their limits.",
+		MinArgs: 1,
+	}
+	c.Assert(planRemove{}.Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestPlanRemoveRun(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"small"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/plans/small" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := planRemove{}.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Plan \"small\" successfully removed!\n")
+}
@@ -0,0 +1,158 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/tsuru-base"
+	"io/ioutil"
+	"launchpad.net/gnuflag"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type logRetention struct {
+	MaxAge     int
+	MaxEntries int
+}
+
+type logRetentionSet struct {
+	tsuru.GuessingCommand
+	maxAge     time.Duration
+	maxEntries int
+	fs         *gnuflag.FlagSet
+}
+
+func (c *logRetentionSet) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "log-retention-set",
+		Usage: "log-retention-set [--app appname] [--max-age duration] [--max-entries number]",
+		Desc: `defines the log retention policy of an app.
+
+Logs older than --max-age (e.g.: 72h) are removed, as well as the oldest logs
+when the app has more than --max-entries logs. Limits that are not defined
+fall back to the default policy, set in tsuru's configuration file.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *logRetentionSet) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.GuessingCommand.Flags()
+		c.fs.DurationVar(&c.maxAge, "max-age", 0, "Maximum age of the logs")
+		c.fs.IntVar(&c.maxEntries, "max-entries", 0, "Maximum number of logs")
+	}
+	return c.fs
+}
+
+func (c *logRetentionSet) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	if c.maxAge < 0 || c.maxEntries < 0 {
+		return errors.New("Log retention limits must not be negative.")
+	}
+	body, err := json.Marshal(logRetention{MaxAge: int(c.maxAge / time.Second), MaxEntries: c.maxEntries})
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL("/logs/retention?app=" + appName)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Log retention of the app %q successfully updated.\n", appName)
+	return nil
+}
+
+type logUsage struct {
+	tsuru.GuessingCommand
+}
+
+func (c *logUsage) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "log-usage",
+		Usage:   "log-usage [--app appname]",
+		Desc:    `displays the storage consumed by the logs of the apps, and their retention policy.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *logUsage) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	uri := "/logs/usage"
+	if err == nil {
+		uri += "?app=" + appName
+	}
+	url, err := cmd.GetURL(uri)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var usage []struct {
+		App       string
+		Entries   int
+		Size      int64
+		Oldest    time.Time
+		Retention logRetention
+	}
+	if err := json.Unmarshal(result, &usage); err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"App", "Entries", "Size", "Oldest", "Max Age", "Max Entries"})
+	for _, u := range usage {
+		oldest, maxAge, maxEntries := "-", "unlimited", "unlimited"
+		if u.Entries > 0 {
+			oldest = u.Oldest.In(time.Local).Format("2006-01-02 15:04:05")
+		}
+		if u.Retention.MaxAge > 0 {
+			maxAge = (time.Duration(u.Retention.MaxAge) * time.Second).String()
+		}
+		if u.Retention.MaxEntries > 0 {
+			maxEntries = strconv.Itoa(u.Retention.MaxEntries)
+		}
+		table.AddRow(cmd.Row([]string{u.App, strconv.Itoa(u.Entries), formatSize(u.Size), oldest, maxAge, maxEntries}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
@@ -0,0 +1,117 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"io/ioutil"
+	"launchpad.net/gocheck"
+	"net/http"
+	"time"
+)
+
+func (s *S) TestLogRetentionSetInfo(c *gocheck.C) {
+	info := (&logRetentionSet{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "log-retention-set")
+	c.Assert(info.Usage, gocheck.Equals, "log-retention-set [--app appname] [--max-age duration] [--max-entries number]")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestLogRetentionSetRun(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			body, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(string(body), gocheck.Equals, `{"MaxAge":259200,"MaxEntries":5000}`)
+			return req.URL.Path == "/logs/retention" && req.Method == "PUT" && req.URL.RawQuery == "app=app1"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := logRetentionSet{}
+	command.Flags().Parse(true, []string{"--app", "app1", "--max-age", "72h", "--max-entries", "5000"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Log retention of the app \"app1\" successfully updated.\n")
+}
+
+func (s *S) TestLogRetentionSetNegativeLimits(c *gocheck.C) {
+	context := cmd.Context{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+	command := logRetentionSet{}
+	command.Flags().Parse(true, []string{"--app", "app1", "--max-entries", "-1"})
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestLogUsageInfo(c *gocheck.C) {
+	info := (&logUsage{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "log-usage")
+	c.Assert(info.Usage, gocheck.Equals, "log-usage [--app appname]")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestLogUsageRun(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	date := time.Date(2013, 10, 29, 14, 30, 0, 0, time.UTC)
+	result := `[{"App":"app1","Entries":1200,"Size":3145728,"Oldest":"2013-10-29T14:30:00Z","Retention":{"MaxAge":86400,"MaxEntries":0}},` +
+		`{"App":"app2","Entries":0,"Size":0,"Oldest":"0001-01-01T00:00:00Z","Retention":{"MaxAge":0,"MaxEntries":0}}]`
+	expected := fmt.Sprintf(`+------+---------+--------+---------------------+-----------+-------------+
+| App  | Entries | Size   | Oldest              | Max Age   | Max Entries |
++------+---------+--------+---------------------+-----------+-------------+
+| app1 | 1200    | 3.0 MB | %s | 24h0m0s   | unlimited   |
+| app2 | 0       | 0 B    | -                   | unlimited | unlimited   |
++------+---------+--------+---------------------+-----------+-------------+
+`, date.In(time.Local).Format("2006-01-02 15:04:05"))
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/logs/usage" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := logUsage{}
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestLogUsageByAppRun(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/logs/usage" && req.Method == "GET" && req.URL.RawQuery == "app=app1"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := logUsage{}
+	command.Flags().Parse(true, []string{"--app", "app1"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+}
+
+func (s *S) TestFormatSize(c *gocheck.C) {
+	c.Assert(formatSize(512), gocheck.Equals, "512 B")
+	c.Assert(formatSize(1536), gocheck.Equals, "1.5 KB")
+	c.Assert(formatSize(3<<20), gocheck.Equals, "3.0 MB")
+	c.Assert(formatSize(5<<30), gocheck.Equals, "5.0 GB")
+}
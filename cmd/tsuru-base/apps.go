@@ -72,6 +72,7 @@ type app struct {
 	Teams      []string
 	Units      []unit
 	Ready      bool
+	Plan       plan
 }
 
 func (a *app) Addr() string {
@@ -104,6 +105,10 @@ Address: %s
 		}
 	}
 	args := []interface{}{a.Name, a.Repository, a.Platform, teams, a.Addr()}
+	if a.Plan.Name != "" {
+		format += "Plan: %s\n"
+		args = append(args, &a.Plan)
+	}
 	if units.Rows() > 0 {
 		format += "Units:\n%s"
 		args = append(args, units)
@@ -0,0 +1,109 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"launchpad.net/gnuflag"
+	"net/http"
+)
+
+type autoScaleRule struct {
+	Metric    string
+	Threshold float64
+	Units     uint
+}
+
+type autoScaleConfig struct {
+	Enabled  bool
+	MinUnits uint
+	MaxUnits uint
+	Increase autoScaleRule
+	Decrease autoScaleRule
+	Cooldown int
+}
+
+type AppAutoScaleSet struct {
+	GuessingCommand
+	min      uint
+	max      uint
+	metric   string
+	up       float64
+	down     float64
+	step     uint
+	cooldown int
+	disable  bool
+}
+
+func (c *AppAutoScaleSet) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-autoscale-set",
+		Usage: "app-autoscale-set --min <units> --max <units> --up <threshold> --down <threshold> [--metric cpu|mem|requests] [--step <units>] [--cooldown <seconds>] [--disable] [--app appname]",
+		Desc: `defines the autoscaling rules of an app.
+
+Units are added when the average of the metric reported by the units of the
+app is above the --up threshold, and removed when it is below the --down
+threshold, always keeping the number of units between --min and --max. Use
+--disable to turn autoscaling off.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppAutoScaleSet) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	config := autoScaleConfig{
+		Enabled:  !c.disable,
+		MinUnits: c.min,
+		MaxUnits: c.max,
+		Increase: autoScaleRule{Metric: c.metric, Threshold: c.up, Units: c.step},
+		Decrease: autoScaleRule{Metric: c.metric, Threshold: c.down, Units: c.step},
+		Cooldown: c.cooldown,
+	}
+	body, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/autoscale", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	if c.disable {
+		fmt.Fprintf(context.Stdout, "Autoscaling successfully disabled for the app %q.\n", appName)
+	} else {
+		fmt.Fprintf(context.Stdout, "Autoscaling rules successfully defined for the app %q.\n", appName)
+	}
+	return nil
+}
+
+func (c *AppAutoScaleSet) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.GuessingCommand.Flags()
+		c.fs.UintVar(&c.min, "min", 1, "Minimum number of units")
+		c.fs.UintVar(&c.max, "max", 1, "Maximum number of units")
+		c.fs.StringVar(&c.metric, "metric", "cpu", "Metric used in the rules: cpu, mem or requests")
+		c.fs.Float64Var(&c.up, "up", 0, "Units are added when the metric is above this threshold")
+		c.fs.Float64Var(&c.down, "down", 0, "Units are removed when the metric is below this threshold")
+		c.fs.UintVar(&c.step, "step", 1, "Number of units added or removed at once")
+		c.fs.IntVar(&c.cooldown, "cooldown", 300, "Seconds to wait between two scaling decisions")
+		c.fs.BoolVar(&c.disable, "disable", false, "Disable autoscaling")
+	}
+	return c.fs
+}
@@ -0,0 +1,77 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestAppAutoScaleSetInfo(c *gocheck.C) {
+	info := (&AppAutoScaleSet{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-autoscale-set")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestAppAutoScaleSetIsAFlaggedCommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &AppAutoScaleSet{}
+}
+
+func (s *S) TestAppAutoScaleSet(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			var config autoScaleConfig
+			err := json.NewDecoder(req.Body).Decode(&config)
+			c.Assert(err, gocheck.IsNil)
+			expected := autoScaleConfig{
+				Enabled:  true,
+				MinUnits: 2,
+				MaxUnits: 10,
+				Increase: autoScaleRule{Metric: "mem", Threshold: 75, Units: 2},
+				Decrease: autoScaleRule{Metric: "mem", Threshold: 25, Units: 2},
+				Cooldown: 300,
+			}
+			c.Assert(config, gocheck.DeepEquals, expected)
+			return req.URL.Path == "/apps/hush/autoscale" && req.Method == "PUT"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppAutoScaleSet{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, []string{"--min", "2", "--max", "10", "--metric", "mem", "--up", "75", "--down", "25", "--step", "2"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Autoscaling rules successfully defined for the app \"hush\".\n")
+}
+
+func (s *S) TestAppAutoScaleSetDisable(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var config autoScaleConfig
+			json.NewDecoder(req.Body).Decode(&config)
+			return req.URL.Path == "/apps/hush/autoscale" && !config.Enabled
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppAutoScaleSet{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, []string{"--disable"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Autoscaling successfully disabled for the app \"hush\".\n")
+}
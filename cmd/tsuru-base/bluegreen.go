@@ -0,0 +1,106 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"net/http"
+)
+
+// postToApp sends a POST request to the given path of the app guessed by the
+// command.
+func postToApp(g *GuessingCommand, client *cmd.Client, path string) (string, error) {
+	appName, err := g.Guess()
+	if err != nil {
+		return "", err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/%s", appName, path))
+	if err != nil {
+		return "", err
+	}
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	return appName, nil
+}
+
+type AppBlueGreenEnable struct {
+	GuessingCommand
+}
+
+func (c *AppBlueGreenEnable) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-blue-green-enable",
+		Usage: "app-blue-green-enable [--app appname]",
+		Desc: `enables the blue/green deploy mode for an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppBlueGreenEnable) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := postToApp(&c.GuessingCommand, client, "blue-green")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Blue/green deploy successfully enabled for the app %q.\n", appName)
+	return nil
+}
+
+type AppPromote struct {
+	GuessingCommand
+}
+
+func (c *AppPromote) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-promote",
+		Usage: "app-promote [--app appname]",
+		Desc: `puts live the version waiting for promotion in a blue/green deploy.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppPromote) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := postToApp(&c.GuessingCommand, client, "promote")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "App %q successfully promoted.\n", appName)
+	return nil
+}
+
+type AppAbortDeploy struct {
+	GuessingCommand
+}
+
+func (c *AppAbortDeploy) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-abort-deploy",
+		Usage: "app-abort-deploy [--app appname]",
+		Desc: `discards the version waiting for promotion in a blue/green deploy.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppAbortDeploy) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := postToApp(&c.GuessingCommand, client, "abort-deploy")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Pending deploy of the app %q successfully aborted.\n", appName)
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestAppBlueGreenEnableInfo(c *gocheck.C) {
+	expected := &cmd.Info{
+		Name:  "app-blue-green-enable",
+		Usage: "app-blue-green-enable [--app appname]",
+		Desc: `enables the blue/green deploy mode for an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppBlueGreenEnable{}).Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestAppBlueGreenEnable(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/hush/blue-green" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppBlueGreenEnable{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Blue/green deploy successfully enabled for the app \"hush\".\n")
+}
+
+func (s *S) TestAppPromoteInfo(c *gocheck.C) {
+	expected := &cmd.Info{
+		Name:  "app-promote",
+		Usage: "app-promote [--app appname]",
+		Desc: `puts live the version waiting for promotion in a blue/green deploy.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppPromote{}).Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestAppPromoteIsAFlaggedCommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &AppPromote{}
+}
+
+func (s *S) TestAppPromote(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/hush/promote" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppPromote{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "App \"hush\" successfully promoted.\n")
+}
+
+func (s *S) TestAppAbortDeployInfo(c *gocheck.C) {
+	expected := &cmd.Info{
+		Name:  "app-abort-deploy",
+		Usage: "app-abort-deploy [--app appname]",
+		Desc: `discards the version waiting for promotion in a blue/green deploy.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppAbortDeploy{}).Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestAppAbortDeploy(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/hush/abort-deploy" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppAbortDeploy{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Pending deploy of the app \"hush\" successfully aborted.\n")
+}
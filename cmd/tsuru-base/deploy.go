@@ -0,0 +1,134 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type deploy struct {
+	ID        string
+	Timestamp time.Time
+	Duration  time.Duration
+	Commit    string
+	User      string
+	Error     string
+	Rollback  bool
+}
+
+func (d *deploy) status() string {
+	if d.Error != "" {
+		return "failed"
+	}
+	if d.Rollback {
+		return "rollback"
+	}
+	return "success"
+}
+
+type AppDeployList struct {
+	GuessingCommand
+}
+
+func (c *AppDeployList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-deploy-list",
+		Usage: "app-deploy-list [--app appname]",
+		Desc: `lists the deploys of an app, from the most recent to the oldest one.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppDeployList) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/deploys", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		fmt.Fprintln(context.Stdout, "No deploys available.")
+		return nil
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var deploys []deploy
+	err = json.Unmarshal(result, &deploys)
+	if err != nil {
+		return err
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"ID", "Date", "Commit", "User", "Duration", "Status"})
+	for _, d := range deploys {
+		date := d.Timestamp.In(time.Local).Format("2006-01-02 15:04:05")
+		duration := (d.Duration / time.Second * time.Second).String()
+		table.AddRow(cmd.Row([]string{d.ID, date, d.Commit, d.User, duration, d.status()}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type AppRollback struct {
+	GuessingCommand
+}
+
+func (c *AppRollback) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-rollback",
+		Usage: "app-rollback <deploy-id> [--app appname]",
+		Desc: `rolls an app back to the version deployed in the given deploy.
+
+Use app-deploy-list to find the id of the deploy. If you don't provide the app
+name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppRollback) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	u, err := cmd.GetURL(fmt.Sprintf("/apps/%s/rollback", appName))
+	if err != nil {
+		return err
+	}
+	body := strings.NewReader(url.Values{"deploy": []string{context.Args[0]}}.Encode())
+	request, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	return err
+}
@@ -0,0 +1,125 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"io/ioutil"
+	"launchpad.net/gocheck"
+	"net/http"
+	"time"
+)
+
+func (s *S) TestAppDeployListInfo(c *gocheck.C) {
+	expected := &cmd.Info{
+		Name:  "app-deploy-list",
+		Usage: "app-deploy-list [--app appname]",
+		Desc: `lists the deploys of an app, from the most recent to the oldest one.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+	c.Assert((&AppDeployList{}).Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestAppDeployListIsAFlaggedCommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &AppDeployList{}
+}
+
+func (s *S) TestAppDeployList(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"ID":"52a7d32c0f6e6a5c0b000001","Timestamp":"2013-12-10T20:30:00Z","Duration":62000000000,"Commit":"a345f3e","User":"gopher@tsuru.io","Error":""},
+{"ID":"52a7d32c0f6e6a5c0b000002","Timestamp":"2013-12-10T20:30:00Z","Duration":3000000000,"Commit":"b12ce7d","User":"gopher@tsuru.io","Error":"deploy failed"}]`
+	date := time.Date(2013, 12, 10, 20, 30, 0, 0, time.UTC).In(time.Local).Format("2006-01-02 15:04:05")
+	expected := `+--------------------------+---------------------+---------+-----------------+----------+---------+
+| ID                       | Date                | Commit  | User            | Duration | Status  |
++--------------------------+---------------------+---------+-----------------+----------+---------+
+| 52a7d32c0f6e6a5c0b000001 | %s | a345f3e | gopher@tsuru.io | 1m2s     | success |
+| 52a7d32c0f6e6a5c0b000002 | %s | b12ce7d | gopher@tsuru.io | 3s       | failed  |
++--------------------------+---------------------+---------+-----------------+----------+---------+
+`
+	expected = fmt.Sprintf(expected, date, date)
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/hush/deploys" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppDeployList{}
+	command.Flags().Parse(true, []string{"--app", "hush"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestAppDeployListWithoutDeploys(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	client := cmd.NewClient(&http.Client{Transport: &testing.Transport{Message: "", Status: http.StatusNoContent}}, nil, manager)
+	fake := &FakeGuesser{name: "hush"}
+	command := AppDeployList{GuessingCommand: GuessingCommand{G: fake}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "No deploys available.\n")
+}
+
+func (s *S) TestAppRollbackInfo(c *gocheck.C) {
+	expected := &cmd.Info{
+		Name:  "app-rollback",
+		Usage: "app-rollback <deploy-id> [--app appname]",
+		Desc: `rolls an app back to the version deployed in the given deploy.
+
+Use app-deploy-list to find the id of the deploy. If you don't provide the app
+name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+	c.Assert((&AppRollback{}).Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestAppRollbackIsAFlaggedCommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &AppRollback{}
+}
+
+func (s *S) TestAppRollback(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{
+		Args:   []string{"52a7d32c0f6e6a5c0b000001"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "Deploy done!", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			b, _ := ioutil.ReadAll(req.Body)
+			return req.URL.Path == "/apps/hush/rollback" && req.Method == "POST" &&
+				string(b) == "deploy=52a7d32c0f6e6a5c0b000001" &&
+				req.Header.Get("Content-Type") == "application/x-www-form-urlencoded"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fake := &FakeGuesser{name: "hush"}
+	command := AppRollback{GuessingCommand: GuessingCommand{G: fake}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Deploy done!")
+}
@@ -5,12 +5,16 @@
 package tsuru
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/fs"
+	"io"
 	"io/ioutil"
+	"launchpad.net/gnuflag"
 	"net/http"
 	"regexp"
 	"sort"
@@ -156,3 +160,257 @@ func requestEnvURL(method string, g GuessingCommand, args []string, client *cmd.
 	}
 	return b, nil
 }
+
+type EnvImport struct {
+	GuessingCommand
+	format    string
+	noRestart bool
+	fs        *gnuflag.FlagSet
+	fsystem   fs.Fs
+}
+
+func (c *EnvImport) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-import",
+		Usage: "env-import <file> [--format dotenv|json] [--no-restart] [--app appname]",
+		Desc: `import environment variables from a file into an app.
+
+The file may be in dotenv format (one NAME=value declaration per line) or in
+JSON format (an object mapping names to values). By default, the format is
+guessed from the extension of the file: files ending in ".json" are read as
+JSON, all other files as dotenv.
+
+All variables are set at once and the app is restarted after the import,
+unless the --no-restart flag is provided.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *EnvImport) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.GuessingCommand.Flags()
+		c.fs.StringVar(&c.format, "format", "", "Format of the file: dotenv or json")
+		c.fs.StringVar(&c.format, "f", "", "Format of the file: dotenv or json")
+		c.fs.BoolVar(&c.noRestart, "no-restart", false, "Do not restart the app after the import")
+	}
+	return c.fs
+}
+
+func (c *EnvImport) filesystem() fs.Fs {
+	if c.fsystem == nil {
+		c.fsystem = fs.OsFs{}
+	}
+	return c.fsystem
+}
+
+func (c *EnvImport) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	path := context.Args[0]
+	format := c.format
+	if format == "" {
+		format = "dotenv"
+		if strings.HasSuffix(path, ".json") {
+			format = "json"
+		}
+	}
+	f, err := c.filesystem().Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var variables map[string]string
+	switch format {
+	case "json":
+		if err := json.NewDecoder(f).Decode(&variables); err != nil {
+			return fmt.Errorf("Invalid JSON file: %s", err)
+		}
+	case "dotenv":
+		if variables, err = parseDotenv(f); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unknown format %q. Valid formats are dotenv and json.", format)
+	}
+	if len(variables) == 0 {
+		return fmt.Errorf("The file %s doesn't declare any environment variable.", path)
+	}
+	body, err := json.Marshal(map[string]interface{}{"envs": variables, "restart": !c.noRestart})
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/env/import", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, err = io.Copy(context.Stdout, response.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "%d variable(s) successfully imported\n", len(variables))
+	return nil
+}
+
+type EnvExport struct {
+	GuessingCommand
+	format string
+	fs     *gnuflag.FlagSet
+}
+
+func (c *EnvExport) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "env-export",
+		Usage: "env-export [--format dotenv|json] [--app appname]",
+		Desc: `export the environment variables of an app.
+
+The variables are written to the standard output, in dotenv format (default) or
+JSON format, and may be imported in another app using env-import. Private
+variables, set by services, are not exported.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *EnvExport) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.GuessingCommand.Flags()
+		c.fs.StringVar(&c.format, "format", "dotenv", "Format of the output: dotenv or json")
+		c.fs.StringVar(&c.format, "f", "dotenv", "Format of the output: dotenv or json")
+	}
+	return c.fs
+}
+
+func (c *EnvExport) Run(context *cmd.Context, client *cmd.Client) error {
+	if c.format != "dotenv" && c.format != "json" {
+		return fmt.Errorf("Unknown format %q. Valid formats are dotenv and json.", c.format)
+	}
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/env/export", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var variables map[string]string
+	if err := json.NewDecoder(response.Body).Decode(&variables); err != nil {
+		return err
+	}
+	if c.format == "json" {
+		b, err := json.MarshalIndent(variables, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(context.Stdout, "%s\n", b)
+		return nil
+	}
+	context.Stdout.Write(formatDotenv(variables))
+	return nil
+}
+
+var dotenvLineRegexp = regexp.MustCompile(`^(?:export\s+)?([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*(.*)$`)
+
+// parseDotenv parses a file in dotenv format. Each line declares a variable in
+// the form NAME=value, and may be prefixed by "export". Values may be quoted:
+// escape sequences (\n, \" and \\) are interpreted inside double quotes, while
+// values in single quotes are used verbatim. Empty lines and lines starting
+// with # are ignored, as well as comments following unquoted values.
+func parseDotenv(r io.Reader) (map[string]string, error) {
+	variables := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := dotenvLineRegexp.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("Invalid declaration at line %d: %s", n, line)
+		}
+		value, err := parseDotenvValue(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value at line %d: %s", n, err)
+		}
+		variables[m[1]] = value
+	}
+	return variables, scanner.Err()
+}
+
+func parseDotenvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	switch raw[0] {
+	case '\'':
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated single quote")
+		}
+		return raw[1 : end+1], nil
+	case '"':
+		var value bytes.Buffer
+		for i := 1; i < len(raw); i++ {
+			switch raw[i] {
+			case '"':
+				return value.String(), nil
+			case '\\':
+				if i+1 == len(raw) {
+					return "", errors.New("unterminated double quote")
+				}
+				i++
+				switch raw[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(raw[i])
+				}
+			default:
+				value.WriteByte(raw[i])
+			}
+		}
+		return "", errors.New("unterminated double quote")
+	}
+	if i := strings.Index(raw, " #"); i > -1 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// formatDotenv formats the variables in dotenv format, sorted by name. Values
+// are always double quoted, so they can be parsed back by parseDotenv.
+func formatDotenv(variables map[string]string) []byte {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s=\"%s\"\n", name, replacer.Replace(variables[name]))
+	}
+	return buf.Bytes()
+}
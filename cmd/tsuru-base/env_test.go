@@ -9,9 +9,11 @@ import (
 	"encoding/json"
 	"github.com/globocom/tsuru/cmd"
 	"github.com/globocom/tsuru/cmd/testing"
+	fs_test "github.com/globocom/tsuru/fs/testing"
 	"io/ioutil"
 	"launchpad.net/gocheck"
 	"net/http"
+	"strings"
 )
 
 func (s *S) TestEnvGetInfo(c *gocheck.C) {
@@ -304,3 +306,162 @@ func (s *S) TestRequestEnvURL(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(b, gocheck.DeepEquals, []byte(result))
 }
+
+func (s *S) TestEnvImportInfo(c *gocheck.C) {
+	info := (&EnvImport{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "env-import")
+	c.Assert(info.Usage, gocheck.Equals, "env-import <file> [--format dotenv|json] [--no-restart] [--app appname]")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestEnvImportIsAFlaggedCommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &EnvImport{}
+}
+
+func (s *S) TestEnvImportRunDotenv(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"production.env"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: " ---> Restarting your app\n", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			want := `{"envs":{"DATABASE_HOST":"localhost","DATABASE_USER":"root"},"restart":true}`
+			defer req.Body.Close()
+			got, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			return req.URL.Path == "/apps/someapp/env/import" && req.Method == "POST" && string(got) == want
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fs := fs_test.RecordingFs{FileContent: "# database\nDATABASE_HOST=localhost\nexport DATABASE_USER=\"root\"\n"}
+	command := EnvImport{fsystem: &fs}
+	command.Flags().Parse(true, []string{"-a", "someapp"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, " ---> Restarting your app\n2 variable(s) successfully imported\n")
+	c.Assert(fs.HasAction("open production.env"), gocheck.Equals, true)
+}
+
+func (s *S) TestEnvImportRunJSONWithoutRestart(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"production.json"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			want := `{"envs":{"DATABASE_HOST":"localhost"},"restart":false}`
+			defer req.Body.Close()
+			got, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			return string(got) == want
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	fs := fs_test.RecordingFs{FileContent: `{"DATABASE_HOST":"localhost"}`}
+	command := EnvImport{fsystem: &fs}
+	command.Flags().Parse(true, []string{"-a", "someapp", "--no-restart"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "1 variable(s) successfully imported\n")
+}
+
+func (s *S) TestEnvImportRunInvalidFile(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"production.env"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fs := fs_test.RecordingFs{FileContent: "DATABASE_HOST=localhost\nthis is not valid\n"}
+	command := EnvImport{fsystem: &fs}
+	command.Flags().Parse(true, []string{"-a", "someapp"})
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "Invalid declaration at line 2: this is not valid")
+}
+
+func (s *S) TestEnvImportRunUnknownFormat(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"production.env"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	fs := fs_test.RecordingFs{FileContent: "DATABASE_HOST=localhost\n"}
+	command := EnvImport{fsystem: &fs}
+	command.Flags().Parse(true, []string{"-a", "someapp", "--format", "yaml"})
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, `Unknown format "yaml". Valid formats are dotenv and json.`)
+}
+
+func (s *S) TestEnvExportInfo(c *gocheck.C) {
+	info := (&EnvExport{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "env-export")
+	c.Assert(info.Usage, gocheck.Equals, "env-export [--format dotenv|json] [--app appname]")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestEnvExportRun(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: `{"DATABASE_USER":"root","GREETING":"say \"hi\"\n"}`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/someapp/env/export" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := EnvExport{}
+	command.Flags().Parse(true, []string{"-a", "someapp"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "DATABASE_USER=\"root\"\nGREETING=\"say \\\"hi\\\"\\n\"\n")
+}
+
+func (s *S) TestEnvExportRunJSON(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &testing.Transport{Message: `{"DATABASE_USER":"root"}`, Status: http.StatusOK}}, nil, manager)
+	command := EnvExport{}
+	command.Flags().Parse(true, []string{"-a", "someapp", "-f", "json"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "{\n  \"DATABASE_USER\": \"root\"\n}\n")
+}
+
+func (s *S) TestParseDotenv(c *gocheck.C) {
+	content := `# comment
+
+export A=1
+B = "with \"quotes\"\nand lines" # comment
+C='single \n quoted'
+D=plain value # comment
+E=
+`
+	variables, err := parseDotenv(strings.NewReader(content))
+	c.Assert(err, gocheck.IsNil)
+	expected := map[string]string{
+		"A": "1",
+		"B": "with \"quotes\"\nand lines",
+		"C": `single \n quoted`,
+		"D": "plain value",
+		"E": "",
+	}
+	c.Assert(variables, gocheck.DeepEquals, expected)
+	variables, err = parseDotenv(bytes.NewReader(formatDotenv(expected)))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(variables, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestParseDotenvUnterminatedQuote(c *gocheck.C) {
+	_, err := parseDotenv(strings.NewReader(`A="unterminated`))
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "Invalid value at line 1: unterminated double quote")
+}
@@ -11,24 +11,37 @@ import (
 	"io"
 	"launchpad.net/gnuflag"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
 type AppLog struct {
 	GuessingCommand
-	fs     *gnuflag.FlagSet
-	source string
-	lines  int
-	follow bool
+	fs      *gnuflag.FlagSet
+	source  string
+	lines   int
+	follow  bool
+	unit    string
+	since   string
+	until   string
+	message string
+	regex   string
+	cursor  string
 }
 
 func (c *AppLog) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "log",
-		Usage: "log [--app appname] [--lines/-l numberOfLines] [--source/-s source] [--follow/-f]",
+		Usage: "log [--app appname] [--lines/-l numberOfLines] [--source/-s source] [--unit/-u unitname] [--since date] [--until date] [--grep/-g text] [--regex/-r expression] [--cursor cursor] [--follow/-f]",
 		Desc: `show logs for an app.
 
-If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.`,
+If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.
+
+Dates given to --since and --until may be absolute, in the RFC 3339 format
+(e.g.: 2013-10-29T14:30:00Z), or relative to the current time (e.g.: 30m, 2h).
+When there are older logs matching the filters, tsuru displays a cursor that
+can be used to see them.`,
 		MinArgs: 0,
 	}
 }
@@ -48,6 +61,9 @@ func (w *jsonWriter) Write(b []byte) (int, error) {
 	for _, l := range logs {
 		date := l.Date.In(time.Local).Format("2006-01-02 15:04:05 -0700")
 		prefix := fmt.Sprintf("%s [%s]:", date, l.Source)
+		if l.Unit != "" {
+			prefix = fmt.Sprintf("%s [%s][%s]:", date, l.Source, l.Unit)
+		}
 		fmt.Fprintf(w.w, "%s %s\n", cmd.Colorfy(prefix, "blue", "", ""), l.Message)
 	}
 	w.b = nil
@@ -58,6 +74,19 @@ type log struct {
 	Date    time.Time
 	Message string
 	Source  string
+	Unit    string
+}
+
+// ParseDate parses a date given to a command (like log), returning it in the
+// RFC 3339 format. Durations are subtracted from the current time.
+func ParseDate(value string) (string, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d).UTC().Format(time.RFC3339), nil
+	}
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		return "", fmt.Errorf("Invalid date %q. Use the RFC 3339 format (e.g.: 2013-10-29T14:30:00Z) or a duration (e.g.: 30m).", value)
+	}
+	return value, nil
 }
 
 func (c *AppLog) Run(context *cmd.Context, client *cmd.Client) error {
@@ -65,17 +94,30 @@ func (c *AppLog) Run(context *cmd.Context, client *cmd.Client) error {
 	if err != nil {
 		return err
 	}
-	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/log?lines=%d", appName, c.lines))
-	if err != nil {
-		return err
+	params := url.Values{}
+	params.Set("lines", strconv.Itoa(c.lines))
+	for name, value := range map[string]string{"source": c.source, "unit": c.unit, "message": c.message, "regex": c.regex, "cursor": c.cursor} {
+		if value != "" {
+			params.Set(name, value)
+		}
 	}
-	if c.source != "" {
-		url = fmt.Sprintf("%s&source=%s", url, c.source)
+	for name, value := range map[string]string{"since": c.since, "until": c.until} {
+		if value != "" {
+			date, err := ParseDate(value)
+			if err != nil {
+				return err
+			}
+			params.Set(name, date)
+		}
 	}
 	if c.follow {
-		url += "&follow=1"
+		params.Set("follow", "1")
 	}
-	request, err := http.NewRequest("GET", url, nil)
+	u, err := cmd.GetURL(fmt.Sprintf("/apps/%s/log?%s", appName, params.Encode()))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
 	if err != nil {
 		return err
 	}
@@ -90,6 +132,9 @@ func (c *AppLog) Run(context *cmd.Context, client *cmd.Client) error {
 	w := jsonWriter{w: context.Stdout}
 	for n, err := io.Copy(&w, response.Body); n > 0 && err == nil; n, err = io.Copy(&w, response.Body) {
 	}
+	if cursor := response.Header.Get("X-Tsuru-Log-Cursor"); cursor != "" && !c.follow {
+		fmt.Fprintf(context.Stderr, "There are older logs, use --cursor %s to see them.\n", cursor)
+	}
 	return nil
 }
 
@@ -102,6 +147,15 @@ func (c *AppLog) Flags() *gnuflag.FlagSet {
 		c.fs.StringVar(&c.source, "s", "", "The log from the given source")
 		c.fs.BoolVar(&c.follow, "follow", false, "Follow logs")
 		c.fs.BoolVar(&c.follow, "f", false, "Follow logs")
+		c.fs.StringVar(&c.unit, "unit", "", "The log from the given unit")
+		c.fs.StringVar(&c.unit, "u", "", "The log from the given unit")
+		c.fs.StringVar(&c.since, "since", "", "The log generated after the given date")
+		c.fs.StringVar(&c.until, "until", "", "The log generated before the given date")
+		c.fs.StringVar(&c.message, "grep", "", "The log containing the given text")
+		c.fs.StringVar(&c.message, "g", "", "The log containing the given text")
+		c.fs.StringVar(&c.regex, "regex", "", "The log matching the given regular expression")
+		c.fs.StringVar(&c.regex, "r", "", "The log matching the given regular expression")
+		c.fs.StringVar(&c.cursor, "cursor", "", "The cursor of the page of logs")
 	}
 	return c.fs
 }
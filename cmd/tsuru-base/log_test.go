@@ -84,6 +84,24 @@ func (s *S) TestJSONWriter(c *gocheck.C) {
 	c.Assert(writer.String(), gocheck.Equals, expected)
 }
 
+func (s *S) TestJSONWriterWithUnit(c *gocheck.C) {
+	t := time.Now()
+	logs := []log{
+		{Date: t, Message: "GET / 200", Source: "app", Unit: "myapp/0"},
+		{Date: t, Message: "restarting", Source: "tsuru"},
+	}
+	b, err := json.Marshal(logs)
+	c.Assert(err, gocheck.IsNil)
+	var writer bytes.Buffer
+	w := jsonWriter{w: &writer}
+	_, err = w.Write(b)
+	c.Assert(err, gocheck.IsNil)
+	date := t.In(time.Local).Format("2006-01-02 15:04:05 -0700")
+	expected := cmd.Colorfy(date+" [app][myapp/0]:", "blue", "", "") + " GET / 200\n"
+	expected = expected + cmd.Colorfy(date+" [tsuru]:", "blue", "", "") + " restarting\n"
+	c.Assert(writer.String(), gocheck.Equals, expected)
+}
+
 func (s *S) TestJSONWriterInvalidJSON(c *gocheck.C) {
 	var writer bytes.Buffer
 	w := jsonWriter{w: &writer}
@@ -173,10 +191,15 @@ func (s *S) TestAppLogShouldReturnNilIfHasNoContent(c *gocheck.C) {
 func (s *S) TestAppLogInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:  "log",
-		Usage: "log [--app appname] [--lines/-l numberOfLines] [--source/-s source] [--follow/-f]",
+		Usage: "log [--app appname] [--lines/-l numberOfLines] [--source/-s source] [--unit/-u unitname] [--since date] [--until date] [--grep/-g text] [--regex/-r expression] [--cursor cursor] [--follow/-f]",
 		Desc: `show logs for an app.
 
-If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.`,
+If you don't provide the app name, tsuru will try to guess it. The default number of lines is 10.
+
+Dates given to --since and --until may be absolute, in the RFC 3339 format
+(e.g.: 2013-10-29T14:30:00Z), or relative to the current time (e.g.: 30m, 2h).
+When there are older logs matching the filters, tsuru displays a cursor that
+can be used to see them.`,
 		MinArgs: 0,
 	}
 	c.Assert((&AppLog{}).Info(), gocheck.DeepEquals, expected)
@@ -278,6 +301,87 @@ func (s *S) TestAppLogWithFollow(c *gocheck.C) {
 	c.Assert(stdout.String(), gocheck.Equals, expected)
 }
 
+func (s *S) TestAppLogWithFilters(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand: GuessingCommand{G: fake}}
+	command.Flags().Parse(true, []string{
+		"--unit", "hitthelights/0", "--since", "2013-10-29T14:00:00Z", "--until", "2013-10-29T15:00:00Z",
+		"--grep", "GET /", "--regex", "5\\d\\d$", "--cursor", "1383055200000000000-52702f5e9cf9a1e4d4000001",
+	})
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			q := req.URL.Query()
+			return req.URL.Path == "/apps/hitthelights/log" &&
+				q.Get("unit") == "hitthelights/0" &&
+				q.Get("since") == "2013-10-29T14:00:00Z" &&
+				q.Get("until") == "2013-10-29T15:00:00Z" &&
+				q.Get("message") == "GET /" &&
+				q.Get("regex") == "5\\d\\d$" &&
+				q.Get("cursor") == "1383055200000000000-52702f5e9cf9a1e4d4000001"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+}
+
+func (s *S) TestAppLogWithRelativeDate(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand: GuessingCommand{G: fake}}
+	command.Flags().Parse(true, []string{"--since", "2h"})
+	var since time.Time
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "[]", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var err error
+			since, err = time.Parse(time.RFC3339, req.URL.Query().Get("since"))
+			return err == nil
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	diff := time.Since(since) - 2*time.Hour
+	c.Assert(diff > -time.Minute && diff < time.Minute, gocheck.Equals, true)
+}
+
+func (s *S) TestAppLogWithInvalidDate(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand: GuessingCommand{G: fake}}
+	command.Flags().Parse(true, []string{"--until", "yesterday"})
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestAppLogShowsCursor(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	fake := &FakeGuesser{name: "hitthelights"}
+	command := AppLog{GuessingCommand: GuessingCommand{G: fake}}
+	command.Flags().Parse(true, nil)
+	trans := &testing.Transport{
+		Message: "[]",
+		Status:  http.StatusOK,
+		Headers: map[string][]string{"X-Tsuru-Log-Cursor": {"1383055200000000000-52702f5e9cf9a1e4d4000001"}},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stderr.String(), gocheck.Equals, "There are older logs, use --cursor 1383055200000000000-52702f5e9cf9a1e4d4000001 to see them.\n")
+}
+
 func (s *S) TestAppLogFlagSet(c *gocheck.C) {
 	command := AppLog{}
 	flagset := command.Flags()
@@ -0,0 +1,149 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+type AppLogDrainAdd struct {
+	GuessingCommand
+}
+
+func (c *AppLogDrainAdd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-log-drain-add",
+		Usage: "app-log-drain-add <url> [--app appname]",
+		Desc: `adds a log drain to an app.
+
+All logs of the app are forwarded to its drains. The supported URLs are:
+
+  syslog://host[:port]      syslog over UDP (port defaults to 514)
+  syslog+tcp://host[:port]  syslog over TCP
+  tcp://host:port           one plain text line per log
+  http[s]://host[/path]     batches of logs POSTed in JSON format
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppLogDrainAdd) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]string{"url": context.Args[0]})
+	if err != nil {
+		return err
+	}
+	u, err := cmd.GetURL(fmt.Sprintf("/apps/%s/log-drains", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Log drain successfully added to the app %q.\n", appName)
+	return nil
+}
+
+type AppLogDrainRemove struct {
+	GuessingCommand
+}
+
+func (c *AppLogDrainRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-log-drain-remove",
+		Usage: "app-log-drain-remove <url> [--app appname]",
+		Desc: `removes a log drain from an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppLogDrainRemove) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	u, err := cmd.GetURL(fmt.Sprintf("/apps/%s/log-drains?url=%s", appName, url.QueryEscape(context.Args[0])))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Log drain successfully removed from the app %q.\n", appName)
+	return nil
+}
+
+type AppLogDrainList struct {
+	GuessingCommand
+}
+
+func (c *AppLogDrainList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-log-drain-list",
+		Usage: "app-log-drain-list [--app appname]",
+		Desc: `lists the log drains of an app.
+
+If you don't provide the app name, tsuru will try to guess it.`,
+		MinArgs: 0,
+	}
+}
+
+func (c *AppLogDrainList) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	u, err := cmd.GetURL(fmt.Sprintf("/apps/%s/log-drains", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var drains []string
+	if err := json.Unmarshal(result, &drains); err != nil {
+		return err
+	}
+	if len(drains) == 0 {
+		fmt.Fprintf(context.Stdout, "The app %q has no log drains.\n", appName)
+		return nil
+	}
+	for _, d := range drains {
+		fmt.Fprintln(context.Stdout, d)
+	}
+	return nil
+}
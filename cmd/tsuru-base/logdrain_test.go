@@ -0,0 +1,111 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"io/ioutil"
+	"launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestAppLogDrainAddInfo(c *gocheck.C) {
+	info := (&AppLogDrainAdd{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-log-drain-add")
+	c.Assert(info.Usage, gocheck.Equals, "app-log-drain-add <url> [--app appname]")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestAppLogDrainAdd(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Args: []string{"syslog://logs.example.com"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			body, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(string(body), gocheck.Equals, `{"url":"syslog://logs.example.com"}`)
+			return req.URL.Path == "/apps/hush/log-drains" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppLogDrainAdd{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Log drain successfully added to the app \"hush\".\n")
+}
+
+func (s *S) TestAppLogDrainRemoveInfo(c *gocheck.C) {
+	info := (&AppLogDrainRemove{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-log-drain-remove")
+	c.Assert(info.Usage, gocheck.Equals, "app-log-drain-remove <url> [--app appname]")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestAppLogDrainRemove(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Args: []string{"tcp://logs.example.com:5000"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			return req.URL.Path == "/apps/hush/log-drains" && req.Method == "DELETE" &&
+				req.URL.Query().Get("url") == "tcp://logs.example.com:5000"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppLogDrainRemove{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Log drain successfully removed from the app \"hush\".\n")
+}
+
+func (s *S) TestAppLogDrainListInfo(c *gocheck.C) {
+	info := (&AppLogDrainList{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-log-drain-list")
+	c.Assert(info.Usage, gocheck.Equals, "app-log-drain-list [--app appname]")
+	c.Assert(info.MinArgs, gocheck.Equals, 0)
+}
+
+func (s *S) TestAppLogDrainList(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: `["syslog://logs.example.com","https://logs.example.com/drain"]`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/hush/log-drains" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppLogDrainList{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "syslog://logs.example.com\nhttps://logs.example.com/drain\n")
+}
+
+func (s *S) TestAppLogDrainListWithoutDrains(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &testing.Transport{Message: "[]", Status: http.StatusOK}}, nil, manager)
+	command := AppLogDrainList{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "The app \"hush\" has no log drains.\n")
+}
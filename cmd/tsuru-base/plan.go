@@ -0,0 +1,132 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+type plan struct {
+	Name     string `json:"name"`
+	Memory   int64  `json:"memory"`
+	Swap     int64  `json:"swap"`
+	CpuShare int    `json:"cpushare"`
+	Default  bool   `json:"default"`
+}
+
+// megabytes formats an amount of bytes in megabytes, or "unlimited" when it
+// is zero.
+func megabytes(n int64) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return strconv.FormatInt(n>>20, 10) + " MB"
+}
+
+func (p *plan) String() string {
+	cpu := "unlimited"
+	if p.CpuShare > 0 {
+		cpu = strconv.Itoa(p.CpuShare)
+	}
+	return fmt.Sprintf("%s (memory: %s, swap: %s, cpu share: %s)", p.Name, megabytes(p.Memory), megabytes(p.Swap), cpu)
+}
+
+type PlanList struct{}
+
+func (PlanList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "plan-list",
+		Usage:   "plan-list",
+		Desc:    "list available plans that can be used when creating an app.",
+		MinArgs: 0,
+	}
+}
+
+func (PlanList) Run(context *cmd.Context, client *cmd.Client) error {
+	url, err := cmd.GetURL("/plans")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var plans []plan
+	if err := json.Unmarshal(result, &plans); err != nil {
+		return err
+	}
+	if len(plans) == 0 {
+		fmt.Fprintln(context.Stdout, "No plans available.")
+		return nil
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Name", "Memory", "Swap", "Cpu Share", "Default"})
+	for _, p := range plans {
+		cpu := "unlimited"
+		if p.CpuShare > 0 {
+			cpu = strconv.Itoa(p.CpuShare)
+		}
+		table.AddRow(cmd.Row([]string{p.Name, megabytes(p.Memory), megabytes(p.Swap), cpu, strconv.FormatBool(p.Default)}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
+
+type AppPlanChange struct {
+	GuessingCommand
+}
+
+func (c *AppPlanChange) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "app-plan-change",
+		Usage: "app-plan-change <planname> [--app appname]",
+		Desc: `changes the plan of an app.
+
+The new limits are applied to the units created from now on, including the
+ones created in the next deploy. If you don't provide the app name, tsuru will
+try to guess it.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *AppPlanChange) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(plan{Name: context.Args[0]})
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/plan", appName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Plan of the app %q successfully changed to %q.\n", appName, context.Args[0])
+	return nil
+}
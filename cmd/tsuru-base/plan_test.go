@@ -0,0 +1,108 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"io/ioutil"
+	"launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestPlanListInfo(c *gocheck.C) {
+	expected := &cmd.Info{
+		Name:    "plan-list",
+		Usage:   "plan-list",
+		Desc:    "list available plans that can be used when creating an app.",
+		MinArgs: 0,
+	}
+	c.Assert(PlanList{}.Info(), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestPlanList(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"name":"large","memory":1073741824,"swap":0,"cpushare":0,"default":false},{"name":"small","memory":268435456,"swap":536870912,"cpushare":50,"default":true}]`
+	expected := `+-------+---------+-----------+-----------+---------+
+| Name  | Memory  | Swap      | Cpu Share | Default |
++-------+---------+-----------+-----------+---------+
+| large | 1024 MB | unlimited | unlimited | false   |
+| small | 256 MB  | 512 MB    | 50        | true    |
++-------+---------+-----------+-----------+---------+
+`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/plans" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := PlanList{}.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestPlanListEmpty(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &testing.Transport{Message: "[]", Status: http.StatusOK}}, nil, manager)
+	err := PlanList{}.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "No plans available.\n")
+}
+
+func (s *S) TestAppPlanChangeInfo(c *gocheck.C) {
+	info := (&AppPlanChange{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "app-plan-change")
+	c.Assert(info.Usage, gocheck.Equals, "app-plan-change <planname> [--app appname]")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestAppPlanChange(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Args: []string{"large"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			body, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(string(body), gocheck.Equals, `{"name":"large","memory":0,"swap":0,"cpushare":0,"default":false}`)
+			return req.URL.Path == "/apps/hush/plan" && req.Method == "PUT"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := AppPlanChange{GuessingCommand: GuessingCommand{G: &FakeGuesser{name: "hush"}}}
+	command.Flags().Parse(true, nil)
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	c.Assert(stdout.String(), gocheck.Equals, "Plan of the app \"hush\" successfully changed to \"large\".\n")
+}
+
+func (s *S) TestAppInfoWithPlan(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `{"name":"app1","ip":"myapp.tsuru.io","platform":"php","repository":"git@git.com:php.git","units":[],"teams":["tsuruteam"],"plan":{"name":"small","memory":268435456,"swap":0,"cpushare":50}}`
+	expected := `Application: app1
+Repository: git@git.com:php.git
+Platform: php
+Teams: tsuruteam
+Address: myapp.tsuru.io
+Plan: small (memory: 256 MB, swap: unlimited, cpu share: 50)
+
+`
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	client := cmd.NewClient(&http.Client{Transport: &testing.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	command := AppInfo{}
+	command.Flags().Parse(true, []string{"--app", "app1"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/globocom/tsuru/cmd"
 	"io"
 	"io/ioutil"
+	"launchpad.net/gnuflag"
 	"net/http"
 	"sort"
 	"strings"
@@ -56,15 +57,55 @@ func (s ServiceList) Run(ctx *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-type ServiceAdd struct{}
+// serviceParams is a flag that can be repeated, each time with a parameter of
+// a service instance in the format name=value.
+type serviceParams map[string]string
 
-func (sa ServiceAdd) Info() *cmd.Info {
-	usage := `service-add <servicename> <serviceinstancename>
+func (p *serviceParams) String() string {
+	pairs := make([]string, 0, len(*p))
+	for name, value := range *p {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func (p *serviceParams) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid parameter %q, it must be in the format name=value", value)
+	}
+	if *p == nil {
+		*p = serviceParams{}
+	}
+	(*p)[parts[0]] = parts[1]
+	return nil
+}
+
+type ServiceAdd struct {
+	plan   string
+	params serviceParams
+	fs     *gnuflag.FlagSet
+}
+
+func (sa *ServiceAdd) Info() *cmd.Info {
+	usage := `service-add <servicename> <serviceinstancename> [--plan planname] [--param name=value]...
 e.g.:
 
     $ tsuru service-add mongodb tsuru_mongodb
 
-Will add a new instance of the "mongodb" service, named "tsuru_mongodb".`
+Will add a new instance of the "mongodb" service, named "tsuru_mongodb".
+
+    $ tsuru service-add mysql tsuru_mysql --plan large
+
+Will add a new instance of the "mysql" service, named "tsuru_mysql", using the
+"large" plan. Use service-info to list the plans offered by a service.
+
+    $ tsuru service-add mysql tsuru_mysql --param version=5.6 --param charset=utf8
+
+Will add a new instance of the "mysql" service, sending the parameters
+"version" and "charset" to the service. The parameters accepted by each
+service are described in its documentation (see service-doc).`
 	return &cmd.Info{
 		Name:    "service-add",
 		Usage:   usage,
@@ -74,24 +115,118 @@ Will add a new instance of the "mongodb" service, named "tsuru_mongodb".`
 	}
 }
 
-func (sa ServiceAdd) Run(ctx *cmd.Context, client *cmd.Client) error {
+func (sa *ServiceAdd) Flags() *gnuflag.FlagSet {
+	if sa.fs == nil {
+		sa.fs = gnuflag.NewFlagSet("service-add", gnuflag.ExitOnError)
+		sa.fs.StringVar(&sa.plan, "plan", "", "The plan of the service instance")
+		sa.fs.StringVar(&sa.plan, "p", "", "The plan of the service instance")
+		sa.fs.Var(&sa.params, "param", "A parameter of the service instance, in the format name=value")
+	}
+	return sa.fs
+}
+
+func (sa *ServiceAdd) Run(ctx *cmd.Context, client *cmd.Client) error {
 	srvName, instName := ctx.Args[0], ctx.Args[1]
-	fmtBody := fmt.Sprintf(`{"name": "%s", "service_name": "%s"}`, instName, srvName)
-	b := bytes.NewBufferString(fmtBody)
+	params := map[string]interface{}{"name": instName, "service_name": srvName}
+	if sa.plan != "" {
+		params["plan"] = sa.plan
+	}
+	if len(sa.params) > 0 {
+		params["params"] = sa.params
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
 	url, err := cmd.GetURL("/services/instances")
 	if err != nil {
 		return err
 	}
-	request, err := http.NewRequest("POST", url, b)
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 	request.Header.Set("Content-Type", "application/json")
-	_, err = client.Do(request)
+	resp, err := client.Do(request)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	fmt.Fprint(ctx.Stdout, "Service successfully added.\n")
+	if resp.StatusCode == http.StatusAccepted {
+		fmt.Fprint(ctx.Stdout, "The instance is being provisioned. Use service-status to check whether it's ready.\n")
+	}
+	return nil
+}
+
+type ServiceUpdate struct {
+	plan   string
+	params serviceParams
+	fs     *gnuflag.FlagSet
+}
+
+func (su *ServiceUpdate) Info() *cmd.Info {
+	usage := `service-update <serviceinstancename> [--plan planname] [--param name=value]...
+e.g.:
+
+    $ tsuru service-update tsuru_mysql --plan large --param version=5.6
+
+Will change the plan of the "tsuru_mysql" instance to "large", and its
+"version" parameter to "5.6". Other parameters are kept, use an empty value
+(--param name=) to remove a parameter.`
+	return &cmd.Info{
+		Name:    "service-update",
+		Usage:   usage,
+		Desc:    "Changes the plan or the parameters of a service instance.",
+		MinArgs: 1,
+		MaxArgs: 1,
+	}
+}
+
+func (su *ServiceUpdate) Flags() *gnuflag.FlagSet {
+	if su.fs == nil {
+		su.fs = gnuflag.NewFlagSet("service-update", gnuflag.ExitOnError)
+		su.fs.StringVar(&su.plan, "plan", "", "The new plan of the service instance")
+		su.fs.StringVar(&su.plan, "p", "", "The new plan of the service instance")
+		su.fs.Var(&su.params, "param", "A parameter of the service instance, in the format name=value")
+	}
+	return su.fs
+}
+
+func (su *ServiceUpdate) Run(ctx *cmd.Context, client *cmd.Client) error {
+	instName := ctx.Args[0]
+	if su.plan == "" && len(su.params) == 0 {
+		return errors.New("You must provide the new plan or at least one parameter.")
+	}
+	params := map[string]interface{}{}
+	if su.plan != "" {
+		params["plan"] = su.plan
+	}
+	if len(su.params) > 0 {
+		params["params"] = su.params
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL("/services/instances/" + instName)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	fmt.Fprint(ctx.Stdout, "Service instance successfully updated.\n")
+	if resp.StatusCode == http.StatusAccepted {
+		fmt.Fprint(ctx.Stdout, "The changes are being applied. Use service-status to check whether the instance is ready.\n")
+	}
 	return nil
 }
 
@@ -118,6 +253,14 @@ func (sb *ServiceBind) Run(ctx *cmd.Context, client *cmd.Client) error {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted {
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(ctx.Stdout, "%s\n", b)
+		return nil
+	}
 	var variables []string
 	dec := json.NewDecoder(resp.Body)
 	msg := fmt.Sprintf("Instance %q is now bound to the app %q.", instanceName, appName)
@@ -259,9 +402,11 @@ e.g.:
 }
 
 type ServiceInstanceModel struct {
-	Name string
-	Apps []string
-	Info map[string]string
+	Name     string
+	PlanName string
+	Params   map[string]string
+	Apps     []string
+	Info     map[string]string
 }
 
 // in returns true if the list contains the value
@@ -315,19 +460,69 @@ func (c ServiceInfo) Run(ctx *cmd.Context, client *cmd.Client) error {
 	if len(instances) > 0 {
 		table := cmd.NewTable()
 		extraHeaders := c.ExtraHeaders(instances)
+		withParams := false
+		for _, instance := range instances {
+			if len(instance.Params) > 0 {
+				withParams = true
+				break
+			}
+		}
 		for _, instance := range instances {
 			apps := strings.Join(instance.Apps, ", ")
-			data := []string{instance.Name, apps}
+			data := []string{instance.Name, instance.PlanName}
+			if withParams {
+				params := serviceParams(instance.Params)
+				data = append(data, params.String())
+			}
+			data = append(data, apps)
 			for _, h := range extraHeaders {
 				data = append(data, instance.Info[h])
 			}
 			table.AddRow(cmd.Row(data))
 		}
-		headers := []string{"Instances", "Apps"}
+		headers := []string{"Instances", "Plan"}
+		if withParams {
+			headers = append(headers, "Params")
+		}
+		headers = append(headers, "Apps")
 		headers = append(headers, extraHeaders...)
 		table.Headers = cmd.Row(headers)
 		ctx.Stdout.Write(table.Bytes())
 	}
+	return c.showPlans(serviceName, ctx, client)
+}
+
+func (ServiceInfo) showPlans(serviceName string, ctx *cmd.Context, client *cmd.Client) error {
+	url, err := cmd.GetURL("/services/" + serviceName + "/plans")
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var plans []struct {
+		Name        string
+		Description string
+	}
+	err = json.NewDecoder(resp.Body).Decode(&plans)
+	if err != nil {
+		return err
+	}
+	if len(plans) > 0 {
+		fmt.Fprintf(ctx.Stdout, "\nPlans\n")
+		table := cmd.NewTable()
+		table.Headers = cmd.Row([]string{"Name", "Description"})
+		for _, p := range plans {
+			table.AddRow(cmd.Row([]string{p.Name, p.Description}))
+		}
+		ctx.Stdout.Write(table.Bytes())
+	}
 	return nil
 }
 
@@ -398,3 +593,65 @@ func (c ServiceRemove) Run(ctx *cmd.Context, client *cmd.Client) error {
 	ctx.Stdout.Write(result)
 	return nil
 }
+
+type ServiceInstanceGrant struct{}
+
+func (c ServiceInstanceGrant) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "service-instance-grant",
+		Usage: "service-instance-grant <serviceinstancename> <teamname>",
+		Desc: `shares a service instance with a team, so apps of the team can be bound to it.
+
+Only members of the teams that own the instance can share it.`,
+		MinArgs: 2,
+	}
+}
+
+func (c ServiceInstanceGrant) Run(ctx *cmd.Context, client *cmd.Client) error {
+	instanceName, teamName := ctx.Args[0], ctx.Args[1]
+	url, err := cmd.GetURL(fmt.Sprintf("/services/instances/%s/teams/%s", instanceName, teamName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.Stdout, "Service instance %q is now shared with the team %q.\n", instanceName, teamName)
+	return nil
+}
+
+type ServiceInstanceRevoke struct{}
+
+func (c ServiceInstanceRevoke) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "service-instance-revoke",
+		Usage: "service-instance-revoke <serviceinstancename> <teamname>",
+		Desc: `stops sharing a service instance with a team.
+
+Apps of the team that are already bound to the instance are not unbound.`,
+		MinArgs: 2,
+	}
+}
+
+func (c ServiceInstanceRevoke) Run(ctx *cmd.Context, client *cmd.Client) error {
+	instanceName, teamName := ctx.Args[0], ctx.Args[1]
+	url, err := cmd.GetURL(fmt.Sprintf("/services/instances/%s/teams/%s", instanceName, teamName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.Stdout, "Service instance %q is no longer shared with the team %q.\n", instanceName, teamName)
+	return nil
+}
@@ -113,6 +113,28 @@ For more details, please check the documentation for the service, using service-
 	c.Assert(stdout.String(), gocheck.Equals, expected)
 }
 
+func (s *S) TestServiceBindPendingInstance(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{
+		Args:   []string{"my-mysql"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	msg := "The service instance is still being provisioned. The app will be bound to it when it's ready."
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: msg, Status: http.StatusAccepted},
+		CondFunc: func(req *http.Request) bool {
+			return req.Method == "PUT" && req.URL.Path == "/services/instances/my-mysql/g1"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := ServiceBind{}
+	command.Flags().Parse(true, []string{"-a", "g1"})
+	err := command.Run(&ctx, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, msg+"\n")
+}
+
 func (s *S) TestServiceBindWithoutFlag(c *gocheck.C) {
 	var (
 		called         bool
@@ -265,12 +287,23 @@ func (s *S) TestServiceUnbindIsAFlaggedComand(c *gocheck.C) {
 }
 
 func (s *S) TestServiceAddInfo(c *gocheck.C) {
-	usage := `service-add <servicename> <serviceinstancename>
+	usage := `service-add <servicename> <serviceinstancename> [--plan planname] [--param name=value]...
 e.g.:
 
     $ tsuru service-add mongodb tsuru_mongodb
 
-Will add a new instance of the "mongodb" service, named "tsuru_mongodb".`
+Will add a new instance of the "mongodb" service, named "tsuru_mongodb".
+
+    $ tsuru service-add mysql tsuru_mysql --plan large
+
+Will add a new instance of the "mysql" service, named "tsuru_mysql", using the
+"large" plan. Use service-info to list the plans offered by a service.
+
+    $ tsuru service-add mysql tsuru_mysql --param version=5.6 --param charset=utf8
+
+Will add a new instance of the "mysql" service, sending the parameters
+"version" and "charset" to the service. The parameters accepted by each
+service are described in its documentation (see service-doc).`
 	expected := &cmd.Info{
 		Name:    "service-add",
 		Usage:   usage,
@@ -294,13 +327,177 @@ func (s *S) TestServiceAddRun(c *gocheck.C) {
 		Stdout: &stdout,
 		Stderr: &stderr,
 	}
-	client := cmd.NewClient(&http.Client{Transport: &testing.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var params map[string]string
+			json.NewDecoder(req.Body).Decode(&params)
+			return req.Method == "POST" && req.URL.Path == "/services/instances" &&
+				params["name"] == "mysql" && params["service_name"] == "my_app_db" && params["plan"] == ""
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
 	err := (&ServiceAdd{}).Run(&context, client)
 	c.Assert(err, gocheck.IsNil)
 	obtained := stdout.String()
 	c.Assert(obtained, gocheck.Equals, result)
 }
 
+func (s *S) TestServiceAddRunWithPlan(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := "Service successfully added.\n"
+	context := cmd.Context{
+		Args:   []string{"mysql", "my_app_db"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var params map[string]string
+			json.NewDecoder(req.Body).Decode(&params)
+			return params["name"] == "my_app_db" && params["service_name"] == "mysql" && params["plan"] == "large"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := ServiceAdd{}
+	command.Flags().Parse(true, []string{"--plan", "large"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, result)
+}
+
+func (s *S) TestServiceAddRunWithParams(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"mysql", "my_app_db"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var body struct {
+				Name   string
+				Params map[string]string
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+			return body.Name == "my_app_db" && body.Params["version"] == "5.6" && body.Params["charset"] == "utf8"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := ServiceAdd{}
+	command.Flags().Parse(true, []string{"--param", "version=5.6", "--param", "charset=utf8"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Service successfully added.\n")
+}
+
+func (s *S) TestServiceAddRunAsynchronousProvisioning(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"mysql", "my_app_db"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.Transport{Message: `Service instance "my_app_db" is being provisioned.`, Status: http.StatusAccepted}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&ServiceAdd{}).Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := `Service successfully added.
+The instance is being provisioned. Use service-status to check whether it's ready.
+`
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestServiceAddFlags(c *gocheck.C) {
+	command := ServiceAdd{}
+	flagset := command.Flags()
+	c.Assert(flagset, gocheck.NotNil)
+	flagset.Parse(true, []string{"-p", "small"})
+	c.Assert(command.plan, gocheck.Equals, "small")
+	plan := flagset.Lookup("plan")
+	c.Assert(plan, gocheck.NotNil)
+	c.Assert(plan.Usage, gocheck.Equals, "The plan of the service instance")
+}
+
+func (s *S) TestServiceParamsFlag(c *gocheck.C) {
+	var params serviceParams
+	err := params.Set("version=5.6")
+	c.Assert(err, gocheck.IsNil)
+	err = params.Set("dsn=user=tsuru")
+	c.Assert(err, gocheck.IsNil)
+	err = params.Set("charset=")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(params, gocheck.DeepEquals, serviceParams{"version": "5.6", "dsn": "user=tsuru", "charset": ""})
+	c.Assert(params.String(), gocheck.Equals, "charset=, dsn=user=tsuru, version=5.6")
+	err = params.Set("version")
+	c.Assert(err, gocheck.ErrorMatches, `^invalid parameter "version", it must be in the format name=value$`)
+	err = params.Set("=5.6")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestServiceUpdateRun(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"my_app_db"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var body struct {
+				Plan   string
+				Params map[string]string
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+			return req.Method == "PUT" && req.URL.Path == "/services/instances/my_app_db" &&
+				body.Plan == "large" && body.Params["version"] == "5.6"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := ServiceUpdate{}
+	command.Flags().Parse(true, []string{"-p", "large", "--param", "version=5.6"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Service instance successfully updated.\n")
+}
+
+func (s *S) TestServiceUpdateRunAsynchronously(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{"my_app_db"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.Transport{Message: `Service instance "my_app_db" is being updated.`, Status: http.StatusAccepted}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := ServiceUpdate{}
+	command.Flags().Parse(true, []string{"--plan", "large"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	expected := `Service instance successfully updated.
+The changes are being applied. Use service-status to check whether the instance is ready.
+`
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestServiceUpdateRunWithoutChanges(c *gocheck.C) {
+	context := cmd.Context{Args: []string{"my_app_db"}}
+	command := ServiceUpdate{}
+	err := command.Run(&context, nil)
+	c.Assert(err, gocheck.ErrorMatches, "^You must provide the new plan or at least one parameter.$")
+}
+
+func (s *S) TestServiceUpdateIsAFlaggedCommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &ServiceUpdate{}
+}
+
+func (s *S) TestServiceAddIsAFlaggedCommand(c *gocheck.C) {
+	var _ cmd.FlaggedCommand = &ServiceAdd{}
+}
+
 func (s *S) TestServiceInstanceStatusInfo(c *gocheck.C) {
 	usg := `service-status <serviceinstancename>
 e.g.:
@@ -361,13 +558,22 @@ func (s *S) TestServiceInfoExtraHeaders(c *gocheck.C) {
 
 func (s *S) TestServiceInfoRun(c *gocheck.C) {
 	var stdout, stderr bytes.Buffer
-	result := `[{"Name":"mymongo", "Apps":["myapp"], "Info":{"key": "value", "key2": "value2"}}]`
+	result := `[{"Name":"mymongo", "PlanName":"small", "Apps":["myapp"], "Info":{"key": "value", "key2": "value2"}}]`
+	plans := `[{"name":"small","description":"1 GB"},{"name":"large","description":"10 GB"}]`
 	expected := `Info for "mongodb"
-+-----------+-------+-------+--------+
-| Instances | Apps  | key   | key2   |
-+-----------+-------+-------+--------+
-| mymongo   | myapp | value | value2 |
-+-----------+-------+-------+--------+
++-----------+-------+-------+-------+--------+
+| Instances | Plan  | Apps  | key   | key2   |
++-----------+-------+-------+-------+--------+
+| mymongo   | small | myapp | value | value2 |
++-----------+-------+-------+-------+--------+
+
+Plans
++-------+-------------+
+| Name  | Description |
++-------+-------------+
+| small | 1 GB        |
+| large | 10 GB       |
++-------+-------------+
 `
 	args := []string{"mongodb"}
 	context := cmd.Context{
@@ -375,13 +581,103 @@ func (s *S) TestServiceInfoRun(c *gocheck.C) {
 		Stdout: &stdout,
 		Stderr: &stderr,
 	}
-	client := cmd.NewClient(&http.Client{Transport: &testing.Transport{Message: result, Status: http.StatusOK}}, nil, manager)
+	trans := &testing.MultiConditionalTransport{
+		ConditionalTransports: []testing.ConditionalTransport{
+			{
+				Transport: testing.Transport{Message: result, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Path == "/services/mongodb"
+				},
+			},
+			{
+				Transport: testing.Transport{Message: plans, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Path == "/services/mongodb/plans"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
 	err := (&ServiceInfo{}).Run(&context, client)
 	c.Assert(err, gocheck.IsNil)
 	obtained := stdout.String()
 	c.Assert(obtained, gocheck.Equals, expected)
 }
 
+func (s *S) TestServiceInfoRunWithoutPlans(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"Name":"mymongo", "Apps":["myapp"]}]`
+	expected := `Info for "mongodb"
++-----------+------+-------+
+| Instances | Plan | Apps  |
++-----------+------+-------+
+| mymongo   |      | myapp |
++-----------+------+-------+
+`
+	context := cmd.Context{
+		Args:   []string{"mongodb"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.MultiConditionalTransport{
+		ConditionalTransports: []testing.ConditionalTransport{
+			{
+				Transport: testing.Transport{Message: result, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Path == "/services/mongodb"
+				},
+			},
+			{
+				Transport: testing.Transport{Message: "[]", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Path == "/services/mongodb/plans"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&ServiceInfo{}).Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestServiceInfoRunWithParams(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"Name":"mymongo", "PlanName":"small", "Params":{"version":"2.4"}, "Apps":["myapp"]}]`
+	expected := `Info for "mongodb"
++-----------+-------+-------------+-------+
+| Instances | Plan  | Params      | Apps  |
++-----------+-------+-------------+-------+
+| mymongo   | small | version=2.4 | myapp |
++-----------+-------+-------------+-------+
+`
+	context := cmd.Context{
+		Args:   []string{"mongodb"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &testing.MultiConditionalTransport{
+		ConditionalTransports: []testing.ConditionalTransport{
+			{
+				Transport: testing.Transport{Message: result, Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Path == "/services/mongodb"
+				},
+			},
+			{
+				Transport: testing.Transport{Message: "[]", Status: http.StatusOK},
+				CondFunc: func(req *http.Request) bool {
+					return req.URL.Path == "/services/mongodb/plans"
+				},
+			},
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&ServiceInfo{}).Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
 func (s *S) TestServiceDocInfo(c *gocheck.C) {
 	i := (&ServiceDoc{}).Info()
 	expected := &cmd.Info{
@@ -455,3 +751,41 @@ func (s *S) TestServiceRemoveRun(c *gocheck.C) {
 	obtained := stdout.String()
 	c.Assert(obtained, gocheck.Equals, result+"\n")
 }
+
+func (s *S) TestServiceInstanceGrantRun(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{
+		Args:   []string{"mysql-db", "otherteam"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	transport := testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(r *http.Request) bool {
+			return r.URL.Path == "/services/instances/mysql-db/teams/otherteam" && r.Method == "PUT"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport}, nil, manager)
+	err := ServiceInstanceGrant{}.Run(&ctx, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, `Service instance "mysql-db" is now shared with the team "otherteam".`+"\n")
+}
+
+func (s *S) TestServiceInstanceRevokeRun(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	ctx := cmd.Context{
+		Args:   []string{"mysql-db", "otherteam"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	transport := testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(r *http.Request) bool {
+			return r.URL.Path == "/services/instances/mysql-db/teams/otherteam" && r.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &transport}, nil, manager)
+	err := ServiceInstanceRevoke{}.Run(&ctx, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, `Service instance "mysql-db" is no longer shared with the team "otherteam".`+"\n")
+}
@@ -0,0 +1,163 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/globocom/tsuru/cmd"
+	"launchpad.net/gnuflag"
+	"net/http"
+	"strings"
+)
+
+type WebhookAdd struct {
+	events string
+	secret string
+	fs     *gnuflag.FlagSet
+}
+
+func (c *WebhookAdd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "webhook-add",
+		Usage: "webhook-add <teamname> <url> [--events event1,event2,...] [--secret secret]",
+		Desc: `adds a webhook to a team.
+
+The events of the apps of the team are POSTed to the URL in JSON format. The
+available events are: deploy, restart, add-units, remove-units, set-env,
+unset-env and swap. By default, all events are sent to the webhook.
+
+Payloads are signed with HMAC-SHA256, using the secret as key, and the
+signature is sent in the X-Tsuru-Signature header. When the secret is not
+provided, tsuru generates one.`,
+		MinArgs: 2,
+	}
+}
+
+func (c *WebhookAdd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("webhook-add", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.events, "events", "", "Comma separated list of events sent to the webhook")
+		c.fs.StringVar(&c.events, "e", "", "Comma separated list of events sent to the webhook")
+		c.fs.StringVar(&c.secret, "secret", "", "Secret used to sign the payloads")
+		c.fs.StringVar(&c.secret, "s", "", "Secret used to sign the payloads")
+	}
+	return c.fs
+}
+
+func (c *WebhookAdd) Run(context *cmd.Context, client *cmd.Client) error {
+	teamName := context.Args[0]
+	params := map[string]interface{}{"url": context.Args[1], "secret": c.secret}
+	if c.events != "" {
+		params["events"] = strings.Split(c.events, ",")
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	u, err := cmd.GetURL(fmt.Sprintf("/teams/%s/webhooks", teamName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var result map[string]string
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Webhook %s successfully added to the team %q.\n", result["id"], teamName)
+	if c.secret == "" {
+		fmt.Fprintf(context.Stdout, "Secret used to sign the payloads: %s\n", result["secret"])
+	}
+	return nil
+}
+
+type WebhookRemove struct{}
+
+func (c *WebhookRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "webhook-remove",
+		Usage:   "webhook-remove <teamname> <id>",
+		Desc:    "removes a webhook from a team. Use webhook-list to find the id of the webhook.",
+		MinArgs: 2,
+	}
+}
+
+func (c *WebhookRemove) Run(context *cmd.Context, client *cmd.Client) error {
+	teamName, id := context.Args[0], context.Args[1]
+	u, err := cmd.GetURL(fmt.Sprintf("/teams/%s/webhooks/%s", teamName, id))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Webhook successfully removed from the team %q.\n", teamName)
+	return nil
+}
+
+type WebhookList struct{}
+
+func (c *WebhookList) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "webhook-list",
+		Usage:   "webhook-list <teamname>",
+		Desc:    "lists the webhooks of a team.",
+		MinArgs: 1,
+	}
+}
+
+func (c *WebhookList) Run(context *cmd.Context, client *cmd.Client) error {
+	teamName := context.Args[0]
+	u, err := cmd.GetURL(fmt.Sprintf("/teams/%s/webhooks", teamName))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var webhooks []struct {
+		ID     string
+		URL    string
+		Events []string
+	}
+	if err := json.NewDecoder(response.Body).Decode(&webhooks); err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		fmt.Fprintf(context.Stdout, "The team %q has no webhooks.\n", teamName)
+		return nil
+	}
+	table := cmd.NewTable()
+	table.Headers = cmd.Row([]string{"Id", "Url", "Events"})
+	for _, w := range webhooks {
+		events := "all"
+		if len(w.Events) > 0 {
+			events = strings.Join(w.Events, ", ")
+		}
+		table.AddRow(cmd.Row([]string{w.ID, w.URL, events}))
+	}
+	context.Stdout.Write(table.Bytes())
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuru
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/cmd/testing"
+	"launchpad.net/gocheck"
+	"net/http"
+)
+
+func (s *S) TestWebhookAddInfo(c *gocheck.C) {
+	info := (&WebhookAdd{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "webhook-add")
+	c.Assert(info.MinArgs, gocheck.Equals, 2)
+}
+
+func (s *S) TestWebhookAdd(c *gocheck.C) {
+	var (
+		called         bool
+		stdout, stderr bytes.Buffer
+	)
+	context := cmd.Context{Args: []string{"myteam", "http://chat.tsuru.io/hook"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: `{"id":"528c8a2e9b9c1a3f6a000001","secret":"abc123"}`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			var params map[string]interface{}
+			err := json.NewDecoder(req.Body).Decode(&params)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(params, gocheck.DeepEquals, map[string]interface{}{
+				"url":    "http://chat.tsuru.io/hook",
+				"secret": "",
+				"events": []interface{}{"deploy", "restart"},
+			})
+			return req.URL.Path == "/teams/myteam/webhooks" && req.Method == "POST"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := WebhookAdd{}
+	command.Flags().Parse(true, []string{"--events", "deploy,restart"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(called, gocheck.Equals, true)
+	expected := "Webhook 528c8a2e9b9c1a3f6a000001 successfully added to the team \"myteam\".\n" +
+		"Secret used to sign the payloads: abc123\n"
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestWebhookAddWithSecret(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"myteam", "http://chat.tsuru.io/hook"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: `{"id":"528c8a2e9b9c1a3f6a000001","secret":"s3cr3t"}`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var params map[string]interface{}
+			json.NewDecoder(req.Body).Decode(&params)
+			return params["secret"] == "s3cr3t" && params["events"] == nil
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := WebhookAdd{}
+	command.Flags().Parse(true, []string{"-s", "s3cr3t"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Webhook 528c8a2e9b9c1a3f6a000001 successfully added to the team \"myteam\".\n")
+}
+
+func (s *S) TestWebhookRemoveInfo(c *gocheck.C) {
+	info := (&WebhookRemove{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "webhook-remove")
+	c.Assert(info.MinArgs, gocheck.Equals, 2)
+}
+
+func (s *S) TestWebhookRemove(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"myteam", "528c8a2e9b9c1a3f6a000001"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/teams/myteam/webhooks/528c8a2e9b9c1a3f6a000001" && req.Method == "DELETE"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&WebhookRemove{}).Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "Webhook successfully removed from the team \"myteam\".\n")
+}
+
+func (s *S) TestWebhookListInfo(c *gocheck.C) {
+	info := (&WebhookList{}).Info()
+	c.Assert(info.Name, gocheck.Equals, "webhook-list")
+	c.Assert(info.MinArgs, gocheck.Equals, 1)
+}
+
+func (s *S) TestWebhookList(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `[{"id":"528c8a2e9b9c1a3f6a000001","team":"myteam","url":"http://chat.tsuru.io/hook","events":["deploy","swap"]},` +
+		`{"id":"528c8a2e9b9c1a3f6a000002","team":"myteam","url":"http://ci.tsuru.io","events":null}]`
+	expected := `+--------------------------+---------------------------+--------------+
+| Id                       | Url                       | Events       |
++--------------------------+---------------------------+--------------+
+| 528c8a2e9b9c1a3f6a000001 | http://chat.tsuru.io/hook | deploy, swap |
+| 528c8a2e9b9c1a3f6a000002 | http://ci.tsuru.io        | all          |
++--------------------------+---------------------------+--------------+
+`
+	context := cmd.Context{Args: []string{"myteam"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.ConditionalTransport{
+		Transport: testing.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/teams/myteam/webhooks" && req.Method == "GET"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&WebhookList{}).Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, expected)
+}
+
+func (s *S) TestWebhookListEmpty(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Args: []string{"myteam"}, Stdout: &stdout, Stderr: &stderr}
+	trans := &testing.Transport{Message: "[]", Status: http.StatusOK}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	err := (&WebhookList{}).Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stdout.String(), gocheck.Equals, "The team \"myteam\" has no webhooks.\n")
+}
@@ -15,12 +15,19 @@ import (
 	"net/http"
 )
 
-type AppCreate struct{}
+type AppCreate struct {
+	plan string
+	fs   *gnuflag.FlagSet
+}
 
-func (AppCreate) Run(context *cmd.Context, client *cmd.Client) error {
+func (c *AppCreate) Run(context *cmd.Context, client *cmd.Client) error {
 	appName := context.Args[0]
 	platform := context.Args[1]
-	b := bytes.NewBufferString(fmt.Sprintf(`{"name":"%s","platform":"%s"}`, appName, platform))
+	params := fmt.Sprintf(`{"name":"%s","platform":"%s"}`, appName, platform)
+	if c.plan != "" {
+		params = fmt.Sprintf(`{"name":"%s","platform":"%s","plan":{"name":"%s"}}`, appName, platform, c.plan)
+	}
+	b := bytes.NewBufferString(params)
 	url, err := cmd.GetURL("/apps")
 	if err != nil {
 		return err
@@ -50,15 +57,24 @@ func (AppCreate) Run(context *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
-func (AppCreate) Info() *cmd.Info {
+func (c *AppCreate) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:    "app-create",
-		Usage:   "app-create <appname> <platform>",
+		Usage:   "app-create <appname> <platform> [--plan planname]",
 		Desc:    "create a new app.",
 		MinArgs: 2,
 	}
 }
 
+func (c *AppCreate) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("app-create", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.plan, "plan", "", "The plan used to create the app.")
+		c.fs.StringVar(&c.plan, "p", "", "The plan used to create the app.")
+	}
+	return c.fs
+}
+
 type AppRemove struct {
 	tsuru.GuessingCommand
 	yes bool
@@ -18,7 +18,7 @@ import (
 func (s *S) TestAppCreateInfo(c *gocheck.C) {
 	expected := &cmd.Info{
 		Name:    "app-create",
-		Usage:   "app-create <appname> <platform>",
+		Usage:   "app-create <appname> <platform> [--plan planname]",
 		Desc:    "create a new app.",
 		MinArgs: 2,
 	}
@@ -47,12 +47,52 @@ Your repository for "ble" project is "git@tsuru.plataformas.glb.com:ble.git"` +
 		},
 	}
 	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
-	command := AppCreate{}
+	command := &AppCreate{}
 	err := command.Run(&context, client)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(stdout.String(), gocheck.Equals, expected)
 }
 
+func (s *S) TestAppCreateWithPlan(c *gocheck.C) {
+	var stdout, stderr bytes.Buffer
+	result := `{"status":"success", "repository_url":"git@tsuru.plataformas.glb.com:ble.git"}`
+	context := cmd.Context{
+		Args:   []string{"ble", "django"},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := testing.ConditionalTransport{
+		Transport: testing.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			defer req.Body.Close()
+			body, err := ioutil.ReadAll(req.Body)
+			c.Assert(err, gocheck.IsNil)
+			c.Assert(string(body), gocheck.Equals, `{"name":"ble","platform":"django","plan":{"name":"small"}}`)
+			return req.Method == "POST" && req.URL.Path == "/apps"
+		},
+	}
+	client := cmd.NewClient(&http.Client{Transport: &trans}, nil, manager)
+	command := &AppCreate{}
+	command.Flags().Parse(true, []string{"--plan", "small"})
+	err := command.Run(&context, client)
+	c.Assert(err, gocheck.IsNil)
+}
+
+func (s *S) TestAppCreateFlags(c *gocheck.C) {
+	command := AppCreate{}
+	flagset := command.Flags()
+	c.Assert(flagset, gocheck.NotNil)
+	flagset.Parse(true, []string{"--plan", "small"})
+	plan := flagset.Lookup("plan")
+	c.Check(plan.Name, gocheck.Equals, "plan")
+	c.Check(plan.Usage, gocheck.Equals, "The plan used to create the app.")
+	c.Check(plan.Value.String(), gocheck.Equals, "small")
+	c.Check(plan.DefValue, gocheck.Equals, "")
+	splan := flagset.Lookup("p")
+	c.Check(splan.Name, gocheck.Equals, "p")
+	c.Check(splan.Value.String(), gocheck.Equals, "small")
+}
+
 func (s *S) TestAppCreateWithInvalidFramework(c *gocheck.C) {
 	var stdout, stderr bytes.Buffer
 	context := cmd.Context{
@@ -61,7 +101,7 @@ func (s *S) TestAppCreateWithInvalidFramework(c *gocheck.C) {
 		Stderr: &stderr,
 	}
 	client := cmd.NewClient(&http.Client{Transport: &testing.Transport{Message: "", Status: http.StatusInternalServerError}}, nil, manager)
-	command := AppCreate{}
+	command := &AppCreate{}
 	err := command.Run(&context, client)
 	c.Assert(err, gocheck.NotNil)
 	c.Assert(stdout.String(), gocheck.Equals, "")
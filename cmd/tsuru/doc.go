@@ -26,6 +26,12 @@ The currently available commands are (grouped by subject):
 	logout            finishes the session with tsuru server
 	change-password   changes your password
 	reset-password    redefines your password
+	token-create      creates a named token for automated clients
+	token-list        lists your active sessions and named tokens
+	token-remove      revokes a session or a named token
+	two-factor-enable enables two-factor authentication
+	two-factor-disable disables two-factor authentication
+	two-factor-recovery-codes generates new recovery codes for two-factor authentication
 	key-add           adds a public key to tsuru deploy server
 	key-remove        removes a public key from tsuru deploy server
 
@@ -34,8 +40,15 @@ The currently available commands are (grouped by subject):
 	team-list         list teams that the user is member
 	team-user-add     adds a user to a team
 	team-user-remove  removes a user from a team
+	team-role-set     defines the role of the members of a team
+	team-user-role-set defines the role of a member of a team
+	role-list         lists the available roles and their permissions
+	webhook-add       adds a webhook to a team
+	webhook-remove    removes a webhook from a team
+	webhook-list      lists the webhooks of a team
 
 	platform-list     list available platforms
+	plan-list         list available plans
 	app-create        creates an app
 	app-remove        removes an app
 	app-list          lists apps that the user has access (see app-grant and team-user-add)
@@ -44,9 +57,22 @@ The currently available commands are (grouped by subject):
 	app-revoke        revokes access to an app from a team
 	unit-add          adds new units to an app
 	unit-remove       remove units from an app
+	app-autoscale-set defines the autoscaling rules of an app
+	app-plan-change   changes the plan of an app
 	log               shows log for an app
+	app-log-drain-add adds a log drain to an app
+	app-log-drain-remove
+	                  removes a log drain from an app
+	app-log-drain-list
+	                  lists the log drains of an app
 	run               runs a command in all units of an app
 	restart           restarts the app's application server
+	app-deploy-list   lists the deploys of an app
+	app-rollback      rolls an app back to a previous deploy
+	app-blue-green-enable
+	                  enables the blue/green deploy mode for an app
+	app-promote       puts live the version pending in a blue/green deploy
+	app-abort-deploy  discards the version pending in a blue/green deploy
 	set-cname         defines a cname for an app
 	unset-cname       unsets the cname from an app
 	swap              swaps the router between two apps
@@ -54,24 +80,33 @@ The currently available commands are (grouped by subject):
 	env-get           display environment variables for an app
 	env-set           set environment variable(s) to an app
 	env-unset         unset environment variable(s) from an app
+	env-import        import environment variables from a file into an app
+	env-export        export the environment variables of an app
 
 	bind              binds an app to a service instance
 	unbind            unbinds an app from a service instance
 
 	service-list      list all services, and instances of each service
 	service-add       creates a new instance of a service
+	service-update    changes the plan or the parameters of a service instance
 	service-remove    removes a instance of a service
 	service-status    checks the status of a service instance
 	service-info      list instances of a service, and apps bound to each instance
 	service-doc       displays documentation for a service
 
+	service-instance-grant   shares a service instance with a team
+	service-instance-revoke  stops sharing a service instance with a team
+
 Use "tsuru help <command>" for more information about a command.
 
 
 Guessing app names
 
 In some app-related commands (app-remove, app-info, app-grant, app-revoke, log,
-run, restart, env-get, env-set, env-unset, bind and unbind), there is an
+run, restart, app-deploy-list, app-rollback, app-blue-green-enable,
+app-promote, app-abort-deploy, app-autoscale-set, app-plan-change,
+app-log-drain-add, app-log-drain-remove, app-log-drain-list, env-get, env-set,
+env-unset, env-import, env-export, bind and unbind), there is an
 optional parameter --app, used to specify the name of the app.
 
 The --app parameter is optional, if omitted, tsuru will try to "guess" the name
@@ -139,12 +174,19 @@ Authenticate within remote tsuru server
 
 Usage:
 
-	% tsuru login <email>
+	% tsuru login [email]
 
 Login will ask for the password and check if the user is successfully
 authenticated. If so, the token generated by the tsuru server will be stored in
 ${HOME}/.tsuru_token.
 
+When the tsuru server uses OAuth for authentication, the email must be omitted:
+login will open the authorization page of the OAuth provider in the browser,
+and wait for the authorization in a local port.
+
+When two-factor authentication is enabled, login will also ask for the code
+generated by the authenticator app (or one of the recovery codes).
+
 All tsuru actions require the user to be authenticated (except login and
 user-create, obviously).
 
@@ -187,6 +229,48 @@ With the token in hand, the user can finally reset the password using the --toke
 The new password will also be mailed to the user.`,
 
 
+Manage sessions and tokens
+
+Usage:
+
+	% tsuru token-create --name <name>
+	% tsuru token-list
+	% tsuru token-remove <id>
+
+token-create creates a long-lived named token, meant to be used by automated
+clients, like continuous integration systems, in the Authorization header
+("Authorization: bearer <token>"). Named tokens don't count in the limit of
+simultaneous sessions.
+
+token-list lists your active sessions and named tokens, with their creation and
+expiration dates, and when and by which client they were last used. Expired
+tokens are not listed.
+
+token-remove revokes a session or a named token, given its id, as displayed by
+token-list.
+
+
+Manage two-factor authentication
+
+Usage:
+
+	% tsuru two-factor-enable
+	% tsuru two-factor-disable
+	% tsuru two-factor-recovery-codes
+
+two-factor-enable starts the enrollment in two-factor authentication: it
+displays a secret (and an otpauth:// URI, that can be turned into a QR code) to
+be added to an authenticator app, and asks for a code generated by the app to
+confirm the enrollment. It then displays ten recovery codes, that can be used
+once each in place of a code, in case you lose your device.
+
+two-factor-disable disables two-factor authentication, and
+two-factor-recovery-codes replaces the recovery codes. Both ask for a code.
+
+Teams may require their members to use two-factor authentication. Members of
+these teams can't use tsuru until they enable it.
+
+
 Add SSH public key to tsuru's git server
 
 Usage:
@@ -274,6 +358,39 @@ A team can never have 0 users. If you are the last member of a team, you can't
 remove yourself from it.
 
 
+List the available roles
+
+Usage:
+
+	% tsuru role-list
+
+role-list lists the roles that can be assigned to teams and to their members,
+along with the permissions each role grants: viewers can only read information
+and logs of apps, deployers can also deploy and restart them, app-admins can
+change and remove apps, and team-admins can also manage the team.
+
+
+Define the role of a team
+
+Usage:
+
+	% tsuru team-role-set <team-name> <role>
+
+team-role-set defines the role of all members of a team in the apps of the
+team. Members of teams without a role are team-admins. Only team-admins can
+change roles or manage the members of a team.
+
+
+Define the role of a member of a team
+
+Usage:
+
+	% tsuru team-user-role-set <team-name> <user@email> <role>
+
+team-user-role-set defines the role of a member of a team, overriding the role
+of the team for that user.
+
+
 Display the list of available platforms
 
 Usage:
@@ -284,16 +401,32 @@ platform-list lists the available platforms. All platforms displayed in this
 list may be used to create new apps (see app-create).
 
 
+Display the list of available plans
+
+Usage:
+
+	% tsuru plan-list
+
+plan-list lists the available plans, with the memory, swap and cpu share that
+each unit of an app using the plan is allowed to consume. Any plan displayed in
+this list may be used to create new apps (see app-create), or to change the
+plan of an existing app (see app-plan-change).
+
+
 Create an app
 
 Usage:
 
-	% tsuru app-create <app-name> <platform>
+	% tsuru app-create <app-name> <platform> [--plan planname]
 
 app-create will create a new app using the given name and platform. For tsuru,
 a platform is a Juju charm. To check the available platforms, use the command
 "platform-list".
 
+The --plan flag defines the resources available to each unit of the app. When
+omitted, the default plan is used. To check the available plans, use the
+command "plan-list".
+
 In order to create an app, you need to be member of at least one team. All
 teams that you are member (see "tsuru team-list") will be able to access the
 app.
@@ -390,7 +523,7 @@ See app's logs
 
 Usage:
 
-	% tsuru log [--app|-a appname] [--lines|-l numberOfLines] [--source|-s source] [--follow|-f]
+	% tsuru log [--app|-a appname] [--lines|-l numberOfLines] [--source|-s source] [--unit|-u unitname] [--since date] [--until date] [--grep|-g text] [--regex|-r expression] [--cursor cursor] [--follow|-f]
 
 Log will show log entries for an app. These logs are not related to the code of
 the app itself, but to actions of the app in tsuru server (deployments,
@@ -400,6 +533,64 @@ The --app flag is optional, see "Guessing app names" section for more details.
 The --lines flag is optional and by default its value is 10.
 The --source flag is optional.
 
+Each entry is prefixed by its date and its source and, for entries sent by the
+units of the app, the name of the unit.
+
+The other flags filter the log entries: --unit selects the entries of a unit,
+--since and --until define a time range, --grep selects entries containing
+the given text and --regex selects entries matching the given regular
+expression. Dates may be given in the RFC 3339 format (e.g.:
+2013-10-29T14:30:00Z), or relative to the current time (e.g.: 30m, 2h).
+
+When there are older entries matching the filters, tsuru displays a cursor.
+Use it with the --cursor flag to see the previous page of entries.
+
+
+Forward app's logs to external services
+
+Usage:
+
+	% tsuru app-log-drain-add <url> [--app appname]
+	% tsuru app-log-drain-remove <url> [--app appname]
+	% tsuru app-log-drain-list [--app appname]
+
+Log drains forward all logs of an app, as they're generated, to external log
+services. The scheme of the URL defines the protocol used by the drain:
+
+	syslog://host[:port]      syslog (RFC 5424) over UDP, port defaults to 514
+	syslog+tcp://host[:port]  syslog (RFC 5424) over TCP
+	tcp://host:port           one plain text line per log
+	http[s]://host[/path]     batches of logs POSTed in JSON format
+
+Delivery is asynchronous: when a drain is unavailable, tsuru retries the
+delivery a few times before discarding the logs. Drains can't point to
+private addresses, like localhost or 10.0.0.0/8.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Notify external services about the events of apps
+
+Usage:
+
+	% tsuru webhook-add <teamname> <url> [--events event1,event2,...] [--secret secret]
+	% tsuru webhook-remove <teamname> <id>
+	% tsuru webhook-list <teamname>
+
+Webhooks notify external services (like chat rooms and deployment trackers)
+about the events of the apps of a team. The available events are deploy,
+restart, add-units, remove-units, set-env, unset-env and swap. Use --events to
+select the events sent to the webhook, by default all events are sent.
+
+Events are POSTed to the URL in JSON format, with the name of the event in the
+X-Tsuru-Event header. The payload is signed with HMAC-SHA256, using the secret
+of the webhook as key, and the signature is sent in the X-Tsuru-Signature
+header, in the format "sha256=<hex digest>". When --secret is not provided,
+tsuru generates a secret and displays it.
+
+Delivery is asynchronous: when the URL is unavailable, tsuru retries the
+delivery a few times, waiting longer between each attempt.
+
 
 Run an arbitrary command in the app machine
 
@@ -467,6 +658,109 @@ application.
 The --app flag is optional, see "Guessing app names" section for more details.
 
 
+List the deploys of an app
+
+Usage:
+
+	% tsuru app-deploy-list [--app appname]
+
+app-deploy-list lists all deploys of the app, from the most recent to the
+oldest one, displaying the id of the deploy, the deployed commit, the user that
+triggered it, how long it took and whether it succeeded.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Roll an app back to a previous deploy
+
+Usage:
+
+	% tsuru app-rollback <deploy-id> [--app appname]
+
+app-rollback deploys again the version of the app deployed in the given
+deploy. Use app-deploy-list to find the id of the deploy. Only successful
+deploys can be used in a rollback. Apps deployed in blue/green mode can't be
+rolled back: deploy the old version again and promote it.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Enable blue/green deploys for an app
+
+Usage:
+
+	% tsuru app-blue-green-enable [--app appname]
+
+app-blue-green-enable creates a hidden standby app for the app. From then on,
+every deploy goes to the app that is not receiving traffic, and is checked by
+sending requests to it. The new version only receives traffic after it is
+promoted with app-promote.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Promote a blue/green deploy
+
+Usage:
+
+	% tsuru app-promote [--app appname]
+
+app-promote puts live the version deployed in the last blue/green deploy,
+swapping the router between the app and its standby app. The previous version
+remains available in the idle app, so running app-promote again without a new
+deploy is not allowed.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Abort a blue/green deploy
+
+Usage:
+
+	% tsuru app-abort-deploy [--app appname]
+
+app-abort-deploy discards the version deployed in the last blue/green deploy,
+keeping the current version live. The idle app can't be swapped with the live
+app until a new version is deployed to it.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Define the autoscaling rules of an app
+
+Usage:
+
+	% tsuru app-autoscale-set --min <units> --max <units> --up <threshold> --down <threshold> [--metric cpu|mem|requests] [--step <units>] [--cooldown <seconds>] [--disable] [--app appname]
+
+app-autoscale-set defines the rules used by tsuru to add and remove units of
+the app automatically. The units of the app report their metrics (cpu, mem or
+requests) to tsuru, and periodically the average of the chosen metric is
+compared to the thresholds: when it is above --up, --step units are added;
+when it is below --down, --step units are removed. The number of units is
+always kept between --min and --max, and no decision is taken until --cooldown
+seconds have passed since the last one, even if it failed. Units are only
+added while the quota of the app allows it.
+
+Every scaling decision is recorded in the app log, use "tsuru log" to see
+them. Use --disable to turn autoscaling off.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Change the plan of an app
+
+Usage:
+
+	% tsuru app-plan-change <planname> [--app appname]
+
+app-plan-change changes the plan of the app. The new memory, swap and cpu share
+limits are applied to the units created from now on, including the ones
+created in the next deploy. The plan can't be changed if the memory consumed by
+the units of the app would exceed the memory available to any of its teams.
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
 Display environment variables of an application
 
 Usage:
@@ -539,6 +833,49 @@ Notice that env-unset will fail silently to undefine private variables.
 The --app flag is optional, see "Guessing app names" section for more details.
 
 
+Import environment variables from a file
+
+Usage:
+
+	% tsuru env-import <file> [--format dotenv|json] [--no-restart] [--app appname]
+
+env-import will define all environment variables declared in the file at once,
+writing them to the units of the app a single time. The file may be in dotenv
+format, with one NAME=value declaration per line, or in JSON format, with an
+object mapping names to values. The format is guessed from the extension of
+the file (".json" for JSON), and may be forced with the --format flag.
+
+env-import cannot redefine private variables: when the file declares a private
+variable, no variable is imported. After the import, the app is restarted,
+unless the --no-restart flag is given. Example of use:
+
+	% cat production.env
+	# database settings
+	MYSQL_DATABASE_NAME=myapp_sql
+	MYSQL_HOST="db.example.com"
+	% tsuru env-import production.env --app myapp
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
+Export environment variables to a file
+
+Usage:
+
+	% tsuru env-export [--format dotenv|json] [--app appname]
+
+env-export will write all public environment variables of the app to the
+standard output, in dotenv (default) or JSON format. Private variables are not
+exported. The output can be imported in another app using env-import, which is
+useful for migrating apps between tsuru servers:
+
+	% tsuru env-export --app myapp > myapp.env
+	% tsuru target-set other
+	% tsuru env-import myapp.env --app myapp
+
+The --app flag is optional, see "Guessing app names" section for more details.
+
+
 Bind an application to a service instance
 
 Usage:
@@ -568,6 +905,27 @@ application from a MySQL service, the app would lose access to the database.
 The --app flag is optional, see "Guessing app names" section for more details.
 
 
+Share a service instance with a team
+
+Usage:
+
+	% tsuru service-instance-grant <instance-name> <teamname>
+
+Shares the service instance with the team, so apps of the team can be bound to
+it. Only members of the teams that own the instance can share it. Instances
+shared with your teams are displayed by service-list.
+
+
+Stop sharing a service instance with a team
+
+Usage:
+
+	% tsuru service-instance-revoke <instance-name> <teamname>
+
+Stops sharing the service instance with the team. Apps of the team that are
+already bound to the instance are not unbound, and can still be unbound later.
+
+
 List available services and instances
 
 Usage:
@@ -576,7 +934,7 @@ Usage:
 
 service-list will retrieve and display a list of services that the user has
 access to. If the user has any instance of services, it will be displayed by
-this command too.
+this command too, including instances shared with the teams of the user.
 
 
 Swap the routing between two apps
@@ -591,11 +949,26 @@ Create a new service instance
 
 Usage:
 
-	% tsuru service-add <service-name> <instance-name>
+	% tsuru service-add <service-name> <instance-name> [--plan plan-name] [--param name=value]...
 
 service-add will create a new service instance. After listing services with
 "service-list", you may want to create a new service instance.
 
+Some services offer plans, like the size of the instance. The plans of a
+service are displayed by "service-info", and the plan of the instance is
+chosen with the --plan flag. The plan of each instance is displayed by
+"service-list", between parentheses.
+
+Services may also accept parameters, like the version of a database. Each
+parameter is provided with the --param flag, in the format name=value, and is
+forwarded to the service. Check the documentation of the service (see
+"service-doc") for the parameters it accepts.
+
+Some services take a while to provision instances. In this case, the instance
+is created in the pending state, and tsuru checks its status until it's ready.
+Apps bound to a pending instance are bound to it as soon as it's ready. Use
+"service-status" to check the status of the instance.
+
 Example of use:
 
 	% tsuru service-list
@@ -614,6 +987,19 @@ Example of use:
 	+----------+-----------+
 
 
+Update a service instance
+
+Usage:
+
+	% tsuru service-update <instance-name> [--plan plan-name] [--param name=value]...
+
+service-update will change the plan or the parameters of a service instance,
+without destroying it. The given parameters are merged into the current
+parameters of the instance, and a parameter with an empty value (--param
+name=) is removed. Like in service-add, the service may take a while to apply
+the changes, in which case the instance goes back to the pending state.
+
+
 Remove a service instance
 
 Usage:
@@ -632,26 +1018,45 @@ Usage:
 	% tsuru service-info <service-name>
 
 service-info will display a list of all instances of a given service (that the
-user has access to), and apps bound to these instances.
+user has access to), the plan, the parameters and the apps bound to these
+instances, and the plans offered by the service. The parameters are only
+displayed when at least one instance has parameters, and their values are
+hidden.
 
 Example of use:
 
 	% tsuru service-info mysql
 	Info for "mysql"
-	+-----------+-------+
-	| Instances | Apps  |
-	+-----------+-------+
-	| newmysql  |       |
-	+-----------+-------+
+	+-----------+-------+-------+
+	| Instances | Plan  | Apps  |
+	+-----------+-------+-------+
+	| newmysql  | small |       |
+	+-----------+-------+-------+
+
+	Plans
+	+-------+-------------+
+	| Name  | Description |
+	+-------+-------------+
+	| small | 1 GB        |
+	| large | 10 GB       |
+	+-------+-------------+
 	% tsuru bind newmysql myapp
 	...
 	% tsuru service-info mysql
 	Info for "mysql"
-	+-----------+-------+
-	| Instances | Apps  |
-	+-----------+-------+
-	| newmysql  | myapp |
-	+-----------+-------+
+	+-----------+-------+-------+
+	| Instances | Plan  | Apps  |
+	+-----------+-------+-------+
+	| newmysql  | small | myapp |
+	+-----------+-------+-------+
+
+	Plans
+	+-------+-------------+
+	| Name  | Description |
+	+-------+-------------+
+	| small | 1 GB        |
+	| large | 10 GB       |
+	+-------+-------------+
 
 
 Check if a service instance is up
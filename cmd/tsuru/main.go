@@ -19,31 +19,50 @@ func buildManager(name string) *cmd.Manager {
 	m := cmd.BuildBaseManager(name, version, header)
 	m.Register(&tsuru.AppRun{})
 	m.Register(&tsuru.AppInfo{})
-	m.Register(AppCreate{})
+	m.Register(&AppCreate{})
 	m.Register(&AppRemove{})
 	m.Register(&UnitAdd{})
 	m.Register(&UnitRemove{})
 	m.Register(tsuru.AppList{})
 	m.Register(&tsuru.AppLog{})
+	m.Register(&tsuru.AppLogDrainAdd{})
+	m.Register(&tsuru.AppLogDrainRemove{})
+	m.Register(&tsuru.AppLogDrainList{})
+	m.Register(&tsuru.WebhookAdd{})
+	m.Register(&tsuru.WebhookRemove{})
+	m.Register(&tsuru.WebhookList{})
 	m.Register(&tsuru.AppGrant{})
 	m.Register(&tsuru.AppRevoke{})
 	m.Register(&tsuru.AppRestart{})
+	m.Register(&tsuru.AppDeployList{})
+	m.Register(&tsuru.AppRollback{})
+	m.Register(&tsuru.AppBlueGreenEnable{})
+	m.Register(&tsuru.AppPromote{})
+	m.Register(&tsuru.AppAbortDeploy{})
+	m.Register(&tsuru.AppAutoScaleSet{})
+	m.Register(&tsuru.AppPlanChange{})
 	m.Register(&tsuru.SetCName{})
 	m.Register(&tsuru.UnsetCName{})
 	m.Register(&tsuru.EnvGet{})
 	m.Register(&tsuru.EnvSet{})
 	m.Register(&tsuru.EnvUnset{})
+	m.Register(&tsuru.EnvImport{})
+	m.Register(&tsuru.EnvExport{})
 	m.Register(&KeyAdd{})
 	m.Register(&KeyRemove{})
 	m.Register(tsuru.ServiceList{})
-	m.Register(tsuru.ServiceAdd{})
+	m.Register(&tsuru.ServiceAdd{})
+	m.Register(&tsuru.ServiceUpdate{})
 	m.Register(tsuru.ServiceRemove{})
 	m.Register(tsuru.ServiceDoc{})
 	m.Register(tsuru.ServiceInfo{})
 	m.Register(tsuru.ServiceInstanceStatus{})
 	m.Register(&tsuru.ServiceBind{})
 	m.Register(&tsuru.ServiceUnbind{})
+	m.Register(tsuru.ServiceInstanceGrant{})
+	m.Register(tsuru.ServiceInstanceRevoke{})
 	m.Register(platformList{})
+	m.Register(tsuru.PlanList{})
 	m.Register(swap{})
 	return m
 }
@@ -24,7 +24,7 @@ func (s *S) TestAppCreateIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	create, ok := manager.Commands["app-create"]
 	c.Assert(ok, gocheck.Equals, true)
-	c.Assert(create, gocheck.FitsTypeOf, AppCreate{})
+	c.Assert(create, gocheck.FitsTypeOf, &AppCreate{})
 }
 
 func (s *S) TestAppRemoveIsRegistered(c *gocheck.C) {
@@ -97,6 +97,20 @@ func (s *S) TestEnvUnsetIsRegistered(c *gocheck.C) {
 	c.Assert(unset, gocheck.FitsTypeOf, &tsuru.EnvUnset{})
 }
 
+func (s *S) TestEnvImportIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	envImport, ok := manager.Commands["env-import"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(envImport, gocheck.FitsTypeOf, &tsuru.EnvImport{})
+}
+
+func (s *S) TestEnvExportIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	envExport, ok := manager.Commands["env-export"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(envExport, gocheck.FitsTypeOf, &tsuru.EnvExport{})
+}
+
 func (s *S) TestKeyAddIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	add, ok := manager.Commands["key-add"]
@@ -122,7 +136,14 @@ func (s *S) TestServiceAddIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	add, ok := manager.Commands["service-add"]
 	c.Assert(ok, gocheck.Equals, true)
-	c.Assert(add, gocheck.FitsTypeOf, tsuru.ServiceAdd{})
+	c.Assert(add, gocheck.FitsTypeOf, &tsuru.ServiceAdd{})
+}
+
+func (s *S) TestServiceUpdateIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	update, ok := manager.Commands["service-update"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(update, gocheck.FitsTypeOf, &tsuru.ServiceUpdate{})
 }
 
 func (s *S) TestServiceRemoveIsRegistered(c *gocheck.C) {
@@ -146,6 +167,20 @@ func (s *S) TestServiceUnbindIsRegistered(c *gocheck.C) {
 	c.Assert(unbind, gocheck.FitsTypeOf, &tsuru.ServiceUnbind{})
 }
 
+func (s *S) TestServiceInstanceGrantIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	grant, ok := manager.Commands["service-instance-grant"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(grant, gocheck.FitsTypeOf, tsuru.ServiceInstanceGrant{})
+}
+
+func (s *S) TestServiceInstanceRevokeIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	revoke, ok := manager.Commands["service-instance-revoke"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(revoke, gocheck.FitsTypeOf, tsuru.ServiceInstanceRevoke{})
+}
+
 func (s *S) TestServiceDocIsRegistered(c *gocheck.C) {
 	manager := buildManager("tsuru")
 	doc, ok := manager.Commands["service-doc"]
@@ -215,3 +250,101 @@ func (s *S) TestSwapIsRegistered(c *gocheck.C) {
 	c.Assert(ok, gocheck.Equals, true)
 	c.Assert(cmd, gocheck.FitsTypeOf, swap{})
 }
+
+func (s *S) TestAppDeployListIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["app-deploy-list"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(list, gocheck.FitsTypeOf, &tsuru.AppDeployList{})
+}
+
+func (s *S) TestAppRollbackIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	rollback, ok := manager.Commands["app-rollback"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(rollback, gocheck.FitsTypeOf, &tsuru.AppRollback{})
+}
+
+func (s *S) TestAppBlueGreenEnableIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	enable, ok := manager.Commands["app-blue-green-enable"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(enable, gocheck.FitsTypeOf, &tsuru.AppBlueGreenEnable{})
+}
+
+func (s *S) TestAppPromoteIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	promote, ok := manager.Commands["app-promote"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(promote, gocheck.FitsTypeOf, &tsuru.AppPromote{})
+}
+
+func (s *S) TestAppAbortDeployIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	abort, ok := manager.Commands["app-abort-deploy"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(abort, gocheck.FitsTypeOf, &tsuru.AppAbortDeploy{})
+}
+
+func (s *S) TestAppAutoScaleSetIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	autoscale, ok := manager.Commands["app-autoscale-set"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(autoscale, gocheck.FitsTypeOf, &tsuru.AppAutoScaleSet{})
+}
+
+func (s *S) TestAppPlanChangeIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	change, ok := manager.Commands["app-plan-change"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(change, gocheck.FitsTypeOf, &tsuru.AppPlanChange{})
+}
+
+func (s *S) TestAppLogDrainAddIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	add, ok := manager.Commands["app-log-drain-add"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(add, gocheck.FitsTypeOf, &tsuru.AppLogDrainAdd{})
+}
+
+func (s *S) TestAppLogDrainRemoveIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	remove, ok := manager.Commands["app-log-drain-remove"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(remove, gocheck.FitsTypeOf, &tsuru.AppLogDrainRemove{})
+}
+
+func (s *S) TestAppLogDrainListIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["app-log-drain-list"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(list, gocheck.FitsTypeOf, &tsuru.AppLogDrainList{})
+}
+
+func (s *S) TestWebhookAddIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	add, ok := manager.Commands["webhook-add"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(add, gocheck.FitsTypeOf, &tsuru.WebhookAdd{})
+}
+
+func (s *S) TestWebhookRemoveIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	remove, ok := manager.Commands["webhook-remove"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(remove, gocheck.FitsTypeOf, &tsuru.WebhookRemove{})
+}
+
+func (s *S) TestWebhookListIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["webhook-list"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(list, gocheck.FitsTypeOf, &tsuru.WebhookList{})
+}
+
+func (s *S) TestPlanListIsRegistered(c *gocheck.C) {
+	manager := buildManager("tsuru")
+	list, ok := manager.Commands["plan-list"]
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(list, gocheck.FitsTypeOf, tsuru.PlanList{})
+}
@@ -52,6 +52,7 @@ func readToken() (string, error) {
 type ServiceModel struct {
 	Service   string
 	Instances []string
+	Plans     []string
 }
 
 func ShowServicesInstancesList(b []byte) ([]byte, error) {
@@ -66,8 +67,14 @@ func ShowServicesInstancesList(b []byte) ([]byte, error) {
 	table := NewTable()
 	table.Headers = Row([]string{"Services", "Instances"})
 	for _, s := range services {
-		insts := strings.Join(s.Instances, ", ")
-		r := Row([]string{s.Service, insts})
+		instances := make([]string, len(s.Instances))
+		for i, name := range s.Instances {
+			instances[i] = name
+			if i < len(s.Plans) && s.Plans[i] != "" {
+				instances[i] += " (" + s.Plans[i] + ")"
+			}
+		}
+		r := Row([]string{s.Service, strings.Join(instances, ", ")})
 		table.AddRow(r)
 	}
 	return table.Bytes(), nil
@@ -52,3 +52,16 @@ func (s *S) TestShowServicesInstancesList(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(string(result), gocheck.Equals, expected)
 }
+
+func (s *S) TestShowServicesInstancesListWithPlans(c *gocheck.C) {
+	expected := `+----------+--------------------------+
+| Services | Instances                |
++----------+--------------------------+
+| mysql    | mysql01 (small), mysql02 |
++----------+--------------------------+
+`
+	b := `[{"service": "mysql", "instances": ["mysql01", "mysql02"], "plans": ["small", ""]}]`
+	result, err := ShowServicesInstancesList([]byte(b))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(result), gocheck.Equals, expected)
+}
@@ -26,6 +26,24 @@ func collect(ticker <-chan time.Time) {
 	}
 }
 
+func autoScale(ticker <-chan time.Time) {
+	for _ = range ticker {
+		log.Print("Evaluating autoscale rules")
+		if err := app.AutoScale(); err != nil {
+			log.Printf("Failed to autoscale apps: %s.", err)
+		}
+	}
+}
+
+func enforceLogRetention(ticker <-chan time.Time) {
+	for _ = range ticker {
+		log.Print("Enforcing log retention policies")
+		if err := app.EnforceLogRetention(); err != nil {
+			log.Printf("Failed to enforce log retention policies: %s.", err)
+		}
+	}
+}
+
 func fatal(err error) {
 	stdlog.Fatal(err)
 }
@@ -60,6 +78,8 @@ func Run(dryMode bool) {
 
 		ticker := time.Tick(time.Minute)
 		fmt.Println("tsuru collector agent started...")
+		go autoScale(time.Tick(time.Minute))
+		go enforceLogRetention(time.Tick(time.Hour))
 		collect(ticker)
 	}
 }
@@ -134,9 +134,11 @@ func (s *Storage) Platforms() *mgo.Collection {
 func (s *Storage) Logs() *mgo.Collection {
 	appNameIndex := mgo.Index{Key: []string{"appname"}}
 	sourceIndex := mgo.Index{Key: []string{"source"}}
+	dateIndex := mgo.Index{Key: []string{"appname", "date"}}
 	c := s.Collection("logs")
 	c.EnsureIndex(appNameIndex)
 	c.EnsureIndex(sourceIndex)
+	c.EnsureIndex(dateIndex)
 	return c
 }
 
@@ -167,8 +169,26 @@ func (s *Storage) PasswordTokens() *mgo.Collection {
 	return s.Collection("password_tokens")
 }
 
+// PendingLogins returns the pending_logins collection from MongoDB, that
+// holds logins waiting for the two-factor authentication code.
+func (s *Storage) PendingLogins() *mgo.Collection {
+	return s.Collection("pending_logins")
+}
+
+// OAuthLogins returns the oauth_logins collection from MongoDB, that holds
+// OAuth logins waiting for the callback of the provider.
+func (s *Storage) OAuthLogins() *mgo.Collection {
+	return s.Collection("oauth_logins")
+}
+
+// UserActions returns the user_actions collection from MongoDB.
 func (s *Storage) UserActions() *mgo.Collection {
-	return s.Collection("user_actions")
+	dateIndex := mgo.Index{Key: []string{"-date", "-_id"}}
+	extraIndex := mgo.Index{Key: []string{"extra"}}
+	c := s.Collection("user_actions")
+	c.EnsureIndex(dateIndex)
+	c.EnsureIndex(extraIndex)
+	return c
 }
 
 // Teams returns the teams collection from MongoDB.
@@ -176,6 +196,35 @@ func (s *Storage) Teams() *mgo.Collection {
 	return s.Collection("teams")
 }
 
+// Deploys returns the deploys collection from MongoDB.
+func (s *Storage) Deploys() *mgo.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	c := s.Collection("deploys")
+	c.EnsureIndex(appIndex)
+	return c
+}
+
+// Webhooks returns the webhooks collection from MongoDB.
+func (s *Storage) Webhooks() *mgo.Collection {
+	teamIndex := mgo.Index{Key: []string{"team"}}
+	c := s.Collection("webhooks")
+	c.EnsureIndex(teamIndex)
+	return c
+}
+
+// UnitMetrics returns the unit_metrics collection from MongoDB.
+func (s *Storage) UnitMetrics() *mgo.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	c := s.Collection("unit_metrics")
+	c.EnsureIndex(appIndex)
+	return c
+}
+
+// Plans returns the plans collection from MongoDB.
+func (s *Storage) Plans() *mgo.Collection {
+	return s.Collection("plans")
+}
+
 // Quota returns the quota collection from MongoDB.
 func (s *Storage) Quota() *mgo.Collection {
 	userIndex := mgo.Index{Key: []string{"owner"}, Unique: true}
@@ -223,6 +223,22 @@ func (s *S) TestPasswordTokens(c *gocheck.C) {
 	c.Assert(tokens, gocheck.DeepEquals, tokensc)
 }
 
+func (s *S) TestPendingLogins(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1:27017", "tsuru_storage_test")
+	defer storage.session.Close()
+	logins := storage.PendingLogins()
+	loginsc := storage.Collection("pending_logins")
+	c.Assert(logins, gocheck.DeepEquals, loginsc)
+}
+
+func (s *S) TestOAuthLogins(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1:27017", "tsuru_storage_test")
+	defer storage.session.Close()
+	logins := storage.OAuthLogins()
+	loginsc := storage.Collection("oauth_logins")
+	c.Assert(logins, gocheck.DeepEquals, loginsc)
+}
+
 func (s *S) TestUserActions(c *gocheck.C) {
 	storage, _ := Open("127.0.0.1:27017", "tsuru_storage_test")
 	defer storage.session.Close()
@@ -231,6 +247,14 @@ func (s *S) TestUserActions(c *gocheck.C) {
 	c.Assert(actions, gocheck.DeepEquals, actionsc)
 }
 
+func (s *S) TestUserActionsIndexes(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1:27017", "tsuru_storage_test")
+	defer storage.session.Close()
+	actions := storage.UserActions()
+	c.Assert(actions, HasIndex, []string{"-date", "-_id"})
+	c.Assert(actions, HasIndex, []string{"extra"})
+}
+
 func (s *S) TestApps(c *gocheck.C) {
 	storage, _ := Open("127.0.0.1:27017", "tsuru_storage_test")
 	defer storage.session.Close()
@@ -309,6 +333,13 @@ func (s *S) TestLogSourceIndex(c *gocheck.C) {
 	c.Assert(logs, HasIndex, []string{"source"})
 }
 
+func (s *S) TestLogDateIndex(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1", "tsuru_storage_test")
+	defer storage.session.Close()
+	logs := storage.Logs()
+	c.Assert(logs, HasIndex, []string{"appname", "date"})
+}
+
 func (s *S) TestRetire(c *gocheck.C) {
 	defer func() {
 		if r := recover(); !c.Failed() && r == nil {
@@ -335,3 +366,56 @@ func (s *S) TestRetire(c *gocheck.C) {
 	c.Check(ok, gocheck.Equals, false)
 	sess.s.Ping()
 }
+
+func (s *S) TestDeploys(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1", "tsuru_storage_test")
+	defer storage.session.Close()
+	deploys := storage.Deploys()
+	deploysc := storage.Collection("deploys")
+	c.Assert(deploys, gocheck.DeepEquals, deploysc)
+}
+
+func (s *S) TestDeploysAppIndex(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1", "tsuru_storage_test")
+	defer storage.session.Close()
+	deploys := storage.Deploys()
+	c.Assert(deploys, HasIndex, []string{"app"})
+}
+
+func (s *S) TestPlans(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1", "tsuru_storage_test")
+	defer storage.session.Close()
+	plans := storage.Plans()
+	plansc := storage.Collection("plans")
+	c.Assert(plans, gocheck.DeepEquals, plansc)
+}
+
+func (s *S) TestUnitMetrics(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1", "tsuru_storage_test")
+	defer storage.session.Close()
+	metrics := storage.UnitMetrics()
+	metricsc := storage.Collection("unit_metrics")
+	c.Assert(metrics, gocheck.DeepEquals, metricsc)
+}
+
+func (s *S) TestUnitMetricsAppIndex(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1", "tsuru_storage_test")
+	defer storage.session.Close()
+	metrics := storage.UnitMetrics()
+	c.Assert(metrics, HasIndex, []string{"app"})
+}
+
+func (s *S) TestWebhooks(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1", "tsuru_storage_test")
+	defer storage.session.Close()
+	webhooks := storage.Webhooks()
+	webhooksc := storage.Collection("webhooks")
+	c.Assert(webhooks, gocheck.DeepEquals, webhooksc)
+}
+
+func (s *S) TestWebhooksTeamIndex(c *gocheck.C) {
+	storage, _ := Open("127.0.0.1", "tsuru_storage_test")
+	defer storage.session.Close()
+	webhooks := storage.Webhooks()
+	c.Assert(webhooks, HasIndex, []string{"team"})
+}
@@ -65,6 +65,34 @@ var insertContainer = action.Action{
 	},
 }
 
+var loadHealth = action.Action{
+	Name: "load-health",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		c := ctx.Previous.(container)
+		c.Healthcheck = loadHealthcheck(&c)
+		return c, nil
+	},
+	Backward: func(ctx action.BWContext) {
+	},
+}
+
+var checkHealth = action.Action{
+	Name: "check-health",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		c := ctx.Previous.(container)
+		if c.Healthcheck != nil {
+			log.Printf("checking health of container %s", c.ID)
+			if err := c.Healthcheck.check(&c); err != nil {
+				log.Printf("error on check health of container %s - %s", c.ID, err)
+				return nil, err
+			}
+		}
+		return c, nil
+	},
+	Backward: func(ctx action.BWContext) {
+	},
+}
+
 var addRoute = action.Action{
 	Name: "add-route",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -93,6 +93,22 @@ func (s *S) TestAddRouteForward(c *gocheck.C) {
 	c.Assert(cont, gocheck.FitsTypeOf, container{})
 }
 
+func (s *S) TestLoadHealthName(c *gocheck.C) {
+	c.Assert(loadHealth.Name, gocheck.Equals, "load-health")
+}
+
+func (s *S) TestCheckHealthName(c *gocheck.C) {
+	c.Assert(checkHealth.Name, gocheck.Equals, "check-health")
+}
+
+func (s *S) TestCheckHealthForwardWithoutHealthcheck(c *gocheck.C) {
+	cont := container{ID: "ble", AppName: "myapp"}
+	context := action.FWContext{Previous: cont}
+	r, err := checkHealth.Forward(context)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(r, gocheck.DeepEquals, cont)
+}
+
 func (s *S) TestSetNetworkInfoName(c *gocheck.C) {
 	c.Assert(setNetworkInfo.Name, gocheck.Equals, "set-network-info")
 }
@@ -24,7 +24,7 @@ func deployCmds(app provision.App, version string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	appRepo := repository.ReadOnlyURL(app.GetName())
+	appRepo := repository.ReadOnlyURL(provision.RepositoryName(app))
 	user, err := config.GetString("docker:ssh:user")
 	if err != nil {
 		return nil, err
@@ -25,6 +25,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
 var (
@@ -35,6 +36,20 @@ var (
 
 const maxTry = 5
 
+// agentTimeout is the maximum amount of time that the agent running in a host
+// may take to accept a connection and to answer a request that removes a
+// container, so removing containers of unreachable hosts doesn't hang.
+var agentTimeout = 10 * time.Second
+
+var agentClient = &http.Client{
+	Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, agentTimeout)
+		},
+		ResponseHeaderTimeout: agentTimeout,
+	},
+}
+
 var clusterNodes map[string]string
 
 func dockerCluster() *cluster.Cluster {
@@ -60,7 +75,8 @@ func dockerCluster() *cluster.Cluster {
 			var scheduler segregatedScheduler
 			dCluster, _ = cluster.New(&scheduler, nodes...)
 		} else {
-			dCluster, _ = cluster.New(nil, nodes...)
+			var scheduler serversScheduler
+			dCluster, _ = cluster.New(&scheduler, nodes...)
 		}
 		if redisServer, err := config.GetString("docker:scheduler:redis-server"); err == nil {
 			prefix, _ := config.GetString("docker:scheduler:redis-prefix")
@@ -97,8 +113,13 @@ func getPort() (string, error) {
 }
 
 func getHostAddr(hostID string) string {
-	fullAddress := clusterNodes[hostID]
-	url, _ := url.Parse(fullAddress)
+	return hostFromURL(clusterNodes[hostID])
+}
+
+// hostFromURL returns the host part of the address of a docker node, in the
+// format used in the HostAddr field of containers.
+func hostFromURL(address string) string {
+	url, _ := url.Parse(address)
 	host, _, _ := net.SplitHostPort(url.Host)
 	return host
 }
@@ -114,6 +135,20 @@ type container struct {
 	Status   string
 	Version  string
 	Image    string
+	Memory   int64
+
+	// Healthcheck is the health check declared by the app when the
+	// container was started, Failures is the number of consecutive failed
+	// probes and LastHealthcheck is the time of the last probe.
+	Healthcheck     *healthcheck
+	Failures        int
+	LastHealthcheck time.Time
+}
+
+// unhealthy reports whether the container failed enough consecutive health
+// check probes to be considered down.
+func (c *container) unhealthy() bool {
+	return c.Healthcheck != nil && c.Failures >= c.Healthcheck.threshold()
 }
 
 func (c *container) getAddress() string {
@@ -139,6 +174,11 @@ func newContainer(app provision.App, imageId string, cmds []string) (container,
 		AttachStdout: false,
 		AttachStderr: false,
 	}
+	if limiter, ok := app.(provision.ResourceLimiter); ok {
+		config.Memory = limiter.GetMemory()
+		config.MemorySwap = limiter.GetSwap()
+		config.CpuShares = int64(limiter.GetCpuShare())
+	}
 	hostID, c, err := dockerCluster().CreateContainer(&config)
 	if err != nil {
 		log.Printf("error on creating container in docker %s - %s", cont.AppName, err.Error())
@@ -147,6 +187,7 @@ func newContainer(app provision.App, imageId string, cmds []string) (container,
 	cont.ID = c.ID
 	cont.Port = port
 	cont.HostAddr = getHostAddr(hostID)
+	cont.Memory = config.Memory
 	return cont, nil
 }
 
@@ -221,6 +262,7 @@ func deploy(app provision.App, version string, w io.Writer) (string, error) {
 		log.Printf("error on get logs for container %s - %s", c.ID, err.Error())
 		return "", err
 	}
+	c.Version = version
 	imageId, err = c.commit()
 	if err != nil {
 		log.Printf("error on commit container %s - %s", c.ID, err.Error())
@@ -230,12 +272,26 @@ func deploy(app provision.App, version string, w io.Writer) (string, error) {
 	return imageId, nil
 }
 
+// start starts a new container for the app, based on the given image, and
+// adds it to the router.
 func start(app provision.App, imageId string, w io.Writer) (*container, error) {
+	actions := []*action.Action{&createContainer, &startContainer, &setNetworkInfo, &insertContainer, &loadHealth, &addRoute}
+	return runStart(app, imageId, actions)
+}
+
+// deployStart is like start, but the container is added to the router only
+// after passing the health check declared by the app. It's used by deploys,
+// that must not route requests to containers running a broken version.
+func deployStart(app provision.App, imageId string, w io.Writer) (*container, error) {
+	actions := []*action.Action{&createContainer, &startContainer, &setNetworkInfo, &insertContainer, &loadHealth, &checkHealth, &addRoute}
+	return runStart(app, imageId, actions)
+}
+
+func runStart(app provision.App, imageId string, actions []*action.Action) (*container, error) {
 	commands, err := runCmds()
 	if err != nil {
 		return nil, err
 	}
-	actions := []*action.Action{&createContainer, &startContainer, &setNetworkInfo, &insertContainer, &addRoute}
 	pipeline := action.NewPipeline(actions...)
 	err = pipeline.Execute(app, imageId, commands)
 	if err != nil {
@@ -281,7 +337,7 @@ func (c *container) remove() error {
 func (c *container) removeHost() error {
 	url := fmt.Sprintf("http://%s:%d/container/%s", c.HostAddr, c.agentPort(), c.IP)
 	request, _ := http.NewRequest("DELETE", url, nil)
-	resp, err := http.DefaultClient.Do(request)
+	resp, err := agentClient.Do(request)
 	if err != nil {
 		return err
 	}
@@ -316,11 +372,12 @@ func (c *container) agentPort() int {
 }
 
 // commit commits an image in docker based in the container
-// and returns the image repository.
+// and returns the image repository. When the container has a version, the
+// image is tagged with it, and the returned name includes the tag.
 func (c *container) commit() (string, error) {
 	log.Printf("commiting container %s", c.ID)
 	repository := assembleImageName(c.AppName)
-	opts := dclient.CommitContainerOptions{Container: c.ID, Repository: repository}
+	opts := dclient.CommitContainerOptions{Container: c.ID, Repository: repository, Tag: c.Version}
 	image, err := dockerCluster().CommitContainer(opts)
 	if err != nil {
 		log.Printf("Could not commit docker image: %s", err.Error())
@@ -328,6 +385,9 @@ func (c *container) commit() (string, error) {
 	}
 	log.Printf("image %s generated from container %s", image.ID, c.ID)
 	replicateImage(repository)
+	if c.Version != "" {
+		return repository + ":" + c.Version, nil
+	}
 	return repository, nil
 }
 
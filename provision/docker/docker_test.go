@@ -59,6 +59,28 @@ func (s *S) TestNewContainer(c *gocheck.C) {
 	c.Assert(cont.Port, gocheck.Equals, port)
 }
 
+func (s *S) TestNewContainerWithResourceLimits(c *gocheck.C) {
+	oldClusterNodes := clusterNodes
+	clusterNodes = map[string]string{"server": s.server.URL()}
+	defer func() { clusterNodes = oldClusterNodes }()
+	err := newImage("tsuru/python", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	app := testing.NewFakeApp("app-name", "python", 1)
+	app.Memory = 512 << 20
+	app.Swap = 1024 << 20
+	app.CpuShare = 100
+	rtesting.FakeRouter.AddBackend(app.GetName())
+	defer rtesting.FakeRouter.RemoveBackend(app.GetName())
+	cont, err := newContainer(app, getImage(app), []string{"docker", "run"})
+	c.Assert(err, gocheck.IsNil)
+	defer cont.remove()
+	dockerContainer, err := dockerCluster().InspectContainer(cont.ID)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dockerContainer.Config.Memory, gocheck.Equals, int64(512<<20))
+	c.Assert(dockerContainer.Config.MemorySwap, gocheck.Equals, int64(1024<<20))
+	c.Assert(dockerContainer.Config.CpuShares, gocheck.Equals, int64(100))
+}
+
 func (s *S) TestGetSSHCommandsDefaultSSHDPath(c *gocheck.C) {
 	rfs := ftesting.RecordingFs{}
 	f, err := rfs.Create("/opt/me/id_dsa.pub")
@@ -434,6 +456,23 @@ func (s *S) TestContainerCommit(c *gocheck.C) {
 	c.Assert(imageId, gocheck.Equals, repository)
 }
 
+func (s *S) TestContainerCommitWithVersion(c *gocheck.C) {
+	_, cleanup := startSSHAgentServer("")
+	defer cleanup()
+	err := newImage("tsuru/python", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	cont, err := s.newContainer()
+	c.Assert(err, gocheck.IsNil)
+	defer cont.remove()
+	defer rtesting.FakeRouter.RemoveBackend(cont.AppName)
+	cont.Version = "a345fe"
+	imageId, err := cont.commit()
+	c.Assert(err, gocheck.IsNil)
+	repoNamespace, _ := config.GetString("docker:repository-namespace")
+	repository := repoNamespace + "/" + cont.AppName
+	c.Assert(imageId, gocheck.Equals, repository+":a345fe")
+}
+
 func (s *S) TestRemoveImage(c *gocheck.C) {
 	err := newImage("tsuru/python", s.server.URL())
 	c.Assert(err, gocheck.IsNil)
@@ -568,7 +607,7 @@ func (s *S) TestContainerLogs(c *gocheck.C) {
 
 func (s *S) TestDockerCluster(c *gocheck.C) {
 	config.Set("docker:servers", []string{"http://localhost:4243", "http://10.10.10.10:4243"})
-	expected, _ := cluster.New(nil,
+	expected, _ := cluster.New(&serversScheduler{},
 		cluster.Node{ID: "server0", Address: "http://localhost:4243"},
 		cluster.Node{ID: "server1", Address: "http://10.10.10.10:4243"},
 	)
@@ -5,14 +5,36 @@
 package docker
 
 import (
+	"bytes"
+	"fmt"
 	dockerClient "github.com/fsouza/go-dockerclient"
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/app"
+	"github.com/globocom/tsuru/db"
 	"github.com/globocom/tsuru/heal"
 	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/queue"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
 	"strings"
+	"time"
 )
 
+// pingTimeout is the maximum amount of time that the node healer waits for
+// a docker node to respond.
+var pingTimeout = 5 * time.Second
+
+// defaultNodeMaxFailures is the number of consecutive failed pings after which
+// a node is disabled and its containers are relocated, when the
+// "docker:healer:max-failures" setting is not defined.
+const defaultNodeMaxFailures = 3
+
 func init() {
 	heal.Register("docker", "container", ContainerHealer{})
+	heal.Register("docker", "node", NodeHealer{})
+	heal.Register("docker", "healthcheck", HealthcheckHealer{})
 }
 
 type ContainerHealer struct{}
@@ -83,3 +105,269 @@ func (h ContainerHealer) unhealthyRunningContainers(containers []container) []co
 	}
 	return unhealthy
 }
+
+// NodeHealer checks the health of docker nodes, relocating the containers of
+// unreachable nodes.
+//
+// Nodes are taken from the docker:servers configuration entry and from the
+// scheduler collection. Nodes that don't respond for nodeMaxFailures
+// consecutive times are disabled, so no new containers are created in them,
+// and enabled again as soon as they respond.
+type NodeHealer struct{}
+
+func (h NodeHealer) Heal() error {
+	nodes, err := h.nodes()
+	if err != nil {
+		return err
+	}
+	maxFailures := nodeMaxFailures()
+	for i := range nodes {
+		n := &nodes[i]
+		if err := pingNode(n.Address); err != nil {
+			log.Printf("Node %s (%s) is unreachable (%d/%d): %s", n.ID, n.Address, n.Failures+1, maxFailures, err)
+			if err := n.setFailures(n.Failures + 1); err != nil {
+				log.Printf("Failed to record the failure of node %s: %s", n.ID, err)
+				continue
+			}
+			if n.Failures < maxFailures {
+				continue
+			}
+			if !n.Disabled {
+				if err := n.setDisabled(true); err != nil {
+					log.Printf("Failed to disable node %s: %s", n.ID, err)
+				}
+			}
+			h.relocateContainers(n.node)
+			continue
+		}
+		if n.Disabled {
+			log.Printf("Node %s (%s) is reachable again, enabling it", n.ID, n.Address)
+			if err := n.setDisabled(false); err != nil {
+				log.Printf("Failed to enable node %s: %s", n.ID, err)
+			}
+		}
+		if n.Failures > 0 {
+			if err := n.setFailures(0); err != nil {
+				log.Printf("Failed to reset the failures of node %s: %s", n.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// nodeMaxFailures returns the number of consecutive failed pings after which
+// a node is disabled, as defined by the "docker:healer:max-failures" setting.
+func nodeMaxFailures() int {
+	n, _ := config.GetInt("docker:healer:max-failures")
+	if n < 1 {
+		return defaultNodeMaxFailures
+	}
+	return n
+}
+
+type healerNode struct {
+	node
+	registered bool
+}
+
+func (n *healerNode) setDisabled(disabled bool) error {
+	if n.registered {
+		return setNodeDisabled(n.ID, disabled)
+	}
+	return setServerDisabled(n.node, disabled)
+}
+
+// setFailures stores the number of consecutive failed pings of the node, so
+// the count is kept between runs of the healer.
+func (n *healerNode) setFailures(failures int) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	update := bson.M{"failures": failures}
+	if n.registered {
+		err = conn.Collection(schedulerCollection).UpdateId(n.ID, bson.M{"$set": update})
+	} else {
+		update["address"] = n.Address
+		_, err = conn.Collection(serversCollection).UpsertId(n.ID, bson.M{"$set": update})
+	}
+	if err != nil {
+		return err
+	}
+	n.Failures = failures
+	return nil
+}
+
+// nodes returns all nodes known by tsuru. Nodes from the scheduler
+// collection are flagged as registered.
+func (NodeHealer) nodes() ([]healerNode, error) {
+	var nodes []healerNode
+	servers, err := listServers()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range servers {
+		nodes = append(nodes, healerNode{node: n})
+	}
+	registered, err := listNodesInTheScheduler()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range registered {
+		nodes = append(nodes, healerNode{node: n, registered: true})
+	}
+	return nodes, nil
+}
+
+// relocateContainers recreates the containers of the given node in other
+// nodes, using the same pipeline used for adding units. Once the replacement
+// is started, the record of the old container is removed from the database,
+// without reaching the unreachable node.
+func (NodeHealer) relocateContainers(n node) {
+	var containers []container
+	coll := collection()
+	err := coll.Find(bson.M{"hostaddr": hostFromURL(n.Address)}).All(&containers)
+	coll.Database.Session.Close()
+	if err != nil {
+		log.Printf("Failed to list containers of node %s: %s", n.ID, err)
+		return
+	}
+	for _, c := range containers {
+		a := app.App{Name: c.AppName}
+		if err := a.Get(); err != nil {
+			log.Printf("Failed to get app %s for relocating container %s: %s", c.AppName, c.ID, err)
+			continue
+		}
+		a.Log(fmt.Sprintf("node %s is unreachable, relocating unit %s", n.ID, c.ID), "tsuru")
+		if r, err := getRouter(); err == nil {
+			if err := r.RemoveRoute(c.AppName, c.getAddress()); err != nil {
+				log.Printf("Failed to remove route of container %s: %s", c.ID, err)
+			}
+		}
+		if err := startReplacement(&a, c); err != nil {
+			log.Printf("Failed to relocate container %s: %s", c.ID, err)
+			a.Log(fmt.Sprintf("failed to relocate unit %s: %s", c.ID, err), "tsuru")
+			continue
+		}
+		coll := collection()
+		if err := coll.RemoveId(c.ID); err != nil {
+			log.Printf("Failed to remove container %s from database: %s", c.ID, err)
+		}
+		coll.Database.Session.Close()
+	}
+}
+
+// replaceUnit starts a new container for the app, using the image of the
+// given container, and removes the given container once the new one is
+// running.
+func replaceUnit(a *app.App, c container) error {
+	if err := startReplacement(a, c); err != nil {
+		return err
+	}
+	if a.RemoveUnit(c.ID) != nil {
+		removeContainer(&c)
+	}
+	return nil
+}
+
+// startReplacement starts a new container for the app, using the image of
+// the given container.
+func startReplacement(a *app.App, c container) error {
+	image := c.Image
+	if image == "" {
+		image = getImage(a)
+	}
+	writer := app.LogWriter{App: a, Writer: ioutil.Discard}
+	started, err := start(a, image, &writer)
+	if err != nil {
+		return err
+	}
+	msg := queue.Message{Action: app.BindService, Args: []string{a.GetName(), started.ID}}
+	go app.Enqueue(msg)
+	return nil
+}
+
+// HealthcheckHealer probes the health check declared by apps in the app.yaml
+// file, in the interval declared by the app. Units that fail the health check
+// enough consecutive times are restarted, and replaced if they keep failing
+// after the restart. Every action is recorded in the app log.
+type HealthcheckHealer struct{}
+
+func (h HealthcheckHealer) Heal() error {
+	var containers []container
+	coll := collection()
+	defer coll.Database.Session.Close()
+	query := bson.M{"status": "running", "healthcheck": bson.M{"$ne": nil}}
+	if err := coll.Find(query).All(&containers); err != nil {
+		return err
+	}
+	for i := range containers {
+		c := &containers[i]
+		if time.Since(c.LastHealthcheck) < c.Healthcheck.interval() {
+			continue
+		}
+		if err := h.checkContainer(c); err != nil {
+			log.Printf("Failed to heal container %s: %s", c.ID, err)
+		}
+	}
+	return nil
+}
+
+// checkContainer probes the health check of the container once, taking
+// action when the number of consecutive failures reaches the threshold.
+func (HealthcheckHealer) checkContainer(c *container) error {
+	c.LastHealthcheck = time.Now()
+	probeErr := c.Healthcheck.probe(c)
+	if probeErr == nil {
+		c.Failures = 0
+	} else {
+		c.Failures++
+	}
+	threshold := c.Healthcheck.threshold()
+	if c.Failures == threshold || c.Failures >= 2*threshold {
+		a := app.App{Name: c.AppName}
+		if err := a.Get(); err != nil {
+			return err
+		}
+		if c.Failures == threshold {
+			a.Log(fmt.Sprintf("healthcheck: unit %s failed %d consecutive health checks (%s), restarting it", c.ID, c.Failures, probeErr), "tsuru")
+			var buf bytes.Buffer
+			if err := c.ssh(&buf, &buf, "/var/lib/tsuru/restart"); err != nil {
+				a.Log(fmt.Sprintf("healthcheck: failed to restart unit %s: %s", c.ID, err), "tsuru")
+			}
+		} else {
+			a.Log(fmt.Sprintf("healthcheck: unit %s is still failing after restart (%s), replacing it", c.ID, probeErr), "tsuru")
+			if err := replaceUnit(&a, *c); err != nil {
+				a.Log(fmt.Sprintf("healthcheck: failed to replace unit %s: %s", c.ID, err), "tsuru")
+				return err
+			}
+			return nil
+		}
+	}
+	coll := collection()
+	defer coll.Database.Session.Close()
+	update := bson.M{"failures": c.Failures, "lasthealthcheck": c.LastHealthcheck}
+	return coll.UpdateId(c.ID, bson.M{"$set": update})
+}
+
+// pingNode checks whether the docker API in the given address is reachable.
+func pingNode(address string) error {
+	client := http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, pingTimeout)
+			},
+			ResponseHeaderTimeout: pingTimeout,
+		},
+	}
+	resp, err := client.Get(strings.TrimRight(address, "/") + "/version")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
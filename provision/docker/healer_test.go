@@ -5,8 +5,17 @@
 package docker
 
 import (
+	"github.com/globocom/config"
+	"github.com/globocom/docker-cluster/cluster"
+	"github.com/globocom/tsuru/app"
 	"github.com/globocom/tsuru/heal"
+	"github.com/globocom/tsuru/provision"
+	rtesting "github.com/globocom/tsuru/router/testing"
+	"labix.org/v2/mgo/bson"
 	"launchpad.net/gocheck"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 )
 
 type HealerSuite struct {
@@ -36,6 +45,12 @@ func (s *HealerSuite) TestContainerHealerShouldBeRegistered(c *gocheck.C) {
 	c.Assert(h, gocheck.FitsTypeOf, ContainerHealer{})
 }
 
+func (s *HealerSuite) TestNodeHealerShouldBeRegistered(c *gocheck.C) {
+	h, err := heal.Get("docker", "node")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(h, gocheck.FitsTypeOf, NodeHealer{})
+}
+
 func (s *HealerSuite) TestContainerHealerImplementsHealInterface(c *gocheck.C) {
 	var h interface{}
 	h = &ContainerHealer{}
@@ -116,3 +131,269 @@ func (s *HealerSuite) TestUnhealthyRunningContainers(c *gocheck.C) {
 	unhealthy := s.healer.unhealthyRunningContainers(containers)
 	c.Assert(unhealthy, gocheck.DeepEquals, expected)
 }
+
+func (s *S) TestPingNode(c *gocheck.C) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer server.Close()
+	err := pingNode(server.URL)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(path, gocheck.Equals, "/version")
+	err = pingNode("http://localhost:1")
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestPingNodeUnexpectedStatus(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	err := pingNode(server.URL)
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *S) TestNodeHealerDisablesUnreachableNodes(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	coll := s.conn.Collection(schedulerCollection)
+	err := coll.Insert(
+		node{ID: "alive", Address: server.URL},
+		node{ID: "back", Address: server.URL, Disabled: true},
+		node{ID: "dead", Address: "http://localhost:1"},
+	)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveAll(bson.M{"_id": bson.M{"$in": []string{"alive", "back", "dead"}}})
+	for i := 0; i < defaultNodeMaxFailures; i++ {
+		err = NodeHealer{}.Heal()
+		c.Assert(err, gocheck.IsNil)
+	}
+	var nodes []node
+	err = coll.Find(nil).Sort("_id").All(&nodes)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(nodes, gocheck.DeepEquals, []node{
+		{ID: "alive", Address: server.URL},
+		{ID: "back", Address: server.URL},
+		{ID: "dead", Address: "http://localhost:1", Disabled: true, Failures: defaultNodeMaxFailures},
+	})
+}
+
+func (s *S) TestNodeHealerDoesNotRelocateContainersAfterOneFailure(c *gocheck.C) {
+	a := app.App{Name: "lost", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	cont := container{ID: "c-lost", AppName: a.Name, Image: "tsuru/python", HostAddr: "localhost", HostPort: "49153"}
+	err = s.conn.Collection(s.collName).Insert(cont)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Collection(s.collName).RemoveAll(bson.M{"appname": a.Name})
+	coll := s.conn.Collection(schedulerCollection)
+	err = coll.Insert(node{ID: "dead", Address: "http://localhost:1"})
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveId("dead")
+	config.Set("docker:healer:max-failures", 2)
+	defer config.Unset("docker:healer:max-failures")
+	err = NodeHealer{}.Heal()
+	c.Assert(err, gocheck.IsNil)
+	var n node
+	err = coll.FindId("dead").One(&n)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n.Disabled, gocheck.Equals, false)
+	c.Assert(n.Failures, gocheck.Equals, 1)
+	query := bson.M{"appname": a.Name, "message": bson.RegEx{Pattern: "relocating unit c-lost"}}
+	count, err := s.conn.Logs().Find(query).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 0)
+	err = NodeHealer{}.Heal()
+	c.Assert(err, gocheck.IsNil)
+	err = coll.FindId("dead").One(&n)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n.Disabled, gocheck.Equals, true)
+	count, err = s.conn.Logs().Find(query).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 1)
+}
+
+func (s *S) TestNodeHealerResetsTheFailuresOfReachableNodes(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	coll := s.conn.Collection(schedulerCollection)
+	err := coll.Insert(node{ID: "flaky", Address: server.URL, Failures: 2})
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveId("flaky")
+	err = NodeHealer{}.Heal()
+	c.Assert(err, gocheck.IsNil)
+	var n node
+	err = coll.FindId("flaky").One(&n)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.DeepEquals, node{ID: "flaky", Address: server.URL})
+}
+
+func (s *S) TestNodeHealerDisablesUnreachableServers(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	down := true
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer flaky.Close()
+	config.Set("docker:servers", []string{server.URL, flaky.URL})
+	defer config.Unset("docker:servers")
+	config.Set("docker:healer:max-failures", 1)
+	defer config.Unset("docker:healer:max-failures")
+	defer s.conn.Collection(serversCollection).RemoveAll(nil)
+	err := NodeHealer{}.Heal()
+	c.Assert(err, gocheck.IsNil)
+	nodes, err := listServers()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(nodes, gocheck.DeepEquals, []node{
+		{ID: "server0", Address: server.URL},
+		{ID: "server1", Address: flaky.URL, Disabled: true, Failures: 1},
+	})
+	enabled, err := serversScheduler{}.Nodes()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(enabled, gocheck.DeepEquals, []cluster.Node{{ID: "server0", Address: server.URL}})
+	down = false
+	err = NodeHealer{}.Heal()
+	c.Assert(err, gocheck.IsNil)
+	nodes, err = listServers()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(nodes[1].Disabled, gocheck.Equals, false)
+	c.Assert(nodes[1].Failures, gocheck.Equals, 0)
+}
+
+func (s *S) TestNodeHealerRelocateContainers(c *gocheck.C) {
+	err := newImage("tsuru/python", s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	a := app.App{Name: "lost", Platform: "python"}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	old := container{ID: "c-lost", AppName: a.Name, Image: "tsuru/python", HostAddr: "localhost", HostPort: "49153"}
+	coll := s.conn.Collection(s.collName)
+	err = coll.Insert(old)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveAll(bson.M{"appname": a.Name})
+	rtesting.FakeRouter.AddBackend(a.Name)
+	defer rtesting.FakeRouter.RemoveBackend(a.Name)
+	rtesting.FakeRouter.AddRoute(a.Name, old.getAddress())
+	NodeHealer{}.relocateContainers(node{ID: "dead", Address: "http://localhost:1"})
+	var containers []container
+	err = coll.Find(bson.M{"appname": a.Name}).All(&containers)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 1)
+	c.Assert(containers[0].ID, gocheck.Not(gocheck.Equals), old.ID)
+	c.Assert(containers[0].HostAddr, gocheck.Equals, "127.0.0.1")
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, old.getAddress()), gocheck.Equals, false)
+	c.Assert(rtesting.FakeRouter.HasRoute(a.Name, containers[0].getAddress()), gocheck.Equals, true)
+}
+
+func (s *S) TestHealthcheckHealerShouldBeRegistered(c *gocheck.C) {
+	h, err := heal.Get("docker", "healthcheck")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(h, gocheck.FitsTypeOf, HealthcheckHealer{})
+}
+
+func (s *S) TestHealthcheckHealerCountsFailures(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	cont := healthcheckContainer(server.URL)
+	cont.AppName = "sick"
+	cont.Status = "running"
+	cont.Healthcheck = &healthcheck{Path: "/status"}
+	coll := s.conn.Collection(s.collName)
+	err := coll.Insert(cont)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveId(cont.ID)
+	err = HealthcheckHealer{}.Heal()
+	c.Assert(err, gocheck.IsNil)
+	var stored container
+	err = coll.FindId(cont.ID).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Failures, gocheck.Equals, 1)
+	c.Assert(stored.LastHealthcheck.IsZero(), gocheck.Equals, false)
+	err = HealthcheckHealer{}.Heal()
+	c.Assert(err, gocheck.IsNil)
+	err = coll.FindId(cont.ID).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Failures, gocheck.Equals, 1)
+}
+
+func (s *S) TestHealthcheckHealerResetsFailures(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	cont := healthcheckContainer(server.URL)
+	cont.Status = "running"
+	cont.Healthcheck = &healthcheck{Path: "/status"}
+	cont.Failures = 2
+	coll := s.conn.Collection(s.collName)
+	err := coll.Insert(cont)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveId(cont.ID)
+	err = HealthcheckHealer{}.checkContainer(&cont)
+	c.Assert(err, gocheck.IsNil)
+	var stored container
+	err = coll.FindId(cont.ID).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Failures, gocheck.Equals, 0)
+}
+
+func (s *S) TestHealthcheckHealerRestartsUnitOnThreshold(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	a := app.App{Name: "sick", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Logs().RemoveAll(bson.M{"appname": a.Name})
+	h, cleanup := startSSHAgentServer("")
+	defer cleanup()
+	cont := healthcheckContainer(server.URL)
+	cont.AppName = a.Name
+	cont.IP = "10.10.10.10"
+	cont.Status = "running"
+	cont.Healthcheck = &healthcheck{Path: "/status", Threshold: 2}
+	cont.Failures = 1
+	coll := s.conn.Collection(s.collName)
+	err = coll.Insert(cont)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveId(cont.ID)
+	err = HealthcheckHealer{}.checkContainer(&cont)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(h.bodies, gocheck.HasLen, 1)
+	c.Assert(h.bodies[0].Cmd, gocheck.Equals, "/var/lib/tsuru/restart")
+	logs, err := a.LastLogs(1, "tsuru")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(logs, gocheck.HasLen, 1)
+	c.Assert(logs[0].Message, gocheck.Equals, "healthcheck: unit c-01 failed 2 consecutive health checks (unexpected status 500), restarting it")
+	var stored container
+	err = coll.FindId(cont.ID).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Failures, gocheck.Equals, 2)
+}
+
+func (s *S) TestCollectUnitReportsUnhealthyContainersAsDown(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	cont := healthcheckContainer(server.URL)
+	cont.AppName = "sick"
+	cont.Status = "running"
+	cont.Healthcheck = &healthcheck{Path: "/status"}
+	cont.Failures = 3
+	units := make(chan provision.Unit, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	collectUnit(cont, units, &wg)
+	wg.Wait()
+	unit := <-units
+	c.Assert(unit.Status, gocheck.Equals, provision.StatusDown)
+}
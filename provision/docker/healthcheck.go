@@ -0,0 +1,146 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/repository"
+	"launchpad.net/goyaml"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// healthcheckInterval is the time between two consecutive requests to the
+// health check path of a container.
+var healthcheckInterval = time.Second
+
+// probeTimeout is the maximum amount of time that a single request to the
+// health check path of a container may take.
+var probeTimeout = 10 * time.Second
+
+const (
+	defaultHealthcheckTimeout   = 60
+	defaultHealthcheckInterval  = 30
+	defaultHealthcheckThreshold = 3
+)
+
+// healthcheck represents the health check declared by the app in the app.yaml
+// file:
+//
+//     healthcheck:
+//       path: /healthcheck
+//       status: 200
+//       timeout: 60
+//       interval: 30
+//       threshold: 3
+//
+// Path is mandatory, Status defaults to 200 and Timeout, the amount of
+// seconds that tsuru waits for the container to become healthy, defaults to
+// 60.
+//
+// Once the container is running, the health check is probed every Interval
+// seconds (30 by default). After Threshold consecutive failures (3 by
+// default), the unit is restarted, and if it keeps failing for another
+// Threshold probes, it is replaced by a new unit.
+type healthcheck struct {
+	Path      string
+	Status    int
+	Timeout   int
+	Interval  int
+	Threshold int
+}
+
+// loadHealthcheck reads the health check declared in the app.yaml file
+// available in the given container. It returns nil if the app does not declare
+// any health check.
+func loadHealthcheck(c *container) *healthcheck {
+	repoPath, err := repository.GetPath()
+	if err != nil {
+		log.Printf("Failed to get the repository path: %s", err)
+		return nil
+	}
+	for _, name := range []string{"app.yaml", "app.yml"} {
+		var buf bytes.Buffer
+		if err := c.ssh(&buf, &buf, "cat", path.Join(repoPath, name)); err != nil {
+			continue
+		}
+		var conf struct{ Healthcheck healthcheck }
+		if err := goyaml.Unmarshal(buf.Bytes(), &conf); err != nil {
+			continue
+		}
+		if conf.Healthcheck.Path != "" {
+			hc := conf.Healthcheck
+			return &hc
+		}
+	}
+	return nil
+}
+
+// check waits until the container answers the health check path with the
+// expected status, returning an error if it does not happen before the
+// timeout.
+func (h *healthcheck) check(c *container) error {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = defaultHealthcheckTimeout
+	}
+	var lastErr error
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for time.Now().Before(deadline) {
+		err := h.probe(c)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		time.Sleep(healthcheckInterval)
+	}
+	return fmt.Errorf("Health check for container %s failed: %s.", c.ID, lastErr)
+}
+
+// probe sends a single request to the health check path of the container,
+// returning an error if the container does not answer with the expected
+// status.
+func (h *healthcheck) probe(c *container) error {
+	status := h.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	client := http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, probeTimeout)
+			},
+			ResponseHeaderTimeout: probeTimeout,
+		},
+	}
+	resp, err := client.Get(c.getAddress() + "/" + strings.TrimLeft(h.Path, "/"))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != status {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (h *healthcheck) interval() time.Duration {
+	if h.Interval > 0 {
+		return time.Duration(h.Interval) * time.Second
+	}
+	return defaultHealthcheckInterval * time.Second
+}
+
+func (h *healthcheck) threshold() int {
+	if h.Threshold > 0 {
+		return h.Threshold
+	}
+	return defaultHealthcheckThreshold
+}
@@ -0,0 +1,132 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/globocom/config"
+	"launchpad.net/gocheck"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+func (s *S) TestLoadHealthcheck(c *gocheck.C) {
+	output := `healthcheck:
+  path: /status
+  status: 204
+  timeout: 10
+`
+	h, cleanup := startSSHAgentServer(output)
+	defer cleanup()
+	config.Set("git:unit-repo", "/home/application/current")
+	defer config.Unset("git:unit-repo")
+	cont := container{ID: "c-01", IP: "10.10.10.10", HostAddr: "127.0.0.1"}
+	hc := loadHealthcheck(&cont)
+	c.Assert(hc, gocheck.DeepEquals, &healthcheck{Path: "/status", Status: 204, Timeout: 10})
+	c.Assert(h.bodies, gocheck.HasLen, 1)
+	c.Assert(h.bodies[0].Cmd, gocheck.Equals, "cat")
+	c.Assert(h.bodies[0].Args, gocheck.DeepEquals, []string{"/home/application/current/app.yaml"})
+}
+
+func (s *S) TestLoadHealthcheckWithoutHealthcheck(c *gocheck.C) {
+	output := `hooks:
+  restart:
+    before:
+      - python manage.py collectstatic
+`
+	_, cleanup := startSSHAgentServer(output)
+	defer cleanup()
+	config.Set("git:unit-repo", "/home/application/current")
+	defer config.Unset("git:unit-repo")
+	cont := container{ID: "c-01", IP: "10.10.10.10", HostAddr: "127.0.0.1"}
+	c.Assert(loadHealthcheck(&cont), gocheck.IsNil)
+}
+
+func healthcheckContainer(serverURL string) container {
+	u, _ := url.Parse(serverURL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	return container{ID: "c-01", HostAddr: host, HostPort: port}
+}
+
+func (s *S) TestHealthcheckCheck(c *gocheck.C) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer server.Close()
+	cont := healthcheckContainer(server.URL)
+	hc := healthcheck{Path: "/status"}
+	err := hc.check(&cont)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(path, gocheck.Equals, "/status")
+}
+
+func (s *S) TestHealthcheckCheckWaitsForTheExpectedStatus(c *gocheck.C) {
+	old := healthcheckInterval
+	healthcheckInterval = 10 * time.Millisecond
+	defer func() { healthcheckInterval = old }()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	cont := healthcheckContainer(server.URL)
+	hc := healthcheck{Path: "status", Timeout: 5}
+	err := hc.check(&cont)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(atomic.LoadInt32(&calls), gocheck.Equals, int32(3))
+}
+
+func (s *S) TestHealthcheckCheckTimeout(c *gocheck.C) {
+	old := healthcheckInterval
+	healthcheckInterval = 100 * time.Millisecond
+	defer func() { healthcheckInterval = old }()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	cont := healthcheckContainer(server.URL)
+	hc := healthcheck{Path: "/status", Timeout: 1}
+	err := hc.check(&cont)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err, gocheck.ErrorMatches, "^Health check for container c-01 failed: unexpected status 500.$")
+}
+
+func (s *S) TestHealthcheckProbe(c *gocheck.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	cont := healthcheckContainer(server.URL)
+	hc := healthcheck{Path: "/status", Status: http.StatusNoContent}
+	c.Assert(hc.probe(&cont), gocheck.IsNil)
+	hc = healthcheck{Path: "/status"}
+	err := hc.probe(&cont)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "unexpected status 204")
+}
+
+func (s *S) TestHealthcheckIntervalAndThreshold(c *gocheck.C) {
+	hc := healthcheck{Path: "/status"}
+	c.Assert(hc.interval(), gocheck.Equals, 30*time.Second)
+	c.Assert(hc.threshold(), gocheck.Equals, 3)
+	hc = healthcheck{Path: "/status", Interval: 10, Threshold: 5}
+	c.Assert(hc.interval(), gocheck.Equals, 10*time.Second)
+	c.Assert(hc.threshold(), gocheck.Equals, 5)
+}
+
+func (s *S) TestContainerUnhealthy(c *gocheck.C) {
+	cont := container{ID: "c-01", Failures: 5}
+	c.Assert(cont.unhealthy(), gocheck.Equals, false)
+	cont.Healthcheck = &healthcheck{Path: "/status", Threshold: 2}
+	c.Assert(cont.unhealthy(), gocheck.Equals, true)
+	cont.Failures = 1
+	c.Assert(cont.unhealthy(), gocheck.Equals, false)
+}
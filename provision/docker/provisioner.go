@@ -105,19 +105,27 @@ func injectEnvsAndRestart(a provision.App) {
 	}
 }
 
-func startInBackground(a provision.App, c container, imageId string, w io.Writer, started chan bool) {
-	newContainer, err := start(a, imageId, w)
+// startContainerFunc starts a new container for the app, during deploys. It's
+// a variable so tests are able to make specific containers fail to start.
+var startContainerFunc = deployStart
+
+type startResult struct {
+	container *container
+	err       error
+}
+
+// startInBackground starts a new container for the app, sending the result
+// to the given channel. Successfully started containers are bound to the
+// service instances of the app.
+func startInBackground(a provision.App, imageId string, w io.Writer, results chan<- startResult) {
+	c, err := startContainerFunc(a, imageId, w)
 	if err != nil {
 		log.Printf("error on start the app %s - %s", a.GetName(), err)
+	} else {
+		msg := queue.Message{Action: app.BindService, Args: []string{a.GetName(), c.ID}}
+		go app.Enqueue(msg)
 	}
-	msg := queue.Message{Action: app.BindService, Args: []string{a.GetName(), newContainer.ID}}
-	go app.Enqueue(msg)
-	if c.ID != "" {
-		if a.RemoveUnit(c.ID) != nil {
-			removeContainer(&c)
-		}
-	}
-	started <- true
+	results <- startResult{container: c, err: err}
 }
 
 func (dockerProvisioner) Swap(app1, app2 provision.App) error {
@@ -133,22 +141,127 @@ func (p *dockerProvisioner) Deploy(a provision.App, version string, w io.Writer)
 	if err != nil {
 		return err
 	}
+	return replaceContainers(a, imageId, w)
+}
+
+// Image returns the name of the image built for the given version of the
+// app. Each deploy tags the image with the deployed version.
+func (p *dockerProvisioner) Image(a provision.App, version string) string {
+	return assembleImageName(a.GetName()) + ":" + version
+}
+
+// ImageDeploy replaces the containers of the app with containers running the
+// given image, without building it again.
+func (p *dockerProvisioner) ImageDeploy(a provision.App, image string, w io.Writer) error {
+	fmt.Fprintf(w, "\n ---> Deploying image %s\n", image)
+	return replaceContainers(a, image, w)
+}
+
+// deployBatchSize returns how many containers are replaced at once in a
+// deploy, as defined by the "docker:deploy-batch-size" setting. When the
+// setting is not defined, all containers are replaced at once.
+func deployBatchSize(total int) int {
+	size, _ := config.GetInt("docker:deploy-batch-size")
+	if size < 1 || size > total {
+		return total
+	}
+	return size
+}
+
+// replaceContainers replaces the containers of the app with new containers,
+// based on the given image.
+//
+// Containers are replaced in batches, and old containers are removed only
+// after their replacements pass the health check declared by the app. If any
+// container in a batch fails, the new containers of the batch are removed, the
+// deploy is aborted and the containers replaced by previous batches are rolled
+// back to their old images.
+func replaceContainers(a provision.App, imageId string, w io.Writer) error {
 	containers, err := listAppContainers(a.GetName())
-	started := make(chan bool, len(containers))
-	if err == nil && len(containers) > 0 {
-		for _, c := range containers {
-			go startInBackground(a, c, imageId, w, started)
+	if err != nil {
+		return err
+	}
+	total := len(containers)
+	if total == 0 {
+		total = 1
+	}
+	batchSize := deployBatchSize(total)
+	var replaced int
+	var deployed []*container
+	for replaced < total {
+		n := batchSize
+		if total-replaced < n {
+			n = total - replaced
 		}
-	} else {
-		go startInBackground(a, container{}, imageId, w, started)
+		results := make(chan startResult, n)
+		for i := 0; i < n; i++ {
+			go startInBackground(a, imageId, w, results)
+		}
+		var started []*container
+		for i := 0; i < n; i++ {
+			result := <-results
+			if result.err != nil {
+				err = result.err
+			} else {
+				started = append(started, result.container)
+			}
+		}
+		if err != nil {
+			for _, c := range started {
+				removeContainer(c)
+			}
+			fmt.Fprintf(w, "\n ---> Failed to start new units (%s). Aborting deploy.\n", err)
+			rollbackContainers(a, containers[:replaced], deployed, w)
+			break
+		}
+		deployed = append(deployed, started...)
+		if len(containers) > 0 {
+			for _, c := range containers[replaced : replaced+n] {
+				c := c
+				if a.RemoveUnit(c.ID) != nil {
+					removeContainer(&c)
+				}
+			}
+		}
+		replaced += n
 	}
-	if <-started {
-		fmt.Fprint(w, "\n ---> App will be restarted, please check its log for more details...\n\n")
-		go injectEnvsAndRestart(a)
+	if err != nil {
+		return err
 	}
+	fmt.Fprint(w, "\n ---> App will be restarted, please check its log for more details...\n\n")
+	go injectEnvsAndRestart(a)
 	return nil
 }
 
+// rollbackContainers replaces the containers started by the batches of a
+// failed deploy with new containers running the images of the old ones,
+// already removed. Containers that can't be rolled back are kept, and the
+// deploy is reported as partial.
+func rollbackContainers(a provision.App, old []container, deployed []*container, w io.Writer) {
+	if len(old) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n ---> Rolling back %d units to the previous image.\n", len(old))
+	results := make(chan startResult, len(old))
+	for _, c := range old {
+		go startInBackground(a, c.Image, w, results)
+	}
+	var rolledBack int
+	for i := 0; i < len(old); i++ {
+		if result := <-results; result.err == nil {
+			rolledBack++
+		}
+	}
+	for _, c := range deployed[:rolledBack] {
+		if a.RemoveUnit(c.ID) != nil {
+			removeContainer(c)
+		}
+	}
+	if kept := len(deployed) - rolledBack; kept > 0 {
+		fmt.Fprintf(w, "\n ---> Failed to roll back %d units. The deploy is partial: they are still running the new image.\n", kept)
+	}
+}
+
 func (p *dockerProvisioner) Destroy(app provision.App) error {
 	containers, _ := listAppContainers(app.GetName())
 	for _, c := range containers {
@@ -340,6 +453,9 @@ func collectUnit(container container, units chan<- provision.Unit, wg *sync.Wait
 	} else {
 		conn.Close()
 		unit.Status = provision.StatusStarted
+		if container.unhealthy() {
+			unit.Status = provision.StatusDown
+		}
 	}
 	log.Printf("collected data for [container %s] - [app %s]", container.ID, container.AppName)
 	units <- unit
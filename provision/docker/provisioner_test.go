@@ -6,6 +6,8 @@ package docker
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	dockerClient "github.com/fsouza/go-dockerclient"
 	"github.com/globocom/config"
 	"github.com/globocom/tsuru/app"
@@ -17,6 +19,7 @@ import (
 	"github.com/globocom/tsuru/queue"
 	rtesting "github.com/globocom/tsuru/router/testing"
 	"github.com/globocom/tsuru/testing"
+	"io"
 	"labix.org/v2/mgo/bson"
 	"launchpad.net/gocheck"
 	stdlog "log"
@@ -25,6 +28,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -127,6 +131,102 @@ func (s *S) TestDeploy(c *gocheck.C) {
 	c.Assert(app.HasLog("tsuru", "Restarting app..."), gocheck.Equals, true)
 }
 
+func (s *S) TestProvisionerImage(c *gocheck.C) {
+	var p dockerProvisioner
+	app := testing.NewFakeApp("cribcaged", "python", 1)
+	expected := assembleImageName("cribcaged") + ":a345fe"
+	c.Assert(p.Image(app, "a345fe"), gocheck.Equals, expected)
+}
+
+func (s *S) TestProvisionerIsImageDeployer(c *gocheck.C) {
+	var _ provision.ImageDeployer = &dockerProvisioner{}
+}
+
+func (s *S) TestImageDeploy(c *gocheck.C) {
+	go s.stopContainers(1)
+	var p dockerProvisioner
+	app := testing.NewFakeApp("cribcaged", "python", 1)
+	err := newImage(p.Image(app, "a345fe"), s.server.URL())
+	c.Assert(err, gocheck.IsNil)
+	setExecut(&etesting.FakeExecutor{})
+	defer setExecut(nil)
+	p.Provision(app)
+	defer p.Destroy(app)
+	var w bytes.Buffer
+	err = p.ImageDeploy(app, p.Image(app, "a345fe"), &w)
+	c.Assert(err, gocheck.IsNil)
+	time.Sleep(6e9)
+	q, err := getQueue()
+	message, err := q.Get(1e6)
+	c.Assert(err, gocheck.IsNil)
+	defer message.Delete()
+	c.Assert(w.String(), gocheck.Matches, "(?s).*---> Deploying image "+p.Image(app, "a345fe")+".*")
+	c.Assert(app.GetCommands(), gocheck.DeepEquals, []string{"serialize", "restart"})
+	containers, err := listAppContainers(app.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 1)
+	c.Assert(containers[0].Image, gocheck.Equals, p.Image(app, "a345fe"))
+}
+
+func (s *S) TestDeployBatchSize(c *gocheck.C) {
+	c.Assert(deployBatchSize(5), gocheck.Equals, 5)
+	config.Set("docker:deploy-batch-size", 2)
+	defer config.Unset("docker:deploy-batch-size")
+	c.Assert(deployBatchSize(5), gocheck.Equals, 2)
+	c.Assert(deployBatchSize(1), gocheck.Equals, 1)
+}
+
+func (s *S) TestReplaceContainersRollsBackPreviousBatches(c *gocheck.C) {
+	config.Set("docker:deploy-batch-size", 2)
+	defer config.Unset("docker:deploy-batch-size")
+	a := testing.NewFakeApp("otherapp", "python", 0)
+	oldImage := assembleImageName(a.GetName()) + ":v1"
+	image := assembleImageName(a.GetName()) + ":v2"
+	for i := 0; i < 4; i++ {
+		err := collection().Insert(container{ID: fmt.Sprintf("old-%d", i), AppName: a.GetName(), Image: oldImage})
+		c.Assert(err, gocheck.IsNil)
+	}
+	defer collection().RemoveAll(bson.M{"appname": a.GetName()})
+	var mut sync.Mutex
+	var calls int
+	startContainerFunc = func(app provision.App, imageId string, w io.Writer) (*container, error) {
+		mut.Lock()
+		defer mut.Unlock()
+		calls++
+		if imageId == image && calls > 2 {
+			return nil, errors.New("health check failed")
+		}
+		cont := container{ID: fmt.Sprintf("cont-%d", calls), AppName: app.GetName(), Image: imageId}
+		if err := collection().Insert(cont); err != nil {
+			return nil, err
+		}
+		return &cont, nil
+	}
+	defer func() { startContainerFunc = deployStart }()
+	var w bytes.Buffer
+	err := replaceContainers(a, image, &w)
+	c.Assert(err, gocheck.ErrorMatches, "health check failed")
+	c.Assert(w.String(), gocheck.Matches, "(?s).*Rolling back 2 units to the previous image.*")
+	c.Assert(w.String(), gocheck.Not(gocheck.Matches), "(?s).*The deploy is partial.*")
+	c.Assert(w.String(), gocheck.Not(gocheck.Matches), "(?s).*App will be restarted.*")
+	containers, err := listAppContainers(a.GetName())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 4)
+	for _, cont := range containers {
+		c.Assert(cont.Image, gocheck.Equals, oldImage)
+	}
+	time.Sleep(1e9)
+	q, err := getQueue()
+	c.Assert(err, gocheck.IsNil)
+	for {
+		message, err := q.Get(1e6)
+		if err != nil {
+			break
+		}
+		message.Delete()
+	}
+}
+
 func getQueue() (queue.Q, error) {
 	queueName := "tsuru-app"
 	qfactory, err := queue.Factory()
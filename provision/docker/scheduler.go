@@ -6,6 +6,7 @@ package docker
 
 import (
 	"errors"
+	"fmt"
 	"github.com/dotcloud/docker"
 	dcli "github.com/fsouza/go-dockerclient"
 	"github.com/globocom/config"
@@ -15,8 +16,9 @@ import (
 	"github.com/globocom/tsuru/db"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
-	"math/rand"
 	"strings"
+	"sync"
+	"time"
 )
 
 // errNoFallback is the error returned when no fallback hosts are configured in
@@ -26,14 +28,135 @@ var errNoFallback = errors.New("No fallback configured in the scheduler")
 var (
 	errNodeAlreadyRegister = errors.New("This node is already registered")
 	errNodeNotFound        = errors.New("Node not found")
+	errNoEnabledServers    = errors.New("All docker servers are disabled")
 )
 
 const schedulerCollection = "docker_scheduler"
 
+// serversCollection stores the nodes from the "docker:servers" setting that
+// were disabled by the node healer.
+const serversCollection = "docker_servers"
+
 type node struct {
-	ID      string `bson:"_id"`
-	Address string
-	Team    string
+	ID       string `bson:"_id"`
+	Address  string
+	Team     string
+	Disabled bool
+	// Failures is the number of consecutive times that the node didn't
+	// respond to the node healer.
+	Failures int `bson:",omitempty"`
+}
+
+// reservationTimeout is the amount of time that a container created by the
+// scheduler is accounted in the load of the node before it shows up in the
+// containers collection.
+const reservationTimeout = 5 * time.Minute
+
+// reservation is a container created by the scheduler that may not be stored
+// in the database yet.
+type reservation struct {
+	host        string
+	app         string
+	memory      int64
+	containerID string
+	created     time.Time
+}
+
+var (
+	reservationsMut sync.Mutex
+	reservations    []*reservation
+)
+
+func releaseReservation(r *reservation) {
+	reservationsMut.Lock()
+	defer reservationsMut.Unlock()
+	for i, res := range reservations {
+		if res == r {
+			reservations = append(reservations[:i], reservations[i+1:]...)
+			return
+		}
+	}
+}
+
+func confirmReservation(r *reservation, containerID string) {
+	reservationsMut.Lock()
+	defer reservationsMut.Unlock()
+	r.containerID = containerID
+}
+
+// nodeLoad represents how busy a node is, from the point of view of an app.
+type nodeLoad struct {
+	appContainers int
+	containers    int
+	memory        int64
+}
+
+// less reports whether the load l is lower than the load o. Nodes with fewer
+// containers of the app come first, so units of the same app are spread
+// across nodes. Ties are broken by the reserved memory and then by the total
+// number of containers.
+func (l nodeLoad) less(o nodeLoad) bool {
+	if l.appContainers != o.appContainers {
+		return l.appContainers < o.appContainers
+	}
+	if l.memory != o.memory {
+		return l.memory < o.memory
+	}
+	return l.containers < o.containers
+}
+
+// chooseNode returns the node with the lowest load for a new container of
+// the given app. The container is accounted in the load of the chosen node
+// until it's stored in the database, so concurrent calls don't pick the same
+// node based on stale data. The returned reservation must be confirmed or
+// released by the caller.
+func chooseNode(nodes []node, appName string, memory int64) (node, *reservation, error) {
+	hosts := make([]string, len(nodes))
+	for i, n := range nodes {
+		hosts[i] = hostFromURL(n.Address)
+	}
+	reservationsMut.Lock()
+	defer reservationsMut.Unlock()
+	var containers []container
+	coll := collection()
+	defer coll.Database.Session.Close()
+	err := coll.Find(bson.M{"hostaddr": bson.M{"$in": hosts}}).Select(bson.M{"appname": 1, "hostaddr": 1, "memory": 1}).All(&containers)
+	if err != nil {
+		return node{}, nil, err
+	}
+	stored := make(map[string]bool, len(containers))
+	loads := make(map[string]nodeLoad, len(nodes))
+	account := func(host, app string, memory int64) {
+		load := loads[host]
+		load.containers++
+		load.memory += memory
+		if app == appName {
+			load.appContainers++
+		}
+		loads[host] = load
+	}
+	for _, c := range containers {
+		stored[c.ID] = true
+		account(c.HostAddr, c.AppName, c.Memory)
+	}
+	pending := reservations[:0]
+	for _, r := range reservations {
+		if stored[r.containerID] || time.Since(r.created) > reservationTimeout {
+			continue
+		}
+		pending = append(pending, r)
+		account(r.host, r.app, r.memory)
+	}
+	reservations = pending
+	chosen := 0
+	for i := range nodes {
+		if loads[hosts[i]].less(loads[hosts[chosen]]) {
+			chosen = i
+		}
+	}
+	r := &reservation{host: hosts[chosen], app: appName, memory: memory, created: time.Now()}
+	reservations = append(reservations, r)
+	return nodes[chosen], r, nil
 }
 
 type segregatedScheduler struct{}
@@ -53,41 +176,52 @@ func (s segregatedScheduler) Schedule(cfg *docker.Config) (string, *docker.Conta
 	app := app.App{Name: appname}
 	err = app.Get()
 	if err != nil {
-		return s.fallback(cfg)
+		return s.fallback(cfg, appname)
 	}
 	if len(app.Teams) == 1 {
 		var nodes []node
-		err = conn.Collection(schedulerCollection).Find(bson.M{"team": app.Teams[0]}).All(&nodes)
+		err = conn.Collection(schedulerCollection).Find(bson.M{"team": app.Teams[0], "disabled": bson.M{"$ne": true}}).All(&nodes)
 		if err != nil || len(nodes) < 1 {
-			return s.fallback(cfg)
+			return s.fallback(cfg, appname)
 		}
-		return s.handle(cfg, nodes)
+		return s.handle(cfg, appname, nodes)
 	}
-	return s.fallback(cfg)
+	return s.fallback(cfg, appname)
 }
 
-func (s segregatedScheduler) fallback(cfg *docker.Config) (string, *docker.Container, error) {
+func (s segregatedScheduler) fallback(cfg *docker.Config, appName string) (string, *docker.Container, error) {
 	conn, err := db.Conn()
 	if err != nil {
 		return "", nil, err
 	}
 	defer conn.Close()
 	var nodes []node
-	err = conn.Collection(schedulerCollection).Find(bson.M{"team": ""}).All(&nodes)
+	err = conn.Collection(schedulerCollection).Find(bson.M{"team": "", "disabled": bson.M{"$ne": true}}).All(&nodes)
 	if err != nil || len(nodes) < 1 {
 		return "", nil, errNoFallback
 	}
-	return s.handle(cfg, nodes)
+	return s.handle(cfg, appName, nodes)
 }
 
-func (segregatedScheduler) handle(cfg *docker.Config, nodes []node) (string, *docker.Container, error) {
-	node := nodes[rand.Intn(len(nodes))]
+// handle creates the container in the node with the lowest load among the
+// given nodes.
+func (segregatedScheduler) handle(cfg *docker.Config, appName string, nodes []node) (string, *docker.Container, error) {
+	node, r, err := chooseNode(nodes, appName, cfg.Memory)
+	if err != nil {
+		return "", nil, err
+	}
 	client, err := dcli.NewClient(node.Address)
 	if err != nil {
+		releaseReservation(r)
 		return node.ID, nil, err
 	}
 	container, err := client.CreateContainer(cfg)
-	return node.ID, container, err
+	if err != nil {
+		releaseReservation(r)
+		return node.ID, nil, err
+	}
+	confirmReservation(r, container.ID)
+	return node.ID, container, nil
 }
 
 func (segregatedScheduler) Nodes() ([]cluster.Node, error) {
@@ -97,7 +231,36 @@ func (segregatedScheduler) Nodes() ([]cluster.Node, error) {
 	}
 	defer conn.Close()
 	var nodes []node
-	err = conn.Collection(schedulerCollection).Find(nil).All(&nodes)
+	err = conn.Collection(schedulerCollection).Find(bson.M{"disabled": bson.M{"$ne": true}}).All(&nodes)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]cluster.Node, len(nodes))
+	for i, node := range nodes {
+		result[i] = cluster.Node{ID: node.ID, Address: node.Address}
+	}
+	return result, nil
+}
+
+// serversScheduler creates containers in the nodes defined in the
+// "docker:servers" setting, skipping the nodes disabled by the node healer.
+type serversScheduler struct{}
+
+func (serversScheduler) Schedule(cfg *docker.Config) (string, *docker.Container, error) {
+	nodes, err := enabledServers()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(nodes) < 1 {
+		return "", nil, errNoEnabledServers
+	}
+	namespace, _ := config.GetString("docker:repository-namespace")
+	appname := strings.Replace(cfg.Image, namespace+"/", "", -1)
+	return segregatedScheduler{}.handle(cfg, appname, nodes)
+}
+
+func (serversScheduler) Nodes() ([]cluster.Node, error) {
+	nodes, err := enabledServers()
 	if err != nil {
 		return nil, err
 	}
@@ -108,6 +271,62 @@ func (segregatedScheduler) Nodes() ([]cluster.Node, error) {
 	return result, nil
 }
 
+// listServers returns the nodes defined in the "docker:servers" setting,
+// flagging the ones disabled by the node healer.
+func listServers() ([]node, error) {
+	servers, _ := config.GetList("docker:servers")
+	nodes := make([]node, len(servers))
+	for i, server := range servers {
+		nodes[i] = node{ID: fmt.Sprintf("server%d", i), Address: server}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var stored []node
+	err = conn.Collection(serversCollection).Find(nil).All(&stored)
+	if err != nil {
+		return nil, err
+	}
+	for i := range nodes {
+		for _, n := range stored {
+			if n.ID == nodes[i].ID && n.Address == nodes[i].Address {
+				nodes[i].Disabled = n.Disabled
+				nodes[i].Failures = n.Failures
+			}
+		}
+	}
+	return nodes, nil
+}
+
+func enabledServers() ([]node, error) {
+	servers, err := listServers()
+	if err != nil {
+		return nil, err
+	}
+	nodes := servers[:0]
+	for _, n := range servers {
+		if !n.Disabled {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+// setServerDisabled enables or disables a node defined in the
+// "docker:servers" setting.
+func setServerDisabled(n node, disabled bool) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	update := bson.M{"$set": bson.M{"address": n.Address, "disabled": disabled}}
+	_, err = conn.Collection(serversCollection).UpsertId(n.ID, update)
+	return err
+}
+
 // AddNodeToScheduler adds a new node to the scheduler, registering for use in
 // the given team. The team parameter is optional, when set to "", the node
 // will be used as a fallback node.
@@ -139,6 +358,17 @@ func removeNodeFromScheduler(n cluster.Node) error {
 	return err
 }
 
+// setNodeDisabled enables or disables a node in the scheduler. Disabled nodes
+// are not used for new containers.
+func setNodeDisabled(id string, disabled bool) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Collection(schedulerCollection).UpdateId(id, bson.M{"$set": bson.M{"disabled": disabled}})
+}
+
 func listNodesInTheScheduler() ([]node, error) {
 	conn, err := db.Conn()
 	if err != nil {
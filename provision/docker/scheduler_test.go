@@ -16,6 +16,7 @@ import (
 	"github.com/globocom/tsuru/db"
 	"labix.org/v2/mgo/bson"
 	"launchpad.net/gocheck"
+	"time"
 )
 
 type SchedulerSuite struct {
@@ -29,6 +30,7 @@ func (s *SchedulerSuite) SetUpSuite(c *gocheck.C) {
 	config.Set("database:url", "127.0.0.1:27017")
 	config.Set("database:name", "docker_scheduler_tests")
 	config.Set("docker:repository-namespace", "tsuru")
+	config.Set("docker:collection", "docker_unit")
 	s.storage, err = db.Conn()
 	c.Assert(err, gocheck.IsNil)
 }
@@ -100,6 +102,70 @@ func (s *SchedulerSuite) TestSchedulerSchedule(c *gocheck.C) {
 	c.Check(node, gocheck.Equals, "server2")
 }
 
+func (s *SchedulerSuite) TestChooseNode(c *gocheck.C) {
+	nodes := []node{
+		{ID: "server0", Address: "http://10.0.0.1:4243"},
+		{ID: "server1", Address: "http://10.0.0.2:4243"},
+		{ID: "server2", Address: "http://10.0.0.3:4243"},
+	}
+	coll := collection()
+	defer coll.Database.Session.Close()
+	err := coll.Insert(
+		container{ID: "c-0", AppName: "myapp", HostAddr: "10.0.0.1"},
+		container{ID: "c-1", AppName: "other", HostAddr: "10.0.0.2", Memory: 512 << 20},
+		container{ID: "c-2", AppName: "other", HostAddr: "10.0.0.3", Memory: 256 << 20},
+		container{ID: "c-3", AppName: "other", HostAddr: "10.0.0.3", Memory: 128 << 20},
+	)
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveAll(bson.M{"_id": bson.M{"$in": []string{"c-0", "c-1", "c-2", "c-3"}}})
+	defer func() { reservations = nil }()
+	chosen, r, err := chooseNode(nodes, "myapp", 256<<20)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(chosen.ID, gocheck.Equals, "server2")
+	c.Assert(r.host, gocheck.Equals, "10.0.0.3")
+	confirmReservation(r, "c-4")
+	chosen, r, err = chooseNode(nodes, "myapp", 256<<20)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(chosen.ID, gocheck.Equals, "server1")
+	releaseReservation(r)
+	chosen, _, err = chooseNode(nodes, "myapp", 256<<20)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(chosen.ID, gocheck.Equals, "server1")
+}
+
+func (s *SchedulerSuite) TestChooseNodeDropsStoredReservations(c *gocheck.C) {
+	nodes := []node{{ID: "server0", Address: "http://10.0.0.1:4243"}}
+	coll := collection()
+	defer coll.Database.Session.Close()
+	defer func() { reservations = nil }()
+	reservations = []*reservation{
+		{host: "10.0.0.1", app: "myapp", containerID: "c-0", created: time.Now()},
+		{host: "10.0.0.1", app: "myapp", containerID: "c-1", created: time.Now().Add(-2 * reservationTimeout)},
+	}
+	err := coll.Insert(container{ID: "c-0", AppName: "myapp", HostAddr: "10.0.0.1"})
+	c.Assert(err, gocheck.IsNil)
+	defer coll.RemoveId("c-0")
+	_, r, err := chooseNode(nodes, "myapp", 0)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(reservations, gocheck.DeepEquals, []*reservation{r})
+}
+
+func (s *SchedulerSuite) TestNodeLoadLess(c *gocheck.C) {
+	var tests = []struct {
+		l, o     nodeLoad
+		expected bool
+	}{
+		{nodeLoad{appContainers: 1}, nodeLoad{appContainers: 2}, true},
+		{nodeLoad{appContainers: 1, memory: 0, containers: 0}, nodeLoad{appContainers: 0, memory: 1 << 30, containers: 20}, false},
+		{nodeLoad{memory: 256}, nodeLoad{memory: 512, containers: 0}, true},
+		{nodeLoad{memory: 256, containers: 3}, nodeLoad{memory: 256, containers: 2}, false},
+		{nodeLoad{containers: 2}, nodeLoad{containers: 2}, false},
+	}
+	for _, t := range tests {
+		c.Check(t.l.less(t.o), gocheck.Equals, t.expected)
+	}
+}
+
 func (s *SchedulerSuite) TestSchedulerNoFallback(c *gocheck.C) {
 	app := app.App{Name: "bill", Teams: []string{"jean"}}
 	err := s.storage.Apps().Insert(app)
@@ -119,7 +119,7 @@ func (p *JujuProvisioner) Provision(app provision.App) error {
 		return cmdError(out, err, args)
 	}
 	setOption := []string{
-		"set", app.GetName(), "app-repo=" + repository.ReadOnlyURL(app.GetName()),
+		"set", app.GetName(), "app-repo=" + repository.ReadOnlyURL(provision.RepositoryName(app)),
 	}
 	runCmd(true, &buf, &buf, setOption...)
 	if p.elbSupport() {
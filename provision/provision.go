@@ -84,6 +84,29 @@ type App interface {
 	Ready() error
 }
 
+// RepositoryNamer is implemented by apps that do not use a repository named
+// after the app itself.
+type RepositoryNamer interface {
+	GetRepositoryName() string
+}
+
+// RepositoryName returns the name of the repository of the given app.
+func RepositoryName(app App) string {
+	if n, ok := app.(RepositoryNamer); ok {
+		return n.GetRepositoryName()
+	}
+	return app.GetName()
+}
+
+// ResourceLimiter is implemented by apps that limit the resources available
+// to each of their units. Memory and swap are expressed in bytes, and zero
+// means no limit.
+type ResourceLimiter interface {
+	GetMemory() int64
+	GetSwap() int64
+	GetCpuShare() int
+}
+
 type CNameManager interface {
 	SetCName(app App, cname string) error
 	UnsetCName(app App, cname string) error
@@ -144,6 +167,18 @@ type Provisioner interface {
 	Swap(App, App) error
 }
 
+// ImageDeployer is a provisioner that builds an image in every deploy, being
+// able to start units from the image built in a previous deploy.
+type ImageDeployer interface {
+	// Image returns the name of the image built for the given version of
+	// the app.
+	Image(app App, version string) string
+
+	// ImageDeploy replaces the units of the app with new units, created
+	// from the given image, previously returned by Image.
+	ImageDeploy(app App, image string, w io.Writer) error
+}
+
 // Commandable is a provisioner that provides commands to extend the tsr
 // command line interface.
 type Commandable interface {
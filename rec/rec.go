@@ -9,6 +9,7 @@ package rec
 import (
 	"errors"
 	"github.com/globocom/tsuru/db"
+	"labix.org/v2/mgo/bson"
 	"time"
 )
 
@@ -20,11 +21,17 @@ var (
 	ErrMissingAction = errors.New("Missing action")
 )
 
-type userAction struct {
-	User   string
-	Action string
-	Extra  []interface{}
-	Date   time.Time
+// Action is an action performed by a user, as recorded by Log.
+//
+// Extra holds the details of the action. Details are recorded as "key=value"
+// strings, like "app=myapp", so they can be used for filtering actions (see
+// Search).
+type Action struct {
+	ID     bson.ObjectId `bson:"_id,omitempty" json:"-"`
+	User   string        `json:"user"`
+	Action string        `json:"action"`
+	Extra  []interface{} `json:"extra"`
+	Date   time.Time     `json:"date"`
 }
 
 // Log stores an action in the database. It launches a goroutine, and may
@@ -46,7 +53,7 @@ func Log(user string, action string, extra ...interface{}) <-chan error {
 			return
 		}
 		defer conn.Close()
-		action := userAction{User: user, Action: action, Extra: extra, Date: time.Now().In(time.UTC)}
+		action := Action{User: user, Action: action, Extra: extra, Date: time.Now().In(time.UTC)}
 		if err := conn.UserActions().Insert(action); err != nil {
 			ch <- err
 		}
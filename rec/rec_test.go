@@ -105,7 +105,7 @@ func (RecSuite) TestLogInvalidData(c *gocheck.C) {
 	conn, err := db.Conn()
 	c.Assert(err, gocheck.IsNil)
 	defer conn.Close()
-	var action userAction
+	var action Action
 	err = conn.UserActions().Find(nil).One(&action)
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(action.User, gocheck.Equals, "gopher@golang.org")
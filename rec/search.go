@@ -0,0 +1,134 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rec
+
+import (
+	"fmt"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit is the number of actions returned by Search when the
+	// filter doesn't define a limit.
+	DefaultLimit = 100
+
+	// MaxLimit is the maximum number of actions returned by Search.
+	MaxLimit = 1000
+)
+
+// Filter is used for searching recorded actions. Empty fields are ignored.
+type Filter struct {
+	User   string
+	Action string
+
+	// App is the name of the app affected by the actions, as recorded in
+	// the "app=<name>" detail.
+	App string
+
+	// Since and Until define the time range of the actions.
+	Since time.Time
+	Until time.Time
+
+	// Limit is the maximum number of actions returned. It defaults to
+	// DefaultLimit, and can't be greater than MaxLimit.
+	Limit int
+
+	// Cursor is returned by Search when there are older actions matching
+	// the filter. Searching with the cursor returns the next page of
+	// actions.
+	Cursor string
+}
+
+func (f *Filter) query() (bson.M, error) {
+	q := bson.M{}
+	if f.User != "" {
+		q["user"] = f.User
+	}
+	if f.Action != "" {
+		q["action"] = f.Action
+	}
+	if f.App != "" {
+		q["extra"] = "app=" + f.App
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		date := bson.M{}
+		if !f.Since.IsZero() {
+			date["$gte"] = f.Since
+		}
+		if !f.Until.IsZero() {
+			date["$lte"] = f.Until
+		}
+		q["date"] = date
+	}
+	if f.Cursor != "" {
+		date, id, err := parseCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		q["$or"] = []bson.M{
+			{"date": bson.M{"$lt": date}},
+			{"date": date, "_id": bson.M{"$lt": id}},
+		}
+	}
+	return q, nil
+}
+
+func (f *Filter) limit() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultLimit
+	case f.Limit > MaxLimit:
+		return MaxLimit
+	}
+	return f.Limit
+}
+
+func cursor(date time.Time, id bson.ObjectId) string {
+	return fmt.Sprintf("%d-%s", date.UnixNano(), id.Hex())
+}
+
+func parseCursor(cursor string) (time.Time, bson.ObjectId, error) {
+	invalid := &errors.ValidationError{Message: "Invalid cursor."}
+	parts := strings.SplitN(cursor, "-", 2)
+	if len(parts) != 2 || !bson.IsObjectIdHex(parts[1]) {
+		return time.Time{}, "", invalid
+	}
+	nsec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", invalid
+	}
+	return time.Unix(0, nsec), bson.ObjectIdHex(parts[1]), nil
+}
+
+// Search returns the recorded actions matching the filter, the most recent
+// first. When the number of actions reaches the limit, it also returns a
+// cursor that can be used to fetch the next page of actions.
+func Search(f *Filter) ([]Action, string, error) {
+	q, err := f.query()
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+	limit := f.limit()
+	var actions []Action
+	err = conn.UserActions().Find(q).Sort("-date", "-_id").Limit(limit).All(&actions)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if l := len(actions); l == limit {
+		next = cursor(actions[l-1].Date, actions[l-1].ID)
+	}
+	return actions, next, nil
+}
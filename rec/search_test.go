@@ -0,0 +1,125 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rec
+
+import (
+	"encoding/json"
+	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"labix.org/v2/mgo/bson"
+	"launchpad.net/gocheck"
+	"time"
+)
+
+func insertActions(c *gocheck.C) (*db.Storage, time.Time) {
+	conn, err := db.Conn()
+	c.Assert(err, gocheck.IsNil)
+	_, err = conn.UserActions().RemoveAll(nil)
+	c.Assert(err, gocheck.IsNil)
+	base := time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC)
+	actions := []Action{
+		{User: "gopher@tsuru.io", Action: "create-app", Extra: []interface{}{"app=myapp", "platform=python"}, Date: base},
+		{User: "gopher@tsuru.io", Action: "set-env", Extra: []interface{}{"app=myapp", "envs=[DATABASE_HOST]"}, Date: base.Add(time.Minute)},
+		{User: "gopher@tsuru.io", Action: "create-app", Extra: []interface{}{"app=otherapp", "platform=ruby"}, Date: base.Add(2 * time.Minute)},
+		{User: "rabbit@tsuru.io", Action: "restart-app", Extra: []interface{}{"app=myapp"}, Date: base.Add(3 * time.Minute)},
+		{User: "rabbit@tsuru.io", Action: "swap-apps", Extra: []interface{}{"app=myapp", "app=otherapp"}, Date: base.Add(4 * time.Minute)},
+	}
+	for _, a := range actions {
+		err := conn.UserActions().Insert(a)
+		c.Assert(err, gocheck.IsNil)
+	}
+	return conn, base
+}
+
+func actionNames(actions []Action) []string {
+	names := make([]string, len(actions))
+	for i, a := range actions {
+		names[i] = a.Action
+	}
+	return names
+}
+
+func (RecSuite) TestSearch(c *gocheck.C) {
+	conn, _ := insertActions(c)
+	defer conn.Close()
+	defer conn.UserActions().RemoveAll(nil)
+	actions, cursor, err := Search(&Filter{})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cursor, gocheck.Equals, "")
+	c.Assert(actionNames(actions), gocheck.DeepEquals,
+		[]string{"swap-apps", "restart-app", "create-app", "set-env", "create-app"})
+}
+
+func (RecSuite) TestSearchByUserAndAction(c *gocheck.C) {
+	conn, _ := insertActions(c)
+	defer conn.Close()
+	defer conn.UserActions().RemoveAll(nil)
+	actions, _, err := Search(&Filter{User: "gopher@tsuru.io", Action: "create-app"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actions, gocheck.HasLen, 2)
+	c.Assert(actions[0].Extra, gocheck.DeepEquals, []interface{}{"app=otherapp", "platform=ruby"})
+	c.Assert(actions[1].Extra, gocheck.DeepEquals, []interface{}{"app=myapp", "platform=python"})
+}
+
+func (RecSuite) TestSearchByApp(c *gocheck.C) {
+	conn, _ := insertActions(c)
+	defer conn.Close()
+	defer conn.UserActions().RemoveAll(nil)
+	actions, _, err := Search(&Filter{App: "otherapp"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actionNames(actions), gocheck.DeepEquals, []string{"swap-apps", "create-app"})
+}
+
+func (RecSuite) TestSearchByDate(c *gocheck.C) {
+	conn, base := insertActions(c)
+	defer conn.Close()
+	defer conn.UserActions().RemoveAll(nil)
+	filter := Filter{Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)}
+	actions, _, err := Search(&filter)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actionNames(actions), gocheck.DeepEquals, []string{"restart-app", "create-app", "set-env"})
+}
+
+func (RecSuite) TestSearchWithCursor(c *gocheck.C) {
+	conn, _ := insertActions(c)
+	defer conn.Close()
+	defer conn.UserActions().RemoveAll(nil)
+	actions, cursor, err := Search(&Filter{Limit: 2})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actionNames(actions), gocheck.DeepEquals, []string{"swap-apps", "restart-app"})
+	c.Assert(cursor, gocheck.Not(gocheck.Equals), "")
+	actions, cursor, err = Search(&Filter{Limit: 2, Cursor: cursor})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actionNames(actions), gocheck.DeepEquals, []string{"create-app", "set-env"})
+	actions, cursor, err = Search(&Filter{Limit: 2, Cursor: cursor})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(actionNames(actions), gocheck.DeepEquals, []string{"create-app"})
+	c.Assert(cursor, gocheck.Equals, "")
+}
+
+func (RecSuite) TestSearchInvalidCursor(c *gocheck.C) {
+	_, _, err := Search(&Filter{Cursor: "invalid"})
+	c.Assert(err, gocheck.FitsTypeOf, &errors.ValidationError{})
+}
+
+func (RecSuite) TestFilterLimit(c *gocheck.C) {
+	c.Assert((&Filter{}).limit(), gocheck.Equals, DefaultLimit)
+	c.Assert((&Filter{Limit: 10}).limit(), gocheck.Equals, 10)
+	c.Assert((&Filter{Limit: 5000}).limit(), gocheck.Equals, MaxLimit)
+}
+
+func (RecSuite) TestActionJSON(c *gocheck.C) {
+	a := Action{
+		ID:     bson.NewObjectId(),
+		User:   "gopher@tsuru.io",
+		Action: "restart-app",
+		Extra:  []interface{}{"app=myapp"},
+		Date:   time.Date(2013, 11, 20, 10, 0, 0, 0, time.UTC),
+	}
+	b, err := json.Marshal(a)
+	c.Assert(err, gocheck.IsNil)
+	expected := `{"user":"gopher@tsuru.io","action":"restart-app","extra":["app=myapp"],"date":"2013-11-20T10:00:00Z"}`
+	c.Assert(string(b), gocheck.Equals, expected)
+}
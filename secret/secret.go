@@ -0,0 +1,187 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package secret encrypts values stored in the database, like the environment
+// variables of apps.
+//
+// Values are encrypted with AES-256 in GCM mode, using the key defined in the
+// "secret:key" setting. Old keys are listed in the "secret:old-keys" setting,
+// so values encrypted with them can still be decrypted, and re-encrypted with
+// the current key (see NeedsRotation and Rotate).
+//
+// When there's no key in the configuration, values are stored in plain text.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/globocom/config"
+	"strings"
+)
+
+// prefix identifies encrypted values. The full format of an encrypted value
+// is: $tsuru-enc$<key id>$<base64 encoded nonce and ciphertext>.
+const prefix = "$tsuru-enc$"
+
+// ErrUnknownKey is returned when the key used to encrypt a value is not
+// available in the configuration.
+var ErrUnknownKey = errors.New("secret: the value was encrypted with an unknown key")
+
+// ErrInvalidValue is returned when an encrypted value is malformed or has been
+// tampered.
+var ErrInvalidValue = errors.New("secret: invalid encrypted value")
+
+type key struct {
+	id   string
+	data []byte
+}
+
+func newKey(s string) key {
+	data := sha256.Sum256([]byte(s))
+	id := sha256.Sum256(data[:])
+	return key{id: hex.EncodeToString(id[:4]), data: data[:]}
+}
+
+func (k key) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.data)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// currentKey returns the key used to encrypt values.
+func currentKey() (key, bool) {
+	s, err := config.GetString("secret:key")
+	if err != nil || s == "" {
+		return key{}, false
+	}
+	return newKey(s), true
+}
+
+// findKey returns the key identified by id, looking in the current key and in
+// the old keys.
+func findKey(id string) (key, bool) {
+	if k, ok := currentKey(); ok && k.id == id {
+		return k, true
+	}
+	olds, _ := config.GetList("secret:old-keys")
+	for _, s := range olds {
+		if k := newKey(s); k.id == id {
+			return k, true
+		}
+	}
+	return key{}, false
+}
+
+// hasKeys indicates whether there's any key, current or old, in the
+// configuration.
+func hasKeys() bool {
+	if Enabled() {
+		return true
+	}
+	olds, _ := config.GetList("secret:old-keys")
+	return len(olds) > 0
+}
+
+// Enabled indicates whether there's a key for encrypting values in the
+// configuration.
+func Enabled() bool {
+	_, ok := currentKey()
+	return ok
+}
+
+// IsEncrypted indicates whether the value has been encrypted by this package.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt encrypts the value using the current key. The value is always
+// encrypted, even if it looks like an encrypted value, so users can't store
+// arbitrary ciphertexts. When encryption is not enabled, the value is returned
+// unchanged.
+func Encrypt(value string) (string, error) {
+	k, ok := currentKey()
+	if !ok {
+		return value, nil
+	}
+	return encrypt(k, value)
+}
+
+func encrypt(k key, value string) (string, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := gcm.Seal(nonce, nonce, []byte(value), []byte(k.id))
+	return fmt.Sprintf("%s%s$%s", prefix, k.id, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// Decrypt returns the value in plain text. Values that are not encrypted are
+// returned unchanged. When there are no keys in the configuration, values are
+// stored in plain text, so they're returned unchanged even if they look
+// encrypted.
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) || !hasKeys() {
+		return value, nil
+	}
+	parts := strings.SplitN(value[len(prefix):], "$", 2)
+	if len(parts) != 2 {
+		return "", ErrInvalidValue
+	}
+	k, ok := findKey(parts[0])
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+	gcm, err := k.gcm()
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", ErrInvalidValue
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, ciphertext, []byte(k.id))
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+	return string(plain), nil
+}
+
+// NeedsRotation indicates whether the value should be encrypted again: either
+// it's stored in plain text, or it was encrypted with an old key.
+func NeedsRotation(value string) bool {
+	k, ok := currentKey()
+	if !ok {
+		return false
+	}
+	return !strings.HasPrefix(value, prefix+k.id+"$")
+}
+
+// Rotate decrypts the value and encrypts it again using the current key.
+func Rotate(value string) (string, error) {
+	k, ok := currentKey()
+	if !ok {
+		return value, nil
+	}
+	plain, err := Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return encrypt(k, plain)
+}
@@ -0,0 +1,140 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"github.com/globocom/config"
+	"launchpad.net/gocheck"
+	"testing"
+)
+
+type S struct{}
+
+var _ = gocheck.Suite(&S{})
+
+func Test(t *testing.T) {
+	gocheck.TestingT(t)
+}
+
+func (s *S) SetUpTest(c *gocheck.C) {
+	config.Set("secret:key", "the-current-key")
+	config.Unset("secret:old-keys")
+}
+
+func (s *S) TearDownTest(c *gocheck.C) {
+	config.Unset("secret:key")
+	config.Unset("secret:old-keys")
+}
+
+func (s *S) TestEncryptAndDecrypt(c *gocheck.C) {
+	encrypted, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(encrypted, gocheck.Not(gocheck.Equals), "s3cr3t")
+	c.Assert(encrypted, gocheck.Matches, `^\$tsuru-enc\$[0-9a-f]{8}\$.+`)
+	c.Assert(IsEncrypted(encrypted), gocheck.Equals, true)
+	decrypted, err := Decrypt(encrypted)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(decrypted, gocheck.Equals, "s3cr3t")
+}
+
+func (s *S) TestEncryptUsesRandomNonces(c *gocheck.C) {
+	first, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	second, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(first, gocheck.Not(gocheck.Equals), second)
+}
+
+func (s *S) TestEncryptValuesThatLookEncrypted(c *gocheck.C) {
+	encrypted, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	again, err := Encrypt(encrypted)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(again, gocheck.Not(gocheck.Equals), encrypted)
+	decrypted, err := Decrypt(again)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(decrypted, gocheck.Equals, encrypted)
+}
+
+func (s *S) TestEncryptWithoutKey(c *gocheck.C) {
+	config.Unset("secret:key")
+	c.Assert(Enabled(), gocheck.Equals, false)
+	value, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, "s3cr3t")
+}
+
+func (s *S) TestDecryptPlainValue(c *gocheck.C) {
+	value, err := Decrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, "s3cr3t")
+}
+
+func (s *S) TestDecryptWithoutKey(c *gocheck.C) {
+	config.Unset("secret:key")
+	value, err := Decrypt(prefix + "plain$value")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, prefix+"plain$value")
+}
+
+func (s *S) TestDecryptWithOnlyOldKeys(c *gocheck.C) {
+	encrypted, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	config.Unset("secret:key")
+	config.Set("secret:old-keys", []interface{}{"the-current-key"})
+	decrypted, err := Decrypt(encrypted)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(decrypted, gocheck.Equals, "s3cr3t")
+}
+
+func (s *S) TestDecryptWithOldKey(c *gocheck.C) {
+	encrypted, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	config.Set("secret:key", "the-new-key")
+	config.Set("secret:old-keys", []interface{}{"the-current-key"})
+	decrypted, err := Decrypt(encrypted)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(decrypted, gocheck.Equals, "s3cr3t")
+}
+
+func (s *S) TestDecryptWithUnknownKey(c *gocheck.C) {
+	encrypted, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	config.Set("secret:key", "the-new-key")
+	_, err = Decrypt(encrypted)
+	c.Assert(err, gocheck.Equals, ErrUnknownKey)
+}
+
+func (s *S) TestDecryptTamperedValue(c *gocheck.C) {
+	encrypted, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	tampered := encrypted[:len(encrypted)-4] + "AAA="
+	_, err = Decrypt(tampered)
+	c.Assert(err, gocheck.Equals, ErrInvalidValue)
+	_, err = Decrypt(prefix + "nokey")
+	c.Assert(err, gocheck.Equals, ErrInvalidValue)
+}
+
+func (s *S) TestNeedsRotationAndRotate(c *gocheck.C) {
+	encrypted, err := Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(NeedsRotation(encrypted), gocheck.Equals, false)
+	c.Assert(NeedsRotation("plain"), gocheck.Equals, true)
+	config.Set("secret:key", "the-new-key")
+	config.Set("secret:old-keys", []interface{}{"the-current-key"})
+	c.Assert(NeedsRotation(encrypted), gocheck.Equals, true)
+	rotated, err := Rotate(encrypted)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(NeedsRotation(rotated), gocheck.Equals, false)
+	config.Unset("secret:old-keys")
+	decrypted, err := Decrypt(rotated)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(decrypted, gocheck.Equals, "s3cr3t")
+}
+
+func (s *S) TestNeedsRotationWithoutKey(c *gocheck.C) {
+	config.Unset("secret:key")
+	c.Assert(NeedsRotation("plain"), gocheck.Equals, false)
+}
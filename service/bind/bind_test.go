@@ -257,6 +257,142 @@ func (s *S) TestBindReturnsPreconditionFailedIfTheAppDoesNotHaveAnUnitAndService
 	c.Assert(e.Message, gocheck.Equals, "This app does not have an IP yet.")
 }
 
+func (s *S) TestBindPendingInstanceRecordsTheApp(c *gocheck.C) {
+	var called int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt32(&called, 1)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		Teams:       []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a, err := createTestApp(s.conn, "painkiller", "", []string{s.team.Name}, []app.Unit{{Ip: "10.10.10.10"}})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = instance.BindApp(&a)
+	c.Assert(err, gocheck.Equals, service.ErrInstancePending)
+	c.Assert(atomic.LoadInt32(&called), gocheck.Equals, int32(0))
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Apps, gocheck.HasLen, 0)
+	c.Assert(stored.PendingApps, gocheck.DeepEquals, []string{a.Name})
+}
+
+func (s *S) TestBindInstanceThatIsNoLongerPending(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"DATABASE_USER":"root"}`))
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StateUp,
+		Teams:       []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a, err := createTestApp(s.conn, "painkiller", "", []string{s.team.Name}, []app.Unit{{Ip: "10.10.10.10"}})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	// the instance was loaded before the service reported it as up
+	instance.State = service.StatePending
+	err = instance.BindApp(&a)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Apps, gocheck.DeepEquals, []string{a.Name})
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+}
+
+func (s *S) TestBindFailedInstance(c *gocheck.C) {
+	srvc := service.Service{Name: "mysql"}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", State: service.StateFailed}
+	a := app.App{Name: "painkiller", Units: []app.Unit{{Ip: "10.10.10.10"}}}
+	err = instance.BindApp(&a)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusPreconditionFailed)
+	c.Assert(e.Message, gocheck.Equals, "This service instance failed to be provisioned.")
+}
+
+func (s *S) TestBindInstanceNotReadyInTheServiceAPI(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StateUp,
+		Teams:       []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a, err := createTestApp(s.conn, "painkiller", "", []string{s.team.Name}, []app.Unit{{Ip: "10.10.10.10"}})
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	err = instance.BindApp(&a)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusPreconditionFailed)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, service.StateUp)
+	c.Assert(stored.Apps, gocheck.HasLen, 0)
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+}
+
+func (s *S) TestUnbindPendingApp(c *gocheck.C) {
+	srvc := service.Service{Name: "mysql"}
+	err := srvc.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	instance := service.ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		State:       service.StatePending,
+		PendingApps: []string{"painkiller", "other"},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "my-mysql"})
+	a := app.App{Name: "painkiller"}
+	err = instance.UnbindApp(&a)
+	c.Assert(err, gocheck.IsNil)
+	var stored service.ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "my-mysql"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.PendingApps, gocheck.DeepEquals, []string{"other"})
+}
+
 func (s *S) TestUnbindUnit(c *gocheck.C) {
 	called := false
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
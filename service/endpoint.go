@@ -5,21 +5,34 @@
 package service
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"github.com/globocom/tsuru/app/bind"
 	"github.com/globocom/tsuru/errors"
 	"github.com/globocom/tsuru/log"
+	"github.com/globocom/tsuru/secret"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 )
 
+// ErrStatusNotImplemented is returned when the service API doesn't implement
+// the status of instances, which is optional.
+var ErrStatusNotImplemented = stderrors.New("The service does not implement the status of instances.")
+
 type Client struct {
 	endpoint string
+	username string
+	password string
+	auth     string
 }
 
 func (c *Client) buildErrorMessage(err error, resp *http.Response) string {
@@ -36,23 +49,69 @@ func (c *Client) buildErrorMessage(err error, resp *http.Response) string {
 func (c *Client) issueRequest(path, method string, params map[string][]string) (*http.Response, error) {
 	log.Print("Issuing request...")
 	v := url.Values(params)
-	var suffix string
+	var suffix, payload string
 	var body io.Reader
 	if method == "DELETE" || method == "GET" {
 		suffix = "?" + v.Encode()
 	} else {
-		body = strings.NewReader(v.Encode())
+		payload = v.Encode()
+		body = strings.NewReader(payload)
 	}
 	url := strings.TrimRight(c.endpoint, "/") + "/" + strings.Trim(path, "/") + suffix
 	req, err := http.NewRequest(method, url, body)
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	if err != nil {
 		log.Printf("Got error while creating request: %s", err)
 		return nil, err
 	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	c.authenticate(req, payload)
 	return http.DefaultClient.Do(req)
 }
 
+// authenticate adds the credentials of the service to the request, according
+// to the authentication scheme of the service. Requests to services without
+// password are not authenticated.
+func (c *Client) authenticate(req *http.Request, payload string) {
+	if c.password == "" {
+		return
+	}
+	if c.auth == AuthHMAC {
+		date := time.Now().UTC().Format(http.TimeFormat)
+		req.Header.Set("Date", date)
+		req.Header.Set("Authorization", "HMAC-SHA256 "+signRequest(c.password, req.Method, req.URL.RequestURI(), date, payload))
+		return
+	}
+	req.SetBasicAuth(c.username, c.password)
+}
+
+// signRequest returns the HMAC-SHA256 signature of the request, in hex. The
+// signed message is composed by the method, the path (including the query
+// string), the date and the body of the request, separated by new lines.
+func signRequest(password, method, path, date, payload string) string {
+	mac := hmac.New(sha256.New, []byte(password))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, path, date, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// instanceParams returns the plan and the parameters of the instance in the
+// format sent to the service api. Parameters are prefixed by "parameters.", so
+// they don't clash with the other fields of the requests, and their values are
+// decrypted.
+func instanceParams(instance *ServiceInstance) (map[string][]string, error) {
+	params := map[string][]string{}
+	if instance.PlanName != "" {
+		params["plan"] = []string{instance.PlanName}
+	}
+	for name, value := range instance.Params {
+		value, err := secret.Decrypt(value)
+		if err != nil {
+			return nil, err
+		}
+		params["parameters."+name] = []string{value}
+	}
+	return params, nil
+}
+
 func (c *Client) jsonFromResponse(resp *http.Response, v interface{}) error {
 	log.Print("Parsing response json...")
 	defer resp.Body.Close()
@@ -64,14 +123,25 @@ func (c *Client) jsonFromResponse(resp *http.Response, v interface{}) error {
 	return json.Unmarshal(body, &v)
 }
 
+// Create creates the instance in the service api. Services that provision
+// instances asynchronously return 202, and the instance is then marked as
+// pending.
 func (c *Client) Create(instance *ServiceInstance) error {
 	var err error
 	log.Print("Attempting to call creation of service instance " + instance.Name + " at " + instance.ServiceName + " api")
 	var resp *http.Response
-	params := map[string][]string{
-		"name": {instance.Name},
+	params, err := instanceParams(instance)
+	if err != nil {
+		return err
 	}
+	params["name"] = []string{instance.Name}
 	if resp, err = c.issueRequest("/resources", "POST", params); err == nil && resp.StatusCode < 300 {
+		if resp.StatusCode == http.StatusAccepted {
+			instance.State = StatePending
+			instance.Creating = true
+		} else {
+			instance.State = StateUp
+		}
 		return nil
 	}
 	msg := "Failed to create the instance " + instance.Name + ": " + c.buildErrorMessage(err, resp)
@@ -79,6 +149,32 @@ func (c *Client) Create(instance *ServiceInstance) error {
 	return &errors.HTTP{Code: http.StatusInternalServerError, Message: msg}
 }
 
+// Update sends the plan and the parameters of the instance to the service api.
+// Like in Create, services that apply the changes asynchronously return 202,
+// and the instance is then marked as pending.
+func (c *Client) Update(instance *ServiceInstance) error {
+	log.Print("Attempting to call update of service instance " + instance.Name + " at " + instance.ServiceName + " api")
+	params, err := instanceParams(instance)
+	if err != nil {
+		return err
+	}
+	resp, err := c.issueRequest("/resources/"+instance.Name, "PUT", params)
+	if err == nil && resp.StatusCode < 300 {
+		if resp.StatusCode == http.StatusAccepted {
+			instance.State = StatePending
+		} else {
+			instance.State = StateUp
+		}
+		return nil
+	}
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "This service does not support updating instances."}
+	}
+	msg := "Failed to update the instance " + instance.Name + ": " + c.buildErrorMessage(err, resp)
+	log.Print(msg)
+	return &errors.HTTP{Code: http.StatusInternalServerError, Message: msg}
+}
+
 func (c *Client) Destroy(instance *ServiceInstance) error {
 	log.Print("Attempting to call destroy of service instance " + instance.Name + " at " + instance.ServiceName + " api")
 	resp, err := c.issueRequest("/resources/"+instance.Name, "DELETE", nil)
@@ -138,7 +234,8 @@ func (c *Client) Unbind(instance *ServiceInstance, unit bind.Unit) error {
 // like below:
 // GET /resources/<name>/status/
 // The service host here is the private ip of the service instance
-// 204 means the service is up, 500 means the service is down
+// 204 means the service is up, 500 means the service is down. Services that
+// don't implement this resource get ErrStatusNotImplemented.
 func (c *Client) Status(instance *ServiceInstance) (string, error) {
 	log.Print("Attempting to call status of service instance " + instance.Name + " at " + instance.ServiceName + " api")
 	var (
@@ -149,11 +246,13 @@ func (c *Client) Status(instance *ServiceInstance) (string, error) {
 	if resp, err = c.issueRequest(url, "GET", nil); err == nil {
 		switch resp.StatusCode {
 		case 202:
-			return "pending", nil
+			return StatePending, nil
 		case 204:
-			return "up", nil
+			return StateUp, nil
 		case 500:
 			return "down", nil
+		case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			return "", ErrStatusNotImplemented
 		}
 	}
 	msg := "Failed to get status of instance " + instance.Name + ": " + c.buildErrorMessage(err, resp)
@@ -180,3 +279,37 @@ func (c *Client) Info(instance *ServiceInstance) ([]map[string]string, error) {
 	}
 	return result, nil
 }
+
+// Plan is a plan offered by a service, like the size of the instances.
+type Plan struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Plans returns the plans offered by the service.
+// The api should be prepared to receive the request,
+// like below:
+// GET /resources/plans
+// Services that don't offer plans may return 404.
+func (c *Client) Plans() ([]Plan, error) {
+	log.Print("Attempting to call plans of service at " + c.endpoint)
+	resp, err := c.issueRequest("/resources/plans", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := "Failed to get the plans of the service: " + c.buildErrorMessage(err, resp)
+		log.Print(msg)
+		return nil, &errors.HTTP{Code: http.StatusInternalServerError, Message: msg}
+	}
+	var plans []Plan
+	err = c.jsonFromResponse(resp, &plans)
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
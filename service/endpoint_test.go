@@ -5,6 +5,9 @@
 package service
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	stderrors "errors"
 	"github.com/globocom/tsuru/app/bind"
 	"github.com/globocom/tsuru/errors"
@@ -117,6 +120,168 @@ func (s *S) TestCreateShouldSendTheNameOfTheResourceToTheEndpoint(c *gocheck.C)
 	c.Assert("application/x-www-form-urlencoded", gocheck.DeepEquals, h.request.Header.Get("Content-Type"))
 }
 
+func (s *S) TestCreateMarksTheInstanceAsUp(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(noContentHandler))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis"}
+	client := &Client{endpoint: ts.URL}
+	err := client.Create(&instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(instance.State, gocheck.Equals, StateUp)
+}
+
+func (s *S) TestCreateMarksTheInstanceAsPendingWhenAPIReturns202(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis"}
+	client := &Client{endpoint: ts.URL}
+	err := client.Create(&instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(instance.State, gocheck.Equals, StatePending)
+	c.Assert(instance.Creating, gocheck.Equals, true)
+}
+
+func (s *S) TestCreateShouldSendThePlanToTheEndpoint(c *gocheck.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis", PlanName: "small"}
+	client := &Client{endpoint: ts.URL}
+	err := client.Create(&instance)
+	c.Assert(err, gocheck.IsNil)
+	h.Lock()
+	defer h.Unlock()
+	v, err := url.ParseQuery(string(h.body))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(map[string][]string(v), gocheck.DeepEquals, map[string][]string{"name": {"my-redis"}, "plan": {"small"}})
+}
+
+func (s *S) TestCreateShouldSendTheParamsToTheEndpoint(c *gocheck.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis", Params: map[string]string{"version": "2.6", "name": "cache"}}
+	client := &Client{endpoint: ts.URL}
+	err := client.Create(&instance)
+	c.Assert(err, gocheck.IsNil)
+	h.Lock()
+	defer h.Unlock()
+	v, err := url.ParseQuery(string(h.body))
+	c.Assert(err, gocheck.IsNil)
+	expected := map[string][]string{
+		"name":               {"my-redis"},
+		"parameters.version": {"2.6"},
+		"parameters.name":    {"cache"},
+	}
+	c.Assert(map[string][]string(v), gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestUpdateShouldSendAPUTRequestToTheResourceURL(c *gocheck.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis", PlanName: "large", Params: map[string]string{"version": "2.8"}}
+	client := &Client{endpoint: ts.URL}
+	err := client.Update(&instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(instance.State, gocheck.Equals, StateUp)
+	h.Lock()
+	defer h.Unlock()
+	c.Assert(h.url, gocheck.Equals, "/resources/my-redis")
+	c.Assert(h.method, gocheck.Equals, "PUT")
+	v, err := url.ParseQuery(string(h.body))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(map[string][]string(v), gocheck.DeepEquals, map[string][]string{"plan": {"large"}, "parameters.version": {"2.8"}})
+}
+
+func (s *S) TestUpdateMarksTheInstanceAsPendingWhenAPIReturns202(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis", State: StateUp}
+	client := &Client{endpoint: ts.URL}
+	err := client.Update(&instance)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(instance.State, gocheck.Equals, StatePending)
+}
+
+func (s *S) TestUpdateServiceWithoutSupportForUpdates(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis"}
+	client := &Client{endpoint: ts.URL}
+	err := client.Update(&instance)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, gocheck.Equals, "This service does not support updating instances.")
+}
+
+func (s *S) TestUpdateShouldReturnErrorIfTheRequestFails(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(failHandler))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "his-redis", ServiceName: "redis"}
+	client := &Client{endpoint: ts.URL}
+	err := client.Update(&instance)
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err, gocheck.ErrorMatches, "^Failed to update the instance "+instance.Name+": Server failed to do its job.$")
+}
+
+func (s *S) TestClientWithoutPasswordDoesNotAuthenticate(c *gocheck.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	client := &Client{endpoint: ts.URL, username: "redis"}
+	err := client.Create(&ServiceInstance{Name: "my-redis", ServiceName: "redis"})
+	c.Assert(err, gocheck.IsNil)
+	h.Lock()
+	defer h.Unlock()
+	c.Assert(h.request.Header.Get("Authorization"), gocheck.Equals, "")
+}
+
+func (s *S) TestClientBasicAuthentication(c *gocheck.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	client := &Client{endpoint: ts.URL, username: "redis", password: "s3cr3t"}
+	err := client.Create(&ServiceInstance{Name: "my-redis", ServiceName: "redis"})
+	c.Assert(err, gocheck.IsNil)
+	h.Lock()
+	defer h.Unlock()
+	c.Assert(h.request.Header.Get("Authorization"), gocheck.Equals, "Basic cmVkaXM6czNjcjN0")
+}
+
+func (s *S) TestClientHMACAuthentication(c *gocheck.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	client := &Client{endpoint: ts.URL, username: "redis", password: "s3cr3t", auth: AuthHMAC}
+	err := client.Create(&ServiceInstance{Name: "my-redis", ServiceName: "redis"})
+	c.Assert(err, gocheck.IsNil)
+	h.Lock()
+	defer h.Unlock()
+	date := h.request.Header.Get("Date")
+	c.Assert(date, gocheck.Not(gocheck.Equals), "")
+	expected := "HMAC-SHA256 " + signRequest("s3cr3t", "POST", "/resources", date, "name=my-redis")
+	c.Assert(h.request.Header.Get("Authorization"), gocheck.Equals, expected)
+}
+
+func (s *S) TestSignRequest(c *gocheck.C) {
+	date := "Wed, 20 Nov 2013 10:00:00 GMT"
+	signature := signRequest("s3cr3t", "DELETE", "/resources/my-redis", date, "")
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte("DELETE\n/resources/my-redis\n" + date + "\n"))
+	c.Assert(signature, gocheck.Equals, hex.EncodeToString(mac.Sum(nil)))
+	other := signRequest("s3cr3t", "DELETE", "/resources/other-redis", date, "")
+	c.Assert(other, gocheck.Not(gocheck.Equals), signature)
+}
+
 func (s *S) TestCreateShouldReturnErrorIfTheRequestFail(c *gocheck.C) {
 	ts := httptest.NewServer(http.HandlerFunc(failHandler))
 	defer ts.Close()
@@ -329,6 +494,19 @@ func (s *S) TestStatusShouldReturnPendingWhenAPIReturns202(c *gocheck.C) {
 	c.Assert(state, gocheck.Equals, "pending")
 }
 
+func (s *S) TestStatusNotImplemented(c *gocheck.C) {
+	for _, code := range []int{http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		instance := ServiceInstance{Name: "hi_there", ServiceName: "redis"}
+		client := Client{endpoint: ts.URL}
+		_, err := client.Status(&instance)
+		ts.Close()
+		c.Check(err, gocheck.Equals, ErrStatusNotImplemented)
+	}
+}
+
 func (s *S) TestInfo(c *gocheck.C) {
 	h := infoHandler{}
 	ts := httptest.NewServer(&h)
@@ -354,3 +532,35 @@ func (s *S) TestInfoNotFound(c *gocheck.C) {
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(result, gocheck.IsNil)
 }
+
+func (s *S) TestPlans(c *gocheck.C) {
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`[{"name":"small","description":"1 GB"},{"name":"large","description":"10 GB"}]`))
+	}))
+	defer ts.Close()
+	client := &Client{endpoint: ts.URL}
+	plans, err := client.Plans()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(path, gocheck.Equals, "/resources/plans")
+	expected := []Plan{{Name: "small", Description: "1 GB"}, {Name: "large", Description: "10 GB"}}
+	c.Assert(plans, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestPlansNotFound(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(notFoundHandler))
+	defer ts.Close()
+	client := &Client{endpoint: ts.URL}
+	plans, err := client.Plans()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(plans, gocheck.IsNil)
+}
+
+func (s *S) TestPlansFailure(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(failHandler))
+	defer ts.Close()
+	client := &Client{endpoint: ts.URL}
+	_, err := client.Plans()
+	c.Assert(err, gocheck.ErrorMatches, "^Failed to get the plans of the service: Server failed to do its job.$")
+}
@@ -8,13 +8,35 @@ import (
 	"errors"
 	"github.com/globocom/tsuru/auth"
 	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/secret"
 	"labix.org/v2/mgo/bson"
 	"strings"
 )
 
+// Authentication schemes used by tsuru when calling the API of services.
+const (
+	// AuthBasic sends the name of the service and its password using HTTP
+	// Basic authentication.
+	AuthBasic = "basic"
+
+	// AuthHMAC signs each request with HMAC-SHA256, using the password of
+	// the service as key.
+	AuthHMAC = "hmac"
+)
+
 type Service struct {
-	Name         string `bson:"_id"`
-	Endpoint     map[string]string
+	Name     string `bson:"_id"`
+	Endpoint map[string]string
+
+	// Password is the secret shared between tsuru and the API of the
+	// service, stored encrypted (see SetPassword). When it's empty,
+	// requests are not authenticated.
+	Password string `json:"-"`
+
+	// Auth is the authentication scheme used in requests to the API of
+	// the service: AuthBasic (default) or AuthHMAC.
+	Auth string
+
 	OwnerTeams   []string `bson:"owner_teams"`
 	Teams        []string
 	Status       string
@@ -61,16 +83,34 @@ func (s *Service) Delete() error {
 	return conn.Services().Update(bson.M{"_id": s.Name}, s)
 }
 
-func (s *Service) getClient(endpoint string) (cli *Client, err error) {
-	if e, ok := s.Endpoint[endpoint]; ok {
-		if !strings.HasPrefix(e, "http://") {
-			e = "http://" + e
-		}
-		cli = &Client{endpoint: e}
-	} else {
-		err = errors.New("Unknown endpoint: " + endpoint)
+// SetPassword validates the authentication scheme and sets the password used
+// in requests to the API of the service, encrypting it.
+func (s *Service) SetPassword(password, scheme string) error {
+	if scheme != "" && scheme != AuthBasic && scheme != AuthHMAC {
+		return errors.New("Invalid authentication scheme: " + scheme + ". Valid schemes are basic and hmac.")
+	}
+	encrypted, err := secret.Encrypt(password)
+	if err != nil {
+		return err
+	}
+	s.Password = encrypted
+	s.Auth = scheme
+	return nil
+}
+
+func (s *Service) getClient(endpoint string) (*Client, error) {
+	e, ok := s.Endpoint[endpoint]
+	if !ok {
+		return nil, errors.New("Unknown endpoint: " + endpoint)
+	}
+	if !strings.HasPrefix(e, "http://") && !strings.HasPrefix(e, "https://") {
+		e = "http://" + e
 	}
-	return
+	password, err := secret.Decrypt(s.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{endpoint: e, username: s.Name, password: password, auth: s.Auth}, nil
 }
 
 func (s *Service) findTeam(team *auth.Team) int {
@@ -154,4 +194,27 @@ func GetServicesByOwnerTeams(teamKind string, u *auth.User) ([]Service, error) {
 type ServiceModel struct {
 	Service   string   `json:"service"`
 	Instances []string `json:"instances"`
+	Plans     []string `json:"plans"`
+}
+
+// Plans returns the plans offered by the service, as advertised by its API.
+func (s *Service) Plans() ([]Plan, error) {
+	endpoint, err := s.getClient("production")
+	if err != nil {
+		return nil, err
+	}
+	return endpoint.Plans()
+}
+
+func (s *Service) validatePlan(name string) error {
+	plans, err := s.Plans()
+	if err != nil {
+		return err
+	}
+	for _, p := range plans {
+		if p.Name == name {
+			return nil
+		}
+	}
+	return ErrInvalidPlan
 }
@@ -7,12 +7,15 @@ package service
 import (
 	"encoding/json"
 	stderrors "errors"
+	"fmt"
 	"github.com/globocom/tsuru/app/bind"
 	"github.com/globocom/tsuru/auth"
 	"github.com/globocom/tsuru/db"
 	"github.com/globocom/tsuru/errors"
 	"github.com/globocom/tsuru/log"
 	"github.com/globocom/tsuru/rec"
+	"github.com/globocom/tsuru/secret"
+	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"net/http"
 	"regexp"
@@ -22,20 +25,48 @@ var (
 	ErrServiceInstanceNotFound = stderrors.New("Service instance not found")
 	ErrInvalidInstanceName     = stderrors.New("Invalid service instance name")
 	ErrAccessNotAllowed        = stderrors.New("User does not have access to this service instance")
+	ErrInvalidPlan             = stderrors.New("Invalid plan for this service")
+	ErrInstancePending         = stderrors.New("The service instance is still being provisioned. The app will be bound to it when it's ready.")
+	ErrInvalidParam            = stderrors.New("Invalid parameter name")
+	ErrAlreadyShared           = stderrors.New("This team already has access to this service instance")
+	ErrNotShared               = stderrors.New("This service instance is not shared with this team")
+
+	// errInstanceNotPending is returned by addPendingApp when the instance
+	// left the pending state before the app could be recorded.
+	errInstanceNotPending = stderrors.New("The service instance is not pending")
 
 	instanceNameRegexp = regexp.MustCompile(`^[A-Za-z][-a-zA-Z0-9_]+$`)
+	paramNameRegexp    = regexp.MustCompile(`^[A-Za-z][-a-zA-Z0-9_]*$`)
+)
+
+// Provisioning states of service instances. Instances created by services
+// that provision them asynchronously stay in the pending state until the
+// service reports them as up, or as failed. Instances without state are up.
+const (
+	StatePending = "pending"
+	StateUp      = "up"
+	StateFailed  = "failed"
 )
 
 type ServiceInstance struct {
 	Name        string
-	ServiceName string `bson:"service_name"`
+	ServiceName string            `bson:"service_name"`
+	PlanName    string            `bson:"plan_name"`
+	Params      map[string]string `bson:"params,omitempty"`
+	State       string
+	// Creating indicates that the instance is pending because the service
+	// is still creating it (it answered the creation with 202), and not
+	// because of an update.
+	Creating    bool `bson:",omitempty"`
 	Apps        []string
+	PendingApps []string `bson:"pending_apps"`
 	Teams       []string
+	SharedTeams []string `bson:"shared_teams,omitempty"`
 }
 
 // DeleteInstance deletes the service instance from the database.
 func DeleteInstance(si *ServiceInstance) error {
-	if len(si.Apps) > 0 {
+	if len(si.Apps) > 0 || len(si.PendingApps) > 0 {
 		msg := "This service instance is bound to at least one app. Unbind them before removing it"
 		return stderrors.New(msg)
 	}
@@ -60,8 +91,12 @@ func (si *ServiceInstance) MarshalJSON() ([]byte, error) {
 	data := map[string]interface{}{
 		"Name":        si.Name,
 		"Teams":       si.Teams,
+		"SharedTeams": si.SharedTeams,
 		"Apps":        si.Apps,
 		"ServiceName": si.ServiceName,
+		"PlanName":    si.PlanName,
+		"Params":      maskParams(si.Params),
+		"State":       si.State,
 		"Info":        info,
 	}
 	return json.Marshal(&data)
@@ -134,25 +169,256 @@ func (si *ServiceInstance) RemoveApp(appName string) error {
 	return nil
 }
 
-func (si *ServiceInstance) update() error {
+func (si *ServiceInstance) findSharedTeam(team *auth.Team) int {
+	for i, name := range si.SharedTeams {
+		if name == team.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+// GrantAccess shares the instance with the team, so apps of the team can be
+// bound to it. The teams that own the instance already have access to it.
+//
+// Only the list of shared teams is changed in the database, so concurrent
+// changes to the other fields of the instance are kept.
+func (si *ServiceInstance) GrantAccess(team *auth.Team) error {
+	for _, name := range si.Teams {
+		if name == team.Name {
+			return ErrAlreadyShared
+		}
+	}
+	if si.findSharedTeam(team) > -1 {
+		return ErrAlreadyShared
+	}
+	err := si.updateWith(
+		bson.M{"shared_teams": bson.M{"$ne": team.Name}},
+		bson.M{"$addToSet": bson.M{"shared_teams": team.Name}},
+	)
+	if err == mgo.ErrNotFound {
+		return ErrAlreadyShared
+	} else if err != nil {
+		return err
+	}
+	si.SharedTeams = append(si.SharedTeams, team.Name)
+	return nil
+}
+
+// RevokeAccess stops sharing the instance with the team. Apps of the team that
+// are already bound to the instance are not unbound.
+func (si *ServiceInstance) RevokeAccess(team *auth.Team) error {
+	index := si.findSharedTeam(team)
+	if index < 0 {
+		return ErrNotShared
+	}
+	err := si.updateWith(
+		bson.M{"shared_teams": team.Name},
+		bson.M{"$pull": bson.M{"shared_teams": team.Name}},
+	)
+	if err == mgo.ErrNotFound {
+		return ErrNotShared
+	} else if err != nil {
+		return err
+	}
+	copy(si.SharedTeams[index:], si.SharedTeams[index+1:])
+	si.SharedTeams = si.SharedTeams[:len(si.SharedTeams)-1]
+	return nil
+}
+
+// IsSharedWith indicates whether the instance is shared with at least one of
+// the given teams.
+func (si *ServiceInstance) IsSharedWith(teams []string) bool {
+	for _, name := range teams {
+		if si.findSharedTeam(&auth.Team{Name: name}) > -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// findPendingApp returns the index of the app in the list of apps waiting for
+// the instance to be ready, or -1 if the app is not in the list.
+func (si *ServiceInstance) findPendingApp(appName string) int {
+	for i, name := range si.PendingApps {
+		if name == appName {
+			return i
+		}
+	}
+	return -1
+}
+
+// addPendingApp records the app in the list of apps that must be bound to the
+// instance as soon as it's ready. The app is only recorded while the instance
+// is pending in the database; otherwise, the state of the instance is
+// reloaded and errInstanceNotPending is returned.
+func (si *ServiceInstance) addPendingApp(appName string) error {
 	conn, err := db.Conn()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	return conn.ServiceInstances().Update(bson.M{"name": si.Name}, si)
+	err = conn.ServiceInstances().Update(
+		bson.M{"name": si.Name, "state": StatePending},
+		bson.M{"$addToSet": bson.M{"pending_apps": appName}},
+	)
+	if err == mgo.ErrNotFound {
+		var stored ServiceInstance
+		err = conn.ServiceInstances().Find(bson.M{"name": si.Name}).Select(bson.M{"state": 1}).One(&stored)
+		if err != nil {
+			return err
+		}
+		si.State = stored.State
+		return errInstanceNotPending
+	} else if err != nil {
+		return err
+	}
+	if si.findPendingApp(appName) < 0 {
+		si.PendingApps = append(si.PendingApps, appName)
+	}
+	return ErrInstancePending
 }
 
-// BindApp makes the bind between the service instance and an app.
-func (si *ServiceInstance) BindApp(app bind.App) error {
-	err := si.AddApp(app.GetName())
+// SetState changes the provisioning state of the instance. When the instance
+// leaves the pending state, the list of apps waiting for it is cleared and
+// returned, so the caller can bind them (or give up on them, when the
+// provisioning fails). The list is read and cleared atomically, so apps that
+// are added concurrently are never lost.
+func (si *ServiceInstance) SetState(state string) ([]string, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if state == StatePending {
+		err = conn.ServiceInstances().Update(bson.M{"name": si.Name}, bson.M{"$set": bson.M{"state": state}})
+		if err != nil {
+			return nil, err
+		}
+		si.State = state
+		return nil, nil
+	}
+	var old ServiceInstance
+	change := mgo.Change{
+		Update: bson.M{
+			"$set":   bson.M{"state": state},
+			"$unset": bson.M{"pending_apps": 1, "creating": 1},
+		},
+	}
+	if _, err = conn.ServiceInstances().Find(bson.M{"name": si.Name}).Apply(change, &old); err != nil {
+		return nil, err
+	}
+	si.State = state
+	si.PendingApps = nil
+	si.Creating = false
+	return old.PendingApps, nil
+}
+
+// Update changes the plan and the parameters of the instance in the service
+// API. The given parameters are merged into the current ones, and parameters
+// with empty values are removed. The instance is only changed when the service
+// API accepts the changes. Services that apply them asynchronously put the
+// instance back in the pending state.
+func (si *ServiceInstance) Update(planName string, params map[string]string) error {
+	if si.State == StatePending {
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: "This service instance is still being provisioned."}
+	}
+	if err := validateParams(params); err != nil {
+		return err
+	}
+	srv := si.Service()
+	updated := *si
+	if planName != "" {
+		if err := srv.validatePlan(planName); err != nil {
+			return err
+		}
+		updated.PlanName = planName
+	}
+	updated.Params = make(map[string]string, len(si.Params)+len(params))
+	for name, value := range si.Params {
+		updated.Params[name] = value
+	}
+	for name, value := range params {
+		if value == "" {
+			delete(updated.Params, name)
+			continue
+		}
+		value, err := secret.Encrypt(value)
+		if err != nil {
+			return err
+		}
+		updated.Params[name] = value
+	}
+	if len(updated.Params) == 0 {
+		updated.Params = nil
+	}
+	endpoint, err := srv.getClient("production")
 	if err != nil {
-		return &errors.HTTP{Code: http.StatusConflict, Message: "This app is already bound to this service instance."}
+		return err
 	}
-	err = si.update()
+	err = endpoint.Update(&updated)
 	if err != nil {
 		return err
 	}
+	change := bson.M{"$set": bson.M{"plan_name": updated.PlanName, "state": updated.State}}
+	if updated.Params == nil {
+		change["$unset"] = bson.M{"params": 1}
+	} else {
+		change["$set"].(bson.M)["params"] = updated.Params
+	}
+	if err := si.updateWith(nil, change); err != nil {
+		return err
+	}
+	*si = updated
+	return nil
+}
+
+// updateWith applies the change to the instance in the database, using
+// targeted operators, so fields that are changed concurrently are kept. The
+// query may add conditions to the name of the instance.
+func (si *ServiceInstance) updateWith(query, change bson.M) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	q := bson.M{"name": si.Name}
+	for k, v := range query {
+		q[k] = v
+	}
+	return conn.ServiceInstances().Update(q, change)
+}
+
+// BindApp makes the bind between the service instance and an app.
+//
+// When the instance is still being provisioned, the app is bound as soon as
+// the instance is ready, and ErrInstancePending is returned. When the service
+// API reports that an instance that is up is not ready for binding, the state
+// of the instance is kept and the error is returned, so the user can try
+// again later.
+func (si *ServiceInstance) BindApp(app bind.App) error {
+	if si.State == StatePending {
+		if err := si.addPendingApp(app.GetName()); err != errInstanceNotPending {
+			return err
+		}
+	}
+	if si.State == StateFailed {
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: "This service instance failed to be provisioned."}
+	}
+	conflict := &errors.HTTP{Code: http.StatusConflict, Message: "This app is already bound to this service instance."}
+	if err := si.AddApp(app.GetName()); err != nil {
+		return conflict
+	}
+	err := si.updateWith(
+		bson.M{"apps": bson.M{"$ne": app.GetName()}},
+		bson.M{"$push": bson.M{"apps": app.GetName()}},
+	)
+	if err == mgo.ErrNotFound {
+		si.RemoveApp(app.GetName())
+		return conflict
+	} else if err != nil {
+		return err
+	}
 	if len(app.GetUnits()) == 0 {
 		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: "This app does not have an IP yet."}
 	}
@@ -181,6 +447,12 @@ func (si *ServiceInstance) BindApp(app bind.App) error {
 		}
 		return app.SetEnvs(envVars, false)
 	case err = <-errChan:
+		if e, ok := err.(*errors.HTTP); ok && e.Code == http.StatusPreconditionFailed {
+			si.RemoveApp(app.GetName())
+			if uErr := si.updateWith(nil, bson.M{"$pull": bson.M{"apps": app.GetName()}}); uErr != nil {
+				return uErr
+			}
+		}
 	}
 	return err
 }
@@ -196,11 +468,15 @@ func (si *ServiceInstance) BindUnit(app bind.App, unit bind.Unit) (map[string]st
 
 // UnbindApp makes the unbind between the service instance and an app.
 func (si *ServiceInstance) UnbindApp(app bind.App) error {
+	if i := si.findPendingApp(app.GetName()); i > -1 {
+		si.PendingApps = append(si.PendingApps[:i], si.PendingApps[i+1:]...)
+		return si.updateWith(nil, bson.M{"$pull": bson.M{"pending_apps": app.GetName()}})
+	}
 	err := si.RemoveApp(app.GetName())
 	if err != nil {
 		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: "This app is not bound to this service instance."}
 	}
-	err = si.update()
+	err = si.updateWith(nil, bson.M{"$pull": bson.M{"apps": app.GetName()}})
 	if err != nil {
 		return err
 	}
@@ -235,10 +511,13 @@ func (si *ServiceInstance) Status() (string, error) {
 }
 
 func genericServiceInstancesFilter(services interface{}, teams []string) (q, f bson.M) {
-	f = bson.M{"name": 1, "service_name": 1, "apps": 1}
+	f = bson.M{"name": 1, "service_name": 1, "plan_name": 1, "state": 1, "apps": 1}
 	q = bson.M{}
 	if len(teams) != 0 {
-		q["teams"] = bson.M{"$in": teams}
+		q["$or"] = []bson.M{
+			{"teams": bson.M{"$in": teams}},
+			{"shared_teams": bson.M{"$in": teams}},
+		}
 	}
 	if v, ok := services.([]Service); ok {
 		names := GetServicesNames(v)
@@ -250,14 +529,75 @@ func genericServiceInstancesFilter(services interface{}, teams []string) (q, f b
 	return
 }
 
-func CreateServiceInstance(name string, service *Service, user *auth.User) error {
-	if !instanceNameRegexp.MatchString(name) {
+// validateParams checks the names of the parameters of an instance. Names are
+// used as keys in the database, so they're restricted to letters, digits,
+// dashes and underscores.
+func validateParams(params map[string]string) error {
+	for name := range params {
+		if !paramNameRegexp.MatchString(name) {
+			return ErrInvalidParam
+		}
+	}
+	return nil
+}
+
+// encryptParams returns a copy of the parameters with the values encrypted
+// using the secret package. Parameters may contain credentials, so their
+// values are stored encrypted and sent in plain text only to the service.
+func encryptParams(params map[string]string) (map[string]string, error) {
+	if params == nil {
+		return nil, nil
+	}
+	encrypted := make(map[string]string, len(params))
+	for name, value := range params {
+		value, err := secret.Encrypt(value)
+		if err != nil {
+			return nil, err
+		}
+		encrypted[name] = value
+	}
+	return encrypted, nil
+}
+
+// maskParams returns a copy of the parameters with the values hidden, so they
+// can be displayed to users.
+func maskParams(params map[string]string) map[string]string {
+	if params == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(params))
+	for name := range params {
+		masked[name] = "***"
+	}
+	return masked
+}
+
+// CreateServiceInstance creates the instance in the service API and stores it
+// in the database. The name of the instance, the plan, when the service offers
+// plans, and the parameters forwarded to the service must be filled by the
+// caller.
+//
+// Services may provision instances asynchronously, in which case the instance
+// is stored in the pending state, and the caller is responsible for checking
+// its status until it's ready.
+func CreateServiceInstance(instance *ServiceInstance, service *Service, user *auth.User) error {
+	if !instanceNameRegexp.MatchString(instance.Name) {
 		return ErrInvalidInstanceName
 	}
-	instance := ServiceInstance{
-		Name:        name,
-		ServiceName: service.Name,
+	instance.ServiceName = service.Name
+	if instance.PlanName != "" {
+		if err := service.validatePlan(instance.PlanName); err != nil {
+			return err
+		}
+	}
+	if err := validateParams(instance.Params); err != nil {
+		return err
 	}
+	params, err := encryptParams(instance.Params)
+	if err != nil {
+		return err
+	}
+	instance.Params = params
 	teams, err := user.Teams()
 	if err != nil {
 		return err
@@ -272,19 +612,19 @@ func CreateServiceInstance(name string, service *Service, user *auth.User) error
 	if err != nil {
 		return err
 	}
-	err = endpoint.Create(&instance)
+	err = endpoint.Create(instance)
 	if err != nil {
 		return err
 	}
 	conn, err := db.Conn()
 	if err != nil {
-		endpoint.Destroy(&instance)
+		endpoint.Destroy(instance)
 		return err
 	}
 	defer conn.Close()
 	err = conn.ServiceInstances().Insert(instance)
 	if err != nil {
-		endpoint.Destroy(&instance)
+		endpoint.Destroy(instance)
 		return err
 	}
 	return nil
@@ -298,7 +638,7 @@ func GetServiceInstancesByServices(services []Service) ([]ServiceInstance, error
 	}
 	defer conn.Close()
 	q, _ := genericServiceInstancesFilter(services, []string{})
-	f := bson.M{"name": 1, "service_name": 1}
+	f := bson.M{"name": 1, "service_name": 1, "plan_name": 1}
 	err = conn.ServiceInstances().Find(q).Select(f).All(&instances)
 	return instances, err
 }
@@ -328,7 +668,7 @@ func GetServiceInstance(name string, u *auth.User) (*ServiceInstance, error) {
 		return nil, err
 	}
 	defer conn.Close()
-	rec.Log(u.Email, "get-service-instance", name)
+	rec.Log(u.Email, "get-service-instance", "instance="+name)
 	var instance ServiceInstance
 	err = conn.ServiceInstances().Find(bson.M{"name": name}).One(&instance)
 	if err != nil {
@@ -339,3 +679,52 @@ func GetServiceInstance(name string, u *auth.User) (*ServiceInstance, error) {
 	}
 	return &instance, nil
 }
+
+// RotateParams encrypts the parameters of all service instances with the
+// current key, including parameters stored in plain text. It returns the
+// number of instances that have been updated.
+func RotateParams() (int, error) {
+	if !secret.Enabled() {
+		return 0, stderrors.New("There's no encryption key in the configuration (secret:key).")
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var instances []ServiceInstance
+	err = conn.ServiceInstances().Find(bson.M{"params": bson.M{"$exists": true}}).Select(bson.M{"name": 1, "params": 1}).All(&instances)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, instance := range instances {
+		var changed bool
+		for name, old := range instance.Params {
+			if !secret.NeedsRotation(old) {
+				continue
+			}
+			value, err := secret.Rotate(old)
+			if err != nil {
+				return n, fmt.Errorf("Failed to rotate the parameter %s of the service instance %s: %s", name, instance.Name, err)
+			}
+			// Like in app.RotateEnvs, each parameter is updated only if
+			// it still has the value that was rotated.
+			field := "params." + name
+			err = conn.ServiceInstances().Update(
+				bson.M{"name": instance.Name, field: old},
+				bson.M{"$set": bson.M{field: value}},
+			)
+			if err == mgo.ErrNotFound {
+				continue
+			} else if err != nil {
+				return n, err
+			}
+			changed = true
+		}
+		if changed {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -10,11 +10,14 @@ import (
 	"github.com/globocom/tsuru/app/bind"
 	"github.com/globocom/tsuru/auth"
 	"github.com/globocom/tsuru/db"
+	"github.com/globocom/tsuru/errors"
+	"github.com/globocom/tsuru/secret"
 	"github.com/globocom/tsuru/testing"
 	"labix.org/v2/mgo/bson"
 	"launchpad.net/gocheck"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"sync/atomic"
 )
 
@@ -180,8 +183,15 @@ func (s *InstanceSuite) TestGenericServiceInstancesFilter(c *gocheck.C) {
 	srvc := Service{Name: "mysql"}
 	teams := []string{s.team.Name}
 	q, f := genericServiceInstancesFilter(srvc, teams)
-	c.Assert(q, gocheck.DeepEquals, bson.M{"service_name": srvc.Name, "teams": bson.M{"$in": teams}})
-	c.Assert(f, gocheck.DeepEquals, bson.M{"name": 1, "service_name": 1, "apps": 1})
+	expected := bson.M{
+		"service_name": srvc.Name,
+		"$or": []bson.M{
+			{"teams": bson.M{"$in": teams}},
+			{"shared_teams": bson.M{"$in": teams}},
+		},
+	}
+	c.Assert(q, gocheck.DeepEquals, expected)
+	c.Assert(f, gocheck.DeepEquals, bson.M{"name": 1, "service_name": 1, "plan_name": 1, "state": 1, "apps": 1})
 }
 
 func (s *InstanceSuite) TestGenericServiceInstancesFilterWithServiceSlice(c *gocheck.C) {
@@ -192,8 +202,15 @@ func (s *InstanceSuite) TestGenericServiceInstancesFilterWithServiceSlice(c *goc
 	names := []string{"mysql", "mongodb"}
 	teams := []string{s.team.Name}
 	q, f := genericServiceInstancesFilter(services, teams)
-	c.Assert(q, gocheck.DeepEquals, bson.M{"service_name": bson.M{"$in": names}, "teams": bson.M{"$in": teams}})
-	c.Assert(f, gocheck.DeepEquals, bson.M{"name": 1, "service_name": 1, "apps": 1})
+	expected := bson.M{
+		"service_name": bson.M{"$in": names},
+		"$or": []bson.M{
+			{"teams": bson.M{"$in": teams}},
+			{"shared_teams": bson.M{"$in": teams}},
+		},
+	}
+	c.Assert(q, gocheck.DeepEquals, expected)
+	c.Assert(f, gocheck.DeepEquals, bson.M{"name": 1, "service_name": 1, "plan_name": 1, "state": 1, "apps": 1})
 }
 
 func (s *InstanceSuite) TestGenericServiceInstancesFilterWithoutSpecifingTeams(c *gocheck.C) {
@@ -205,7 +222,7 @@ func (s *InstanceSuite) TestGenericServiceInstancesFilterWithoutSpecifingTeams(c
 	teams := []string{}
 	q, f := genericServiceInstancesFilter(services, teams)
 	c.Assert(q, gocheck.DeepEquals, bson.M{"service_name": bson.M{"$in": names}})
-	c.Assert(f, gocheck.DeepEquals, bson.M{"name": 1, "service_name": 1, "apps": 1})
+	c.Assert(f, gocheck.DeepEquals, bson.M{"name": 1, "service_name": 1, "plan_name": 1, "state": 1, "apps": 1})
 }
 
 func (s *InstanceSuite) TestGetServiceInstancesByServicesAndTeams(c *gocheck.C) {
@@ -259,6 +276,118 @@ func (s *InstanceSuite) TestGetServiceInstancesByServicesAndTeams(c *gocheck.C)
 	c.Assert(sInstances, gocheck.DeepEquals, expected)
 }
 
+func (s *InstanceSuite) TestGetServiceInstancesByServicesAndTeamsIncludesSharedInstances(c *gocheck.C) {
+	srvc := Service{Name: "mysql"}
+	err := s.conn.Services().Insert(&srvc)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srvc.Name)
+	sInstance := ServiceInstance{
+		Name:        "shared-sql",
+		ServiceName: srvc.Name,
+		Teams:       []string{"other-team"},
+		SharedTeams: []string{s.team.Name},
+	}
+	err = s.conn.ServiceInstances().Insert(&sInstance)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": sInstance.Name})
+	sInstances, err := GetServiceInstancesByServicesAndTeams([]Service{srvc}, s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(sInstances, gocheck.HasLen, 1)
+	c.Assert(sInstances[0].Name, gocheck.Equals, sInstance.Name)
+}
+
+func (s *InstanceSuite) TestGrantAccess(c *gocheck.C) {
+	si := ServiceInstance{Name: "ql", Teams: []string{s.team.Name}}
+	err := si.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": si.Name})
+	team := auth.Team{Name: "other-team"}
+	err = si.GrantAccess(&team)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(si.SharedTeams, gocheck.DeepEquals, []string{"other-team"})
+	c.Assert(si.IsSharedWith([]string{"some-team", "other-team"}), gocheck.Equals, true)
+	c.Assert(si.IsSharedWith([]string{s.team.Name}), gocheck.Equals, false)
+	var stored ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": si.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.SharedTeams, gocheck.DeepEquals, []string{"other-team"})
+}
+
+func (s *InstanceSuite) TestGrantAccessKeepsConcurrentChanges(c *gocheck.C) {
+	si := ServiceInstance{
+		Name:        "ql",
+		Teams:       []string{s.team.Name},
+		State:       StatePending,
+		Creating:    true,
+		PendingApps: []string{"myapp"},
+	}
+	err := si.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": si.Name})
+	stale := si
+	_, err = si.SetState(StateUp)
+	c.Assert(err, gocheck.IsNil)
+	err = stale.GrantAccess(&auth.Team{Name: "other-team"})
+	c.Assert(err, gocheck.IsNil)
+	var stored ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": si.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.SharedTeams, gocheck.DeepEquals, []string{"other-team"})
+	c.Assert(stored.State, gocheck.Equals, StateUp)
+	c.Assert(stored.Creating, gocheck.Equals, false)
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+	err = stale.RevokeAccess(&auth.Team{Name: "other-team"})
+	c.Assert(err, gocheck.IsNil)
+	err = s.conn.ServiceInstances().Find(bson.M{"name": si.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.SharedTeams, gocheck.HasLen, 0)
+	c.Assert(stored.State, gocheck.Equals, StateUp)
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+}
+
+func (s *InstanceSuite) TestGrantAccessSharedConcurrently(c *gocheck.C) {
+	si := ServiceInstance{Name: "ql", Teams: []string{s.team.Name}}
+	err := si.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": si.Name})
+	stale := si
+	err = si.GrantAccess(&auth.Team{Name: "other-team"})
+	c.Assert(err, gocheck.IsNil)
+	err = stale.GrantAccess(&auth.Team{Name: "other-team"})
+	c.Assert(err, gocheck.Equals, ErrAlreadyShared)
+	var stored ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": si.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.SharedTeams, gocheck.DeepEquals, []string{"other-team"})
+}
+
+func (s *InstanceSuite) TestGrantAccessTeamAlreadyHasAccess(c *gocheck.C) {
+	si := ServiceInstance{Name: "ql", Teams: []string{s.team.Name}, SharedTeams: []string{"other-team"}}
+	err := si.GrantAccess(s.team)
+	c.Assert(err, gocheck.ErrorMatches, "^This team already has access to this service instance$")
+	err = si.GrantAccess(&auth.Team{Name: "other-team"})
+	c.Assert(err, gocheck.ErrorMatches, "^This team already has access to this service instance$")
+	c.Assert(si.SharedTeams, gocheck.DeepEquals, []string{"other-team"})
+}
+
+func (s *InstanceSuite) TestRevokeAccess(c *gocheck.C) {
+	si := ServiceInstance{Name: "ql", Teams: []string{s.team.Name}, SharedTeams: []string{"other-team", "third-team"}}
+	err := si.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": si.Name})
+	err = si.RevokeAccess(&auth.Team{Name: "other-team"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(si.SharedTeams, gocheck.DeepEquals, []string{"third-team"})
+	var stored ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": si.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.SharedTeams, gocheck.DeepEquals, []string{"third-team"})
+	err = si.RevokeAccess(&auth.Team{Name: "other-team"})
+	c.Assert(err, gocheck.ErrorMatches, "^This service instance is not shared with this team$")
+	err = si.RevokeAccess(s.team)
+	c.Assert(err, gocheck.ErrorMatches, "^This service instance is not shared with this team$")
+}
+
 func (s *InstanceSuite) TestGetServiceInstancesByServicesAndTeamsForUsersThatAreNotMembersOfAnyTeam(c *gocheck.C) {
 	u := auth.User{Email: "noteamforme@globo.com", Password: "123"}
 	err := u.Create()
@@ -308,7 +437,7 @@ func (s *InstanceSuite) TestMarshalJSON(c *gocheck.C) {
 	err := s.conn.Services().Insert(&srvc)
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.Services().RemoveId(srvc.Name)
-	si := ServiceInstance{Name: "ql", ServiceName: srvc.Name}
+	si := ServiceInstance{Name: "ql", ServiceName: srvc.Name, PlanName: "small"}
 	data, err := json.Marshal(&si)
 	c.Assert(err, gocheck.IsNil)
 	var result map[string]interface{}
@@ -317,13 +446,31 @@ func (s *InstanceSuite) TestMarshalJSON(c *gocheck.C) {
 	expected := map[string]interface{}{
 		"Name":        "ql",
 		"Teams":       nil,
+		"SharedTeams": nil,
 		"Apps":        nil,
 		"ServiceName": "mysql",
+		"PlanName":    "small",
+		"Params":      nil,
+		"State":       "",
 		"Info":        map[string]interface{}{"key": "value"},
 	}
 	c.Assert(result, gocheck.DeepEquals, expected)
 }
 
+func (s *InstanceSuite) TestMarshalJSONHidesTheValuesOfTheParams(c *gocheck.C) {
+	srvc := Service{Name: "mysql", Endpoint: map[string]string{"production": ""}}
+	err := s.conn.Services().Insert(&srvc)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srvc.Name)
+	si := ServiceInstance{Name: "ql", ServiceName: srvc.Name, Params: map[string]string{"password": "s3cr3t"}}
+	data, err := json.Marshal(&si)
+	c.Assert(err, gocheck.IsNil)
+	var result map[string]interface{}
+	err = json.Unmarshal(data, &result)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(result["Params"], gocheck.DeepEquals, map[string]interface{}{"password": "***"})
+}
+
 func (s *InstanceSuite) TestMarshalJSONWithoutInfo(c *gocheck.C) {
 	srvc := Service{Name: "mysql", Endpoint: map[string]string{"production": ""}}
 	err := s.conn.Services().Insert(&srvc)
@@ -338,8 +485,12 @@ func (s *InstanceSuite) TestMarshalJSONWithoutInfo(c *gocheck.C) {
 	expected := map[string]interface{}{
 		"Name":        "ql",
 		"Teams":       nil,
+		"SharedTeams": nil,
 		"Apps":        nil,
 		"ServiceName": "mysql",
+		"PlanName":    "",
+		"Params":      nil,
+		"State":       "",
 		"Info":        nil,
 	}
 	c.Assert(result, gocheck.DeepEquals, expected)
@@ -359,8 +510,12 @@ func (s *InstanceSuite) TestMarshalJSONWithoutEndpoint(c *gocheck.C) {
 	expected := map[string]interface{}{
 		"Name":        "ql",
 		"Teams":       nil,
+		"SharedTeams": nil,
 		"Apps":        nil,
 		"ServiceName": "mysql",
+		"PlanName":    "",
+		"Params":      nil,
+		"State":       "",
 		"Info":        nil,
 	}
 	c.Assert(result, gocheck.DeepEquals, expected)
@@ -397,6 +552,12 @@ func (s *InstanceSuite) TestDeleteInstanceWithApps(c *gocheck.C) {
 	c.Assert(err, gocheck.ErrorMatches, "^This service instance is bound to at least one app. Unbind them before removing it$")
 }
 
+func (s *InstanceSuite) TestDeleteInstanceWithPendingApps(c *gocheck.C) {
+	si := ServiceInstance{Name: "instance", State: StatePending, PendingApps: []string{"foo"}}
+	err := DeleteInstance(&si)
+	c.Assert(err, gocheck.ErrorMatches, "^This service instance is bound to at least one app. Unbind them before removing it$")
+}
+
 func (s *InstanceSuite) TestCreateServiceInstance(c *gocheck.C) {
 	var requests int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -408,7 +569,7 @@ func (s *InstanceSuite) TestCreateServiceInstance(c *gocheck.C) {
 	err := s.conn.Services().Insert(&srv)
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.Services().RemoveId(srv.Name)
-	err = CreateServiceInstance("instance", &srv, s.user)
+	err = CreateServiceInstance(&ServiceInstance{Name: "instance"}, &srv, s.user)
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
 	_, err = GetServiceInstance("instance", s.user)
@@ -435,7 +596,7 @@ func (s *InstanceSuite) TestCreateServiceInstanceRestrictedService(c *gocheck.C)
 	err = s.conn.Services().Insert(&srv)
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.Services().RemoveId(srv.Name)
-	err = CreateServiceInstance("instance", &srv, s.user)
+	err = CreateServiceInstance(&ServiceInstance{Name: "instance"}, &srv, s.user)
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
 	instance, err := GetServiceInstance("instance", s.user)
@@ -452,13 +613,76 @@ func (s *InstanceSuite) TestCreateServiceInstanceEndpointFailure(c *gocheck.C) {
 	err := s.conn.Services().Insert(&srv)
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.Services().RemoveId(srv.Name)
-	err = CreateServiceInstance("instance", &srv, s.user)
+	err = CreateServiceInstance(&ServiceInstance{Name: "instance"}, &srv, s.user)
 	c.Assert(err, gocheck.NotNil)
 	count, err := s.conn.ServiceInstances().Find(bson.M{"name": "instance"}).Count()
 	c.Assert(err, gocheck.IsNil)
 	c.Assert(count, gocheck.Equals, 0)
 }
 
+func (s *InstanceSuite) TestCreateServiceInstanceAsynchronously(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{Name: "instance"}
+	err = CreateServiceInstance(&instance, &srv, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	c.Assert(instance.State, gocheck.Equals, StatePending)
+	stored, err := GetServiceInstance("instance", s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, StatePending)
+}
+
+func (s *InstanceSuite) TestCreateServiceInstanceWithPlan(c *gocheck.C) {
+	var plan string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`[{"name":"small","description":"1 GB"},{"name":"large","description":"10 GB"}]`))
+			return
+		}
+		plan = r.FormValue("plan")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	err = CreateServiceInstance(&ServiceInstance{Name: "instance", PlanName: "large"}, &srv, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	c.Assert(plan, gocheck.Equals, "large")
+	instance, err := GetServiceInstance("instance", s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(instance.PlanName, gocheck.Equals, "large")
+	c.Assert(instance.ServiceName, gocheck.Equals, "mysql")
+}
+
+func (s *InstanceSuite) TestCreateServiceInstanceWithInvalidPlan(c *gocheck.C) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`[{"name":"small","description":"1 GB"}]`))
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	err = CreateServiceInstance(&ServiceInstance{Name: "instance", PlanName: "huge"}, &srv, s.user)
+	c.Assert(err, gocheck.Equals, ErrInvalidPlan)
+	c.Assert(atomic.LoadInt32(&requests), gocheck.Equals, int32(1))
+	count, err := s.conn.ServiceInstances().Find(bson.M{"name": "instance"}).Count()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(count, gocheck.Equals, 0)
+}
+
 func (s *InstanceSuite) TestCreateServiceInstanceValidatesTheName(c *gocheck.C) {
 	var tests = []struct {
 		input string
@@ -483,7 +707,7 @@ func (s *InstanceSuite) TestCreateServiceInstanceValidatesTheName(c *gocheck.C)
 	c.Assert(err, gocheck.IsNil)
 	defer s.conn.Services().RemoveId(srv.Name)
 	for _, t := range tests {
-		err := CreateServiceInstance(t.input, &srv, s.user)
+		err := CreateServiceInstance(&ServiceInstance{Name: t.input}, &srv, s.user)
 		if err != t.err {
 			c.Errorf("Is %q valid? Want %#v. Got %#v", t.input, t.err, err)
 		}
@@ -491,6 +715,231 @@ func (s *InstanceSuite) TestCreateServiceInstanceValidatesTheName(c *gocheck.C)
 	}
 }
 
+func (s *InstanceSuite) TestCreateServiceInstanceWithParams(c *gocheck.C) {
+	var version string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version = r.FormValue("parameters.version")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	err = CreateServiceInstance(&ServiceInstance{Name: "instance", Params: map[string]string{"version": "5.6"}}, &srv, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	c.Assert(version, gocheck.Equals, "5.6")
+	instance, err := GetServiceInstance("instance", s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(instance.Params, gocheck.DeepEquals, map[string]string{"version": "5.6"})
+}
+
+func (s *InstanceSuite) TestCreateServiceInstanceEncryptsTheParams(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	var password string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		password = r.FormValue("parameters.password")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	err = CreateServiceInstance(&ServiceInstance{Name: "instance", Params: map[string]string{"password": "s3cr3t"}}, &srv, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	c.Assert(password, gocheck.Equals, "s3cr3t")
+	instance, err := GetServiceInstance("instance", s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(secret.IsEncrypted(instance.Params["password"]), gocheck.Equals, true)
+	value, err := secret.Decrypt(instance.Params["password"])
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(value, gocheck.Equals, "s3cr3t")
+}
+
+func (s *InstanceSuite) TestCreateServiceInstanceWithInvalidParam(c *gocheck.C) {
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": "http://localhost:1"}}
+	err := CreateServiceInstance(&ServiceInstance{Name: "instance", Params: map[string]string{"my.version": "5.6"}}, &srv, s.user)
+	c.Assert(err, gocheck.Equals, ErrInvalidParam)
+	err = CreateServiceInstance(&ServiceInstance{Name: "instance", Params: map[string]string{"$version": "5.6"}}, &srv, s.user)
+	c.Assert(err, gocheck.Equals, ErrInvalidParam)
+}
+
+func (s *InstanceSuite) TestUpdate(c *gocheck.C) {
+	var (
+		method string
+		form   url.Values
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Write([]byte(`[{"name":"small","description":"1 GB"},{"name":"large","description":"10 GB"}]`))
+			return
+		}
+		method = r.Method
+		r.ParseForm()
+		form = r.PostForm
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{
+		Name:        "instance",
+		ServiceName: "mysql",
+		PlanName:    "small",
+		Params:      map[string]string{"version": "5.5", "charset": "latin1"},
+		State:       StateUp,
+		Teams:       []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	err = instance.Update("large", map[string]string{"version": "5.6", "charset": ""})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(method, gocheck.Equals, "PUT")
+	c.Assert(map[string][]string(form), gocheck.DeepEquals, map[string][]string{"plan": {"large"}, "parameters.version": {"5.6"}})
+	stored, err := GetServiceInstance("instance", s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.PlanName, gocheck.Equals, "large")
+	c.Assert(stored.Params, gocheck.DeepEquals, map[string]string{"version": "5.6"})
+	c.Assert(stored.State, gocheck.Equals, StateUp)
+}
+
+func (s *InstanceSuite) TestUpdateEncryptsTheParams(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{Name: "instance", Params: map[string]string{"user": "root"}}
+	err = CreateServiceInstance(&instance, &srv, s.user)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	err = instance.Update("", map[string]string{"password": "s3cr3t"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(map[string][]string(form), gocheck.DeepEquals, map[string][]string{"parameters.user": {"root"}, "parameters.password": {"s3cr3t"}})
+	stored, err := GetServiceInstance("instance", s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Params, gocheck.HasLen, 2)
+	for name, expected := range map[string]string{"user": "root", "password": "s3cr3t"} {
+		c.Assert(secret.IsEncrypted(stored.Params[name]), gocheck.Equals, true)
+		value, err := secret.Decrypt(stored.Params[name])
+		c.Assert(err, gocheck.IsNil)
+		c.Assert(value, gocheck.Equals, expected)
+	}
+}
+
+func (s *InstanceSuite) TestUpdateKeepsTheInstanceWhenTheServiceFails(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(failHandler))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{
+		Name:        "instance",
+		ServiceName: "mysql",
+		Params:      map[string]string{"version": "5.5"},
+		Teams:       []string{s.team.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	err = instance.Update("", map[string]string{"version": "5.6"})
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(instance.Params, gocheck.DeepEquals, map[string]string{"version": "5.5"})
+	stored, err := GetServiceInstance("instance", s.user)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.Params, gocheck.DeepEquals, map[string]string{"version": "5.5"})
+}
+
+func (s *InstanceSuite) TestUpdatePendingInstance(c *gocheck.C) {
+	instance := ServiceInstance{Name: "instance", ServiceName: "mysql", State: StatePending}
+	err := instance.Update("large", nil)
+	c.Assert(err, gocheck.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, gocheck.Equals, true)
+	c.Assert(e.Code, gocheck.Equals, http.StatusPreconditionFailed)
+}
+
+func (s *InstanceSuite) TestUpdateWithInvalidPlan(c *gocheck.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name":"small","description":"1 GB"}]`))
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	instance := ServiceInstance{Name: "instance", ServiceName: "mysql", PlanName: "small"}
+	err = instance.Update("huge", nil)
+	c.Assert(err, gocheck.Equals, ErrInvalidPlan)
+	c.Assert(instance.PlanName, gocheck.Equals, "small")
+}
+
+func (s *InstanceSuite) TestSetState(c *gocheck.C) {
+	instance := ServiceInstance{
+		Name:        "instance",
+		State:       StatePending,
+		PendingApps: []string{"myapp"},
+	}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	apps, err := instance.SetState(StatePending)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(apps, gocheck.IsNil)
+	c.Assert(instance.PendingApps, gocheck.DeepEquals, []string{"myapp"})
+	apps, err = instance.SetState(StateUp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(apps, gocheck.DeepEquals, []string{"myapp"})
+	var stored ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "instance"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, StateUp)
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+}
+
+func (s *InstanceSuite) TestSetStateKeepsConcurrentChanges(c *gocheck.C) {
+	instance := ServiceInstance{
+		Name:        "instance",
+		State:       StatePending,
+		Creating:    true,
+		PendingApps: []string{"myapp"},
+	}
+	err := instance.Create()
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "instance"})
+	err = s.conn.ServiceInstances().Update(
+		bson.M{"name": "instance"},
+		bson.M{"$push": bson.M{"pending_apps": "otherapp", "apps": "boundapp"}},
+	)
+	c.Assert(err, gocheck.IsNil)
+	apps, err := instance.SetState(StateUp)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(apps, gocheck.DeepEquals, []string{"myapp", "otherapp"})
+	var stored ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": "instance"}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(stored.State, gocheck.Equals, StateUp)
+	c.Assert(stored.Creating, gocheck.Equals, false)
+	c.Assert(stored.PendingApps, gocheck.HasLen, 0)
+	c.Assert(stored.Apps, gocheck.DeepEquals, []string{"boundapp"})
+}
+
 func (s *InstanceSuite) TestStatus(c *gocheck.C) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
@@ -523,7 +972,7 @@ func (s *InstanceSuite) TestGetServiceInstance(c *gocheck.C) {
 	action := testing.Action{
 		User:   s.user.Email,
 		Action: "get-service-instance",
-		Extra:  []interface{}{"mongo-1"},
+		Extra:  []interface{}{"instance=mongo-1"},
 	}
 	c.Assert(action, testing.IsRecorded)
 	instance, err = GetServiceInstance("mongo-6", s.user)
@@ -533,3 +982,41 @@ func (s *InstanceSuite) TestGetServiceInstance(c *gocheck.C) {
 	c.Assert(instance, gocheck.IsNil)
 	c.Assert(err, gocheck.Equals, ErrAccessNotAllowed)
 }
+
+func (s *InstanceSuite) TestRotateParams(c *gocheck.C) {
+	config.Set("secret:key", "old-key")
+	defer config.Unset("secret:key")
+	defer config.Unset("secret:old-keys")
+	password, err := secret.Encrypt("s3cr3t")
+	c.Assert(err, gocheck.IsNil)
+	instance := ServiceInstance{
+		Name:   "rotated",
+		Params: map[string]string{"password": password, "user": "root"},
+	}
+	err = s.conn.ServiceInstances().Insert(instance)
+	c.Assert(err, gocheck.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": instance.Name})
+	config.Set("secret:key", "new-key")
+	config.Set("secret:old-keys", []interface{}{"old-key"})
+	n, err := RotateParams()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 1)
+	var stored ServiceInstance
+	err = s.conn.ServiceInstances().Find(bson.M{"name": instance.Name}).One(&stored)
+	c.Assert(err, gocheck.IsNil)
+	config.Unset("secret:old-keys")
+	for name, expected := range map[string]string{"password": "s3cr3t", "user": "root"} {
+		c.Assert(secret.NeedsRotation(stored.Params[name]), gocheck.Equals, false)
+		value, err := secret.Decrypt(stored.Params[name])
+		c.Assert(err, gocheck.IsNil)
+		c.Assert(value, gocheck.Equals, expected)
+	}
+	n, err = RotateParams()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(n, gocheck.Equals, 0)
+}
+
+func (s *InstanceSuite) TestRotateParamsWithoutKey(c *gocheck.C) {
+	_, err := RotateParams()
+	c.Assert(err, gocheck.NotNil)
+}
@@ -6,6 +6,7 @@ package service
 
 import (
 	"encoding/json"
+	"github.com/globocom/config"
 	"labix.org/v2/mgo/bson"
 	"launchpad.net/gocheck"
 )
@@ -72,7 +73,28 @@ func (s *S) TestGetClient(c *gocheck.C) {
 	service := Service{Name: "redis", Endpoint: endpoints}
 	cli, err := service.getClient("production")
 	c.Assert(err, gocheck.IsNil)
-	c.Assert(cli, gocheck.DeepEquals, &Client{endpoint: endpoints["production"]})
+	c.Assert(cli, gocheck.DeepEquals, &Client{endpoint: endpoints["production"], username: "redis"})
+}
+
+func (s *S) TestGetClientWithPassword(c *gocheck.C) {
+	config.Set("secret:key", "encryption-key")
+	defer config.Unset("secret:key")
+	service := Service{Name: "redis", Endpoint: map[string]string{"production": "https://redis.api.com"}}
+	err := service.SetPassword("s3cr3t", AuthHMAC)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(service.Password, gocheck.Not(gocheck.Equals), "s3cr3t")
+	cli, err := service.getClient("production")
+	c.Assert(err, gocheck.IsNil)
+	expected := &Client{endpoint: "https://redis.api.com", username: "redis", password: "s3cr3t", auth: AuthHMAC}
+	c.Assert(cli, gocheck.DeepEquals, expected)
+}
+
+func (s *S) TestSetPasswordInvalidScheme(c *gocheck.C) {
+	service := Service{Name: "redis"}
+	err := service.SetPassword("s3cr3t", "digest")
+	c.Assert(err, gocheck.NotNil)
+	c.Assert(err.Error(), gocheck.Equals, "Invalid authentication scheme: digest. Valid schemes are basic and hmac.")
+	c.Assert(service.Password, gocheck.Equals, "")
 }
 
 func (s *S) TestGetClientWithouHTTP(c *gocheck.C) {
@@ -235,10 +257,12 @@ func (s *S) TestServiceModelMarshalJSON(c *gocheck.C) {
 	expected[0] = map[string]interface{}{
 		"service":   "mysql",
 		"instances": nil,
+		"plans":     nil,
 	}
 	expected[1] = map[string]interface{}{
 		"service":   "mongo",
 		"instances": nil,
+		"plans":     nil,
 	}
 	result := make([]map[string]interface{}, 2)
 	err = json.Unmarshal(data, &result)
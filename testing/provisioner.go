@@ -62,6 +62,9 @@ type FakeApp struct {
 	commMut  sync.Mutex
 	ready    bool
 	deploys  uint
+	Memory   int64
+	Swap     int64
+	CpuShare int
 }
 
 func NewFakeApp(name, platform string, units int) *FakeApp {
@@ -137,6 +140,18 @@ func (a *FakeApp) GetDeploys() uint {
 	return a.deploys
 }
 
+func (a *FakeApp) GetMemory() int64 {
+	return a.Memory
+}
+
+func (a *FakeApp) GetSwap() int64 {
+	return a.Swap
+}
+
+func (a *FakeApp) GetCpuShare() int {
+	return a.CpuShare
+}
+
 func (a *FakeApp) ProvisionedUnits() []provision.AppUnit {
 	return a.units
 }